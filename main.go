@@ -2,10 +2,13 @@ package main
 
 import (
 	"bufio"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"os"
 	"path"
@@ -19,6 +22,7 @@ import (
 	"github.com/appbaseio/arc/middleware/logger"
 	"github.com/appbaseio/arc/plugins"
 	"github.com/appbaseio/arc/util"
+	"github.com/appbaseio/arc/util/hasher"
 	"github.com/gorilla/mux"
 	"github.com/robfig/cron"
 	"github.com/rs/cors"
@@ -102,6 +106,11 @@ func main() {
 		log.Errorln(logTag, ": reading env file", envFile, ": ", err)
 	}
 
+	// configure the algorithm and the cost the passwords are hashed with
+	if err := hasher.ConfigureFromEnv(); err != nil {
+		log.Fatal("error configuring the password hashing: ", err)
+	}
+
 	router := mux.NewRouter().StrictSlash(true)
 
 	if PlanRefreshInterval == "" {
@@ -263,12 +272,65 @@ func main() {
 	if https {
 		httpsCert := os.Getenv("HTTPS_CERT")
 		httpsKey := os.Getenv("HTTPS_KEY")
-		log.Fatal(http.ListenAndServeTLS(addr, httpsCert, httpsKey, handler))
+		tlsConfig, err := clientAuthTLSConfig()
+		if err != nil {
+			log.Fatal("error configuring the client certificate authentication: ", err)
+		}
+		srv := &http.Server{Addr: addr, Handler: handler, TLSConfig: tlsConfig}
+		log.Fatal(srv.ListenAndServeTLS(httpsCert, httpsKey))
 	} else {
 		log.Fatal(http.ListenAndServe(addr, handler))
 	}
 }
 
+// clientAuthTLSConfig returns the tls config that requests the client certificates,
+// as configured through HTTPS_CLIENT_CA and HTTPS_CLIENT_AUTH. Only the certificates
+// verified against the client CA bundle can be used to authenticate the requests.
+func clientAuthTLSConfig() (*tls.Config, error) {
+	clientCA := os.Getenv("HTTPS_CLIENT_CA")
+	mode := strings.ToLower(os.Getenv("HTTPS_CLIENT_AUTH"))
+	if mode == "" {
+		if clientCA == "" {
+			return nil, nil
+		}
+		// clients without a certificate can still use basic auth or a jwt
+		mode = "verify_if_given"
+	}
+
+	var clientAuth tls.ClientAuthType
+	switch mode {
+	case "none":
+		return nil, nil
+	case "request":
+		clientAuth = tls.RequestClientCert
+	case "require":
+		clientAuth = tls.RequireAnyClientCert
+	case "verify_if_given":
+		clientAuth = tls.VerifyClientCertIfGiven
+	case "require_and_verify":
+		clientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, fmt.Errorf("invalid HTTPS_CLIENT_AUTH %q, must be one of none, request, require, verify_if_given or require_and_verify", mode)
+	}
+
+	config := &tls.Config{ClientAuth: clientAuth}
+	if clientCA == "" {
+		if clientAuth == tls.VerifyClientCertIfGiven || clientAuth == tls.RequireAndVerifyClientCert {
+			return nil, fmt.Errorf("HTTPS_CLIENT_CA is required to verify the client certificates")
+		}
+		return config, nil
+	}
+	bundle, err := ioutil.ReadFile(clientCA)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read HTTPS_CLIENT_CA: %v", err)
+	}
+	config.ClientCAs = x509.NewCertPool()
+	if !config.ClientCAs.AppendCertsFromPEM(bundle) {
+		return nil, fmt.Errorf("no certificates found in HTTPS_CLIENT_CA %s", clientCA)
+	}
+	return config, nil
+}
+
 func LoadPIFromFile(path string) (plugin.Symbol, error) {
 	pf, err1 := plugin.Open(path)
 	if err1 != nil {
@@ -2,7 +2,6 @@ package classify
 
 import (
 	"net/http"
-	"regexp"
 	"strings"
 
 	"github.com/appbaseio/arc/middleware"
@@ -20,42 +19,21 @@ func indices(h http.HandlerFunc) http.HandlerFunc {
 		indices := util.IndicesFromRequest(req)
 		currentCache := GetIndexAliasCache()
 
-		for _, index := range indices {
-			// '*' in case of all indices put alias in context
-			if index == "*" {
+		for _, pattern := range indices {
+			if strings.Contains(pattern, "*") {
+				// put the aliases of all the cached indices matched by the pattern in context
 				for cachedItem := range currentCache {
+					if !index.MatchPattern(pattern, cachedItem) {
+						continue
+					}
 					alias := GetIndexAlias(cachedItem)
 					if alias != "" {
 						indices = append(indices, alias)
 					}
 				}
-				break
-			} else if strings.Contains(index, "*") {
-				// in case of regex check if string contains '*' in naming pattern, if contains and doesn't have '.*' and replace '*' with '.*' because golang regex can match in that pattern. Next match regex patters with existing index names in cache and add those alias to context.
-				regex := index
-
-				if !strings.Contains(index, ".*") {
-					regex = strings.Replace(regex, "*", ".*", -1)
-				}
-				r, _ := regexp.Compile(regex)
-				cachedIndices := []string{}
-
-				for cachedItem := range currentCache {
-					cachedIndices = append(cachedIndices, cachedItem)
-				}
-				for _, val := range cachedIndices {
-					if r.MatchString(val) {
-						alias := GetIndexAlias(val)
-						if alias != "" {
-							indices = append(indices, alias)
-						}
-						break
-					}
-				}
-
 			} else {
 				// get alias for index and put in context
-				alias := GetIndexAlias(index)
+				alias := GetIndexAlias(pattern)
 				if alias != "" {
 					indices = append(indices, alias)
 				}
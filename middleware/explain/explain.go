@@ -0,0 +1,335 @@
+// Package explain simulates requests through the middleware chains of the plugins and
+// records the decision of each middleware, without the requests reaching the handlers.
+package explain
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strings"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/appbaseio/arc/model/acl"
+	"github.com/appbaseio/arc/model/category"
+	"github.com/appbaseio/arc/model/credential"
+	"github.com/appbaseio/arc/model/index"
+	"github.com/appbaseio/arc/model/op"
+	"github.com/appbaseio/arc/util"
+	"github.com/appbaseio/arc/util/iplookup"
+)
+
+const logTag = "[explain]"
+
+type contextKey string
+
+// ctxKey is a key against which the trace of a simulated request is stored.
+const ctxKey = contextKey("explain_trace")
+
+// closureSuffix matches the suffix of the functions returned by middleware constructors.
+var closureSuffix = regexp.MustCompile(`(\.func\d+)+$`)
+
+// Step is the decision of a single middleware the simulated request passed through,
+// along with what the request was classified as when it passed it.
+type Step struct {
+	Middleware string   `json:"middleware"`
+	Passed     bool     `json:"passed"`
+	Category   string   `json:"category,omitempty"`
+	ACL        string   `json:"acl,omitempty"`
+	Op         string   `json:"op,omitempty"`
+	Indices    []string `json:"indices,omitempty"`
+}
+
+// Trace records the steps of a simulated request. The middlewares that authenticate
+// the requests use its credential instead, and the ones with side effects, like
+// recording the request or consuming the rate limits, skip them.
+type Trace struct {
+	Credential credential.AuthCredential
+	Steps      []Step
+	// Reached tells whether the request passed every middleware of the chain.
+	Reached bool
+}
+
+// NewContext returns a new context carrying the trace t, which makes the requests
+// served with it simulated.
+func NewContext(ctx context.Context, t *Trace) context.Context {
+	return context.WithValue(ctx, ctxKey, t)
+}
+
+// FromContext returns the trace of a simulated request, or nil if the request isn't
+// simulated.
+func FromContext(ctx context.Context) *Trace {
+	t, _ := ctx.Value(ctxKey).(*Trace)
+	return t
+}
+
+func (t *Trace) enter(name string) {
+	t.Steps = append(t.Steps, Step{Middleware: name})
+}
+
+func (t *Trace) pass(name string, ctx context.Context) {
+	for i := len(t.Steps) - 1; i >= 0; i-- {
+		s := &t.Steps[i]
+		if s.Middleware != name || s.Passed {
+			continue
+		}
+		s.Passed = true
+		if c, err := category.FromContext(ctx); err == nil {
+			s.Category = c.String()
+		}
+		if a, err := acl.FromContext(ctx); err == nil {
+			s.ACL = a.String()
+		}
+		if o, err := op.FromContext(ctx); err == nil {
+			s.Op = o.String()
+		}
+		if indices, err := index.FromContext(ctx); err == nil {
+			s.Indices = indices
+		}
+		return
+	}
+}
+
+// failed returns the step of the middleware that rejected the request, if any.
+func (t *Trace) failed() *Step {
+	for i := len(t.Steps) - 1; i >= 0; i-- {
+		if !t.Steps[i].Passed {
+			return &t.Steps[i]
+		}
+	}
+	return nil
+}
+
+// Wrap wraps the handler h in the middleware m, recording in the trace of the simulated
+// requests whether they pass m.
+func Wrap(m func(http.HandlerFunc) http.HandlerFunc, h http.HandlerFunc) http.HandlerFunc {
+	name := Name(m)
+	next := m(func(w http.ResponseWriter, req *http.Request) {
+		if t := FromContext(req.Context()); t != nil {
+			t.pass(name, req.Context())
+		}
+		h(w, req)
+	})
+	return func(w http.ResponseWriter, req *http.Request) {
+		if t := FromContext(req.Context()); t != nil {
+			t.enter(name)
+		}
+		next(w, req)
+	}
+}
+
+// Handler returns the handler h, which the simulated requests don't reach: they are
+// only marked as having passed the middleware chain.
+func Handler(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if t := FromContext(req.Context()); t != nil {
+			t.Reached = true
+			return
+		}
+		h(w, req)
+	}
+}
+
+// chainEntries are the functions through which the handlers adapted by Wrap and Handler
+// are entered, which tell the middleware chains apart from the plain handlers.
+var chainEntries = map[uintptr]bool{
+	funcPC(Wrap(func(h http.HandlerFunc) http.HandlerFunc { return h }, nil)): true,
+	funcPC(Handler(nil)): true,
+}
+
+func funcPC(f interface{}) uintptr {
+	return reflect.ValueOf(f).Pointer()
+}
+
+// Guard returns the handler h, refusing the simulated requests unless h is a middleware
+// chain adapted with Wrap and Handler: any other handler would serve them for real.
+func Guard(h http.HandlerFunc) http.HandlerFunc {
+	if chainEntries[funcPC(h)] {
+		return h
+	}
+	return func(w http.ResponseWriter, req *http.Request) {
+		if t := FromContext(req.Context()); t != nil {
+			util.WriteBackError(w, "the route isn't served through a middleware chain, its requests can't be simulated",
+				http.StatusNotImplemented)
+			return
+		}
+		h(w, req)
+	}
+}
+
+// Name returns the name of the middleware m as "package.function", e.g. "auth.basicAuth".
+func Name(m interface{}) string {
+	f := runtime.FuncForPC(reflect.ValueOf(m).Pointer())
+	if f == nil {
+		return "unknown"
+	}
+	name := f.Name()
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	// method values are suffixed with "-fm" and qualified by their receiver
+	name = strings.TrimSuffix(name, "-fm")
+	name = closureSuffix.ReplaceAllString(name, "")
+	if i := strings.Index(name, ")."); i >= 0 {
+		name = name[:strings.Index(name, ".")] + name[i+1:]
+	}
+	return name
+}
+
+// Request is the request to simulate.
+type Request struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	// Body is either the JSON body of the request, or a string holding a raw body,
+	// e.g. the ndjson of a bulk request.
+	Body    json.RawMessage `json:"body,omitempty"`
+	IP      string          `json:"ip,omitempty"`
+	Referer string          `json:"referer,omitempty"`
+}
+
+// Result is the outcome of a simulated request.
+type Result struct {
+	Method  string `json:"method"`
+	Path    string `json:"path"`
+	Route   string `json:"route,omitempty"`
+	Allowed bool   `json:"allowed"`
+	// FailedStep is the middleware that rejected the request, with the status code and
+	// the message it responded with.
+	FailedStep string `json:"failed_step,omitempty"`
+	Status     int    `json:"status,omitempty"`
+	Reason     string `json:"reason,omitempty"`
+	Steps      []Step `json:"steps"`
+}
+
+// Run simulates the request r, authenticated as c, through the router and returns the
+// decisions of the middlewares the request passed through. The request is neither
+// forwarded upstream nor recorded.
+func Run(router *mux.Router, c credential.AuthCredential, r *Request) (*Result, error) {
+	if r.Method == "" || !strings.HasPrefix(r.Path, "/") {
+		return nil, fmt.Errorf(`"method" and "path", starting with "/", are required`)
+	}
+	body, err := rawBody(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf(`invalid "body": %v`, err)
+	}
+	t := &Trace{Credential: c}
+	req, err := http.NewRequest(strings.ToUpper(r.Method), r.Path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(NewContext(context.Background(), t))
+	if r.IP != "" {
+		if net.ParseIP(r.IP) == nil {
+			return nil, fmt.Errorf(`invalid "ip": %s`, r.IP)
+		}
+		req.RemoteAddr = net.JoinHostPort(r.IP, "0")
+	}
+	if r.Referer != "" {
+		req.Header.Set("Referer", r.Referer)
+	}
+	if len(body) > 0 {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	result := &Result{Method: req.Method, Path: req.URL.Path, Steps: []Step{}}
+	var match mux.RouteMatch
+	if !router.Match(req, &match) || match.Route == nil {
+		result.Status = http.StatusNotFound
+		result.Reason = "no route matches the request"
+		return result, nil
+	}
+	if tpl, err := match.Route.GetPathTemplate(); err == nil {
+		result.Route = tpl
+	}
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	result.Steps = append(result.Steps, t.Steps...)
+	if t.Reached {
+		result.Allowed = true
+		return result, nil
+	}
+	if s := t.failed(); s != nil {
+		result.FailedStep = s.Middleware
+	}
+	result.Status = rec.Code
+	result.Reason = responseMessage(rec.Body.Bytes())
+	return result, nil
+}
+
+// Serve simulates the request described by the body of req as c and writes back the
+// result. The request is made from the ip of req, unless the body sets one.
+func Serve(w http.ResponseWriter, req *http.Request, router *mux.Router, c credential.AuthCredential) {
+	if router == nil {
+		log.Errorln(logTag, ": no router to simulate the request through")
+		util.WriteBackError(w, "unable to explain the request", http.StatusInternalServerError)
+		return
+	}
+	reqBody, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		log.Errorln(logTag, ": can't read request body:", err)
+		util.WriteBackError(w, "can't read request body", http.StatusBadRequest)
+		return
+	}
+	var r Request
+	if err := json.Unmarshal(reqBody, &r); err != nil {
+		util.WriteBackError(w, "can't parse request body", http.StatusBadRequest)
+		return
+	}
+	if r.IP == "" && net.ParseIP(iplookup.FromRequest(req)) != nil {
+		r.IP = iplookup.FromRequest(req)
+	}
+	result, err := Run(router, c, &r)
+	if err != nil {
+		util.WriteBackError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	raw, err := json.Marshal(result)
+	if err != nil {
+		log.Errorln(logTag, ": can't marshal the explain result:", err)
+		util.WriteBackError(w, "unable to explain the request", http.StatusInternalServerError)
+		return
+	}
+	util.WriteBackRaw(w, raw, http.StatusOK)
+}
+
+// rawBody returns the body of the request to simulate, unquoting the raw bodies.
+func rawBody(body json.RawMessage) ([]byte, error) {
+	if len(body) == 0 || string(body) == "null" {
+		return nil, nil
+	}
+	if body[0] == '"' {
+		var raw string
+		if err := json.Unmarshal(body, &raw); err != nil {
+			return nil, err
+		}
+		return []byte(raw), nil
+	}
+	return body, nil
+}
+
+// responseMessage extracts the message of the error responses written by the middlewares.
+func responseMessage(body []byte) string {
+	var resp struct {
+		Message string `json:"message"`
+		Error   struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return strings.TrimSpace(string(body))
+	}
+	if resp.Error.Message != "" {
+		return resp.Error.Message
+	}
+	return resp.Message
+}
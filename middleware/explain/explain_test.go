@@ -0,0 +1,112 @@
+package explain_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/gorilla/mux"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/appbaseio/arc/middleware"
+	"github.com/appbaseio/arc/middleware/explain"
+	"github.com/appbaseio/arc/model/category"
+	"github.com/appbaseio/arc/model/permission"
+	"github.com/appbaseio/arc/util"
+)
+
+func classifyDocs(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		docs := category.Docs
+		h(w, req.WithContext(category.NewContext(req.Context(), &docs)))
+	}
+}
+
+func rejectDeletes(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodDelete {
+			util.WriteBackError(w, "deletes are not allowed", http.StatusUnauthorized)
+			return
+		}
+		h(w, req)
+	}
+}
+
+func TestExplain(t *testing.T) {
+	var served int
+	handler := func(w http.ResponseWriter, req *http.Request) {
+		served++
+	}
+	chain := new(middleware.Fifo)
+	router := mux.NewRouter()
+	router.Methods(http.MethodPut, http.MethodDelete).
+		Path("/{index}/_doc/{id}").
+		HandlerFunc(explain.Guard(chain.Adapt(handler, classifyDocs, rejectDeletes)))
+	router.Methods(http.MethodPost).
+		Path("/_login").
+		HandlerFunc(explain.Guard(handler))
+	p := &permission.Permission{Username: "shop"}
+
+	Convey("Explaining requests", t, func() {
+		Convey("records the middlewares passed without reaching the handler", func() {
+			result, err := explain.Run(router, p, &explain.Request{
+				Method: "put",
+				Path:   "/products/_doc/1",
+				Body:   json.RawMessage(`{"name": "apple"}`),
+			})
+			So(err, ShouldBeNil)
+			So(result.Allowed, ShouldBeTrue)
+			So(result.Route, ShouldEqual, "/{index}/_doc/{id}")
+			So(result.Steps, ShouldHaveLength, 2)
+			So(result.Steps[0].Middleware, ShouldEqual, "explain_test.classifyDocs")
+			So(result.Steps[0].Category, ShouldEqual, category.Docs.String())
+			So(result.Steps[1].Passed, ShouldBeTrue)
+			So(served, ShouldEqual, 0)
+		})
+
+		Convey("reports the middleware that rejected the request", func() {
+			result, err := explain.Run(router, p, &explain.Request{Method: "DELETE", Path: "/products/_doc/1"})
+			So(err, ShouldBeNil)
+			So(result.Allowed, ShouldBeFalse)
+			So(result.FailedStep, ShouldEqual, "explain_test.rejectDeletes")
+			So(result.Status, ShouldEqual, http.StatusUnauthorized)
+			So(result.Reason, ShouldEqual, "deletes are not allowed")
+		})
+
+		Convey("reports the requests that match no route", func() {
+			result, err := explain.Run(router, p, &explain.Request{Method: "GET", Path: "/products/_doc/1"})
+			So(err, ShouldBeNil)
+			So(result.Allowed, ShouldBeFalse)
+			So(result.Steps, ShouldBeEmpty)
+			So(result.Status, ShouldEqual, http.StatusNotFound)
+
+			_, err = explain.Run(router, p, &explain.Request{Method: "GET", Path: "products"})
+			So(err, ShouldNotBeNil)
+			_, err = explain.Run(router, p, &explain.Request{Method: "GET", Path: "/products", IP: "localhost"})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("refuses the routes without a middleware chain", func() {
+			result, err := explain.Run(router, p, &explain.Request{Method: "POST", Path: "/_login"})
+			So(err, ShouldBeNil)
+			So(result.Allowed, ShouldBeFalse)
+			So(result.Status, ShouldEqual, http.StatusNotImplemented)
+			So(served, ShouldEqual, 0)
+		})
+
+		Convey("leaves the requests that aren't simulated untouched", func() {
+			req, _ := http.NewRequest(http.MethodPut, "/products/_doc/1", nil)
+			router.ServeHTTP(noopWriter{}, req)
+			So(served, ShouldEqual, 1)
+			req, _ = http.NewRequest(http.MethodPost, "/_login", nil)
+			router.ServeHTTP(noopWriter{}, req)
+			So(served, ShouldEqual, 2)
+		})
+	})
+}
+
+type noopWriter struct{}
+
+func (noopWriter) Header() http.Header         { return http.Header{} }
+func (noopWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (noopWriter) WriteHeader(int)             {}
@@ -2,6 +2,8 @@ package middleware
 
 import (
 	"net/http"
+
+	"github.com/appbaseio/arc/middleware/explain"
 )
 
 // Fifo is a type that implements Adapter. It provides
@@ -10,10 +12,13 @@ type Fifo string
 
 // Adapt adapts the handler in First-In, First-Out manner.
 // The request will pass through the middleware in the sequence
-// in which they are passed in the function.
+// in which they are passed in the function. The simulated requests
+// of the explain package record their way through the middleware,
+// without reaching the handler.
 func (f *Fifo) Adapt(h http.HandlerFunc, m ...Middleware) http.HandlerFunc {
+	h = explain.Handler(h)
 	for i := len(m) - 1; i >= 0; i-- {
-		h = m[i](h)
+		h = explain.Wrap(m[i], h)
 	}
 	return h
 }
@@ -10,6 +10,7 @@ import (
 	log "github.com/sirupsen/logrus"
 
 	"github.com/appbaseio/arc/middleware"
+	"github.com/appbaseio/arc/middleware/explain"
 	"github.com/appbaseio/arc/model/category"
 	"github.com/appbaseio/arc/model/credential"
 	"github.com/appbaseio/arc/model/permission"
@@ -67,6 +68,8 @@ func (rl *Ratelimiter) rateLimit(h http.HandlerFunc) http.HandlerFunc {
 		}
 
 		if reqCredential == credential.Permission {
+			// simulated requests don't consume the limits
+			consume := explain.FromContext(ctx) == nil
 			remoteIP := iplookup.FromRequest(req)
 			errMsg := "An error occurred while validating rate limit"
 			reqPermission, err := permission.FromContext(ctx)
@@ -92,7 +95,7 @@ func (rl *Ratelimiter) rateLimit(h http.HandlerFunc) http.HandlerFunc {
 			}
 
 			key := fmt.Sprintf("%s:%s", reqPermission.Username, *reqCategory)
-			if rl.limitExceededByACL(key, categoryLimit) {
+			if rl.limitExceededByACL(key, categoryLimit, consume) {
 				util.WriteBackMessage(w, "Rate limit exceeded", http.StatusTooManyRequests)
 				return
 			}
@@ -100,7 +103,7 @@ func (rl *Ratelimiter) rateLimit(h http.HandlerFunc) http.HandlerFunc {
 			// limit on IP per hour
 			ipLimit := reqPermission.GetIPLimit()
 			key = fmt.Sprintf("%s:%s", reqPermission.Username, remoteIP)
-			if rl.limitExceededByIP(key, ipLimit) {
+			if rl.limitExceededByIP(key, ipLimit, consume) {
 				util.WriteBackMessage(w, "Rate limit exceeded", http.StatusTooManyRequests)
 				return
 			}
@@ -110,23 +113,27 @@ func (rl *Ratelimiter) rateLimit(h http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
-func (rl *Ratelimiter) limitExceededByACL(key string, aclLimit int64) bool {
+func (rl *Ratelimiter) limitExceededByACL(key string, aclLimit int64, consume bool) bool {
 	period := 1 * time.Second
 	rem, _ := rl.peekLimit(key, aclLimit, period)
 	if rem <= 0 {
 		return true
 	}
-	rl.limit(key, aclLimit, period)
+	if consume {
+		rl.limit(key, aclLimit, period)
+	}
 	return false
 }
 
-func (rl *Ratelimiter) limitExceededByIP(key string, ipLimit int64) bool {
+func (rl *Ratelimiter) limitExceededByIP(key string, ipLimit int64, consume bool) bool {
 	period := 1 * time.Hour
 	rem, _ := rl.peekLimit(key, ipLimit, period)
 	if rem <= 0 {
 		return true
 	}
-	rl.limit(key, ipLimit, period)
+	if consume {
+		rl.limit(key, ipLimit, period)
+	}
 	return false
 }
 
@@ -70,6 +70,14 @@ func hasACL(ctx context.Context, c credential.Credential, acl *acl.ACL) (bool, e
 		if err != nil {
 			return false, err
 		}
+		// the acl is allowed on each index by the grant that allows the index
+		indices, ok, err := grantedIndices(ctx, reqPermission)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			return reqPermission.Allows(indices, acl, nil)
+		}
 		return reqPermission.HasACL(*acl), nil
 	default:
 		return false, fmt.Errorf("invalid credentials state reached")
@@ -0,0 +1,48 @@
+package validate
+
+import (
+	"context"
+
+	"github.com/appbaseio/arc/model/acl"
+	"github.com/appbaseio/arc/model/index"
+	"github.com/appbaseio/arc/model/op"
+	"github.com/appbaseio/arc/model/permission"
+)
+
+// grantedIndices returns the request indices the acl and op of a permission with grants
+// are evaluated against, along with whether they are to be evaluated per grant. The
+// routes whose indices aren't validated, e.g. the ones of arc's own system indices, are
+// evaluated against the permission's ops and acls instead, which is detected by the
+// request indices not being granted at all.
+func grantedIndices(ctx context.Context, p *permission.Permission) ([]string, bool, error) {
+	if !p.HasGrants() {
+		return nil, false, nil
+	}
+	reqIndices, err := index.FromContext(ctx)
+	if err != nil {
+		return nil, false, nil
+	}
+	granted, err := p.Allows(reqIndices, nil, nil)
+	if err != nil || !granted {
+		return nil, false, err
+	}
+	return reqIndices, true, nil
+}
+
+// optionalACL returns the acl of the request, or nil for the routes that don't classify one.
+func optionalACL(ctx context.Context) *acl.ACL {
+	reqACL, err := acl.FromContext(ctx)
+	if err != nil {
+		return nil
+	}
+	return reqACL
+}
+
+// optionalOp returns the op of the request, or nil for the routes that don't classify one.
+func optionalOp(ctx context.Context) *op.Operation {
+	reqOp, err := op.FromContext(ctx)
+	if err != nil {
+		return nil
+	}
+	return reqOp
+}
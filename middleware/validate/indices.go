@@ -72,6 +72,25 @@ func indices(h http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
+// IndexAccess checks whether the request credential stored in the context can access the given
+// indices. The grants of a permission must also allow the acl and the op of the request on them.
+func IndexAccess(ctx context.Context, indices ...string) (bool, error) {
+	reqCredential, err := credential.FromContext(ctx)
+	if err != nil {
+		return false, err
+	}
+	if reqCredential == credential.Permission {
+		reqPermission, err := permission.FromContext(ctx)
+		if err != nil {
+			return false, err
+		}
+		if reqPermission.HasGrants() {
+			return reqPermission.Allows(indices, optionalACL(ctx), optionalOp(ctx))
+		}
+	}
+	return allowedIndexAccess(ctx, reqCredential, indices)
+}
+
 func allowedClusterAccess(ctx context.Context, c credential.Credential) (bool, error) {
 	switch c {
 	case credential.User:
@@ -70,6 +70,14 @@ func canPerform(ctx context.Context, c credential.Credential, o *op.Operation) (
 		if err != nil {
 			return false, err
 		}
+		// the op is allowed on each index by the grant that allows the index and the acl
+		indices, ok, err := grantedIndices(ctx, reqPermission)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			return reqPermission.Allows(indices, optionalACL(ctx), o)
+		}
 		return reqPermission.CanDo(*o), nil
 	default:
 		return false, fmt.Errorf("invalid credential state reached")
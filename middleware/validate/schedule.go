@@ -0,0 +1,59 @@
+package validate
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/appbaseio/arc/middleware"
+	"github.com/appbaseio/arc/model/credential"
+	"github.com/appbaseio/arc/model/permission"
+	"github.com/appbaseio/arc/util"
+)
+
+// PermissionSchedule returns a middleware that checks whether the schedule of a permission
+// allows it to be used at the time of the request.
+func PermissionSchedule() middleware.Middleware {
+	return validateSchedule
+}
+
+func validateSchedule(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		ctx := req.Context()
+
+		reqCredential, err := credential.FromContext(ctx)
+		if err != nil {
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if reqCredential == credential.Permission {
+			reqPermission, err := permission.FromContext(ctx)
+			if err != nil {
+				log.Errorln(logTag, ":", err)
+				util.WriteBackError(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			status, err := reqPermission.Schedule.Status(time.Now())
+			if err != nil {
+				log.Errorln(logTag, ":", err)
+				util.WriteBackError(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			if status != permission.ScheduleActive {
+				msg := fmt.Sprintf("permission with username=%s is not scheduled to be used now: %s",
+					reqPermission.Username, status)
+				w.Header().Set("www-authenticate", "Basic realm=\"Authentication Required\"")
+				util.WriteBackError(w, msg, http.StatusUnauthorized)
+				return
+			}
+		}
+
+		h(w, req)
+	}
+}
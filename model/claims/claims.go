@@ -0,0 +1,50 @@
+package claims
+
+import (
+	"context"
+	"strings"
+
+	"github.com/appbaseio/arc/errors"
+)
+
+type contextKey string
+
+// ctxKey is the key against which the jwt claims are stored in the context.
+const ctxKey = contextKey("claims")
+
+// Claims are the claims of the JWT used to authenticate a request.
+type Claims map[string]interface{}
+
+// NewContext returns a new context carrying the given claims.
+func NewContext(ctx context.Context, c Claims) context.Context {
+	return context.WithValue(ctx, ctxKey, c)
+}
+
+// FromContext retrieves the claims stored against the claims.ctxKey from the context.
+func FromContext(ctx context.Context) (Claims, error) {
+	ctxClaims := ctx.Value(ctxKey)
+	if ctxClaims == nil {
+		return nil, errors.NewNotFoundInContextError("claims.Claims")
+	}
+	reqClaims, ok := ctxClaims.(Claims)
+	if !ok {
+		return nil, errors.NewInvalidCastError("ctxClaims", "claims.Claims")
+	}
+	return reqClaims, nil
+}
+
+// Lookup returns the value of the claim at the given dotted path, e.g. "app.tenant_id".
+func (c Claims) Lookup(path string) (interface{}, bool) {
+	var value interface{} = map[string]interface{}(c)
+	for _, key := range strings.Split(path, ".") {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		value, ok = m[key]
+		if !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
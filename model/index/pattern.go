@@ -0,0 +1,217 @@
+package index
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// wildcard matches any sequence of characters in an index pattern.
+	wildcard = "*"
+
+	// exclusionPrefix marks an index pattern as a negative pattern, e.g. "-secret-*".
+	exclusionPrefix = "-"
+
+	// systemPrefix is the prefix of system indices, e.g. ".users", ".permissions".
+	systemPrefix = "."
+
+	// SystemExclusion is the negative pattern that excludes all the system indices.
+	SystemExclusion = exclusionPrefix + systemPrefix + wildcard
+
+	// allIndices is the elasticsearch alias for all the indices.
+	allIndices = "_all"
+
+	// invalidChars are the characters elasticsearch doesn't permit in index names.
+	invalidChars = "\\/?\"<>| ,#"
+)
+
+// Patterns is a compiled list of index patterns a credential has access to. A
+// pattern is an anchored glob, where "*" matches any sequence of characters.
+// Patterns prefixed with "-" exclude the indices they match, exclusions always
+// take precedence over the inclusions. System indices, i.e. indices with a "."
+// prefix, are only matched by patterns that explicitly begin with a ".".
+type Patterns struct {
+	includes []string
+	excludes []string
+}
+
+// Compile validates the given index patterns and returns the compiled Patterns.
+func Compile(patterns []string) (*Patterns, error) {
+	p := &Patterns{}
+	for _, pattern := range patterns {
+		if err := validatePattern(pattern); err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(pattern, exclusionPrefix) {
+			p.excludes = append(p.excludes, strings.TrimPrefix(pattern, exclusionPrefix))
+		} else {
+			p.includes = append(p.includes, pattern)
+		}
+	}
+	return p, nil
+}
+
+// Validate checks whether the given index patterns are valid.
+func Validate(patterns []string) error {
+	_, err := Compile(patterns)
+	return err
+}
+
+// Match checks whether the given index name is matched by the index patterns.
+func Match(patterns []string, name string) (bool, error) {
+	p, err := Compile(patterns)
+	if err != nil {
+		return false, err
+	}
+	return p.Match(name), nil
+}
+
+func validatePattern(pattern string) error {
+	name := strings.TrimPrefix(pattern, exclusionPrefix)
+	if name == "" {
+		return fmt.Errorf(`invalid index pattern "%s": pattern cannot be empty`, pattern)
+	}
+	if strings.HasPrefix(name, exclusionPrefix) {
+		return fmt.Errorf(`invalid index pattern "%s": pattern cannot be excluded twice`, pattern)
+	}
+	if strings.ContainsAny(name, invalidChars) {
+		return fmt.Errorf(`invalid index pattern "%s": pattern cannot contain any of %q`, pattern, invalidChars)
+	}
+	return nil
+}
+
+// Match checks whether the given index name, or index pattern, is matched by the
+// patterns. An index pattern in the name is matched only if every index it could
+// possibly expand to is matched, i.e. it doesn't overlap with any exclusion.
+func (p *Patterns) Match(name string) bool {
+	// an exclusion in the request can only narrow down the indices
+	if strings.HasPrefix(name, exclusionPrefix) {
+		return true
+	}
+	if name == allIndices {
+		name = wildcard
+	}
+
+	var included bool
+	for _, pattern := range p.includes {
+		if MatchPattern(pattern, name) {
+			included = true
+			break
+		}
+	}
+	if !included {
+		return false
+	}
+
+	isPattern := strings.Contains(name, wildcard)
+	for _, pattern := range p.excludes {
+		if isPattern && overlaps(pattern, name) {
+			return false
+		}
+		if MatchPattern(pattern, name) {
+			return false
+		}
+	}
+	return true
+}
+
+// MatchPattern checks whether the index name is matched by the anchored glob pattern.
+// System indices are only matched by patterns that begin with a ".".
+func MatchPattern(pattern, name string) bool {
+	if IsSystem(name) && !IsSystem(pattern) {
+		return false
+	}
+	return Glob(pattern, name)
+}
+
+// ExpandsToSystem checks whether the index name, or index pattern, of a request can expand
+// to system indices without naming them explicitly, e.g. "*" or "_all".
+func ExpandsToSystem(name string) bool {
+	if name == allIndices {
+		return true
+	}
+	if !strings.Contains(name, wildcard) || IsSystem(name) || strings.HasPrefix(name, exclusionPrefix) {
+		return false
+	}
+	return overlaps(name, systemPrefix+wildcard)
+}
+
+// ExcludeSystem returns the index names and patterns of a request with the system indices
+// excluded from the ones that can expand to them. Elasticsearch expands "*" and "_all" to
+// the system indices too, which the patterns only match when they are named explicitly.
+func ExcludeSystem(names []string) []string {
+	excluded := make([]string, 0, len(names)+1)
+	for _, name := range names {
+		if !ExpandsToSystem(name) {
+			excluded = append(excluded, name)
+			continue
+		}
+		// "_all" can't be combined with other patterns
+		if name == allIndices {
+			name = wildcard
+		}
+		excluded = append(excluded, name, SystemExclusion)
+	}
+	return excluded
+}
+
+// IsSystem checks whether the index name, or index pattern, refers to a system index.
+func IsSystem(name string) bool {
+	return strings.HasPrefix(name, systemPrefix)
+}
+
+// Glob matches the name against the pattern in its entirety, where "*" matches any
+// sequence of characters and every other character matches itself.
+func Glob(pattern, name string) bool {
+	// position to backtrack to on a mismatch after the last seen wildcard
+	starIdx, matchIdx := -1, 0
+	p, n := 0, 0
+	for n < len(name) {
+		switch {
+		case p < len(pattern) && pattern[p] == '*':
+			starIdx, matchIdx = p, n
+			p++
+		case p < len(pattern) && pattern[p] == name[n]:
+			p++
+			n++
+		case starIdx != -1:
+			p = starIdx + 1
+			matchIdx++
+			n = matchIdx
+		default:
+			return false
+		}
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// overlaps checks whether there exists an index name that is matched by both the patterns.
+func overlaps(a, b string) bool {
+	type state struct{ i, j int }
+	memo := make(map[state]bool)
+	var intersect func(i, j int) bool
+	intersect = func(i, j int) bool {
+		s := state{i, j}
+		if v, ok := memo[s]; ok {
+			return v
+		}
+		var result bool
+		switch {
+		case i == len(a) && j == len(b):
+			result = true
+		case i < len(a) && a[i] == '*':
+			// either the wildcard matches nothing or it consumes the next char of b
+			result = intersect(i+1, j) || (j < len(b) && intersect(i, j+1))
+		case j < len(b) && b[j] == '*':
+			result = intersect(i, j+1) || (i < len(a) && intersect(i+1, j))
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			result = intersect(i+1, j+1)
+		}
+		memo[s] = result
+		return result
+	}
+	return intersect(0, 0)
+}
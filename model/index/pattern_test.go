@@ -0,0 +1,76 @@
+package index
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPatterns(t *testing.T) {
+	Convey("Index patterns", t, func() {
+		Convey("Patterns are anchored", func() {
+			p, err := Compile([]string{"logs"})
+			So(err, ShouldBeNil)
+			So(p.Match("logs"), ShouldBeTrue)
+			So(p.Match("mylogs-secret"), ShouldBeFalse)
+			So(p.Match("logs-1"), ShouldBeFalse)
+		})
+
+		Convey("Wildcards match any sequence of characters", func() {
+			p, err := Compile([]string{"logs-*-2019"})
+			So(err, ShouldBeNil)
+			So(p.Match("logs-app-2019"), ShouldBeTrue)
+			So(p.Match("logs--2019"), ShouldBeTrue)
+			So(p.Match("logs-app-2020"), ShouldBeFalse)
+		})
+
+		Convey("Exclusions take precedence", func() {
+			p, err := Compile([]string{"*", "-secret-*"})
+			So(err, ShouldBeNil)
+			So(p.Match("products"), ShouldBeTrue)
+			So(p.Match("secret-keys"), ShouldBeFalse)
+			// request patterns overlapping with an exclusion are rejected
+			So(p.Match("secret*"), ShouldBeFalse)
+			So(p.Match("*"), ShouldBeFalse)
+			So(p.Match("prod*"), ShouldBeTrue)
+		})
+
+		Convey("System indices must be granted explicitly", func() {
+			p, err := Compile([]string{"*"})
+			So(err, ShouldBeNil)
+			So(p.Match(".users"), ShouldBeFalse)
+			So(p.Match(".permissions"), ShouldBeFalse)
+			// a request pattern is matched on the indices it expands to once the system
+			// indices are excluded from it
+			So(ExcludeSystem([]string{"*"}), ShouldResemble, []string{"*", "-.*"})
+			So(p.Match("*"), ShouldBeTrue)
+
+			p, err = Compile([]string{"*", ".logs*"})
+			So(err, ShouldBeNil)
+			So(p.Match(".logs"), ShouldBeTrue)
+			So(p.Match(".users"), ShouldBeFalse)
+		})
+
+		Convey("System indices are excluded from the request patterns", func() {
+			So(ExpandsToSystem("*"), ShouldBeTrue)
+			So(ExpandsToSystem("_all"), ShouldBeTrue)
+			So(ExpandsToSystem("*-logs"), ShouldBeTrue)
+			So(ExpandsToSystem("logs-*"), ShouldBeFalse)
+			So(ExpandsToSystem(".logs*"), ShouldBeFalse)
+			So(ExpandsToSystem("products"), ShouldBeFalse)
+			So(ExpandsToSystem("-*"), ShouldBeFalse)
+
+			So(ExcludeSystem([]string{"_all"}), ShouldResemble, []string{"*", "-.*"})
+			So(ExcludeSystem([]string{"*", ".logs*"}), ShouldResemble, []string{"*", "-.*", ".logs*"})
+			So(ExcludeSystem([]string{"products", "logs-*"}), ShouldResemble, []string{"products", "logs-*"})
+		})
+
+		Convey("Invalid patterns are rejected", func() {
+			So(Validate([]string{""}), ShouldNotBeNil)
+			So(Validate([]string{"-"}), ShouldNotBeNil)
+			So(Validate([]string{"--logs"}), ShouldNotBeNil)
+			So(Validate([]string{"logs,secret"}), ShouldNotBeNil)
+			So(Validate([]string{"logs-*", "-logs-secret"}), ShouldBeNil)
+		})
+	})
+}
@@ -0,0 +1,63 @@
+package lockout
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/appbaseio/arc/errors"
+)
+
+type contextKey string
+
+// ctxKey is a key against which the lockouts caused by a request are stored.
+const ctxKey = contextKey("request_lockouts")
+
+// Event describes a username or source ip that got locked out after repeated
+// authentication failures.
+type Event struct {
+	Type        string    `json:"type"`
+	Value       string    `json:"value"`
+	Failures    int       `json:"failures"`
+	LockedUntil time.Time `json:"locked_until"`
+}
+
+// Events collects the lockouts caused by the authentication failure of a request, so
+// that the middleware that put them in the request context can act upon them once the
+// request is served.
+type Events struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+// Add records the lockout event.
+func (e *Events) Add(event Event) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.events = append(e.events, event)
+}
+
+// List returns the recorded lockouts.
+func (e *Events) List() []Event {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]Event(nil), e.events...)
+}
+
+// NewContext returns a new context carrying the lockout events e.
+func NewContext(ctx context.Context, e *Events) context.Context {
+	return context.WithValue(ctx, ctxKey, e)
+}
+
+// FromContext retrieves the lockout events stored in the context against ctxKey.
+func FromContext(ctx context.Context) (*Events, error) {
+	ctxEvents := ctx.Value(ctxKey)
+	if ctxEvents == nil {
+		return nil, errors.NewNotFoundInContextError("*lockout.Events")
+	}
+	reqEvents, ok := ctxEvents.(*Events)
+	if !ok {
+		return nil, errors.NewInvalidCastError("ctxEvents", "*lockout.Events")
+	}
+	return reqEvents, nil
+}
@@ -0,0 +1,156 @@
+package permission
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/appbaseio/arc/model/claims"
+)
+
+// claimTemplate matches the templates in a filter that refer to a jwt claim, e.g. "{{claims.tenant_id}}".
+var claimTemplate = regexp.MustCompile(`{{\s*claims\.([^{}\s]+)\s*}}`)
+
+// SetFilter sets the query DSL filter that restricts the documents the permission can access.
+func SetFilter(filter map[string]interface{}) Options {
+	return func(p *Permission) error {
+		if err := validateFilter(filter); err != nil {
+			return err
+		}
+		p.Filter = filter
+		return nil
+	}
+}
+
+func validateFilter(filter map[string]interface{}) error {
+	if len(filter) > 1 {
+		return fmt.Errorf(`invalid filter: filter must contain a single query clause, use a "bool" query to combine clauses`)
+	}
+	return walkFilter(filter, func(s string) error {
+		// every "{{" must begin a valid claim template
+		if strings.Count(s, "{{") != len(claimTemplate.FindAllString(s, -1)) {
+			return fmt.Errorf(`invalid filter: malformed template in "%s", expected "{{claims.<claim>}}"`, s)
+		}
+		return nil
+	})
+}
+
+// walkFilter calls fn on every string, map keys included, in the filter.
+func walkFilter(value interface{}, fn func(string) error) error {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, elem := range v {
+			if err := fn(key); err != nil {
+				return err
+			}
+			if err := walkFilter(elem, fn); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, elem := range v {
+			if err := walkFilter(elem, fn); err != nil {
+				return err
+			}
+		}
+	case string:
+		return fn(v)
+	}
+	return nil
+}
+
+// HasFilter checks whether the permission restricts the documents it can access.
+func (p *Permission) HasFilter() bool {
+	return len(p.Filter) > 0
+}
+
+// RenderFilter returns the permission filter with its claim templates replaced by the
+// values of the given claims. A string that consists of a single template is replaced
+// by the claim value as is, preserving its type. It returns an error if a referenced
+// claim isn't present, the filter must never be widened by a missing claim.
+func (p *Permission) RenderFilter(c claims.Claims) (map[string]interface{}, error) {
+	rendered, err := renderFilter(p.Filter, c)
+	if err != nil {
+		return nil, err
+	}
+	return rendered.(map[string]interface{}), nil
+}
+
+func renderFilter(value interface{}, c claims.Claims) (interface{}, error) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		rendered := make(map[string]interface{}, len(v))
+		for key, elem := range v {
+			renderedKey, err := renderString(key, c)
+			if err != nil {
+				return nil, err
+			}
+			k, ok := renderedKey.(string)
+			if !ok {
+				return nil, fmt.Errorf(`claim template "%s" used as a field name must be a string`, key)
+			}
+			rendered[k], err = renderFilter(elem, c)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return rendered, nil
+	case []interface{}:
+		rendered := make([]interface{}, len(v))
+		for i, elem := range v {
+			var err error
+			rendered[i], err = renderFilter(elem, c)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return rendered, nil
+	case string:
+		return renderString(v, c)
+	default:
+		return v, nil
+	}
+}
+
+func renderString(s string, c claims.Claims) (interface{}, error) {
+	matches := claimTemplate.FindAllStringSubmatchIndex(s, -1)
+	if len(matches) == 0 {
+		return s, nil
+	}
+
+	// the string is a single template, the claim value replaces it as is
+	if len(matches) == 1 && matches[0][0] == 0 && matches[0][1] == len(s) {
+		return lookupClaim(c, s[matches[0][2]:matches[0][3]])
+	}
+
+	var b strings.Builder
+	last := 0
+	for _, m := range matches {
+		value, err := lookupClaim(c, s[m[2]:m[3]])
+		if err != nil {
+			return nil, err
+		}
+		switch value.(type) {
+		case map[string]interface{}, []interface{}, nil:
+			return nil, fmt.Errorf(`claim "%s" cannot be embedded in "%s": claim must be a string, number or boolean`, s[m[2]:m[3]], s)
+		}
+		b.WriteString(s[last:m[0]])
+		if f, ok := value.(float64); ok {
+			b.WriteString(strconv.FormatFloat(f, 'f', -1, 64))
+		} else {
+			b.WriteString(fmt.Sprint(value))
+		}
+		last = m[1]
+	}
+	b.WriteString(s[last:])
+	return b.String(), nil
+}
+
+func lookupClaim(c claims.Claims, path string) (interface{}, error) {
+	value, ok := c.Lookup(path)
+	if !ok {
+		return nil, fmt.Errorf(`claim "%s" referenced by the permission filter is missing`, path)
+	}
+	return value, nil
+}
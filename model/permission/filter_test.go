@@ -0,0 +1,58 @@
+package permission
+
+import (
+	"testing"
+
+	"github.com/appbaseio/arc/model/claims"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFilter(t *testing.T) {
+	Convey("Permission filters", t, func() {
+		Convey("Malformed templates are rejected", func() {
+			So(validateFilter(map[string]interface{}{
+				"term": map[string]interface{}{"tenant_id": "{{claims.tenant_id}}"},
+			}), ShouldBeNil)
+			So(validateFilter(map[string]interface{}{
+				"term": map[string]interface{}{"tenant_id": "{{tenant_id}}"},
+			}), ShouldNotBeNil)
+			So(validateFilter(map[string]interface{}{
+				"term":  map[string]interface{}{"tenant_id": "a"},
+				"match": map[string]interface{}{"title": "b"},
+			}), ShouldNotBeNil)
+		})
+
+		Convey("Templates are rendered with the claims", func() {
+			p := &Permission{Filter: map[string]interface{}{
+				"bool": map[string]interface{}{
+					"filter": []interface{}{
+						map[string]interface{}{"term": map[string]interface{}{"tenant_id": "{{claims.tenant_id}}"}},
+						map[string]interface{}{"term": map[string]interface{}{"region": "eu-{{ claims.app.region }}"}},
+					},
+				},
+			}}
+			c := claims.Claims{
+				"tenant_id": float64(1234567),
+				"app":       map[string]interface{}{"region": "west"},
+			}
+			filter, err := p.RenderFilter(c)
+			So(err, ShouldBeNil)
+			clauses := filter["bool"].(map[string]interface{})["filter"].([]interface{})
+			So(clauses[0], ShouldResemble, map[string]interface{}{"term": map[string]interface{}{"tenant_id": float64(1234567)}})
+			So(clauses[1], ShouldResemble, map[string]interface{}{"term": map[string]interface{}{"region": "eu-west"}})
+			// the stored filter is left untouched
+			So(p.Filter["bool"].(map[string]interface{})["filter"].([]interface{})[0], ShouldResemble,
+				map[string]interface{}{"term": map[string]interface{}{"tenant_id": "{{claims.tenant_id}}"}})
+		})
+
+		Convey("Missing claims are rejected", func() {
+			p := &Permission{Filter: map[string]interface{}{
+				"term": map[string]interface{}{"tenant_id": "{{claims.tenant_id}}"},
+			}}
+			_, err := p.RenderFilter(nil)
+			So(err, ShouldNotBeNil)
+			_, err = p.RenderFilter(claims.Claims{"sub": "foo"})
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,227 @@
+package permission
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/appbaseio/arc/model/acl"
+	"github.com/appbaseio/arc/model/index"
+	"github.com/appbaseio/arc/model/op"
+)
+
+// Grant gives access to the indices matching its patterns, restricted to its ops, acls
+// and field and document rules, e.g. "read on products-*" and "write on cart-*" are two
+// grants of the same permission. The ops, acls and rules a grant doesn't set are the
+// ones of the permission. A permission without grants has a single grant made of its
+// indices, ops, acls and rules.
+type Grant struct {
+	Indices  []string               `json:"indices"`
+	Ops      []op.Operation         `json:"ops,omitempty"`
+	ACLs     []acl.ACL              `json:"acls,omitempty"`
+	Includes []string               `json:"include_fields,omitempty"`
+	Excludes []string               `json:"exclude_fields,omitempty"`
+	Filter   map[string]interface{} `json:"filter,omitempty"`
+}
+
+// SetGrants sets the grants of the permission. Grants must always be set after
+// setting the categories.
+func SetGrants(grants []Grant) Options {
+	return func(p *Permission) error {
+		if err := validateGrants(grants); err != nil {
+			return err
+		}
+		for _, g := range grants {
+			if err := p.ValidateACLs(g.ACLs...); err != nil {
+				return err
+			}
+		}
+		p.Grants = grants
+		return nil
+	}
+}
+
+func validateGrants(grants []Grant) error {
+	for i, g := range grants {
+		if len(g.Indices) == 0 {
+			return fmt.Errorf(`grant %d must have at least one index pattern in "indices"`, i)
+		}
+		if err := index.Validate(g.Indices); err != nil {
+			return fmt.Errorf("grant %d: %v", i, err)
+		}
+		if err := validateFields("include_fields", g.Includes); err != nil {
+			return fmt.Errorf("grant %d: %v", i, err)
+		}
+		if err := validateFields("exclude_fields", g.Excludes); err != nil {
+			return fmt.Errorf("grant %d: %v", i, err)
+		}
+		if g.Filter != nil {
+			if err := validateFilter(g.Filter); err != nil {
+				return fmt.Errorf("grant %d: %v", i, err)
+			}
+		}
+	}
+	return nil
+}
+
+// HasGrants checks whether the permission evaluates its access per grant.
+func (p *Permission) HasGrants() bool {
+	return len(p.Grants) > 0
+}
+
+// EffectiveGrants returns the grants of the permission with the unset fields taken from
+// the permission, or the single grant made of the permission fields if it has no grants.
+func (p *Permission) EffectiveGrants() []Grant {
+	if !p.HasGrants() {
+		return []Grant{{
+			Indices:  p.Indices,
+			Ops:      p.Ops,
+			ACLs:     p.ACLs,
+			Includes: p.Includes,
+			Excludes: p.Excludes,
+			Filter:   p.Filter,
+		}}
+	}
+	grants := make([]Grant, len(p.Grants))
+	for i, g := range p.Grants {
+		if g.Ops == nil {
+			g.Ops = p.Ops
+		}
+		if g.ACLs == nil {
+			g.ACLs = p.ACLs
+		}
+		if g.Includes == nil && g.Excludes == nil {
+			g.Includes, g.Excludes = p.Includes, p.Excludes
+		}
+		if g.Filter == nil {
+			g.Filter = p.Filter
+		}
+		grants[i] = g
+	}
+	return grants
+}
+
+// allows checks whether the grant covers the index, or the cluster if name is empty,
+// along with the acl and the op, unless they are nil.
+func (g *Grant) allows(name string, a *acl.ACL, o *op.Operation) (bool, error) {
+	if name == "" {
+		name = "*"
+	}
+	ok, err := index.Match(g.Indices, name)
+	if err != nil || !ok {
+		return false, err
+	}
+	if a != nil && !acl.Contains(g.ACLs, *a) {
+		return false, nil
+	}
+	if o != nil {
+		for _, grantOp := range g.Ops {
+			if grantOp == *o {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	return true, nil
+}
+
+// grantsFor returns the grants that allow the acl and op on the index, or on the cluster
+// if name is empty.
+func (p *Permission) grantsFor(name string, a *acl.ACL, o *op.Operation) ([]Grant, error) {
+	var matched []Grant
+	for _, g := range p.EffectiveGrants() {
+		ok, err := g.allows(name, a, o)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			matched = append(matched, g)
+		}
+	}
+	return matched, nil
+}
+
+// Allows checks whether a single grant of the permission allows the acl and the op on
+// each of the indices, or on the cluster if no indices are given. A nil acl or op isn't
+// checked. Unlike checking the indices, acls and ops of the permission independently,
+// a grant that allows reading an index and another that allows writing to some other
+// index don't allow writing to the former.
+func (p *Permission) Allows(indices []string, a *acl.ACL, o *op.Operation) (bool, error) {
+	if len(indices) == 0 {
+		indices = []string{""}
+	}
+	for _, name := range indices {
+		matched, err := p.grantsFor(name, a, o)
+		if err != nil {
+			return false, err
+		}
+		if len(matched) == 0 {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// ForRequest returns the permission that applies to a request on the given indices, or
+// on the cluster if no indices are given, with the field and document rules of the grants
+// that allow the request. The rules of several grants allowing the same index are merged
+// like the ones of several permissions. It returns an error if the indices are governed
+// by different rules, since a request is restricted by a single set of rules. A permission
+// without grants is returned as is.
+func (p *Permission) ForRequest(indices []string, a *acl.ACL, o *op.Operation) (*Permission, error) {
+	if !p.HasGrants() {
+		return p, nil
+	}
+	if len(indices) == 0 {
+		indices = []string{""}
+	}
+
+	var rules *Permission
+	for _, name := range indices {
+		matched, err := p.grantsFor(name, a, o)
+		if err != nil {
+			return nil, err
+		}
+		if len(matched) == 0 {
+			return nil, fmt.Errorf("permission doesn't grant access to index %q", name)
+		}
+		indexRules := grantRules(matched)
+		if rules == nil {
+			rules = indexRules
+			continue
+		}
+		if !reflect.DeepEqual(rules.Includes, indexRules.Includes) ||
+			!reflect.DeepEqual(rules.Excludes, indexRules.Excludes) ||
+			!reflect.DeepEqual(rules.Filter, indexRules.Filter) {
+			return nil, fmt.Errorf("the indices of the request are granted with different field or document rules, they must be requested separately")
+		}
+	}
+
+	scoped := *p
+	scoped.Includes, scoped.Excludes, scoped.Filter = rules.Includes, rules.Excludes, rules.Filter
+	return &scoped, nil
+}
+
+// grantRules merges the field and document rules of the grants. The absence of a rule
+// is normalized, so that the rules of different indices can be compared.
+func grantRules(grants []Grant) *Permission {
+	createdAt := time.Now().Format(time.RFC3339)
+	permissions := make([]*Permission, len(grants))
+	for i, g := range grants {
+		permissions[i] = &Permission{
+			CreatedAt: createdAt,
+			TTL:       -1,
+			Includes:  g.Includes,
+			Excludes:  g.Excludes,
+			Filter:    g.Filter,
+		}
+	}
+	merged := *Merge(permissions...)
+	if !isFieldRestricted(&merged) {
+		merged.Includes, merged.Excludes = nil, nil
+	}
+	if !merged.HasFilter() {
+		merged.Filter = nil
+	}
+	return &merged
+}
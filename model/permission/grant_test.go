@@ -0,0 +1,129 @@
+package permission
+
+import (
+	"testing"
+	"time"
+
+	"github.com/appbaseio/arc/model/acl"
+	"github.com/appbaseio/arc/model/category"
+	"github.com/appbaseio/arc/model/op"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestGrants(t *testing.T) {
+	search, index := acl.Search, acl.Index
+	read, write := op.Read, op.Write
+	shop := &Permission{
+		Username:   "shop",
+		Categories: []category.Category{category.Docs, category.Search},
+		ACLs:       []acl.ACL{acl.Get, acl.Search, acl.Index},
+		Ops:        []op.Operation{op.Read},
+		CreatedAt:  time.Now().Format(time.RFC3339),
+		TTL:        -1,
+		Grants: []Grant{
+			{Indices: []string{"products-*"}, Excludes: []string{"cost"}},
+			{Indices: []string{"cart-*"}, Ops: []op.Operation{op.Read, op.Write}},
+		},
+	}
+
+	Convey("Evaluating the grants of a permission", t, func() {
+		Convey("evaluates the indices, acls and ops together", func() {
+			ok, err := shop.Allows([]string{"products-1"}, &search, &read)
+			So(err, ShouldBeNil)
+			So(ok, ShouldBeTrue)
+			ok, _ = shop.Allows([]string{"cart-1"}, &index, &write)
+			So(ok, ShouldBeTrue)
+			// writing is only granted on the carts
+			ok, _ = shop.Allows([]string{"products-1"}, &index, &write)
+			So(ok, ShouldBeFalse)
+			ok, _ = shop.Allows([]string{"products-1", "cart-1"}, &index, &write)
+			So(ok, ShouldBeFalse)
+			ok, _ = shop.CanAccessIndices("products-1", "cart-1")
+			So(ok, ShouldBeTrue)
+			ok, _ = shop.CanAccessIndices("orders")
+			So(ok, ShouldBeFalse)
+			ok, _ = shop.CanAccessCluster()
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("treats the flat fields as a single grant", func() {
+			flat := &Permission{Indices: []string{"books"}, ACLs: []acl.ACL{acl.Search}, Ops: []op.Operation{op.Read}}
+			So(flat.EffectiveGrants(), ShouldHaveLength, 1)
+			ok, _ := flat.Allows([]string{"books"}, &search, &read)
+			So(ok, ShouldBeTrue)
+			ok, _ = flat.Allows([]string{"books"}, &search, &write)
+			So(ok, ShouldBeFalse)
+			scoped, err := flat.ForRequest([]string{"books"}, &search, &read)
+			So(err, ShouldBeNil)
+			So(scoped, ShouldEqual, flat)
+		})
+
+		Convey("scopes the field rules to the grant of the indices", func() {
+			scoped, err := shop.ForRequest([]string{"products-1"}, &search, &read)
+			So(err, ShouldBeNil)
+			So(scoped.Excludes, ShouldResemble, []string{"cost"})
+			So(scoped.Username, ShouldEqual, "shop")
+
+			scoped, err = shop.ForRequest([]string{"cart-1"}, &search, &read)
+			So(err, ShouldBeNil)
+			So(scoped.Excludes, ShouldBeNil)
+
+			_, err = shop.ForRequest([]string{"products-1", "cart-1"}, &search, &read)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("validates the grants", func() {
+			_, err := New("admin", SetGrants([]Grant{{Ops: []op.Operation{op.Read}}}))
+			So(err, ShouldNotBeNil)
+			_, err = New("admin", SetCategories([]category.Category{category.Docs}),
+				SetGrants([]Grant{{Indices: []string{"cart-*"}, ACLs: []acl.ACL{acl.Search}}}))
+			So(err, ShouldNotBeNil)
+			_, err = New("admin", SetGrants([]Grant{{Indices: []string{"cart-*"}, Excludes: []string{"cost."}}}))
+			So(err, ShouldNotBeNil)
+			_, err = New("admin", SetIncludes([]string{""}))
+			So(err, ShouldNotBeNil)
+			_, err = (&Permission{Excludes: []string{"user..ssn"}}).GetPatch(false)
+			So(err, ShouldNotBeNil)
+			p, err := New("admin", SetGrants([]Grant{{Indices: []string{"cart-*"}}}))
+			So(err, ShouldBeNil)
+			So(p.HasGrants(), ShouldBeTrue)
+		})
+
+		Convey("validates the patched grants against the patched categories", func() {
+			patch := &Permission{
+				Categories: []category.Category{category.Docs},
+				Grants:     []Grant{{Indices: []string{"cart-*"}, ACLs: []acl.ACL{acl.Search}}},
+			}
+			_, err := patch.GetPatch(false)
+			So(err, ShouldNotBeNil)
+
+			patch.Categories = []category.Category{category.Docs, category.Search}
+			doc, err := patch.GetPatch(false)
+			So(err, ShouldBeNil)
+			So(doc["grants"], ShouldResemble, patch.Grants)
+
+			// without categories they are left to be validated against the existing ones
+			patch.Categories = nil
+			_, err = patch.GetPatch(false)
+			So(err, ShouldBeNil)
+		})
+
+		Convey("keeps the grants of each permission when merged", func() {
+			reader := &Permission{
+				Username:  "reader",
+				ACLs:      []acl.ACL{acl.Search},
+				Ops:       []op.Operation{op.Read},
+				Indices:   []string{"orders"},
+				CreatedAt: time.Now().Format(time.RFC3339),
+				TTL:       -1,
+			}
+			merged := Merge(shop, reader)
+			So(merged.Grants, ShouldHaveLength, 3)
+			ok, _ := merged.Allows([]string{"orders"}, &search, &read)
+			So(ok, ShouldBeTrue)
+			// the ops of the merged permission don't widen the grants
+			ok, _ = merged.Allows([]string{"orders"}, &index, &write)
+			So(ok, ShouldBeFalse)
+		})
+	})
+}
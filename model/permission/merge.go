@@ -0,0 +1,207 @@
+package permission
+
+import (
+	"strings"
+	"time"
+
+	"github.com/appbaseio/arc/model/acl"
+	"github.com/appbaseio/arc/model/op"
+)
+
+// Merge computes the effective permission of a credential that holds several permissions,
+// e.g. a jwt carrying multiple roles. The effective permission grants the union of the
+// categories, acls, ops, indices, sources and referers and the most permissive limits of
+// the permissions. The access to the indices is evaluated per permission though, each
+// permission being turned into grants, so that an index excluded by one permission remains
+// accessible through another that grants it, with the ops and acls of the latter. Expired
+// permissions don't contribute to the effective permission, which is itself expired only
+// if all of the permissions are. The effective permission can be used during the schedule
+// windows of any of the permissions, within the widest of their schedule bounds, and isn't
+// restricted by a schedule if any of the permissions isn't. Likewise, its quotas are the
+// largest of the permissions, a missing quota being unlimited, while its usage is charged
+// to each of the permissions.
+//
+// Field level and document level restrictions are lifted if any of the permissions isn't
+// restricted, otherwise the fields excluded by any of the permissions remain excluded and
+// the documents matching the filter of any of the permissions can be accessed.
+func Merge(permissions ...*Permission) *Permission {
+	var active []*Permission
+	for _, p := range permissions {
+		if expired, err := p.IsExpired(); err == nil && !expired {
+			active = append(active, p)
+		}
+	}
+	if len(active) == 0 {
+		if len(permissions) == 0 {
+			return nil
+		}
+		active = permissions
+	}
+	if len(active) == 1 {
+		return active[0]
+	}
+
+	first := active[0]
+	merged := &Permission{
+		Owner:            first.Owner,
+		Creator:          first.Creator,
+		PasswordHashType: first.PasswordHashType,
+		CreatedAt:        first.CreatedAt,
+		TTL:              first.TTL,
+		Limits:           &Limits{},
+	}
+	var usernames, roles []string
+	var filters []interface{}
+	var grants []Grant
+	fieldRestricted, docRestricted := true, true
+	for _, p := range active {
+		usernames = append(usernames, p.Username)
+		if p.Role != "" {
+			roles = append(roles, p.Role)
+		}
+		for _, c := range p.Categories {
+			if !merged.HasCategory(c) {
+				merged.Categories = append(merged.Categories, c)
+			}
+		}
+		for _, a := range p.ACLs {
+			if !merged.HasACL(a) {
+				merged.ACLs = append(merged.ACLs, a)
+			}
+		}
+		for _, o := range p.Ops {
+			if !merged.CanDo(o) {
+				merged.Ops = append(merged.Ops, o)
+			}
+		}
+		merged.Indices = appendUnique(merged.Indices, p.Indices...)
+		merged.Sources = appendUnique(merged.Sources, p.Sources...)
+		merged.Referers = appendUnique(merged.Referers, p.Referers...)
+		if p.Limits != nil {
+			mergeLimits(merged.Limits, p.Limits)
+		}
+		if expiresAfter(p, merged) {
+			merged.CreatedAt, merged.TTL = p.CreatedAt, p.TTL
+		}
+
+		if !isFieldRestricted(p) {
+			fieldRestricted = false
+		}
+		if len(p.Includes) == 0 {
+			merged.Includes = appendUnique(merged.Includes, "*")
+		} else {
+			merged.Includes = appendUnique(merged.Includes, p.Includes...)
+		}
+		merged.Excludes = appendUnique(merged.Excludes, p.Excludes...)
+
+		if !p.HasFilter() {
+			docRestricted = false
+		}
+		filters = append(filters, p.Filter)
+
+		grants = append(grants, p.EffectiveGrants()...)
+	}
+	merged.Username = strings.Join(usernames, ",")
+	merged.Schedule = mergeSchedules(active)
+	merged.Quotas = mergeQuotas(active)
+	merged.MergedFrom = active
+	merged.Role = strings.Join(roles, ",")
+
+	if !fieldRestricted {
+		merged.Includes, merged.Excludes = []string{"*"}, []string{}
+	}
+	if docRestricted {
+		merged.Filter = map[string]interface{}{
+			"bool": map[string]interface{}{
+				"should":               filters,
+				"minimum_should_match": 1,
+			},
+		}
+	}
+	// the index access is evaluated per permission, through their grants, since the union
+	// of their index patterns would let the exclusions of one permission revoke the indices
+	// granted by another. The grants mustn't inherit the ops and acls of the other permissions.
+	for i := range grants {
+		if grants[i].Ops == nil {
+			grants[i].Ops = []op.Operation{}
+		}
+		if grants[i].ACLs == nil {
+			grants[i].ACLs = []acl.ACL{}
+		}
+	}
+	merged.Grants = grants
+	return merged
+}
+
+func appendUnique(values []string, elems ...string) []string {
+	for _, elem := range elems {
+		var found bool
+		for _, value := range values {
+			if value == elem {
+				found = true
+				break
+			}
+		}
+		if !found {
+			values = append(values, elem)
+		}
+	}
+	return values
+}
+
+// isFieldRestricted checks whether the permission restricts the fields that can be accessed.
+func isFieldRestricted(p *Permission) bool {
+	if len(p.Includes) == 0 && len(p.Excludes) == 0 {
+		return false
+	}
+	return len(p.Excludes) > 0 || p.Includes[0] != "*"
+}
+
+// expiresAfter checks whether the permission p expires after the permission other.
+func expiresAfter(p, other *Permission) bool {
+	if other.TTL < 0 {
+		return false
+	}
+	if p.TTL < 0 {
+		return true
+	}
+	pCreatedAt, err := time.Parse(time.RFC3339, p.CreatedAt)
+	if err != nil {
+		return false
+	}
+	otherCreatedAt, err := time.Parse(time.RFC3339, other.CreatedAt)
+	if err != nil {
+		return true
+	}
+	return pCreatedAt.Add(p.TTL).After(otherCreatedAt.Add(other.TTL))
+}
+
+func maxLimit(a, b int64) int64 {
+	if b > a {
+		return b
+	}
+	return a
+}
+
+// mergeLimits raises each of the limits to the respective limit in other.
+func mergeLimits(l, other *Limits) {
+	l.IPLimit = maxLimit(l.IPLimit, other.IPLimit)
+	l.DocsLimit = maxLimit(l.DocsLimit, other.DocsLimit)
+	l.SearchLimit = maxLimit(l.SearchLimit, other.SearchLimit)
+	l.IndicesLimit = maxLimit(l.IndicesLimit, other.IndicesLimit)
+	l.CatLimit = maxLimit(l.CatLimit, other.CatLimit)
+	l.ClustersLimit = maxLimit(l.ClustersLimit, other.ClustersLimit)
+	l.MiscLimit = maxLimit(l.MiscLimit, other.MiscLimit)
+	l.UserLimit = maxLimit(l.UserLimit, other.UserLimit)
+	l.PermissionLimit = maxLimit(l.PermissionLimit, other.PermissionLimit)
+	l.AnalyticsLimit = maxLimit(l.AnalyticsLimit, other.AnalyticsLimit)
+	l.RulesLimit = maxLimit(l.RulesLimit, other.RulesLimit)
+	l.TemplatesLimit = maxLimit(l.TemplatesLimit, other.TemplatesLimit)
+	l.SuggestionsLimit = maxLimit(l.SuggestionsLimit, other.SuggestionsLimit)
+	l.StreamsLimit = maxLimit(l.StreamsLimit, other.StreamsLimit)
+	l.AuthLimit = maxLimit(l.AuthLimit, other.AuthLimit)
+	l.FunctionsLimit = maxLimit(l.FunctionsLimit, other.FunctionsLimit)
+	l.ReactiveSearchLimit = maxLimit(l.ReactiveSearchLimit, other.ReactiveSearchLimit)
+	l.SearchRelevancyLimit = maxLimit(l.SearchRelevancyLimit, other.SearchRelevancyLimit)
+	l.SearchGraderLimit = maxLimit(l.SearchGraderLimit, other.SearchGraderLimit)
+}
@@ -0,0 +1,109 @@
+package permission
+
+import (
+	"testing"
+	"time"
+
+	"github.com/appbaseio/arc/model/acl"
+	"github.com/appbaseio/arc/model/category"
+	"github.com/appbaseio/arc/model/op"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMerge(t *testing.T) {
+	createdAt := time.Now().Format(time.RFC3339)
+	reader := &Permission{
+		Username:   "reader",
+		Role:       "reader",
+		Categories: []category.Category{category.Docs, category.Search},
+		ACLs:       []acl.ACL{acl.Get, acl.Search},
+		Ops:        []op.Operation{op.Read},
+		Indices:    []string{"books"},
+		CreatedAt:  createdAt,
+		TTL:        time.Hour,
+		Limits:     &Limits{IPLimit: 100, SearchLimit: 10},
+		Excludes:   []string{"price"},
+		Filter:     map[string]interface{}{"term": map[string]interface{}{"public": true}},
+	}
+	writer := &Permission{
+		Username:   "writer",
+		Role:       "writer",
+		Categories: []category.Category{category.Docs},
+		ACLs:       []acl.ACL{acl.Get, acl.Index},
+		Ops:        []op.Operation{op.Read, op.Write},
+		Indices:    []string{"books", "authors"},
+		CreatedAt:  createdAt,
+		TTL:        -1,
+		Limits:     &Limits{IPLimit: 50, SearchLimit: 20},
+		Includes:   []string{"title"},
+		Filter:     map[string]interface{}{"term": map[string]interface{}{"owner": "writer"}},
+	}
+
+	Convey("Merging permissions", t, func() {
+		Convey("A single permission is returned as is", func() {
+			So(Merge(reader), ShouldEqual, reader)
+			So(Merge(), ShouldBeNil)
+		})
+
+		Convey("Grants and limits are combined", func() {
+			merged := Merge(reader, writer)
+			So(merged.Role, ShouldEqual, "reader,writer")
+			So(merged.Categories, ShouldResemble, []category.Category{category.Docs, category.Search})
+			So(merged.ACLs, ShouldResemble, []acl.ACL{acl.Get, acl.Search, acl.Index})
+			So(merged.Ops, ShouldResemble, []op.Operation{op.Read, op.Write})
+			So(merged.Indices, ShouldResemble, []string{"books", "authors"})
+			So(merged.Limits.IPLimit, ShouldEqual, 100)
+			So(merged.Limits.SearchLimit, ShouldEqual, 20)
+			So(merged.TTL, ShouldEqual, time.Duration(-1))
+			So(merged.Includes, ShouldResemble, []string{"*", "title"})
+			So(merged.Excludes, ShouldResemble, []string{"price"})
+			should := merged.Filter["bool"].(map[string]interface{})["should"].([]interface{})
+			So(should, ShouldHaveLength, 2)
+		})
+
+		Convey("Index access is evaluated per permission", func() {
+			logs := &Permission{
+				Username:  "logs",
+				ACLs:      []acl.ACL{acl.Search},
+				Ops:       []op.Operation{op.Read},
+				Indices:   []string{"logs-*"},
+				CreatedAt: createdAt,
+				TTL:       -1,
+			}
+			app := &Permission{
+				Username:  "app",
+				ACLs:      []acl.ACL{acl.Search, acl.Index},
+				Ops:       []op.Operation{op.Read, op.Write},
+				Indices:   []string{"*", "-logs-*"},
+				CreatedAt: createdAt,
+				TTL:       -1,
+			}
+			merged := Merge(logs, app)
+			So(merged.Grants, ShouldHaveLength, 2)
+			search, index, read, write := acl.Search, acl.Index, op.Read, op.Write
+			ok, err := merged.Allows([]string{"logs-2024"}, &search, &read)
+			So(err, ShouldBeNil)
+			So(ok, ShouldBeTrue)
+			ok, _ = merged.Allows([]string{"logs-2024"}, &index, &write)
+			So(ok, ShouldBeFalse)
+			ok, _ = merged.Allows([]string{"products"}, &index, &write)
+			So(ok, ShouldBeTrue)
+		})
+
+		Convey("Restrictions are lifted by an unrestricted permission", func() {
+			unrestricted := *writer
+			unrestricted.Includes, unrestricted.Filter = nil, nil
+			merged := Merge(reader, &unrestricted)
+			So(merged.Includes, ShouldResemble, []string{"*"})
+			So(merged.Excludes, ShouldBeEmpty)
+			So(merged.HasFilter(), ShouldBeFalse)
+		})
+
+		Convey("Expired permissions are ignored", func() {
+			expired := *writer
+			expired.TTL = time.Second
+			expired.CreatedAt = time.Now().Add(-time.Minute).Format(time.RFC3339)
+			So(Merge(reader, &expired), ShouldEqual, reader)
+		})
+	})
+}
@@ -8,11 +8,10 @@ import (
 	"strings"
 	"time"
 
-	log "github.com/sirupsen/logrus"
-
 	"github.com/appbaseio/arc/errors"
 	"github.com/appbaseio/arc/model/acl"
 	"github.com/appbaseio/arc/model/category"
+	"github.com/appbaseio/arc/model/index"
 	"github.com/appbaseio/arc/model/op"
 	"github.com/appbaseio/arc/util"
 	"github.com/google/uuid"
@@ -32,24 +31,32 @@ const (
 
 // Permission defines a permission type.
 type Permission struct {
-	Username    string              `json:"username"`
-	Password    string              `json:"password"`
-	Owner       string              `json:"owner"`
-	Creator     string              `json:"creator"`
-	Role        string              `json:"role"`
-	Categories  []category.Category `json:"categories"`
-	ACLs        []acl.ACL           `json:"acls"`
-	Ops         []op.Operation      `json:"ops"`
-	Indices     []string            `json:"indices"`
-	Sources     []string            `json:"sources"`
-	Referers    []string            `json:"referers"`
-	CreatedAt   string              `json:"created_at"`
-	TTL         time.Duration       `json:"ttl"`
-	Limits      *Limits             `json:"limits"`
-	Description string              `json:"description"`
-	Includes    []string            `json:"include_fields"`
-	Excludes    []string            `json:"exclude_fields"`
-	Expired     bool                `json:"expired"`
+	Username         string                 `json:"username"`
+	Password         string                 `json:"password,omitempty"`
+	PasswordHashType string                 `json:"password_hash_type"`
+	Owner            string                 `json:"owner"`
+	Creator          string                 `json:"creator"`
+	Role             string                 `json:"role"`
+	Categories       []category.Category    `json:"categories"`
+	ACLs             []acl.ACL              `json:"acls"`
+	Ops              []op.Operation         `json:"ops"`
+	Indices          []string               `json:"indices"`
+	Sources          []string               `json:"sources"`
+	Referers         []string               `json:"referers"`
+	CreatedAt        string                 `json:"created_at"`
+	TTL              time.Duration          `json:"ttl"`
+	Limits           *Limits                `json:"limits"`
+	Description      string                 `json:"description"`
+	Includes         []string               `json:"include_fields"`
+	Excludes         []string               `json:"exclude_fields"`
+	Filter           map[string]interface{} `json:"filter"`
+	Grants           []Grant                `json:"grants,omitempty"`
+	Schedule         *Schedule              `json:"schedule,omitempty"`
+	Quotas           *Quotas                `json:"quotas,omitempty"`
+	Expired          bool                   `json:"expired"`
+	ScheduleStatus   string                 `json:"schedule_status,omitempty"`
+	// MergedFrom holds the permissions an effective permission was merged from.
+	MergedFrom []*Permission `json:"-"`
 }
 
 // Limits defines the rate limits for each category.
@@ -142,11 +149,8 @@ func SetIndices(indices []string) Options {
 		if indices == nil {
 			return errors.ErrNilIndices
 		}
-		for _, pattern := range indices {
-			pattern = strings.Replace(pattern, "*", ".*", -1)
-			if _, err := regexp.Compile(pattern); err != nil {
-				return err
-			}
+		if err := index.Validate(indices); err != nil {
+			return err
 		}
 		p.Indices = indices
 		return nil
@@ -171,6 +175,9 @@ func SetSources(sources []string) Options {
 // SetIncludes sets the includes fields
 func SetIncludes(includes []string) Options {
 	return func(p *Permission) error {
+		if err := validateFields("include_fields", includes); err != nil {
+			return err
+		}
 		p.Includes = includes
 		return nil
 	}
@@ -179,11 +186,26 @@ func SetIncludes(includes []string) Options {
 // SetExcludes sets the excludes fields
 func SetExcludes(excludes []string) Options {
 	return func(p *Permission) error {
+		if err := validateFields("exclude_fields", excludes); err != nil {
+			return err
+		}
 		p.Excludes = excludes
 		return nil
 	}
 }
 
+// validateFields checks the include or exclude field patterns, which are matched against
+// the full dotted paths of the fields, "*" matching any sequence of characters.
+func validateFields(key string, fields []string) error {
+	for _, field := range fields {
+		if field == "" || strings.HasPrefix(field, ".") || strings.HasSuffix(field, ".") ||
+			strings.Contains(field, "..") {
+			return fmt.Errorf(`invalid pattern "%s" in "%s": must be a dotted field path`, field, key)
+		}
+	}
+	return nil
+}
+
 func validateSources(sources []string) error {
 	for _, source := range sources {
 		_, _, err := net.ParseCIDR(source)
@@ -434,40 +456,32 @@ func (p *Permission) CanDo(op op.Operation) bool {
 
 // CanAccessCluster checks whether the user can access cluster level routes.
 func (p *Permission) CanAccessCluster() (bool, error) {
-	for _, pattern := range p.Indices {
-		pattern = strings.Replace(pattern, "*", ".*", -1)
-		matched, err := regexp.MatchString(pattern, "*")
-		if err != nil {
-			return false, err
-		}
-		if matched {
-			return true, nil
-		}
+	if p.HasGrants() {
+		return p.Allows(nil, nil, nil)
 	}
-	return false, nil
+	return index.Match(p.Indices, "*")
 }
 
 // CanAccessIndex checks whether the permission has access to given index or index pattern.
 func (p *Permission) CanAccessIndex(name string) (bool, error) {
-	for _, pattern := range p.Indices {
-		pattern = strings.Replace(pattern, "*", ".*", -1)
-		matched, err := regexp.MatchString(pattern, name)
-		if err != nil {
-			log.Errorln("invalid index regexp", pattern, "encontered: ", err)
-			return false, err
-		}
-		if matched {
-			return matched, nil
-		}
+	if p.HasGrants() {
+		return p.Allows([]string{name}, nil, nil)
 	}
-	return false, nil
+	return index.Match(p.Indices, name)
 }
 
 // CanAccessIndices checks whether the user has access to the given indices.
 func (p *Permission) CanAccessIndices(indices ...string) (bool, error) {
-	for _, index := range indices {
-		if ok, err := p.CanAccessIndex(index); !ok || err != nil {
-			return ok, err
+	if p.HasGrants() {
+		return p.Allows(indices, nil, nil)
+	}
+	patterns, err := index.Compile(p.Indices)
+	if err != nil {
+		return false, err
+	}
+	for _, name := range indices {
+		if !patterns.Match(name) {
+			return false, nil
 		}
 	}
 	return true, nil
@@ -535,6 +549,9 @@ func (p *Permission) GetPatch(rolePatched bool) (map[string]interface{}, error)
 	if p.Password != "" {
 		return nil, errors.NewUnsupportedPatchError("permission", "password")
 	}
+	if p.PasswordHashType != "" {
+		return nil, errors.NewUnsupportedPatchError("permission", "password_hash_type")
+	}
 	if p.Creator != "" {
 		return nil, errors.NewUnsupportedPatchError("permission", "creator")
 	}
@@ -556,6 +573,9 @@ func (p *Permission) GetPatch(rolePatched bool) (map[string]interface{}, error)
 		patch["ops"] = p.Ops
 	}
 	if p.Indices != nil {
+		if err := index.Validate(p.Indices); err != nil {
+			return nil, err
+		}
 		patch["indices"] = p.Indices
 	}
 	if p.Sources != nil {
@@ -643,11 +663,49 @@ func (p *Permission) GetPatch(rolePatched bool) (map[string]interface{}, error)
 		patch["description"] = p.Description
 	}
 	if p.Includes != nil {
+		if err := validateFields("include_fields", p.Includes); err != nil {
+			return nil, err
+		}
 		patch["include_fields"] = p.Includes
 	}
 	if p.Excludes != nil {
+		if err := validateFields("exclude_fields", p.Excludes); err != nil {
+			return nil, err
+		}
 		patch["exclude_fields"] = p.Excludes
 	}
+	if p.Filter != nil {
+		if err := validateFilter(p.Filter); err != nil {
+			return nil, err
+		}
+		patch["filter"] = p.Filter
+	}
+	if p.Grants != nil {
+		if err := validateGrants(p.Grants); err != nil {
+			return nil, err
+		}
+		// the grants patched without categories are validated against the existing ones
+		if p.Categories != nil {
+			for _, g := range p.Grants {
+				if err := p.ValidateACLs(g.ACLs...); err != nil {
+					return nil, err
+				}
+			}
+		}
+		patch["grants"] = p.Grants
+	}
+	if p.Schedule != nil {
+		if err := validateSchedule(p.Schedule); err != nil {
+			return nil, err
+		}
+		patch["schedule"] = p.Schedule
+	}
+	if p.Quotas != nil {
+		if err := validateQuotas(p.Quotas); err != nil {
+			return nil, err
+		}
+		patch["quotas"] = p.Quotas
+	}
 
 	return patch, nil
 }
@@ -0,0 +1,143 @@
+package permission
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/appbaseio/arc/model/category"
+)
+
+// Quota is a budget of requests per day and per month, a zero budget being unlimited.
+// The days and months are the calendar ones in UTC.
+type Quota struct {
+	Daily   int64 `json:"daily,omitempty"`
+	Monthly int64 `json:"monthly,omitempty"`
+}
+
+// Quotas are the request budgets of a permission, across all the categories as well as
+// per category, e.g. 100000 requests a month of which at most 1000 a day are "docs"
+// requests. Unlike the rate limits, the usage of the quotas is persisted.
+type Quotas struct {
+	Quota
+	Categories map[string]Quota `json:"categories,omitempty"`
+}
+
+// SetQuotas sets the request quotas of the permission.
+func SetQuotas(q *Quotas) Options {
+	return func(p *Permission) error {
+		if err := validateQuotas(q); err != nil {
+			return err
+		}
+		p.Quotas = q
+		return nil
+	}
+}
+
+func validateQuotas(q *Quotas) error {
+	if err := q.Quota.validate(); err != nil {
+		return err
+	}
+	for name, quota := range q.Categories {
+		if err := json.Unmarshal([]byte(fmt.Sprintf("%q", name)), new(category.Category)); err != nil {
+			return fmt.Errorf("invalid quota category: %v", err)
+		}
+		if err := quota.validate(); err != nil {
+			return fmt.Errorf(`quota of category "%s": %v`, name, err)
+		}
+	}
+	return nil
+}
+
+func (q Quota) validate() error {
+	if q.Daily < 0 || q.Monthly < 0 {
+		return fmt.Errorf("quotas can't be negative")
+	}
+	return nil
+}
+
+// HasQuotas checks whether the permission has any request budget.
+func (p *Permission) HasQuotas() bool {
+	if p.Quotas == nil {
+		return false
+	}
+	if p.Quotas.Daily > 0 || p.Quotas.Monthly > 0 {
+		return true
+	}
+	for _, q := range p.Quotas.Categories {
+		if q.Daily > 0 || q.Monthly > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// QuotaSources returns the permissions the usage of the permission is charged to, i.e. the
+// permissions it was merged from, if any, or the permission itself.
+func (p *Permission) QuotaSources() []*Permission {
+	if len(p.MergedFrom) > 0 {
+		return p.MergedFrom
+	}
+	return []*Permission{p}
+}
+
+// QuotaFor returns the budget of the permission for the given category.
+func (p *Permission) QuotaFor(c category.Category) Quota {
+	if p.Quotas == nil {
+		return Quota{}
+	}
+	return p.Quotas.Categories[c.String()]
+}
+
+// mergeQuota returns the larger of the two budgets, zero being unlimited.
+func mergeQuota(q, other Quota) Quota {
+	if q.Daily > 0 && other.Daily > 0 {
+		q.Daily = maxLimit(q.Daily, other.Daily)
+	} else {
+		q.Daily = 0
+	}
+	if q.Monthly > 0 && other.Monthly > 0 {
+		q.Monthly = maxLimit(q.Monthly, other.Monthly)
+	} else {
+		q.Monthly = 0
+	}
+	return q
+}
+
+// mergeQuotas returns the largest of the quotas of the permissions, or nil if one of them
+// has no budget at all. A category budget only remains if all of the permissions have one.
+func mergeQuotas(permissions []*Permission) *Quotas {
+	var merged *Quotas
+	for i, p := range permissions {
+		if !p.HasQuotas() {
+			return nil
+		}
+		if i == 0 {
+			merged = &Quotas{Quota: p.Quotas.Quota, Categories: make(map[string]Quota)}
+			for name, q := range p.Quotas.Categories {
+				merged.Categories[name] = q
+			}
+			continue
+		}
+		merged.Quota = mergeQuota(merged.Quota, p.Quotas.Quota)
+		for name, q := range merged.Categories {
+			other, ok := p.Quotas.Categories[name]
+			if !ok {
+				delete(merged.Categories, name)
+				continue
+			}
+			merged.Categories[name] = mergeQuota(q, other)
+		}
+	}
+	for name, q := range merged.Categories {
+		if q.Daily == 0 && q.Monthly == 0 {
+			delete(merged.Categories, name)
+		}
+	}
+	if len(merged.Categories) == 0 {
+		merged.Categories = nil
+	}
+	if merged.Daily == 0 && merged.Monthly == 0 && merged.Categories == nil {
+		return nil
+	}
+	return merged
+}
@@ -0,0 +1,38 @@
+package permission
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestQuotas(t *testing.T) {
+	Convey("Permission quotas", t, func() {
+		Convey("are validated", func() {
+			So(validateQuotas(&Quotas{Quota: Quota{Daily: 10}}), ShouldBeNil)
+			So(validateQuotas(&Quotas{Quota: Quota{Monthly: -1}}), ShouldNotBeNil)
+			So(validateQuotas(&Quotas{Categories: map[string]Quota{"docs": {Daily: 10}}}), ShouldBeNil)
+			So(validateQuotas(&Quotas{Categories: map[string]Quota{"doc": {Daily: 10}}}), ShouldNotBeNil)
+		})
+
+		Convey("are merged into the largest budgets", func() {
+			createdAt := time.Now().Format(time.RFC3339)
+			small := &Permission{Username: "small", CreatedAt: createdAt, TTL: -1, Quotas: &Quotas{
+				Quota:      Quota{Daily: 10, Monthly: 100},
+				Categories: map[string]Quota{"docs": {Daily: 1}, "search": {Daily: 5}},
+			}}
+			large := &Permission{Username: "large", CreatedAt: createdAt, TTL: -1, Quotas: &Quotas{
+				Quota:      Quota{Daily: 20},
+				Categories: map[string]Quota{"docs": {Daily: 2}},
+			}}
+			merged := Merge(small, large)
+			So(merged.Quotas.Daily, ShouldEqual, 20)
+			So(merged.Quotas.Monthly, ShouldEqual, 0)
+			So(merged.Quotas.Categories, ShouldResemble, map[string]Quota{"docs": {Daily: 2}})
+
+			unlimited := &Permission{Username: "any", CreatedAt: createdAt, TTL: -1}
+			So(Merge(small, unlimited).Quotas, ShouldBeNil)
+		})
+	})
+}
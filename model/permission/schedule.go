@@ -0,0 +1,304 @@
+package permission
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The statuses of a permission schedule at a given time.
+const (
+	ScheduleActive     = "active"
+	ScheduleOutside    = "outside_window"
+	ScheduleNotStarted = "not_started"
+	ScheduleEnded      = "ended"
+)
+
+const (
+	dateLayout    = "2006-01-02"
+	minutesPerDay = 24 * 60
+)
+
+// locations caches the time zones loaded by name, since loading one reads the tz database.
+var locations sync.Map
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// Schedule restricts the times at which a permission can be used, in addition to its ttl.
+// A permission with a schedule can only be used during one of its windows, if it has any,
+// and between its not_before and not_after bounds, if set. The bounds are either RFC3339
+// timestamps or dates, a not_after date being included in the schedule.
+type Schedule struct {
+	// TimeZone is the IANA time zone the windows and dates are in, defaults to UTC.
+	TimeZone  string       `json:"time_zone,omitempty"`
+	Windows   []TimeWindow `json:"windows,omitempty"`
+	NotBefore string       `json:"not_before,omitempty"`
+	NotAfter  string       `json:"not_after,omitempty"`
+}
+
+// TimeWindow is a daily range of time, e.g. 09:00 to 17:00, on the given weekdays, or on
+// every day if none are given. A window that ends before it starts runs past midnight into
+// the next day, e.g. 22:00 to 06:00 on "fri" ends on saturday morning. A window can be in
+// a time zone other than the one of its schedule.
+type TimeWindow struct {
+	Days     []string `json:"days,omitempty"`
+	Start    string   `json:"start"`
+	End      string   `json:"end"`
+	TimeZone string   `json:"time_zone,omitempty"`
+}
+
+// SetSchedule sets the schedule of the permission.
+func SetSchedule(s *Schedule) Options {
+	return func(p *Permission) error {
+		if err := validateSchedule(s); err != nil {
+			return err
+		}
+		p.Schedule = s
+		return nil
+	}
+}
+
+func validateSchedule(s *Schedule) error {
+	loc, err := loadLocation(s.TimeZone)
+	if err != nil {
+		return err
+	}
+	notBefore, err := parseScheduleBound(s.NotBefore, loc, false)
+	if err != nil {
+		return fmt.Errorf(`invalid schedule "not_before": %v`, err)
+	}
+	notAfter, err := parseScheduleBound(s.NotAfter, loc, true)
+	if err != nil {
+		return fmt.Errorf(`invalid schedule "not_after": %v`, err)
+	}
+	if !notBefore.IsZero() && !notAfter.IsZero() && !notBefore.Before(notAfter) {
+		return fmt.Errorf(`schedule "not_before" must be before "not_after"`)
+	}
+	for i, w := range s.Windows {
+		if err := w.validate(); err != nil {
+			return fmt.Errorf("schedule window %d: %v", i, err)
+		}
+	}
+	return nil
+}
+
+func (w *TimeWindow) validate() error {
+	if _, err := loadLocation(w.TimeZone); err != nil {
+		return err
+	}
+	for _, day := range w.Days {
+		if _, ok := weekdays[strings.ToLower(day)]; !ok {
+			return fmt.Errorf(`invalid day "%s", must be one of "mon", "tue", "wed", "thu", "fri", "sat" or "sun"`, day)
+		}
+	}
+	start, err := parseClock(w.Start)
+	if err != nil {
+		return err
+	}
+	end, err := parseClock(w.End)
+	if err != nil {
+		return err
+	}
+	if start == end {
+		return fmt.Errorf(`"start" and "end" must differ, use "00:00" to "24:00" for a whole day`)
+	}
+	if start == minutesPerDay {
+		return fmt.Errorf(`"start" must be before "24:00"`)
+	}
+	return nil
+}
+
+// Status returns whether the schedule allows the permission to be used at t, or why it
+// doesn't. A nil schedule is always active.
+func (s *Schedule) Status(t time.Time) (string, error) {
+	if s == nil {
+		return ScheduleActive, nil
+	}
+	loc, err := loadLocation(s.TimeZone)
+	if err != nil {
+		return "", err
+	}
+	notBefore, err := parseScheduleBound(s.NotBefore, loc, false)
+	if err != nil {
+		return "", err
+	}
+	if !notBefore.IsZero() && t.Before(notBefore) {
+		return ScheduleNotStarted, nil
+	}
+	notAfter, err := parseScheduleBound(s.NotAfter, loc, true)
+	if err != nil {
+		return "", err
+	}
+	if !notAfter.IsZero() && !t.Before(notAfter) {
+		return ScheduleEnded, nil
+	}
+	if len(s.Windows) == 0 {
+		return ScheduleActive, nil
+	}
+	for _, w := range s.Windows {
+		windowLoc := loc
+		if w.TimeZone != "" {
+			if windowLoc, err = loadLocation(w.TimeZone); err != nil {
+				return "", err
+			}
+		}
+		ok, err := w.contains(t.In(windowLoc))
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			return ScheduleActive, nil
+		}
+	}
+	return ScheduleOutside, nil
+}
+
+// contains checks whether the window contains t, in the time zone of the window.
+func (w *TimeWindow) contains(t time.Time) (bool, error) {
+	start, err := parseClock(w.Start)
+	if err != nil {
+		return false, err
+	}
+	end, err := parseClock(w.End)
+	if err != nil {
+		return false, err
+	}
+	minute := t.Hour()*60 + t.Minute()
+	if start < end {
+		return w.hasDay(t.Weekday()) && minute >= start && minute < end, nil
+	}
+	// the window runs past midnight, t is either in the evening of a day of the window
+	// or in the morning that follows it
+	if minute >= start {
+		return w.hasDay(t.Weekday()), nil
+	}
+	return minute < end && w.hasDay((t.Weekday()+6)%7), nil
+}
+
+func (w *TimeWindow) hasDay(day time.Weekday) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, d := range w.Days {
+		if weekdays[strings.ToLower(d)] == day {
+			return true
+		}
+	}
+	return false
+}
+
+// parseClock parses a time of the day in the "15:04" format into minutes since midnight,
+// "24:00" being the end of the day.
+func parseClock(value string) (int, error) {
+	parts := strings.Split(value, ":")
+	if len(parts) != 2 || len(parts[0]) != 2 || len(parts[1]) != 2 {
+		return 0, fmt.Errorf(`invalid time "%s", must be in the "hh:mm" format`, value)
+	}
+	hours, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, fmt.Errorf(`invalid time "%s", must be in the "hh:mm" format`, value)
+	}
+	minutes, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, fmt.Errorf(`invalid time "%s", must be in the "hh:mm" format`, value)
+	}
+	clock := hours*60 + minutes
+	if hours < 0 || minutes < 0 || minutes > 59 || clock > minutesPerDay {
+		return 0, fmt.Errorf(`invalid time "%s", must be between "00:00" and "24:00"`, value)
+	}
+	return clock, nil
+}
+
+// parseScheduleBound parses a schedule bound, which is either an RFC3339 timestamp or a
+// date in the time zone of the schedule. A date ending the schedule is included in it.
+func parseScheduleBound(value string, loc *time.Location, end bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation(dateLayout, value, loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf(`"%s" must be an RFC3339 timestamp or a "yyyy-mm-dd" date`, value)
+	}
+	if end {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+func loadLocation(name string) (*time.Location, error) {
+	if name == "" {
+		return time.UTC, nil
+	}
+	if loc, ok := locations.Load(name); ok {
+		return loc.(*time.Location), nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf(`invalid time zone "%s": %v`, name, err)
+	}
+	locations.Store(name, loc)
+	return loc, nil
+}
+
+// mergeSchedules returns the schedule under which any of the permissions can be used, or
+// nil if one of them isn't restricted by a schedule. The windows keep their own time zones
+// and the bounds are widened to the earliest start and the latest end.
+func mergeSchedules(permissions []*Permission) *Schedule {
+	merged := &Schedule{}
+	var notBefore, notAfter time.Time
+	hasWindows := true
+	for i, p := range permissions {
+		s := p.Schedule
+		if s == nil {
+			return nil
+		}
+		loc, err := loadLocation(s.TimeZone)
+		if err != nil {
+			return nil
+		}
+		if len(s.Windows) == 0 {
+			hasWindows = false
+		}
+		for _, w := range s.Windows {
+			if w.TimeZone == "" {
+				w.TimeZone = loc.String()
+			}
+			merged.Windows = append(merged.Windows, w)
+		}
+
+		start, _ := parseScheduleBound(s.NotBefore, loc, false)
+		if i == 0 || (!notBefore.IsZero() && (start.IsZero() || start.Before(notBefore))) {
+			notBefore = start
+		}
+		end, _ := parseScheduleBound(s.NotAfter, loc, true)
+		if i == 0 || (!notAfter.IsZero() && (end.IsZero() || end.After(notAfter))) {
+			notAfter = end
+		}
+	}
+	if !hasWindows {
+		merged.Windows = nil
+	}
+	if !notBefore.IsZero() {
+		merged.NotBefore = notBefore.Format(time.RFC3339)
+	}
+	if !notAfter.IsZero() {
+		merged.NotAfter = notAfter.Format(time.RFC3339)
+	}
+	if len(merged.Windows) == 0 && merged.NotBefore == "" && merged.NotAfter == "" {
+		return nil
+	}
+	return merged
+}
@@ -0,0 +1,97 @@
+package permission
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSchedule(t *testing.T) {
+	at := func(value string) time.Time {
+		ts, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ts
+	}
+
+	Convey("Permission schedules", t, func() {
+		Convey("allow the business hours in their time zone", func() {
+			s := &Schedule{
+				TimeZone: "America/New_York",
+				Windows:  []TimeWindow{{Days: []string{"mon", "tue", "wed", "thu", "fri"}, Start: "09:00", End: "17:00"}},
+			}
+			So(validateSchedule(s), ShouldBeNil)
+			// monday 10:00 in new york
+			status, err := s.Status(at("2024-03-04T15:00:00Z"))
+			So(err, ShouldBeNil)
+			So(status, ShouldEqual, ScheduleActive)
+			// monday 08:00 in new york
+			status, _ = s.Status(at("2024-03-04T13:00:00Z"))
+			So(status, ShouldEqual, ScheduleOutside)
+			// saturday 10:00 in new york
+			status, _ = s.Status(at("2024-03-09T15:00:00Z"))
+			So(status, ShouldEqual, ScheduleOutside)
+		})
+
+		Convey("run the windows ending before they start past midnight", func() {
+			s := &Schedule{Windows: []TimeWindow{{Days: []string{"fri"}, Start: "22:00", End: "06:00"}}}
+			status, _ := s.Status(at("2024-03-08T23:00:00Z"))
+			So(status, ShouldEqual, ScheduleActive)
+			status, _ = s.Status(at("2024-03-09T05:59:00Z"))
+			So(status, ShouldEqual, ScheduleActive)
+			status, _ = s.Status(at("2024-03-09T06:00:00Z"))
+			So(status, ShouldEqual, ScheduleOutside)
+			// the morning of friday follows a thursday
+			status, _ = s.Status(at("2024-03-08T05:00:00Z"))
+			So(status, ShouldEqual, ScheduleOutside)
+		})
+
+		Convey("are bounded by their dates", func() {
+			s := &Schedule{TimeZone: "Asia/Kolkata", NotBefore: "2024-03-01", NotAfter: "2024-03-31"}
+			So(validateSchedule(s), ShouldBeNil)
+			status, _ := s.Status(at("2024-02-29T18:29:00Z"))
+			So(status, ShouldEqual, ScheduleNotStarted)
+			status, _ = s.Status(at("2024-03-31T18:00:00Z"))
+			So(status, ShouldEqual, ScheduleActive)
+			status, _ = s.Status(at("2024-03-31T18:30:00Z"))
+			So(status, ShouldEqual, ScheduleEnded)
+			var none *Schedule
+			status, _ = none.Status(time.Now())
+			So(status, ShouldEqual, ScheduleActive)
+		})
+
+		Convey("are validated", func() {
+			So(validateSchedule(&Schedule{TimeZone: "Mars/Olympus_Mons"}), ShouldNotBeNil)
+			So(validateSchedule(&Schedule{Windows: []TimeWindow{{Start: "9:00", End: "17:00"}}}), ShouldNotBeNil)
+			So(validateSchedule(&Schedule{Windows: []TimeWindow{{Start: "09:00", End: "09:00"}}}), ShouldNotBeNil)
+			So(validateSchedule(&Schedule{Windows: []TimeWindow{{Days: []string{"monday"}, Start: "09:00", End: "17:00"}}}), ShouldNotBeNil)
+			So(validateSchedule(&Schedule{NotBefore: "2024-03-31", NotAfter: "2024-03-01"}), ShouldNotBeNil)
+			So(validateSchedule(&Schedule{Windows: []TimeWindow{{Start: "00:00", End: "24:00"}}}), ShouldBeNil)
+		})
+
+		Convey("are merged into the union of their windows", func() {
+			createdAt := time.Now().Format(time.RFC3339)
+			day := &Permission{Username: "day", CreatedAt: createdAt, TTL: -1, Schedule: &Schedule{
+				TimeZone: "Europe/Paris",
+				Windows:  []TimeWindow{{Start: "09:00", End: "17:00"}},
+			}}
+			night := &Permission{Username: "night", CreatedAt: createdAt, TTL: -1, Schedule: &Schedule{
+				Windows: []TimeWindow{{Start: "22:00", End: "02:00"}},
+			}}
+			merged := Merge(day, night)
+			So(merged.Schedule.Windows, ShouldHaveLength, 2)
+			So(merged.Schedule.Windows[0].TimeZone, ShouldEqual, "Europe/Paris")
+			status, _ := merged.Schedule.Status(at("2024-03-04T09:00:00Z"))
+			So(status, ShouldEqual, ScheduleActive)
+			status, _ = merged.Schedule.Status(at("2024-03-04T23:00:00Z"))
+			So(status, ShouldEqual, ScheduleActive)
+			status, _ = merged.Schedule.Status(at("2024-03-04T19:00:00Z"))
+			So(status, ShouldEqual, ScheduleOutside)
+
+			unscheduled := &Permission{Username: "any", CreatedAt: createdAt, TTL: -1}
+			So(Merge(day, unscheduled).Schedule, ShouldBeNil)
+		})
+	})
+}
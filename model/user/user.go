@@ -3,13 +3,12 @@ package user
 import (
 	"context"
 	"fmt"
-	"regexp"
-	"strings"
 	"time"
 
 	"github.com/appbaseio/arc/errors"
 	"github.com/appbaseio/arc/model/acl"
 	"github.com/appbaseio/arc/model/category"
+	"github.com/appbaseio/arc/model/index"
 	"github.com/appbaseio/arc/model/op"
 )
 
@@ -102,11 +101,8 @@ func SetIndices(indices []string) Options {
 		if indices == nil {
 			return errors.ErrNilIndices
 		}
-		for _, pattern := range indices {
-			pattern = strings.Replace(pattern, "*", ".*", -1)
-			if _, err := regexp.Compile(pattern); err != nil {
-				return err
-			}
+		if err := index.Validate(indices); err != nil {
+			return err
 		}
 		u.Indices = indices
 		return nil
@@ -248,39 +244,23 @@ func (u *User) CanDo(op op.Operation) bool {
 
 // CanAccessCluster checks whether the user can access cluster level routes.
 func (u *User) CanAccessCluster() (bool, error) {
-	for _, pattern := range u.Indices {
-		pattern = strings.Replace(pattern, "*", ".*", -1)
-		matched, err := regexp.MatchString(pattern, "*")
-		if err != nil {
-			return false, err
-		}
-		if matched {
-			return true, nil
-		}
-	}
-	return false, nil
+	return index.Match(u.Indices, "*")
 }
 
 // CanAccessIndex checks whether the user has access to the given index or index pattern.
 func (u *User) CanAccessIndex(name string) (bool, error) {
-	for _, pattern := range u.Indices {
-		pattern = strings.Replace(pattern, "*", ".*", -1)
-		matched, err := regexp.MatchString(pattern, name)
-		if err != nil {
-			return false, err
-		}
-		if matched {
-			return true, nil
-		}
-	}
-	return false, nil
+	return index.Match(u.Indices, name)
 }
 
 // CanAccessIndices checks whether the user has access to the given indices.
 func (u *User) CanAccessIndices(indices ...string) (bool, error) {
-	for _, index := range indices {
-		if ok, err := u.CanAccessIndex(index); !ok || err != nil {
-			return ok, err
+	patterns, err := index.Compile(u.Indices)
+	if err != nil {
+		return false, err
+	}
+	for _, name := range indices {
+		if !patterns.Match(name) {
+			return false, nil
 		}
 	}
 	return true, nil
@@ -317,6 +297,9 @@ func (u *User) GetPatch() (map[string]interface{}, error) {
 		patch["ops"] = u.Ops
 	}
 	if u.Indices != nil {
+		if err := index.Validate(u.Indices); err != nil {
+			return nil, err
+		}
 		patch["indices"] = u.Indices
 	}
 	if u.CreatedAt != "" {
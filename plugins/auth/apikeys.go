@@ -0,0 +1,229 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/appbaseio/arc/model/credential"
+	"github.com/appbaseio/arc/model/permission"
+	"github.com/appbaseio/arc/model/user"
+)
+
+const (
+	// apiKeyPrefix is the prefix of all the api keys, it makes them easy to spot in
+	// source code and logs.
+	apiKeyPrefix    = "arc"
+	apiKeyDocType   = "apikey"
+	apiKeyDocPrefix = "_apikey_"
+	apiKeyHeader    = "X-API-Key"
+	apiKeyParam     = "api_key"
+	// apiKeyCheckInterval is the duration for which an api key is assumed to be valid
+	// once verified, keys deleted via other arc instances are noticed after it.
+	apiKeyCheckInterval = 30 * time.Second
+)
+
+var errInvalidAPIKey = fmt.Errorf("invalid or expired api key")
+
+// apiKey is a credential that authenticates the requests on behalf of a permission.
+// Only the hash of the key is stored, the key itself is returned once on creation.
+type apiKey struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+	Name string `json:"name,omitempty"`
+	// DisplayPrefix is the beginning of the key, it helps identify a key without revealing it.
+	DisplayPrefix string `json:"display_prefix"`
+	KeyHash       string `json:"key_hash"`
+	// Username is the username of the permission the key grants.
+	Username  string `json:"username"`
+	CreatedAt string `json:"created_at"`
+	ExpiresAt string `json:"expires_at,omitempty"`
+}
+
+// apiKeyResponse describes an api key without its hash.
+type apiKeyResponse struct {
+	ID            string `json:"id"`
+	Name          string `json:"name,omitempty"`
+	DisplayPrefix string `json:"display_prefix"`
+	Username      string `json:"username"`
+	CreatedAt     string `json:"created_at"`
+	ExpiresAt     string `json:"expires_at,omitempty"`
+	// Key is only returned when the key is created.
+	Key string `json:"key,omitempty"`
+}
+
+func (k apiKey) response() apiKeyResponse {
+	return apiKeyResponse{
+		ID:            k.ID,
+		Name:          k.Name,
+		DisplayPrefix: k.DisplayPrefix,
+		Username:      k.Username,
+		CreatedAt:     k.CreatedAt,
+		ExpiresAt:     k.ExpiresAt,
+	}
+}
+
+func (k apiKey) isExpired(now time.Time) bool {
+	if k.ExpiresAt == "" {
+		return false
+	}
+	expiresAt, err := time.Parse(time.RFC3339, k.ExpiresAt)
+	return err != nil || now.After(expiresAt)
+}
+
+// newAPIKey generates a key of the form "arc_<id>_<secret>" for the permission.
+func newAPIKey(name, username, expiresAt string) (*apiKey, string, error) {
+	if username == "" {
+		return nil, "", fmt.Errorf(`"username" must be provided`)
+	}
+	if expiresAt != "" {
+		t, err := time.Parse(time.RFC3339, expiresAt)
+		if err != nil {
+			return nil, "", fmt.Errorf(`invalid "expires_at", must be in RFC3339 format: %v`, err)
+		}
+		if t.Before(time.Now()) {
+			return nil, "", fmt.Errorf(`"expires_at" must be in the future`)
+		}
+	}
+	buf := make([]byte, 4)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, "", err
+	}
+	id := hex.EncodeToString(buf)
+	secret, err := randomToken(24)
+	if err != nil {
+		return nil, "", err
+	}
+	key := apiKeyPrefix + "_" + id + "_" + secret
+	return &apiKey{
+		Type:          apiKeyDocType,
+		ID:            id,
+		Name:          name,
+		DisplayPrefix: apiKeyPrefix + "_" + id,
+		KeyHash:       hashToken(key),
+		Username:      username,
+		CreatedAt:     time.Now().Format(time.RFC3339),
+		ExpiresAt:     expiresAt,
+	}, key, nil
+}
+
+// parseAPIKeyID returns the id of the api key, which identifies its stored hash.
+func parseAPIKeyID(key string) (string, bool) {
+	parts := strings.SplitN(key, "_", 3)
+	if len(parts) != 3 || parts[0] != apiKeyPrefix || parts[1] == "" || parts[2] == "" {
+		return "", false
+	}
+	return parts[1], true
+}
+
+// canMintAPIKey reports whether the user that made the request can create api keys for
+// the permission p: only the admins and the owner of the permission can, the requests
+// authenticated via permissions can't.
+func canMintAPIKey(ctx context.Context, p *permission.Permission) (bool, error) {
+	reqCredential, err := credential.FromContext(ctx)
+	if err != nil {
+		return false, err
+	}
+	if reqCredential != credential.User {
+		return false, nil
+	}
+	reqUser, err := user.FromContext(ctx)
+	if err != nil {
+		return false, err
+	}
+	if reqUser.IsAdmin != nil && *reqUser.IsAdmin {
+		return true, nil
+	}
+	return p.Owner == reqUser.Username, nil
+}
+
+// apiKeyFromRequest extracts the api key from the X-API-Key header or, for requests
+// that only read data, from the api_key query param. The key is removed from the
+// request, so that it doesn't get forwarded upstream.
+func apiKeyFromRequest(req *http.Request, readOnly bool) (string, bool) {
+	if key := req.Header.Get(apiKeyHeader); key != "" {
+		req.Header.Del(apiKeyHeader)
+		return key, true
+	}
+	query := req.URL.Query()
+	key := query.Get(apiKeyParam)
+	if key == "" {
+		return "", false
+	}
+	query.Del(apiKeyParam)
+	req.URL.RawQuery = query.Encode()
+	if !readOnly {
+		return "", false
+	}
+	return key, true
+}
+
+// apiKeyCache remembers the api keys that were recently verified, against the hash of the key.
+type apiKeyCache struct {
+	mu   sync.Mutex
+	keys map[string]cachedAPIKey
+}
+
+type cachedAPIKey struct {
+	key        apiKey
+	verifiedAt time.Time
+}
+
+func newAPIKeyCache() *apiKeyCache {
+	return &apiKeyCache{keys: make(map[string]cachedAPIKey)}
+}
+
+func (c *apiKeyCache) get(hash string) (apiKey, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cached, ok := c.keys[hash]
+	if ok && time.Since(cached.verifiedAt) > apiKeyCheckInterval {
+		delete(c.keys, hash)
+		return apiKey{}, false
+	}
+	return cached.key, ok
+}
+
+func (c *apiKeyCache) put(k apiKey) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.keys[k.KeyHash] = cachedAPIKey{key: k, verifiedAt: time.Now()}
+}
+
+func (c *apiKeyCache) remove(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for hash, cached := range c.keys {
+		if cached.key.ID == id {
+			delete(c.keys, hash)
+		}
+	}
+}
+
+// validateAPIKey verifies the api key and returns the username of the permission it grants.
+func (a *Auth) validateAPIKey(ctx context.Context, key string) (string, error) {
+	id, ok := parseAPIKeyID(key)
+	if !ok {
+		return "", errInvalidAPIKey
+	}
+	hash := hashToken(key)
+	now := time.Now()
+	if k, ok := a.apiKeys.get(hash); ok && !k.isExpired(now) {
+		return k.Username, nil
+	}
+	k, err := a.es.getAPIKey(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	if k == nil || subtle.ConstantTimeCompare([]byte(k.KeyHash), []byte(hash)) != 1 || k.isExpired(now) {
+		return "", errInvalidAPIKey
+	}
+	a.apiKeys.put(*k)
+	return k.Username, nil
+}
@@ -0,0 +1,130 @@
+package auth
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/appbaseio/arc/model/credential"
+	"github.com/appbaseio/arc/model/permission"
+	"github.com/appbaseio/arc/model/user"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAPIKeys(t *testing.T) {
+	Convey("Api keys", t, func() {
+		Convey("are generated with their id and hash", func() {
+			k, key, err := newAPIKey("ci", "shop", "")
+			So(err, ShouldBeNil)
+			id, ok := parseAPIKeyID(key)
+			So(ok, ShouldBeTrue)
+			So(id, ShouldEqual, k.ID)
+			So(k.KeyHash, ShouldEqual, hashToken(key))
+			So(k.DisplayPrefix, ShouldEqual, "arc_"+id)
+			So(k.isExpired(time.Now()), ShouldBeFalse)
+
+			_, _, err = newAPIKey("ci", "", "")
+			So(err, ShouldNotBeNil)
+			_, _, err = newAPIKey("ci", "shop", time.Now().Add(-time.Hour).Format(time.RFC3339))
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("expire at their expiry", func() {
+			k := apiKey{ExpiresAt: time.Now().Add(time.Hour).Format(time.RFC3339)}
+			So(k.isExpired(time.Now()), ShouldBeFalse)
+			So(k.isExpired(time.Now().Add(2*time.Hour)), ShouldBeTrue)
+		})
+
+		Convey("are parsed", func() {
+			for _, key := range []string{"", "arc_1", "arc__secret", "arc_1_", "key_1_secret"} {
+				_, ok := parseAPIKeyID(key)
+				So(ok, ShouldBeFalse)
+			}
+			id, ok := parseAPIKeyID("arc_1a2b_se_cret")
+			So(ok, ShouldBeTrue)
+			So(id, ShouldEqual, "1a2b")
+		})
+
+		Convey("are taken from the requests", func() {
+			req := httptest.NewRequest("POST", "/products/_doc", nil)
+			req.Header.Set(apiKeyHeader, "arc_1_secret")
+			key, ok := apiKeyFromRequest(req, false)
+			So(ok, ShouldBeTrue)
+			So(key, ShouldEqual, "arc_1_secret")
+			So(req.Header.Get(apiKeyHeader), ShouldBeEmpty)
+
+			req = httptest.NewRequest("GET", "/products/_search?q=apple&api_key=arc_1_secret", nil)
+			key, ok = apiKeyFromRequest(req, true)
+			So(ok, ShouldBeTrue)
+			So(key, ShouldEqual, "arc_1_secret")
+			So(req.URL.RawQuery, ShouldEqual, "q=apple")
+
+			// the query param is stripped but not accepted for requests that write data
+			req = httptest.NewRequest("POST", "/products/_doc?api_key=arc_1_secret", nil)
+			_, ok = apiKeyFromRequest(req, false)
+			So(ok, ShouldBeFalse)
+			So(req.URL.RawQuery, ShouldBeEmpty)
+		})
+	})
+
+	Convey("Minting api keys", t, func() {
+		shop := &permission.Permission{Username: "shop", Owner: "alice"}
+		asUser := func(username string, isAdmin bool) context.Context {
+			ctx := credential.NewContext(context.Background(), credential.User)
+			return user.NewContext(ctx, &user.User{Username: username, IsAdmin: &isAdmin})
+		}
+
+		Convey("is allowed to the admins and the owner of the permission", func() {
+			ok, err := canMintAPIKey(asUser("admin", true), shop)
+			So(err, ShouldBeNil)
+			So(ok, ShouldBeTrue)
+			ok, err = canMintAPIKey(asUser("alice", false), shop)
+			So(err, ShouldBeNil)
+			So(ok, ShouldBeTrue)
+		})
+
+		Convey("is refused to the other users and the permissions", func() {
+			ok, err := canMintAPIKey(asUser("bob", false), shop)
+			So(err, ShouldBeNil)
+			So(ok, ShouldBeFalse)
+
+			ctx := credential.NewContext(context.Background(), credential.Permission)
+			ctx = permission.NewContext(ctx, &permission.Permission{Username: "alice"})
+			ok, err = canMintAPIKey(ctx, shop)
+			So(err, ShouldBeNil)
+			So(ok, ShouldBeFalse)
+		})
+	})
+
+	Convey("Caching api keys", t, func() {
+		c := newAPIKeyCache()
+		k := apiKey{ID: "1", KeyHash: "hash-1", Username: "shop"}
+
+		Convey("returns the verified keys", func() {
+			c.put(k)
+			cached, ok := c.get(k.KeyHash)
+			So(ok, ShouldBeTrue)
+			So(cached, ShouldResemble, k)
+			_, ok = c.get("hash-2")
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("expires the keys after the check interval", func() {
+			c.keys[k.KeyHash] = cachedAPIKey{key: k, verifiedAt: time.Now().Add(-2 * apiKeyCheckInterval)}
+			_, ok := c.get(k.KeyHash)
+			So(ok, ShouldBeFalse)
+			So(c.keys, ShouldBeEmpty)
+		})
+
+		Convey("removes the keys by id", func() {
+			c.put(k)
+			c.put(apiKey{ID: "2", KeyHash: "hash-2", Username: "shop"})
+			c.remove(k.ID)
+			_, ok := c.get(k.KeyHash)
+			So(ok, ShouldBeFalse)
+			_, ok = c.get("hash-2")
+			So(ok, ShouldBeTrue)
+		})
+	})
+}
@@ -2,47 +2,103 @@ package auth
 
 import (
 	"context"
-	"crypto/rsa"
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
 	"io/ioutil"
 	"os"
+	"strconv"
 	"sync"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 
 	"github.com/appbaseio/arc/middleware"
-	"github.com/appbaseio/arc/model/credential"
 	"github.com/appbaseio/arc/plugins"
-	"github.com/appbaseio/arc/util"
-	"github.com/dgrijalva/jwt-go"
 )
 
 const (
-	logTag                    = "[auth]"
-	envUsersEsIndex           = "USERS_ES_INDEX"
-	defaultUsersEsIndex       = ".users"
-	envEsURL                  = "ES_CLUSTER_URL"
-	envPermissionsEsIndex     = "PERMISSIONS_ES_INDEX"
-	defaultPermissionsEsIndex = ".permissions"
-	envPublicKeyEsIndex       = "PUBLIC_KEY_ES_INDEX"
-	defaultPublicKeyEsIndex   = ".publickey"
-	envJwtRsaPublicKeyLoc     = "JWT_RSA_PUBLIC_KEY_LOC"
-	envJwtRoleKey             = "JWT_ROLE_KEY"
-	settings                  = `{ "settings" : { %s "index.number_of_shards" : 1, "index.number_of_replicas" : %d } }`
-	publicKeyDocID            = "_public_key"
+	logTag                        = "[auth]"
+	envUsersEsIndex               = "USERS_ES_INDEX"
+	defaultUsersEsIndex           = ".users"
+	envEsURL                      = "ES_CLUSTER_URL"
+	envPermissionsEsIndex         = "PERMISSIONS_ES_INDEX"
+	defaultPermissionsEsIndex     = ".permissions"
+	envPublicKeyEsIndex           = "PUBLIC_KEY_ES_INDEX"
+	defaultPublicKeyEsIndex       = ".publickey"
+	envJwtRsaPublicKeyLoc         = "JWT_RSA_PUBLIC_KEY_LOC"
+	envJwtRoleKey                 = "JWT_ROLE_KEY"
+	settings                      = `{ "settings" : { %s "index.number_of_shards" : 1, "index.number_of_replicas" : %d } }`
+	publicKeyDocID                = "_public_key"
+	envCacheSize                  = "CREDENTIAL_CACHE_SIZE"
+	envCacheTTL                   = "CREDENTIAL_CACHE_TTL"
+	envCachePollInterval          = "CREDENTIAL_CACHE_POLL_INTERVAL"
+	defaultCacheSize              = 1000
+	defaultCacheTTL               = 5 * time.Minute
+	defaultCachePollInterval      = 10 * time.Second
+	envIssuersRefreshInterval     = "JWT_ISSUERS_REFRESH_INTERVAL"
+	defaultIssuersRefreshInterval = 5 * time.Minute
+	issuersDocID                  = "_jwt_issuers"
+	signingKeyDocID               = "_arc_signing_key"
+	envAccessTokenTTL             = "SESSION_ACCESS_TOKEN_TTL"
+	envRefreshTokenTTL            = "SESSION_REFRESH_TOKEN_TTL"
+	defaultAccessTokenTTL         = 15 * time.Minute
+	defaultRefreshTokenTTL        = 24 * time.Hour
+	expiredSessionsPurgeInterval  = time.Hour
+	envRevocationsEsIndex         = "REVOCATIONS_ES_INDEX"
+	defaultRevocationsEsIndex     = ".revocations"
+	envRevocationsRefreshInterval = "REVOCATIONS_REFRESH_INTERVAL"
+	defaultRevocationsRefresh     = 10 * time.Second
+	envLockoutThreshold           = "AUTH_LOCKOUT_THRESHOLD"
+	envLockoutIPThreshold         = "AUTH_LOCKOUT_IP_THRESHOLD"
+	envLockoutDuration            = "AUTH_LOCKOUT_DURATION"
+	envLockoutMaxDuration         = "AUTH_LOCKOUT_MAX_DURATION"
+	lockoutPurgeInterval          = time.Minute
+	certBindingsDocID             = "_cert_bindings"
+	envCertBindingsRefresh        = "CERT_BINDINGS_REFRESH_INTERVAL"
+	defaultCertBindingsRefresh    = 10 * time.Second
 )
 
+var defaultLockoutPolicy = lockoutPolicy{
+	usernameThreshold: 5,
+	ipThreshold:       20,
+	duration:          time.Minute,
+	maxDuration:       time.Hour,
+}
+
 var (
 	singleton *Auth
 	once      sync.Once
 )
 
-// Auth (TODO - clear cache after fixed entries: LRU?)
+// Auth authenticates the requests using basic auth or jwt credentials.
 type Auth struct {
-	mu              sync.Mutex
-	credentialCache map[string]credential.AuthCredential
-	jwtRsaPublicKey *rsa.PublicKey
-	jwtRoleKey      string
-	es              authService
+	cache   *credentialCache
+	issuers *issuerSet
+	// issuersMu serializes the modifications of the stored issuers.
+	issuersMu sync.Mutex
+	// the session tokens issued by arc are signed with signingKey
+	signingKid      string
+	signingKey      ed25519.PrivateKey
+	sessionIssuer   *jwtIssuer
+	activeSessions  *sessionCache
+	accessTokenTTL  time.Duration
+	refreshTokenTTL time.Duration
+	revocations     *revocationSet
+	lockouts        *lockoutTracker
+	certBindings    *certBindingSet
+	apiKeys         *apiKeyCache
+	// ldap is set if the users can authenticate against an ldap server
+	ldap      *ldapConfig
+	ldapUsers *ldapCache
+	// oidc is set if the users can log in via an openid connect provider
+	oidc *oidcConfig
+	// totp enforces the second factor of the users with two-factor authentication enabled
+	totp      *totpPolicy
+	totpSteps *usedTOTPSteps
+	// certBindingsMu serializes the modifications of the stored certificate bindings.
+	certBindingsMu sync.Mutex
+	es             authService
 }
 
 // Instance returns the singleton instance of the auth plugin. Instance
@@ -51,7 +107,15 @@ type Auth struct {
 func Instance() *Auth {
 	once.Do(func() {
 		singleton = &Auth{
-			credentialCache: make(map[string]credential.AuthCredential),
+			cache:          newCredentialCache(defaultCacheSize, defaultCacheTTL),
+			issuers:        newIssuerSet(),
+			activeSessions: newSessionCache(),
+			revocations:    newRevocationSet(),
+			lockouts:       newLockoutTracker(defaultLockoutPolicy),
+			certBindings:   newCertBindingSet(),
+			apiKeys:        newAPIKeyCache(),
+			ldapUsers:      newLDAPCache(),
+			totpSteps:      newUsedTOTPSteps(),
 		}
 	})
 	return singleton
@@ -78,13 +142,55 @@ func (a *Auth) InitFunc() error {
 	if publicKeyIndex == "" {
 		publicKeyIndex = defaultPublicKeyEsIndex
 	}
+	revocationIndex := os.Getenv(envRevocationsEsIndex)
+	if revocationIndex == "" {
+		revocationIndex = defaultRevocationsEsIndex
+	}
 	var err error
 
 	// initialize the dao
-	a.es, err = initPlugin(userIndex, permissionIndex)
+	a.es, err = initPlugin(userIndex, permissionIndex, revocationIndex)
+	if err != nil {
+		return err
+	}
+
+	// initialize the credential cache
+	cacheSize, err := envInt(envCacheSize, defaultCacheSize)
+	if err != nil {
+		return err
+	}
+	cacheTTL, err := envDuration(envCacheTTL, defaultCacheTTL)
+	if err != nil {
+		return err
+	}
+	pollInterval, err := envDuration(envCachePollInterval, defaultCachePollInterval)
 	if err != nil {
 		return err
 	}
+	a.cache = newCredentialCache(cacheSize, cacheTTL)
+	if pollInterval > 0 {
+		go a.pollCredentialChanges(pollInterval)
+	}
+
+	// initialize the brute-force protection
+	policy := defaultLockoutPolicy
+	if policy.usernameThreshold, err = envInt(envLockoutThreshold, policy.usernameThreshold); err != nil {
+		return err
+	}
+	if policy.ipThreshold, err = envInt(envLockoutIPThreshold, policy.ipThreshold); err != nil {
+		return err
+	}
+	if policy.duration, err = envDuration(envLockoutDuration, policy.duration); err != nil {
+		return err
+	}
+	if policy.maxDuration, err = envDuration(envLockoutMaxDuration, policy.maxDuration); err != nil {
+		return err
+	}
+	if policy.maxDuration < policy.duration {
+		policy.maxDuration = policy.duration
+	}
+	a.lockouts.setPolicy(policy)
+	go a.lockouts.purgeStale(lockoutPurgeInterval)
 
 	// Create public key index
 	_, err = a.es.createIndex(publicKeyIndex, settings)
@@ -104,28 +210,174 @@ func (a *Auth) InitFunc() error {
 				log.Errorln(logTag, ":unable to read the public key file from environment,", err)
 			}
 			var record = publicKey{}
-			record.PublicKey = string(publicKeyBuf)
-			record.RoleKey = a.jwtRoleKey
+			record.PublicKey = base64.StdEncoding.EncodeToString(publicKeyBuf)
+			record.RoleKey = os.Getenv(envJwtRoleKey)
 			_, err = a.savePublicKey(context.Background(), publicKeyIndex, record)
 			if err != nil {
 				log.Errorln(logTag, ":unable to save public key record from environment,", err)
 			}
 		}
-	} else {
-		publicKeyBuf, err := util.DecodeBase64Key(record.PublicKey)
+	} else if err := a.setLegacyIssuer(record); err != nil {
+		log.Errorln(logTag, ":error parsing public key record,", err)
+	}
+
+	// load the trusted issuers and keep their keys up to date
+	refreshInterval, err := envDuration(envIssuersRefreshInterval, defaultIssuersRefreshInterval)
+	if err != nil {
+		return err
+	}
+	a.reloadIssuers(context.Background())
+	if refreshInterval > 0 {
+		go a.refreshIssuers(refreshInterval)
+	}
+
+	// users can authenticate with their ldap credentials if an ldap server is configured
+	a.ldap, err = ldapConfigFromEnv()
+	if err != nil {
+		return err
+	}
+
+	// users can log in via an openid connect provider if one is configured
+	a.oidc, err = oidcConfigFromEnv()
+	if err != nil {
+		return err
+	}
+
+	a.totp, err = totpPolicyFromEnv()
+	if err != nil {
+		return err
+	}
+
+	// load the client certificate bindings and keep them up to date
+	certBindingsRefresh, err := envDuration(envCertBindingsRefresh, defaultCertBindingsRefresh)
+	if err != nil {
+		return err
+	}
+	if err := a.reloadCertBindings(context.Background()); err != nil {
+		log.Errorln(logTag, ": unable to fetch the certificate bindings:", err)
+	}
+	if certBindingsRefresh > 0 {
+		go a.refreshCertBindings(certBindingsRefresh)
+	}
+
+	// load the key to sign the session tokens with
+	a.accessTokenTTL, err = envDuration(envAccessTokenTTL, defaultAccessTokenTTL)
+	if err != nil {
+		return err
+	}
+	a.refreshTokenTTL, err = envDuration(envRefreshTokenTTL, defaultRefreshTokenTTL)
+	if err != nil {
+		return err
+	}
+	if err := a.initSessions(context.Background()); err != nil {
+		return err
+	}
+	go a.purgeExpiredSessions(expiredSessionsPurgeInterval)
+
+	// load the revoked jwts and keep them up to date
+	_, err = a.es.createIndex(revocationIndex, settings)
+	if err != nil {
+		return err
+	}
+	revocationsRefresh, err := envDuration(envRevocationsRefreshInterval, defaultRevocationsRefresh)
+	if err != nil {
+		return err
+	}
+	if err := a.reloadRevocations(context.Background()); err != nil {
+		return err
+	}
+	if revocationsRefresh > 0 {
+		go a.refreshRevocations(revocationsRefresh)
+	}
+
+	return nil
+}
+
+func envInt(envVar string, defaultValue int) (int, error) {
+	value := os.Getenv(envVar)
+	if value == "" {
+		return defaultValue, nil
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s: invalid value for %s: %v", logTag, envVar, err)
+	}
+	return i, nil
+}
+
+func envDuration(envVar string, defaultValue time.Duration) (time.Duration, error) {
+	value := os.Getenv(envVar)
+	if value == "" {
+		return defaultValue, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s: invalid value for %s: %v", logTag, envVar, err)
+	}
+	return d, nil
+}
+
+// pollCredentialChanges purges the credential cache whenever the users or the permissions
+// are modified, possibly by another arc instance, which is detected by polling the max
+// sequence numbers of the users and permissions indices.
+func (a *Auth) pollCredentialChanges(interval time.Duration) {
+	var lastSeqNo int64 = -1
+	for range time.Tick(interval) {
+		seqNo, err := a.es.getCredentialsSeqNo(context.Background())
 		if err != nil {
-			log.Errorln(logTag, ":error parsing public key record,", err)
+			log.Errorln(logTag, ": unable to fetch the credentials sequence number:", err)
+			continue
 		}
-		a.jwtRsaPublicKey, err = jwt.ParseRSAPublicKeyFromPEM(publicKeyBuf)
-		if err != nil {
-			log.Errorln(logTag, ":error parsing public key record,", err)
+		if lastSeqNo != -1 && seqNo != lastSeqNo {
+			log.Println(logTag, ": credentials modified, purging the credential cache")
+			a.cache.purge()
 		}
-		a.jwtRoleKey = record.RoleKey
+		lastSeqNo = seqNo
 	}
+}
 
+// setLegacyIssuer trusts the tokens signed with the key of the public key record.
+func (a *Auth) setLegacyIssuer(record publicKey) error {
+	legacyIssuer, err := newLegacyIssuer(record)
+	if err != nil {
+		return err
+	}
+	a.issuers.setLegacy(legacyIssuer)
 	return nil
 }
 
+// reloadIssuers loads the issuers, which might have been modified by another arc
+// instance, and fetches the keys of the issuers that were added or modified.
+func (a *Auth) reloadIssuers(ctx context.Context) {
+	if record, err := a.es.getPublicKey(ctx); err == nil {
+		if err := a.setLegacyIssuer(record); err != nil {
+			log.Errorln(logTag, ":error parsing public key record,", err)
+		}
+	}
+	configs, err := a.es.getIssuers(ctx)
+	if err != nil {
+		log.Errorln(logTag, ": unable to fetch the jwt issuers:", err)
+		return
+	}
+	for _, i := range a.issuers.set(configs) {
+		if err := i.refreshKeys(ctx); err != nil {
+			log.Errorln(logTag, ": unable to fetch the keys of issuer", i.config.Name, ":", err)
+		}
+	}
+}
+
+// refreshIssuers periodically reloads the issuers and refreshes their keys, so that
+// rotated keys are picked up before they are used to sign any tokens.
+func (a *Auth) refreshIssuers(interval time.Duration) {
+	for range time.Tick(interval) {
+		ctx := context.Background()
+		a.reloadIssuers(ctx)
+		for _, i := range a.issuers.list() {
+			i.refreshKeysIfStale(ctx)
+		}
+	}
+}
+
 // Routes returns an empty slices since the plugin solely acts as a middleware.
 func (a *Auth) Routes() []plugins.Route {
 	return a.routes()
@@ -0,0 +1,116 @@
+package auth
+
+import (
+	clist "container/list"
+	"sync"
+	"time"
+
+	"github.com/appbaseio/arc/model/credential"
+)
+
+// credentialCache is a size bounded LRU cache of credentials where each entry
+// expires after a fixed TTL. Credentials are cached against their username and
+// role permissions are additionally cached against their role.
+type credentialCache struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	entries map[string]*clist.Element
+	// order holds the entries from the most to the least recently used.
+	order *clist.List
+}
+
+type cacheEntry struct {
+	key       string
+	value     credential.AuthCredential
+	expiresAt time.Time
+}
+
+func newCredentialCache(size int, ttl time.Duration) *credentialCache {
+	return &credentialCache{
+		size:    size,
+		ttl:     ttl,
+		entries: make(map[string]*clist.Element),
+		order:   clist.New(),
+	}
+}
+
+func usernameKey(username string) string {
+	return "username:" + username
+}
+
+func roleKey(role string) string {
+	return "role:" + role
+}
+
+// get returns the credential cached against the key, unless it has expired.
+func (c *credentialCache) get(key string) (credential.AuthCredential, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*cacheEntry)
+	if time.Now().After(entry.expiresAt) {
+		c.removeElement(elem)
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return entry.value, true
+}
+
+// put caches the credential against the key, evicting the least recently used
+// entry if the cache is full.
+func (c *credentialCache) put(key string, value credential.AuthCredential) {
+	if c.size <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	expiresAt := time.Now().Add(c.ttl)
+	if elem, ok := c.entries[key]; ok {
+		entry := elem.Value.(*cacheEntry)
+		entry.value, entry.expiresAt = value, expiresAt
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&cacheEntry{key, value, expiresAt})
+	for c.order.Len() > c.size {
+		c.removeElement(c.order.Back())
+	}
+}
+
+// remove removes the entry cached against the key.
+func (c *credentialCache) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		c.removeElement(elem)
+	}
+}
+
+// removeCredential removes every entry holding the credential with the given id,
+// i.e. the entry against its username as well as the one against its role.
+func (c *credentialCache) removeCredential(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, elem := range c.entries {
+		if elem.Value.(*cacheEntry).value.Id() == id {
+			c.removeElement(elem)
+		}
+	}
+}
+
+// purge removes all the entries from the cache.
+func (c *credentialCache) purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]*clist.Element)
+	c.order.Init()
+}
+
+func (c *credentialCache) removeElement(elem *clist.Element) {
+	c.order.Remove(elem)
+	delete(c.entries, elem.Value.(*cacheEntry).key)
+}
@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/appbaseio/arc/model/permission"
+	"github.com/appbaseio/arc/model/user"
+)
+
+func TestCredentialCache(t *testing.T) {
+	Convey("Caching credentials", t, func() {
+		Convey("evicts the least recently used entries", func() {
+			c := newCredentialCache(2, time.Minute)
+			c.put(usernameKey("a"), &user.User{Username: "a"})
+			c.put(usernameKey("b"), &user.User{Username: "b"})
+			// using "a" makes "b" the least recently used
+			_, ok := c.get(usernameKey("a"))
+			So(ok, ShouldBeTrue)
+			c.put(usernameKey("c"), &user.User{Username: "c"})
+
+			_, ok = c.get(usernameKey("b"))
+			So(ok, ShouldBeFalse)
+			for _, username := range []string{"a", "c"} {
+				cached, ok := c.get(usernameKey(username))
+				So(ok, ShouldBeTrue)
+				So(cached.Id(), ShouldEqual, username)
+			}
+			So(c.order.Len(), ShouldEqual, 2)
+			So(c.entries, ShouldHaveLength, 2)
+		})
+
+		Convey("updates the entries in place", func() {
+			c := newCredentialCache(2, time.Minute)
+			c.put(usernameKey("a"), &user.User{Username: "a"})
+			c.put(usernameKey("a"), &user.User{Username: "a", Email: "a@example.com"})
+			cached, ok := c.get(usernameKey("a"))
+			So(ok, ShouldBeTrue)
+			So(cached.(*user.User).Email, ShouldEqual, "a@example.com")
+			So(c.order.Len(), ShouldEqual, 1)
+		})
+
+		Convey("expires the entries after the ttl", func() {
+			c := newCredentialCache(2, time.Millisecond)
+			c.put(usernameKey("a"), &user.User{Username: "a"})
+			time.Sleep(5 * time.Millisecond)
+			_, ok := c.get(usernameKey("a"))
+			So(ok, ShouldBeFalse)
+			So(c.entries, ShouldBeEmpty)
+			So(c.order.Len(), ShouldEqual, 0)
+		})
+
+		Convey("caches nothing without a size", func() {
+			c := newCredentialCache(0, time.Minute)
+			c.put(usernameKey("a"), &user.User{Username: "a"})
+			_, ok := c.get(usernameKey("a"))
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("removes every entry of a credential", func() {
+			c := newCredentialCache(10, time.Minute)
+			p := &permission.Permission{Username: "shop", Role: "shopper"}
+			c.put(usernameKey(p.Username), p)
+			c.put(roleKey(p.Role), p)
+			c.put(usernameKey("other"), &permission.Permission{Username: "other"})
+
+			c.removeCredential(p.Id())
+			_, ok := c.get(usernameKey(p.Username))
+			So(ok, ShouldBeFalse)
+			_, ok = c.get(roleKey(p.Role))
+			So(ok, ShouldBeFalse)
+			_, ok = c.get(usernameKey("other"))
+			So(ok, ShouldBeTrue)
+
+			c.remove(usernameKey("other"))
+			So(c.entries, ShouldBeEmpty)
+		})
+
+		Convey("purges all the entries", func() {
+			c := newCredentialCache(10, time.Minute)
+			c.put(usernameKey("a"), &user.User{Username: "a"})
+			c.put(roleKey("r"), &permission.Permission{Username: "b", Role: "r"})
+			c.purge()
+			So(c.entries, ShouldBeEmpty)
+			So(c.order.Len(), ShouldEqual, 0)
+		})
+	})
+}
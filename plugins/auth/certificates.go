@@ -0,0 +1,163 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// certBinding maps the client certificates with the given subject common name,
+// subject alternative name or sha-256 fingerprint to an arc user or permission.
+type certBinding struct {
+	Name        string `json:"name"`
+	CommonName  string `json:"common_name,omitempty"`
+	SAN         string `json:"san,omitempty"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+	Username    string `json:"username"`
+	CreatedAt   string `json:"created_at,omitempty"`
+}
+
+type certBindingsRecord struct {
+	Bindings []certBinding `json:"bindings"`
+}
+
+// normalizeFingerprint lower cases the hex encoded fingerprint and strips its separators.
+func normalizeFingerprint(fingerprint string) string {
+	return strings.ToLower(strings.NewReplacer(":", "", " ", "").Replace(fingerprint))
+}
+
+// validate checks that the binding matches the certificates by exactly one attribute.
+func (b *certBinding) validate() error {
+	var n int
+	for _, value := range []string{b.CommonName, b.SAN, b.Fingerprint} {
+		if value != "" {
+			n++
+		}
+	}
+	if n != 1 {
+		return fmt.Errorf(`exactly one of "common_name", "san" or "fingerprint" must be provided`)
+	}
+	if b.Username == "" {
+		return fmt.Errorf(`"username" must be provided`)
+	}
+	if b.Fingerprint != "" {
+		b.Fingerprint = normalizeFingerprint(b.Fingerprint)
+		if raw, err := hex.DecodeString(b.Fingerprint); err != nil || len(raw) != sha256.Size {
+			return fmt.Errorf(`"fingerprint" must be the hex encoded sha-256 fingerprint of the certificate`)
+		}
+	}
+	if b.CreatedAt == "" {
+		b.CreatedAt = time.Now().Format(time.RFC3339)
+	}
+	return nil
+}
+
+// certFingerprint returns the hex encoded sha-256 fingerprint of the certificate.
+func certFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// certSANs returns the subject alternative names of the certificate.
+func certSANs(cert *x509.Certificate) []string {
+	var sans []string
+	sans = append(sans, cert.DNSNames...)
+	sans = append(sans, cert.EmailAddresses...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		sans = append(sans, uri.String())
+	}
+	return sans
+}
+
+// certBindingSet holds the certificate bindings in memory, indexed by the attribute
+// they match the certificates by.
+type certBindingSet struct {
+	mu           sync.RWMutex
+	fingerprints map[string]string
+	sans         map[string]string
+	commonNames  map[string]string
+}
+
+func newCertBindingSet() *certBindingSet {
+	s := &certBindingSet{}
+	s.set(nil)
+	return s
+}
+
+// set replaces the bindings held by the set.
+func (s *certBindingSet) set(bindings []certBinding) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fingerprints = make(map[string]string)
+	s.sans = make(map[string]string)
+	s.commonNames = make(map[string]string)
+	for _, b := range bindings {
+		switch {
+		case b.Fingerprint != "":
+			s.fingerprints[normalizeFingerprint(b.Fingerprint)] = b.Username
+		case b.SAN != "":
+			s.sans[b.SAN] = b.Username
+		case b.CommonName != "":
+			s.commonNames[b.CommonName] = b.Username
+		}
+	}
+}
+
+// usernameFor returns the username bound to the verified client certificate of the
+// connection. The fingerprint bindings take precedence over the san bindings, which
+// take precedence over the common name bindings.
+func (s *certBindingSet) usernameFor(state *tls.ConnectionState) (string, bool) {
+	// certificates that weren't verified against the client CA bundle are not trusted
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return "", false
+	}
+	cert := state.VerifiedChains[0][0]
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if username, ok := s.fingerprints[certFingerprint(cert)]; ok {
+		return username, true
+	}
+	for _, san := range certSANs(cert) {
+		if username, ok := s.sans[san]; ok {
+			return username, true
+		}
+	}
+	if cert.Subject.CommonName != "" {
+		if username, ok := s.commonNames[cert.Subject.CommonName]; ok {
+			return username, true
+		}
+	}
+	return "", false
+}
+
+// reloadCertBindings loads the certificate bindings, which might have been modified
+// by another arc instance.
+func (a *Auth) reloadCertBindings(ctx context.Context) error {
+	bindings, err := a.es.getCertBindings(ctx)
+	if err != nil {
+		return err
+	}
+	a.certBindings.set(bindings)
+	return nil
+}
+
+// refreshCertBindings periodically reloads the certificate bindings.
+func (a *Auth) refreshCertBindings(interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := a.reloadCertBindings(context.Background()); err != nil {
+			log.Errorln(logTag, ": unable to fetch the certificate bindings:", err)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/appbaseio/arc/model/credential"
+	"github.com/appbaseio/arc/model/permission"
+	"github.com/appbaseio/arc/model/user"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func testCertificate(t *testing.T, commonName string, dnsNames ...string) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	raw, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func verified(cert *x509.Certificate) *tls.ConnectionState {
+	return &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+}
+
+func TestCertBindings(t *testing.T) {
+	cert := testCertificate(t, "billing", "billing.internal")
+	fingerprint := certFingerprint(cert)
+
+	Convey("Binding client certificates", t, func() {
+		Convey("validates the bindings", func() {
+			b := certBinding{Name: "billing", Fingerprint: strings.ToUpper(fingerprint[:2]) + ":" + fingerprint[2:], Username: "billing"}
+			So(b.validate(), ShouldBeNil)
+			So(b.Fingerprint, ShouldEqual, fingerprint)
+			So(b.CreatedAt, ShouldNotBeEmpty)
+
+			So((&certBinding{Username: "billing"}).validate(), ShouldNotBeNil)
+			So((&certBinding{CommonName: "billing", SAN: "billing.internal", Username: "billing"}).validate(), ShouldNotBeNil)
+			So((&certBinding{CommonName: "billing"}).validate(), ShouldNotBeNil)
+			So((&certBinding{Fingerprint: "abcd", Username: "billing"}).validate(), ShouldNotBeNil)
+		})
+
+		Convey("matches the fingerprint before the san and the common name", func() {
+			s := newCertBindingSet()
+			s.set([]certBinding{
+				{CommonName: "billing", Username: "by-cn"},
+				{SAN: "billing.internal", Username: "by-san"},
+			})
+			username, ok := s.usernameFor(verified(cert))
+			So(ok, ShouldBeTrue)
+			So(username, ShouldEqual, "by-san")
+
+			s.set([]certBinding{
+				{CommonName: "billing", Username: "by-cn"},
+				{SAN: "billing.internal", Username: "by-san"},
+				{Fingerprint: fingerprint, Username: "by-fingerprint"},
+			})
+			username, _ = s.usernameFor(verified(cert))
+			So(username, ShouldEqual, "by-fingerprint")
+
+			s.set([]certBinding{{CommonName: "billing", Username: "by-cn"}})
+			username, _ = s.usernameFor(verified(cert))
+			So(username, ShouldEqual, "by-cn")
+		})
+
+		Convey("only trusts the verified certificates", func() {
+			s := newCertBindingSet()
+			s.set([]certBinding{{CommonName: "billing", Username: "billing"}})
+			_, ok := s.usernameFor(&tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}})
+			So(ok, ShouldBeFalse)
+			_, ok = s.usernameFor(nil)
+			So(ok, ShouldBeFalse)
+			_, ok = s.usernameFor(verified(testCertificate(t, "other")))
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("is only allowed to the admin users", func() {
+			serve := func(ctx context.Context) int {
+				w := httptest.NewRecorder()
+				req := httptest.NewRequest("GET", "/_cert_bindings", nil).WithContext(ctx)
+				isAdmin(func(w http.ResponseWriter, req *http.Request) {})(w, req)
+				return w.Code
+			}
+			admin, notAdmin := true, false
+			ctx := credential.NewContext(context.Background(), credential.User)
+			So(serve(user.NewContext(ctx, &user.User{Username: "admin", IsAdmin: &admin})), ShouldEqual, http.StatusOK)
+			So(serve(user.NewContext(ctx, &user.User{Username: "shop", IsAdmin: &notAdmin})), ShouldEqual, http.StatusUnauthorized)
+
+			ctx = credential.NewContext(context.Background(), credential.Permission)
+			So(serve(permission.NewContext(ctx, &permission.Permission{Username: "shop"})), ShouldEqual, http.StatusUnauthorized)
+		})
+	})
+}
@@ -4,7 +4,9 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"net/url"
 	"os"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 
@@ -12,24 +14,33 @@ import (
 	"github.com/appbaseio/arc/model/permission"
 	"github.com/appbaseio/arc/model/user"
 	"github.com/appbaseio/arc/util"
+	es7 "github.com/olivere/elastic/v7"
 )
 
 type elasticsearch struct {
 	userIndex, userType             string
 	permissionIndex, permissionType string
+	revocationIndex                 string
 }
 
 type publicKey struct {
-	PublicKey string `json:"public_key"`
-	RoleKey   string `json:"role_key"`
+	PublicKey  string   `json:"public_key"`
+	Secret     string   `json:"secret,omitempty"`
+	RoleKey    string   `json:"role_key"`
+	Algorithms []string `json:"algorithms,omitempty"`
 }
 
-func initPlugin(userIndex, permissionIndex string) (*elasticsearch, error) {
+type issuersRecord struct {
+	Issuers []issuer `json:"issuers"`
+}
+
+func initPlugin(userIndex, permissionIndex, revocationIndex string) (*elasticsearch, error) {
 	// auth only has to establish a connection to es, users, permissions
 	// plugin handles the creation of their respective meta indices
 	es := &elasticsearch{
 		userIndex, "_doc",
 		permissionIndex, "_doc",
+		revocationIndex,
 	}
 
 	return es, nil
@@ -96,6 +107,340 @@ func (es *elasticsearch) getPublicKey(ctx context.Context) (publicKey, error) {
 	}
 }
 
+// Get the trusted jwt issuers
+func (es *elasticsearch) getIssuers(ctx context.Context) ([]issuer, error) {
+	publicKeyIndex := os.Getenv(envPublicKeyEsIndex)
+	if publicKeyIndex == "" {
+		publicKeyIndex = defaultPublicKeyEsIndex
+	}
+	switch util.GetVersion() {
+	case 6:
+		return es.getIssuersEs6(ctx, publicKeyIndex)
+	default:
+		return es.getIssuersEs7(ctx, publicKeyIndex)
+	}
+}
+
+// Replace the trusted jwt issuers
+func (es *elasticsearch) saveIssuers(ctx context.Context, issuers []issuer) error {
+	publicKeyIndex := os.Getenv(envPublicKeyEsIndex)
+	if publicKeyIndex == "" {
+		publicKeyIndex = defaultPublicKeyEsIndex
+	}
+	if issuers == nil {
+		issuers = []issuer{}
+	}
+	_, err := util.GetClient7().
+		Index().
+		Index(publicKeyIndex).
+		BodyJson(issuersRecord{issuers}).
+		Id(issuersDocID).
+		Refresh("wait_for").
+		Do(ctx)
+	return err
+}
+
+// Get the client certificate bindings
+func (es *elasticsearch) getCertBindings(ctx context.Context) ([]certBinding, error) {
+	publicKeyIndex := os.Getenv(envPublicKeyEsIndex)
+	if publicKeyIndex == "" {
+		publicKeyIndex = defaultPublicKeyEsIndex
+	}
+	switch util.GetVersion() {
+	case 6:
+		return es.getCertBindingsEs6(ctx, publicKeyIndex)
+	default:
+		return es.getCertBindingsEs7(ctx, publicKeyIndex)
+	}
+}
+
+// Replace the client certificate bindings
+func (es *elasticsearch) saveCertBindings(ctx context.Context, bindings []certBinding) error {
+	publicKeyIndex := os.Getenv(envPublicKeyEsIndex)
+	if publicKeyIndex == "" {
+		publicKeyIndex = defaultPublicKeyEsIndex
+	}
+	if bindings == nil {
+		bindings = []certBinding{}
+	}
+	_, err := util.GetClient7().
+		Index().
+		Index(publicKeyIndex).
+		BodyJson(certBindingsRecord{bindings}).
+		Id(certBindingsDocID).
+		Refresh("wait_for").
+		Do(ctx)
+	return err
+}
+
+// Get the key the session tokens are signed with
+func (es *elasticsearch) getSigningKey(ctx context.Context) (*signingKeyRecord, error) {
+	publicKeyIndex := os.Getenv(envPublicKeyEsIndex)
+	if publicKeyIndex == "" {
+		publicKeyIndex = defaultPublicKeyEsIndex
+	}
+	switch util.GetVersion() {
+	case 6:
+		return es.getSigningKeyEs6(ctx, publicKeyIndex)
+	default:
+		return es.getSigningKeyEs7(ctx, publicKeyIndex)
+	}
+}
+
+// Create the key the session tokens are signed with, unless it already exists
+func (es *elasticsearch) createSigningKey(ctx context.Context, record signingKeyRecord) (bool, error) {
+	publicKeyIndex := os.Getenv(envPublicKeyEsIndex)
+	if publicKeyIndex == "" {
+		publicKeyIndex = defaultPublicKeyEsIndex
+	}
+	_, err := util.GetClient7().
+		Index().
+		Index(publicKeyIndex).
+		BodyJson(record).
+		Id(signingKeyDocID).
+		OpType("create").
+		Refresh("wait_for").
+		Do(ctx)
+	if es7.IsConflict(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Get the session with the given id, nil if it doesn't exist
+func (es *elasticsearch) getSession(ctx context.Context, sid string) (*session, error) {
+	publicKeyIndex := os.Getenv(envPublicKeyEsIndex)
+	if publicKeyIndex == "" {
+		publicKeyIndex = defaultPublicKeyEsIndex
+	}
+	switch util.GetVersion() {
+	case 6:
+		return es.getSessionEs6(ctx, publicKeyIndex, sid)
+	default:
+		return es.getSessionEs7(ctx, publicKeyIndex, sid)
+	}
+}
+
+// Create or update a session, a session fetched earlier is only updated if it hasn't
+// been modified since
+func (es *elasticsearch) saveSession(ctx context.Context, sid string, s *session) error {
+	publicKeyIndex := os.Getenv(envPublicKeyEsIndex)
+	if publicKeyIndex == "" {
+		publicKeyIndex = defaultPublicKeyEsIndex
+	}
+	req := util.GetClient7().
+		Index().
+		Index(publicKeyIndex).
+		BodyJson(s).
+		Id(sessionDocPrefix + sid).
+		Refresh("wait_for")
+	if s.primaryTerm > 0 {
+		req = req.IfSeqNo(s.seqNo).IfPrimaryTerm(s.primaryTerm)
+	} else {
+		req = req.OpType("create")
+	}
+	_, err := req.Do(ctx)
+	if es7.IsConflict(err) {
+		return errInvalidRefreshToken
+	}
+	return err
+}
+
+// Delete the session with the given id
+func (es *elasticsearch) deleteSession(ctx context.Context, sid string) error {
+	publicKeyIndex := os.Getenv(envPublicKeyEsIndex)
+	if publicKeyIndex == "" {
+		publicKeyIndex = defaultPublicKeyEsIndex
+	}
+	_, err := util.GetClient7().
+		Delete().
+		Index(publicKeyIndex).
+		Id(sessionDocPrefix + sid).
+		Refresh("wait_for").
+		Do(ctx)
+	if es7.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// Delete the sessions whose refresh tokens have expired
+func (es *elasticsearch) deleteExpiredSessions(ctx context.Context) error {
+	publicKeyIndex := os.Getenv(envPublicKeyEsIndex)
+	if publicKeyIndex == "" {
+		publicKeyIndex = defaultPublicKeyEsIndex
+	}
+	query := es7.NewBoolQuery().Filter(
+		es7.NewTermQuery("type.keyword", sessionDocType),
+		es7.NewRangeQuery("expires_at").Lt(time.Now().UnixNano()/int64(time.Millisecond)),
+	)
+	_, err := util.GetClient7().
+		DeleteByQuery(publicKeyIndex).
+		Query(query).
+		ProceedOnVersionConflict().
+		Do(ctx)
+	return err
+}
+
+// Get the api key with the given id, nil if it doesn't exist
+func (es *elasticsearch) getAPIKey(ctx context.Context, id string) (*apiKey, error) {
+	publicKeyIndex := os.Getenv(envPublicKeyEsIndex)
+	if publicKeyIndex == "" {
+		publicKeyIndex = defaultPublicKeyEsIndex
+	}
+	switch util.GetVersion() {
+	case 6:
+		return es.getAPIKeyEs6(ctx, publicKeyIndex, id)
+	default:
+		return es.getAPIKeyEs7(ctx, publicKeyIndex, id)
+	}
+}
+
+// Get all the api keys
+func (es *elasticsearch) getAPIKeys(ctx context.Context) ([]apiKey, error) {
+	publicKeyIndex := os.Getenv(envPublicKeyEsIndex)
+	if publicKeyIndex == "" {
+		publicKeyIndex = defaultPublicKeyEsIndex
+	}
+	switch util.GetVersion() {
+	case 6:
+		return es.getAPIKeysEs6(ctx, publicKeyIndex)
+	default:
+		return es.getAPIKeysEs7(ctx, publicKeyIndex)
+	}
+}
+
+// Create an api key
+func (es *elasticsearch) createAPIKey(ctx context.Context, k apiKey) error {
+	publicKeyIndex := os.Getenv(envPublicKeyEsIndex)
+	if publicKeyIndex == "" {
+		publicKeyIndex = defaultPublicKeyEsIndex
+	}
+	_, err := util.GetClient7().
+		Index().
+		Index(publicKeyIndex).
+		BodyJson(k).
+		Id(apiKeyDocPrefix + k.ID).
+		OpType("create").
+		Refresh("wait_for").
+		Do(ctx)
+	return err
+}
+
+// Delete the api key with the given id, returns false if it doesn't exist
+func (es *elasticsearch) deleteAPIKey(ctx context.Context, id string) (bool, error) {
+	publicKeyIndex := os.Getenv(envPublicKeyEsIndex)
+	if publicKeyIndex == "" {
+		publicKeyIndex = defaultPublicKeyEsIndex
+	}
+	_, err := util.GetClient7().
+		Delete().
+		Index(publicKeyIndex).
+		Id(apiKeyDocPrefix + id).
+		Refresh("wait_for").
+		Do(ctx)
+	if es7.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Get the totp enrollment of the user, nil if it doesn't exist
+func (es *elasticsearch) getTOTPEnrollment(ctx context.Context, username string) (*totpEnrollment, error) {
+	publicKeyIndex := os.Getenv(envPublicKeyEsIndex)
+	if publicKeyIndex == "" {
+		publicKeyIndex = defaultPublicKeyEsIndex
+	}
+	switch util.GetVersion() {
+	case 6:
+		return es.getTOTPEnrollmentEs6(ctx, publicKeyIndex, username)
+	default:
+		return es.getTOTPEnrollmentEs7(ctx, publicKeyIndex, username)
+	}
+}
+
+// Create or update the totp enrollment of a user, an enrollment fetched earlier is
+// only updated if it hasn't been modified since
+func (es *elasticsearch) saveTOTPEnrollment(ctx context.Context, e *totpEnrollment) error {
+	publicKeyIndex := os.Getenv(envPublicKeyEsIndex)
+	if publicKeyIndex == "" {
+		publicKeyIndex = defaultPublicKeyEsIndex
+	}
+	req := util.GetClient7().
+		Index().
+		Index(publicKeyIndex).
+		BodyJson(e).
+		Id(totpDocPrefix + e.Username).
+		Refresh("wait_for")
+	if e.primaryTerm > 0 {
+		req = req.IfSeqNo(e.seqNo).IfPrimaryTerm(e.primaryTerm)
+	} else {
+		req = req.OpType("create")
+	}
+	_, err := req.Do(ctx)
+	if es7.IsConflict(err) {
+		return errTOTPModified
+	}
+	return err
+}
+
+// Delete the totp enrollment of the user
+func (es *elasticsearch) deleteTOTPEnrollment(ctx context.Context, username string) error {
+	publicKeyIndex := os.Getenv(envPublicKeyEsIndex)
+	if publicKeyIndex == "" {
+		publicKeyIndex = defaultPublicKeyEsIndex
+	}
+	_, err := util.GetClient7().
+		Delete().
+		Index(publicKeyIndex).
+		Id(totpDocPrefix + username).
+		Refresh("wait_for").
+		Do(ctx)
+	if es7.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// Get all the jwt revocations
+func (es *elasticsearch) getRevocations(ctx context.Context) ([]revocation, error) {
+	switch util.GetVersion() {
+	case 6:
+		return es.getRevocationsEs6(ctx)
+	default:
+		return es.getRevocationsEs7(ctx)
+	}
+}
+
+// Save a jwt revocation
+func (es *elasticsearch) saveRevocation(ctx context.Context, r revocation) error {
+	_, err := util.GetClient7().
+		Index().
+		Index(es.revocationIndex).
+		BodyJson(r).
+		Id(r.ID).
+		Refresh("wait_for").
+		Do(ctx)
+	return err
+}
+
+// Delete a jwt revocation
+func (es *elasticsearch) deleteRevocation(ctx context.Context, id string) error {
+	_, err := util.GetClient7().
+		Delete().
+		Index(es.revocationIndex).
+		Id(id).
+		Refresh("wait_for").
+		Do(ctx)
+	return err
+}
+
 func (es *elasticsearch) getCredential(ctx context.Context, username string) (credential.AuthCredential, error) {
 	switch util.GetVersion() {
 	case 6:
@@ -105,6 +450,84 @@ func (es *elasticsearch) getCredential(ctx context.Context, username string) (cr
 	}
 }
 
+// getCredentialsSeqNo returns the sum of the max sequence numbers of the users and
+// permissions indices, which changes whenever a user or permission is modified.
+func (es *elasticsearch) getCredentialsSeqNo(ctx context.Context) (int64, error) {
+	params := url.Values{}
+	params.Set("level", "shards")
+	params.Set("filter_path", "indices.*.shards.*.routing.primary,indices.*.shards.*.seq_no.max_seq_no")
+	response, err := util.GetClient7().PerformRequest(ctx, es7.PerformRequestOptions{
+		Method: "GET",
+		Path:   fmt.Sprintf("/%s,%s/_stats", es.userIndex, es.permissionIndex),
+		Params: params,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var stats struct {
+		Indices map[string]struct {
+			Shards map[string][]struct {
+				Routing struct {
+					Primary bool `json:"primary"`
+				} `json:"routing"`
+				SeqNo struct {
+					MaxSeqNo int64 `json:"max_seq_no"`
+				} `json:"seq_no"`
+			} `json:"shards"`
+		} `json:"indices"`
+	}
+	if err := json.Unmarshal(response.Body, &stats); err != nil {
+		return 0, err
+	}
+
+	var seqNo int64
+	for _, index := range stats.Indices {
+		for _, copies := range index.Shards {
+			for _, shard := range copies {
+				if shard.Routing.Primary {
+					seqNo += shard.SeqNo.MaxSeqNo
+				}
+			}
+		}
+	}
+	return seqNo, nil
+}
+
+// rehashScript replaces the password hash, unless the password was changed in the meantime.
+const rehashScript = `
+if (ctx._source.password == params.old_hash) {
+	ctx._source.password = params.hash;
+	ctx._source.password_hash_type = params.hash_type;
+} else {
+	ctx.op = "none";
+}`
+
+// Replace the password hash of the user or permission with a hash of the same password
+func (es *elasticsearch) updatePasswordHash(ctx context.Context, obj credential.AuthCredential, hash, hashType string) error {
+	var index, docType, id, oldHash string
+	switch c := obj.(type) {
+	case *user.User:
+		index, docType, id, oldHash = es.userIndex, es.userType, c.Username, c.Password
+	case *permission.Permission:
+		index, docType, id, oldHash = es.permissionIndex, es.permissionType, c.Username, c.Password
+	default:
+		return fmt.Errorf("unsupported credential type %T", obj)
+	}
+	_, err := util.GetClient7().Update().
+		Index(index).
+		Type(docType).
+		Id(id).
+		Script(es7.NewScript(rehashScript).Params(map[string]interface{}{
+			"old_hash":  oldHash,
+			"hash":      hash,
+			"hash_type": hashType,
+		})).
+		Refresh("wait_for").
+		Do(ctx)
+	return err
+}
+
 func (es *elasticsearch) putUser(ctx context.Context, u user.User) (bool, error) {
 	_, err := util.GetClient7().Index().
 		Index(es.userIndex).
@@ -203,6 +626,10 @@ func (es *elasticsearch) getRolePermission(ctx context.Context, role string) (*p
 	if err != nil {
 		return nil, err
 	}
+	if data == nil {
+		// no permission has the role
+		return nil, nil
+	}
 
 	var p permission.Permission
 	err = json.Unmarshal(data, &p)
@@ -32,6 +32,44 @@ func (es *elasticsearch) getPublicKeyEs6(ctx context.Context, publicKeyIndex, pu
 	return record, nil
 }
 
+func (es *elasticsearch) getIssuersEs6(ctx context.Context, publicKeyIndex string) ([]issuer, error) {
+	response, err := util.GetClient6().Get().
+		Index(publicKeyIndex).
+		Id(issuersDocID).
+		Do(ctx)
+	if es6.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var record issuersRecord
+	err = json.Unmarshal(*response.Source, &record)
+	if err != nil {
+		return nil, err
+	}
+	return record.Issuers, nil
+}
+
+func (es *elasticsearch) getCertBindingsEs6(ctx context.Context, publicKeyIndex string) ([]certBinding, error) {
+	response, err := util.GetClient6().Get().
+		Index(publicKeyIndex).
+		Id(certBindingsDocID).
+		Do(ctx)
+	if es6.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var record certBindingsRecord
+	err = json.Unmarshal(*response.Source, &record)
+	if err != nil {
+		return nil, err
+	}
+	return record.Bindings, nil
+}
+
 func (es *elasticsearch) getCredentialEs6(ctx context.Context, username string) (credential.AuthCredential, error) {
 	matchUsername := es6.NewTermQuery("username.keyword", username)
 
@@ -95,3 +133,133 @@ func (es *elasticsearch) getRawRolePermissionEs6(ctx context.Context, role strin
 	}
 	return nil, nil
 }
+
+func (es *elasticsearch) getSigningKeyEs6(ctx context.Context, publicKeyIndex string) (*signingKeyRecord, error) {
+	response, err := util.GetClient6().Get().
+		Index(publicKeyIndex).
+		Id(signingKeyDocID).
+		Do(ctx)
+	if es6.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var record signingKeyRecord
+	err = json.Unmarshal(*response.Source, &record)
+	if err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+func (es *elasticsearch) getSessionEs6(ctx context.Context, publicKeyIndex, sid string) (*session, error) {
+	response, err := util.GetClient6().Get().
+		Index(publicKeyIndex).
+		Id(sessionDocPrefix + sid).
+		Do(ctx)
+	if es6.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var s session
+	err = json.Unmarshal(*response.Source, &s)
+	if err != nil {
+		return nil, err
+	}
+	if s.Type != sessionDocType {
+		return nil, nil
+	}
+	if response.SeqNo != nil && response.PrimaryTerm != nil {
+		s.seqNo, s.primaryTerm = *response.SeqNo, *response.PrimaryTerm
+	}
+	return &s, nil
+}
+
+func (es *elasticsearch) getAPIKeyEs6(ctx context.Context, publicKeyIndex, id string) (*apiKey, error) {
+	response, err := util.GetClient6().Get().
+		Index(publicKeyIndex).
+		Id(apiKeyDocPrefix + id).
+		Do(ctx)
+	if es6.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var k apiKey
+	err = json.Unmarshal(*response.Source, &k)
+	if err != nil {
+		return nil, err
+	}
+	if k.Type != apiKeyDocType {
+		return nil, nil
+	}
+	return &k, nil
+}
+
+func (es *elasticsearch) getAPIKeysEs6(ctx context.Context, publicKeyIndex string) ([]apiKey, error) {
+	resp, err := util.GetClient6().Search().
+		Index(publicKeyIndex).
+		Query(es6.NewTermQuery("type.keyword", apiKeyDocType)).
+		Size(10000).
+		Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var keys []apiKey
+	for _, hit := range resp.Hits.Hits {
+		var k apiKey
+		if err := json.Unmarshal(*hit.Source, &k); err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+func (es *elasticsearch) getTOTPEnrollmentEs6(ctx context.Context, publicKeyIndex, username string) (*totpEnrollment, error) {
+	response, err := util.GetClient6().Get().
+		Index(publicKeyIndex).
+		Id(totpDocPrefix + username).
+		Do(ctx)
+	if es6.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var e totpEnrollment
+	err = json.Unmarshal(*response.Source, &e)
+	if err != nil {
+		return nil, err
+	}
+	if e.Type != totpDocType {
+		return nil, nil
+	}
+	if response.SeqNo != nil && response.PrimaryTerm != nil {
+		e.seqNo, e.primaryTerm = *response.SeqNo, *response.PrimaryTerm
+	}
+	return &e, nil
+}
+
+func (es *elasticsearch) getRevocationsEs6(ctx context.Context) ([]revocation, error) {
+	resp, err := util.GetClient6().Search().
+		Index(es.revocationIndex).
+		Size(10000).
+		Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var revocations []revocation
+	for _, hit := range resp.Hits.Hits {
+		var r revocation
+		if err := json.Unmarshal(*hit.Source, &r); err != nil {
+			return nil, err
+		}
+		revocations = append(revocations, r)
+	}
+	return revocations, nil
+}
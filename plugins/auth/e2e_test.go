@@ -1,8 +1,12 @@
 package auth
 
 import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
 	"net/http"
 	"testing"
+	"time"
 
 	"github.com/appbaseio/arc/model/category"
 	"github.com/appbaseio/arc/model/op"
@@ -62,21 +66,23 @@ var defaultAdminLimits = permission.Limits{
 }
 
 var createPermissionResponse = map[string]interface{}{
-	"owner":          "foo",
-	"creator":        "foo",
-	"role":           "admin",
-	"categories":     adminCategories,
-	"acls":           category.ACLsFor(adminCategories...),
-	"ops":            adminOps,
-	"indices":        []string{"*"},
-	"sources":        []string{"0.0.0.0/0"},
-	"referers":       []string{"*"},
-	"ttl":            -1,
-	"limits":         &defaultAdminLimits,
-	"description":    "TEST PERMISSION WITH ROLE",
-	"include_fields": nil,
-	"exclude_fields": nil,
-	"expired":        false,
+	"password_hash_type": "bcrypt",
+	"owner":              "foo",
+	"creator":            "foo",
+	"role":               "admin",
+	"categories":         adminCategories,
+	"acls":               category.ACLsFor(adminCategories...),
+	"ops":                adminOps,
+	"indices":            []string{"*"},
+	"sources":            []string{"0.0.0.0/0"},
+	"referers":           []string{"*"},
+	"ttl":                -1,
+	"limits":             &defaultAdminLimits,
+	"description":        "TEST PERMISSION WITH ROLE",
+	"include_fields":     nil,
+	"exclude_fields":     nil,
+	"filter":             nil,
+	"expired":            false,
 }
 
 var updatePermissionsRequest = map[string]interface{}{
@@ -180,6 +186,22 @@ var savePublicKeyResponse = map[string]interface{}{
 	"message": "Public key saved successfully.",
 }
 
+var saveIssuerRequest = map[string]interface{}{
+	"issuer":   "https://idp.example.com/",
+	"audience": "arc",
+	"keys": []interface{}{
+		map[string]interface{}{
+			"kty": "RSA",
+			"kid": "key-1",
+			"alg": "RS256",
+			"n":   "nzyis1ZjfNB0bBgKFMSvvkTtwlvBsaJq7S5wA-kzeVOVpVWwkWdVha4s38XM_pa_yr47av7-z3VTmvDRyAHcaT92whREFpLv9cj5lTeJSibyr_Mrm_YtjCZVWgaOYIhwrXwKLqPr_11inWsAkfIytvHWTxZYEcXLgAXFuUuaS3uF9gEiNQwzGTU1v0FqkqTBr4B8nW3HCN47XUu0t8Y0e-lf4s4OxQawWD79J9_5d3Ry0vbV3Am1FtGJiJvOwRsIfVChDpYStTcHTCMqtvWbV6L11BWkpzGXSW4Hv43qa-GSYOD2QU68Mb59oSk2OB-BtOLpJofmbGEGgvmwyCI9Mw",
+			"e":   "AQAB",
+		},
+	},
+	"role_key":   "app.role",
+	"clock_skew": "30s",
+}
+
 func TestRBAC(t *testing.T) {
 	var username string
 	var password string
@@ -209,6 +231,111 @@ func TestRBAC(t *testing.T) {
 			So(response, ShouldResemble, savePublicKeyRequest)
 		})
 
+		Convey("Save a jwt issuer", func() {
+			response, err, _ := util.MakeHttpRequest(http.MethodPut, "/_jwt_issuer/idp", saveIssuerRequest)
+			So(err, ShouldBeNil)
+			So(response.(map[string]interface{})["message"], ShouldEqual, "Issuer saved successfully.")
+
+			response, err, _ = util.MakeHttpRequest(http.MethodGet, "/_jwt_issuer/idp", nil)
+			So(err, ShouldBeNil)
+			expected := util.StructToMap(saveIssuerRequest).(map[string]interface{})
+			expected["name"] = "idp"
+			So(response, ShouldResemble, expected)
+		})
+
+		Convey("Reject a jwt issuer without keys", func() {
+			_, _, res := util.MakeHttpRequest(http.MethodPut, "/_jwt_issuer/invalid", map[string]interface{}{
+				"issuer": "https://invalid.example.com/",
+			})
+			So(res.StatusCode, ShouldEqual, http.StatusBadRequest)
+		})
+
+		Convey("Reject a jwt issuer with an unsupported algorithm", func() {
+			_, _, res := util.MakeHttpRequest(http.MethodPut, "/_jwt_issuer/invalid", map[string]interface{}{
+				"issuer":     "https://invalid.example.com/",
+				"keys":       saveIssuerRequest["keys"],
+				"algorithms": []string{"none"},
+			})
+			So(res.StatusCode, ShouldEqual, http.StatusBadRequest)
+		})
+
+		Convey("Reject a jwt issuer with a short hmac secret", func() {
+			_, _, res := util.MakeHttpRequest(http.MethodPut, "/_jwt_issuer/invalid", map[string]interface{}{
+				"issuer": "https://invalid.example.com/",
+				"keys": []interface{}{
+					map[string]interface{}{"kty": "oct", "kid": "hmac", "k": "c2VjcmV0"},
+				},
+			})
+			So(res.StatusCode, ShouldEqual, http.StatusBadRequest)
+		})
+
+		Convey("Redact the hmac secret of a jwt issuer", func() {
+			_, err, _ := util.MakeHttpRequest(http.MethodPut, "/_jwt_issuer/hmac", map[string]interface{}{
+				"issuer":     "https://hmac.example.com/",
+				"algorithms": []string{"HS256"},
+				"keys": []interface{}{
+					map[string]interface{}{"kty": "oct", "kid": "hmac", "k": "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY"},
+				},
+			})
+			So(err, ShouldBeNil)
+
+			response, err, _ := util.MakeHttpRequest(http.MethodGet, "/_jwt_issuer/hmac", nil)
+			So(err, ShouldBeNil)
+			keys := response.(map[string]interface{})["keys"].([]interface{})
+			So(keys[0].(map[string]interface{})["k"], ShouldEqual, "[REDACTED]")
+
+			_, _, res := util.MakeHttpRequest(http.MethodDelete, "/_jwt_issuer/hmac", nil)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+		})
+
+		Convey("Delete a jwt issuer", func() {
+			response, err, _ := util.MakeHttpRequest(http.MethodDelete, "/_jwt_issuer/idp", nil)
+			So(err, ShouldBeNil)
+			So(response.(map[string]interface{})["message"], ShouldEqual, "Issuer deleted successfully.")
+
+			_, _, res := util.MakeHttpRequest(http.MethodGet, "/_jwt_issuer/idp", nil)
+			So(res.StatusCode, ShouldEqual, http.StatusNotFound)
+		})
+
+		Convey("Save a certificate binding", func() {
+			response, err, _ := util.MakeHttpRequest(http.MethodPut, "/_cert_binding/service", map[string]interface{}{
+				"common_name": "service.internal",
+				"username":    "foo",
+			})
+			So(err, ShouldBeNil)
+			So(response.(map[string]interface{})["message"], ShouldEqual, "Certificate binding saved successfully.")
+
+			response, err, _ = util.MakeHttpRequest(http.MethodGet, "/_cert_bindings", nil)
+			So(err, ShouldBeNil)
+			bindings := response.(map[string]interface{})["bindings"].([]interface{})
+			So(bindings, ShouldHaveLength, 1)
+			So(bindings[0].(map[string]interface{})["common_name"], ShouldEqual, "service.internal")
+		})
+
+		Convey("Reject an invalid certificate binding", func() {
+			_, _, res := util.MakeHttpRequest(http.MethodPut, "/_cert_binding/invalid", map[string]interface{}{
+				"common_name": "service.internal",
+				"fingerprint": "00",
+				"username":    "foo",
+			})
+			So(res.StatusCode, ShouldEqual, http.StatusBadRequest)
+
+			_, _, res = util.MakeHttpRequest(http.MethodPut, "/_cert_binding/invalid", map[string]interface{}{
+				"san":      "service.internal",
+				"username": "unknown",
+			})
+			So(res.StatusCode, ShouldEqual, http.StatusBadRequest)
+		})
+
+		Convey("Delete a certificate binding", func() {
+			response, err, _ := util.MakeHttpRequest(http.MethodDelete, "/_cert_binding/service", nil)
+			So(err, ShouldBeNil)
+			So(response.(map[string]interface{})["message"], ShouldEqual, "Certificate binding deleted successfully.")
+
+			_, _, res := util.MakeHttpRequest(http.MethodDelete, "/_cert_binding/service", nil)
+			So(res.StatusCode, ShouldEqual, http.StatusNotFound)
+		})
+
 		Convey("Create permission with role", func() {
 			requestBody := permission.Permission{
 				Description: "TEST PERMISSION WITH ROLE",
@@ -224,6 +351,9 @@ func TestRBAC(t *testing.T) {
 			password, _ = parsedResponse["password"].(string)
 			createdAt, _ = parsedResponse["created_at"].(string)
 
+			// the password is only returned once, on creation
+			So(password, ShouldNotBeEmpty)
+
 			delete(parsedResponse, "username")
 			delete(parsedResponse, "password")
 			delete(parsedResponse, "created_at")
@@ -241,7 +371,6 @@ func TestRBAC(t *testing.T) {
 			}
 			var getPermissionResponse = createPermissionResponse
 			getPermissionResponse["username"] = username
-			getPermissionResponse["password"] = password
 			getPermissionResponse["created_at"] = createdAt
 			mockMap := util.StructToMap(getPermissionResponse)
 
@@ -270,6 +399,42 @@ func TestRBAC(t *testing.T) {
 			So(parsedResponse, ShouldResemble, mockMap)
 		})
 
+		Convey("Create an api key for the permission", func() {
+			response, err, res := util.MakeHttpRequest(http.MethodPost, "/_apikey", map[string]interface{}{
+				"name":     "browser",
+				"username": username,
+			})
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusCreated)
+			created := response.(map[string]interface{})
+			So(created["key"], ShouldStartWith, created["display_prefix"].(string)+"_")
+
+			response, err, _ = util.MakeHttpRequest(http.MethodGet, "/_apikey/"+created["id"].(string), nil)
+			So(err, ShouldBeNil)
+			So(response.(map[string]interface{})["key"], ShouldBeNil)
+			So(response.(map[string]interface{})["key_hash"], ShouldBeNil)
+
+			response, err, _ = util.MakeHttpRequest(http.MethodDelete, "/_apikey/"+created["id"].(string), nil)
+			So(err, ShouldBeNil)
+			So(response.(map[string]interface{})["message"], ShouldEqual, "API key deleted successfully.")
+		})
+
+		Convey("Reject an api key for a user", func() {
+			_, _, res := util.MakeHttpRequest(http.MethodPost, "/_apikey", map[string]interface{}{
+				"username": "foo",
+			})
+			So(res.StatusCode, ShouldEqual, http.StatusBadRequest)
+		})
+
+		Convey("Reject an invalid api key", func() {
+			req, _ := http.NewRequest(http.MethodGet, "http://localhost:8000/_apikey", nil)
+			req.Header.Set("X-API-Key", "arc_00000000_invalid")
+			res, err := http.DefaultClient.Do(req)
+			So(err, ShouldBeNil)
+			res.Body.Close()
+			So(res.StatusCode, ShouldEqual, http.StatusUnauthorized)
+		})
+
 		Convey("Delete permission with role", func() {
 			response, err, _ := util.MakeHttpRequest(http.MethodDelete, "/_role/"+roleName, nil)
 
@@ -291,3 +456,131 @@ func TestRBAC(t *testing.T) {
 		})
 	})
 }
+
+// makeRequestWithToken makes a request to arc authenticated with the given bearer token.
+func makeRequestWithToken(token, method, url string, requestBody interface{}) (map[string]interface{}, *http.Response, error) {
+	marshalledRequest, err := json.Marshal(requestBody)
+	if err != nil {
+		return nil, nil, err
+	}
+	req, _ := http.NewRequest(method, "http://localhost:8000"+url, bytes.NewBuffer(marshalledRequest))
+	req.Header.Add("Authorization", "Bearer "+token)
+	req.Header.Add("Content-Type", "application/json")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, res, err
+	}
+	var response map[string]interface{}
+	err = json.Unmarshal(body, &response)
+	return response, res, err
+}
+
+func TestSessions(t *testing.T) {
+	var accessToken, refreshToken string
+	build := util.BuildArc{}
+	util.StartArc(&build)
+	build.Start()
+	defer build.Close()
+	Convey("Testing sessions", t, func() {
+		Convey("Login with invalid credentials", func() {
+			_, _, res := util.MakeHttpRequest(http.MethodPost, "/_login", map[string]interface{}{
+				"username": "foo",
+				"password": "invalid",
+			})
+			So(res.StatusCode, ShouldEqual, http.StatusUnauthorized)
+		})
+
+		Convey("Login", func() {
+			response, err, _ := util.MakeHttpRequest(http.MethodPost, "/_login", map[string]interface{}{
+				"username": "foo",
+				"password": "bar",
+			})
+			So(err, ShouldBeNil)
+			tokens := response.(map[string]interface{})
+			So(tokens["token_type"], ShouldEqual, "Bearer")
+			accessToken, _ = tokens["access_token"].(string)
+			refreshToken, _ = tokens["refresh_token"].(string)
+			So(accessToken, ShouldNotBeEmpty)
+			So(refreshToken, ShouldNotBeEmpty)
+		})
+
+		Convey("Authenticate with the access token", func() {
+			_, res, err := makeRequestWithToken(accessToken, http.MethodGet, "/_jwt_issuers", nil)
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+		})
+
+		Convey("Refresh the tokens", func() {
+			response, err, _ := util.MakeHttpRequest(http.MethodPost, "/_token/refresh", map[string]interface{}{
+				"refresh_token": refreshToken,
+			})
+			So(err, ShouldBeNil)
+			tokens := response.(map[string]interface{})
+			So(tokens["refresh_token"], ShouldNotEqual, refreshToken)
+
+			// refresh tokens can only be used once
+			_, _, res := util.MakeHttpRequest(http.MethodPost, "/_token/refresh", map[string]interface{}{
+				"refresh_token": refreshToken,
+			})
+			So(res.StatusCode, ShouldEqual, http.StatusUnauthorized)
+			accessToken, _ = tokens["access_token"].(string)
+			refreshToken, _ = tokens["refresh_token"].(string)
+		})
+
+		Convey("Logout", func() {
+			response, err, _ := util.MakeHttpRequest(http.MethodPost, "/_logout", map[string]interface{}{
+				"refresh_token": refreshToken,
+			})
+			So(err, ShouldBeNil)
+			So(response.(map[string]interface{})["message"], ShouldEqual, "Logged out successfully.")
+
+			_, res, _ := makeRequestWithToken(accessToken, http.MethodGet, "/_jwt_issuers", nil)
+			So(res.StatusCode, ShouldEqual, http.StatusUnauthorized)
+
+			_, _, res = util.MakeHttpRequest(http.MethodPost, "/_token/refresh", map[string]interface{}{
+				"refresh_token": refreshToken,
+			})
+			So(res.StatusCode, ShouldEqual, http.StatusUnauthorized)
+		})
+
+		Convey("Revoke the tokens of a subject", func() {
+			response, err, _ := util.MakeHttpRequest(http.MethodPost, "/_login", map[string]interface{}{
+				"username": "foo",
+				"password": "bar",
+			})
+			So(err, ShouldBeNil)
+			accessToken, _ = response.(map[string]interface{})["access_token"].(string)
+
+			response, err, _ = util.MakeHttpRequest(http.MethodPost, "/_revocations", map[string]interface{}{
+				"subject":       "foo",
+				"issued_before": time.Now().Add(time.Minute).Format(time.RFC3339),
+			})
+			So(err, ShouldBeNil)
+			id, _ := response.(map[string]interface{})["id"].(string)
+			So(id, ShouldNotBeEmpty)
+
+			_, res, _ := makeRequestWithToken(accessToken, http.MethodGet, "/_jwt_issuers", nil)
+			So(res.StatusCode, ShouldEqual, http.StatusUnauthorized)
+
+			_, _, res = util.MakeHttpRequest(http.MethodDelete, "/_revocations/"+id, nil)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+
+			_, res, _ = makeRequestWithToken(accessToken, http.MethodGet, "/_jwt_issuers", nil)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+		})
+
+		Convey("Reject an invalid revocation", func() {
+			_, _, res := util.MakeHttpRequest(http.MethodPost, "/_revocations", map[string]interface{}{
+				"jti":     "token-id",
+				"subject": "foo",
+			})
+			So(res.StatusCode, ShouldEqual, http.StatusBadRequest)
+		})
+	})
+}
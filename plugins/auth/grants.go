@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/appbaseio/arc/model/acl"
+	"github.com/appbaseio/arc/model/category"
+	"github.com/appbaseio/arc/model/op"
+	"github.com/appbaseio/arc/model/user"
+)
+
+var errNoMappedGroup = fmt.Errorf("user is not a member of any of the mapped groups")
+
+// groupGrant is what the members of a group of an external identity provider are granted.
+type groupGrant struct {
+	IsAdmin    bool                `json:"is_admin"`
+	Categories []category.Category `json:"categories"`
+	ACLs       []acl.ACL           `json:"acls"`
+	Ops        []op.Operation      `json:"ops"`
+	Indices    []string            `json:"indices"`
+}
+
+// groupMappingsFromEnv parses the JSON object, mapping the group names to their grants,
+// held by the env var. The group names are matched case insensitively.
+func groupMappingsFromEnv(envVar string) (map[string]groupGrant, error) {
+	groups := make(map[string]groupGrant)
+	if value := os.Getenv(envVar); value != "" {
+		if err := json.Unmarshal([]byte(value), &groups); err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", envVar, err)
+		}
+	}
+	mappings := make(map[string]groupGrant)
+	for group, grant := range groups {
+		mappings[strings.ToLower(group)] = grant
+	}
+	return mappings, nil
+}
+
+// userForGrants returns the arc user granted the union of the grants. The defaults of
+// the users apply to whatever the grants leave out.
+func userForGrants(username string, grants []groupGrant, passwordHashType string) (*user.User, error) {
+	if len(grants) == 0 {
+		return nil, errNoMappedGroup
+	}
+
+	var merged groupGrant
+	for _, grant := range grants {
+		merged.IsAdmin = merged.IsAdmin || grant.IsAdmin
+		for _, c := range grant.Categories {
+			if !hasCategory(merged.Categories, c) {
+				merged.Categories = append(merged.Categories, c)
+			}
+		}
+		for _, a := range grant.ACLs {
+			if !hasACL(merged.ACLs, a) {
+				merged.ACLs = append(merged.ACLs, a)
+			}
+		}
+		for _, o := range grant.Ops {
+			if !hasOp(merged.Ops, o) {
+				merged.Ops = append(merged.Ops, o)
+			}
+		}
+		merged.Indices = appendUniqueStrings(merged.Indices, grant.Indices...)
+	}
+
+	var opts []user.Options
+	if merged.Categories != nil {
+		opts = append(opts, user.SetCategories(merged.Categories))
+	}
+	if merged.ACLs != nil {
+		opts = append(opts, user.SetACLs(merged.ACLs))
+	}
+	if merged.Ops != nil {
+		opts = append(opts, user.SetOps(merged.Ops))
+	}
+	if merged.Indices != nil {
+		opts = append(opts, user.SetIndices(merged.Indices))
+	}
+	var u *user.User
+	var err error
+	if merged.IsAdmin {
+		u, err = user.NewAdmin(username, "", opts...)
+	} else {
+		u, err = user.New(username, "", opts...)
+	}
+	if err != nil {
+		return nil, err
+	}
+	u.PasswordHashType = passwordHashType
+	return u, nil
+}
+
+func hasCategory(categories []category.Category, c category.Category) bool {
+	for _, category := range categories {
+		if category == c {
+			return true
+		}
+	}
+	return false
+}
+
+func hasACL(acls []acl.ACL, a acl.ACL) bool {
+	for _, acl := range acls {
+		if acl == a {
+			return true
+		}
+	}
+	return false
+}
+
+func hasOp(ops []op.Operation, o op.Operation) bool {
+	for _, op := range ops {
+		if op == o {
+			return true
+		}
+	}
+	return false
+}
+
+func appendUniqueStrings(values []string, others ...string) []string {
+	for _, other := range others {
+		var found bool
+		for _, value := range values {
+			if value == other {
+				found = true
+				break
+			}
+		}
+		if !found {
+			values = append(values, other)
+		}
+	}
+	return values
+}
@@ -2,54 +2,50 @@ package auth
 
 import (
 	"context"
-	"crypto/rsa"
+	"crypto/subtle"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 
-	"github.com/dgrijalva/jwt-go"
-
+	"github.com/appbaseio/arc/model/permission"
+	"github.com/appbaseio/arc/model/user"
 	"github.com/appbaseio/arc/util"
+	"github.com/appbaseio/arc/util/iplookup"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/dgrijalva/jwt-go/request"
+	"github.com/gorilla/mux"
+	es7 "github.com/olivere/elastic/v7"
 )
 
 func (a *Auth) savePublicKey(ctx context.Context, indexName string, record publicKey) (interface{}, error) {
-	var jwtRsaPublicKey *rsa.PublicKey
-	if record.PublicKey != "" {
-		publicKeyBuf, err := util.DecodeBase64Key(record.PublicKey)
-		if err != nil {
-			log.Errorln(logTag, ": error indexing public key record", err)
-			return false, err
-		}
-		jwtRsaPublicKey, err = jwt.ParseRSAPublicKeyFromPEM(publicKeyBuf)
-		if err != nil {
-			log.Errorln(logTag, ": error indexing public key record", err)
-			return false, err
-		}
-	} else {
+	if record.PublicKey == "" && record.Secret == "" {
 		return false, errors.New("Public key is missing in the request body")
 	}
-	if strings.TrimSpace(record.RoleKey) == "" {
-		record.RoleKey = "role"
+	legacyIssuer, err := newLegacyIssuer(record)
+	if err != nil {
+		log.Errorln(logTag, ": error indexing public key record", err)
+		return false, err
 	}
+	record.RoleKey = legacyIssuer.config.RoleKey
 
 	// Update es index
-	_, err := a.es.savePublicKey(ctx, indexName, record)
+	_, err = a.es.savePublicKey(ctx, indexName, record)
 	if err != nil {
 		log.Errorln(logTag, ": error indexing public key record", logTag)
 		return false, err
 	}
 
 	// Update cached public key
-	if jwtRsaPublicKey != nil {
-		a.jwtRsaPublicKey = jwtRsaPublicKey
-		a.jwtRoleKey = record.RoleKey
-	}
+	a.issuers.setLegacy(legacyIssuer)
 
 	return true, nil
 }
@@ -57,6 +53,9 @@ func (a *Auth) savePublicKey(ctx context.Context, indexName string, record publi
 func (a *Auth) getPublicKey() http.HandlerFunc {
 	return func(w http.ResponseWriter, req *http.Request) {
 		record, _ := a.es.getPublicKey(req.Context())
+		if record.Secret != "" {
+			record.Secret = redactedSecret
+		}
 		rawPermission, err := json.Marshal(record)
 		if err != nil {
 			msg := fmt.Sprintf(`public key record not found`)
@@ -106,3 +105,1040 @@ func (a *Auth) setPublicKey() http.HandlerFunc {
 		util.WriteBackRaw(w, raw, http.StatusOK)
 	}
 }
+
+func (a *Auth) getIssuers() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		issuers, err := a.es.getIssuers(req.Context())
+		if err != nil {
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, "an error occurred while fetching the issuers", http.StatusInternalServerError)
+			return
+		}
+		redacted := []issuer{}
+		for _, i := range issuers {
+			redacted = append(redacted, i.redacted())
+		}
+		raw, err := json.Marshal(issuersRecord{redacted})
+		if err != nil {
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, "an error occurred while fetching the issuers", http.StatusInternalServerError)
+			return
+		}
+		util.WriteBackRaw(w, raw, http.StatusOK)
+	}
+}
+
+func (a *Auth) getIssuer() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		name := mux.Vars(req)["name"]
+		issuers, err := a.es.getIssuers(req.Context())
+		if err != nil {
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, "an error occurred while fetching the issuer", http.StatusInternalServerError)
+			return
+		}
+		for _, i := range issuers {
+			if i.Name == name {
+				raw, err := json.Marshal(i.redacted())
+				if err != nil {
+					log.Errorln(logTag, ":", err)
+					util.WriteBackError(w, "an error occurred while fetching the issuer", http.StatusInternalServerError)
+					return
+				}
+				util.WriteBackRaw(w, raw, http.StatusOK)
+				return
+			}
+		}
+		util.WriteBackError(w, fmt.Sprintf(`issuer with "name"="%s" not found`, name), http.StatusNotFound)
+	}
+}
+
+func (a *Auth) putIssuer() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		name := mux.Vars(req)["name"]
+		reqBody, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, "Can't read request body", http.StatusBadRequest)
+			return
+		}
+		defer req.Body.Close()
+
+		var body issuer
+		err = json.Unmarshal(reqBody, &body)
+		if err != nil {
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, "Can't parse request body", http.StatusBadRequest)
+			return
+		}
+		if name == legacyIssuerName || name == sessionIssuerName {
+			util.WriteBackError(w, fmt.Sprintf(`issuer name "%s" is reserved`, name), http.StatusBadRequest)
+			return
+		}
+		if body.Issuer == sessionTokenIssuer {
+			util.WriteBackError(w, fmt.Sprintf(`issuer "%s" is reserved for the tokens issued by arc`, body.Issuer), http.StatusBadRequest)
+			return
+		}
+		body.Name = name
+
+		newIssuer, err := newJWTIssuer(body)
+		if err != nil {
+			util.WriteBackError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		// make sure the keys can be fetched before trusting the issuer
+		if err := newIssuer.refreshKeys(req.Context()); err != nil {
+			msg := fmt.Sprintf(`unable to fetch the keys of issuer "%s": %v`, name, err)
+			util.WriteBackError(w, msg, http.StatusBadRequest)
+			return
+		}
+
+		a.issuersMu.Lock()
+		defer a.issuersMu.Unlock()
+		issuers, err := a.es.getIssuers(req.Context())
+		if err != nil {
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, "an error occurred while saving the issuer", http.StatusInternalServerError)
+			return
+		}
+		var updated []issuer
+		for _, i := range issuers {
+			if i.Name == name {
+				continue
+			}
+			if i.Issuer == newIssuer.config.Issuer {
+				msg := fmt.Sprintf(`issuer "%s" already trusts tokens with "iss"="%s"`, i.Name, i.Issuer)
+				util.WriteBackError(w, msg, http.StatusBadRequest)
+				return
+			}
+			updated = append(updated, i)
+		}
+		updated = append(updated, newIssuer.config)
+
+		if err := a.es.saveIssuers(req.Context(), updated); err != nil {
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, "an error occurred while saving the issuer", http.StatusInternalServerError)
+			return
+		}
+		a.issuers.set(updated)
+
+		util.WriteBackMessage(w, "Issuer saved successfully.", http.StatusOK)
+	}
+}
+
+func (a *Auth) deleteIssuer() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		name := mux.Vars(req)["name"]
+
+		a.issuersMu.Lock()
+		defer a.issuersMu.Unlock()
+		issuers, err := a.es.getIssuers(req.Context())
+		if err != nil {
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, "an error occurred while deleting the issuer", http.StatusInternalServerError)
+			return
+		}
+		var updated []issuer
+		for _, i := range issuers {
+			if i.Name != name {
+				updated = append(updated, i)
+			}
+		}
+		if len(updated) == len(issuers) {
+			util.WriteBackError(w, fmt.Sprintf(`issuer with "name"="%s" not found`, name), http.StatusNotFound)
+			return
+		}
+
+		if err := a.es.saveIssuers(req.Context(), updated); err != nil {
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, "an error occurred while deleting the issuer", http.StatusInternalServerError)
+			return
+		}
+		a.issuers.set(updated)
+
+		util.WriteBackMessage(w, "Issuer deleted successfully.", http.StatusOK)
+	}
+}
+
+// effectivePermissionResponse describes the permission granted to a jwt.
+type effectivePermissionResponse struct {
+	Roles        []string               `json:"roles"`
+	MatchedRoles []string               `json:"matched_roles"`
+	Permission   *permission.Permission `json:"permission"`
+}
+
+func (a *Auth) getEffectivePermissionForToken() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		reqBody, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, "Can't read request body", http.StatusBadRequest)
+			return
+		}
+		defer req.Body.Close()
+
+		var body struct {
+			Token string `json:"token"`
+		}
+		if err := json.Unmarshal(reqBody, &body); err != nil {
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, "Can't parse request body", http.StatusBadRequest)
+			return
+		}
+		if body.Token == "" {
+			util.WriteBackError(w, `"token" is required in the request body`, http.StatusBadRequest)
+			return
+		}
+
+		token, tokenIssuer, err := a.parseToken(body.Token)
+		if err != nil {
+			util.WriteBackError(w, fmt.Sprintf("Unable to parse JWT: %v", err), http.StatusBadRequest)
+			return
+		}
+		roles, ok := tokenIssuer.roles(token.Claims.(jwt.MapClaims))
+		if !ok {
+			util.WriteBackError(w, "JWT doesn't contain any roles", http.StatusBadRequest)
+			return
+		}
+
+		response := effectivePermissionResponse{Roles: roles, MatchedRoles: []string{}}
+		var rolePermissions []*permission.Permission
+		for _, role := range roles {
+			p, err := a.getRolePermission(req.Context(), role)
+			if err != nil {
+				log.Errorln(logTag, ":", err)
+				util.WriteBackError(w, "an error occurred while fetching the role permissions", http.StatusInternalServerError)
+				return
+			}
+			if p != nil {
+				response.MatchedRoles = append(response.MatchedRoles, role)
+				rolePermissions = append(rolePermissions, p)
+			}
+		}
+		if len(rolePermissions) == 0 {
+			msg := fmt.Sprintf("No API credentials match with provided roles: %s", strings.Join(roles, ", "))
+			util.WriteBackError(w, msg, http.StatusNotFound)
+			return
+		}
+		// the merged permission might be a cached role permission itself
+		effective := *permission.Merge(rolePermissions...)
+		effective.Password = ""
+		response.Permission = &effective
+
+		raw, err := json.Marshal(response)
+		if err != nil {
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, "an error occurred while computing the effective permission", http.StatusInternalServerError)
+			return
+		}
+		util.WriteBackRaw(w, raw, http.StatusOK)
+	}
+}
+
+// tokenRequest is the request body of the login, token refresh and logout endpoints.
+type tokenRequest struct {
+	Username     string `json:"username"`
+	Password     string `json:"password"`
+	RefreshToken string `json:"refresh_token"`
+	// OTP is the one-time password of the users with two-factor authentication enabled.
+	OTP string `json:"otp"`
+}
+
+func readTokenRequest(req *http.Request) (tokenRequest, error) {
+	var body tokenRequest
+	reqBody, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return body, err
+	}
+	defer req.Body.Close()
+	if len(reqBody) == 0 {
+		return body, nil
+	}
+	err = json.Unmarshal(reqBody, &body)
+	return body, err
+}
+
+func writeTokenResponse(w http.ResponseWriter, response *tokenResponse) {
+	raw, err := json.Marshal(response)
+	if err != nil {
+		log.Errorln(logTag, ":", err)
+		util.WriteBackError(w, "an error occurred while issuing the tokens", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	util.WriteBackRaw(w, raw, http.StatusOK)
+}
+
+func (a *Auth) loginHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		body, err := readTokenRequest(req)
+		if err != nil {
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, "Can't parse request body", http.StatusBadRequest)
+			return
+		}
+		username, password, ok := req.BasicAuth()
+		if !ok {
+			username, password = body.Username, body.Password
+		}
+		if username == "" || password == "" {
+			util.WriteBackError(w, "username and password are required", http.StatusBadRequest)
+			return
+		}
+
+		reqIP := iplookup.FromRequest(req)
+		if until, locked := a.lockouts.lockedUntil(username, reqIP); locked {
+			writeLockedOut(w, until)
+			return
+		}
+
+		otp := body.OTP
+		if otp == "" {
+			otp = req.Header.Get(otpHeader)
+		}
+		response, err := a.login(req.Context(), username, password, otp)
+		switch err {
+		case errInvalidLogin, errInvalidSecondFactor:
+			a.lockouts.recordFailure(req, username, reqIP)
+			util.WriteBackError(w, err.Error(), http.StatusUnauthorized)
+			return
+		case errSecondFactorRequired:
+			util.WriteBackError(w, err.Error(), http.StatusUnauthorized)
+			return
+		case errSecondFactorNotEnrolled:
+			util.WriteBackError(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, "an error occurred while logging in", http.StatusInternalServerError)
+			return
+		}
+		a.lockouts.recordSuccess(username)
+		writeTokenResponse(w, response)
+	}
+}
+
+func (a *Auth) refreshTokenHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		body, err := readTokenRequest(req)
+		if err != nil {
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, "Can't parse request body", http.StatusBadRequest)
+			return
+		}
+		if body.RefreshToken == "" {
+			util.WriteBackError(w, `"refresh_token" is required in the request body`, http.StatusBadRequest)
+			return
+		}
+
+		response, err := a.refresh(req.Context(), body.RefreshToken)
+		if err == errInvalidRefreshToken {
+			util.WriteBackError(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, "an error occurred while refreshing the tokens", http.StatusInternalServerError)
+			return
+		}
+		writeTokenResponse(w, response)
+	}
+}
+
+func (a *Auth) logoutHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		body, err := readTokenRequest(req)
+		if err != nil {
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, "Can't parse request body", http.StatusBadRequest)
+			return
+		}
+		// either the refresh token or the access token of the session ends it
+		token := body.RefreshToken
+		if token == "" {
+			token, _ = request.AuthorizationHeaderExtractor.ExtractToken(req)
+		}
+		if token == "" {
+			util.WriteBackError(w, "either the refresh token or the access token is required", http.StatusBadRequest)
+			return
+		}
+
+		err = a.logout(req.Context(), token)
+		if err == errInvalidRefreshToken || err == errInvalidAccessToken {
+			util.WriteBackError(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, "an error occurred while logging out", http.StatusInternalServerError)
+			return
+		}
+		util.WriteBackMessage(w, "Logged out successfully.", http.StatusOK)
+	}
+}
+
+func (a *Auth) getRevocations() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		raw, err := json.Marshal(a.revocations.list())
+		if err != nil {
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, "an error occurred while fetching the revocations", http.StatusInternalServerError)
+			return
+		}
+		util.WriteBackRaw(w, raw, http.StatusOK)
+	}
+}
+
+func (a *Auth) postRevocation() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		reqBody, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, "Can't read request body", http.StatusBadRequest)
+			return
+		}
+		defer req.Body.Close()
+
+		var body revocation
+		if err := json.Unmarshal(reqBody, &body); err != nil {
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, "Can't parse request body", http.StatusBadRequest)
+			return
+		}
+		r, err := newRevocation(body)
+		if err != nil {
+			util.WriteBackError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := a.es.saveRevocation(req.Context(), r); err != nil {
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, "an error occurred while saving the revocation", http.StatusInternalServerError)
+			return
+		}
+		a.revocations.add(r)
+
+		raw, err := json.Marshal(r)
+		if err != nil {
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, "an error occurred while saving the revocation", http.StatusInternalServerError)
+			return
+		}
+		util.WriteBackRaw(w, raw, http.StatusCreated)
+	}
+}
+
+func (a *Auth) deleteRevocation() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		id := mux.Vars(req)["id"]
+		err := a.es.deleteRevocation(req.Context(), id)
+		if es7.IsNotFound(err) {
+			util.WriteBackError(w, fmt.Sprintf(`revocation with "id"="%s" not found`, id), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, "an error occurred while deleting the revocation", http.StatusInternalServerError)
+			return
+		}
+		a.revocations.remove(id)
+
+		util.WriteBackMessage(w, "Revocation deleted successfully.", http.StatusOK)
+	}
+}
+
+func (a *Auth) getLockouts() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		raw, err := json.Marshal(a.lockouts.list())
+		if err != nil {
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, "an error occurred while fetching the lockouts", http.StatusInternalServerError)
+			return
+		}
+		util.WriteBackRaw(w, raw, http.StatusOK)
+	}
+}
+
+func (a *Auth) deleteLockouts() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		username := req.URL.Query().Get("username")
+		ip := req.URL.Query().Get("ip")
+		n := a.lockouts.clear(username, ip)
+		util.WriteBackMessage(w, fmt.Sprintf("Cleared %d lockout(s).", n), http.StatusOK)
+	}
+}
+
+func (a *Auth) getCertBindings() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		bindings, err := a.es.getCertBindings(req.Context())
+		if err != nil {
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, "an error occurred while fetching the certificate bindings", http.StatusInternalServerError)
+			return
+		}
+		if bindings == nil {
+			bindings = []certBinding{}
+		}
+		raw, err := json.Marshal(certBindingsRecord{bindings})
+		if err != nil {
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, "an error occurred while fetching the certificate bindings", http.StatusInternalServerError)
+			return
+		}
+		util.WriteBackRaw(w, raw, http.StatusOK)
+	}
+}
+
+func (a *Auth) putCertBinding() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		name := mux.Vars(req)["name"]
+		reqBody, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, "Can't read request body", http.StatusBadRequest)
+			return
+		}
+		defer req.Body.Close()
+
+		var body certBinding
+		err = json.Unmarshal(reqBody, &body)
+		if err != nil {
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, "Can't parse request body", http.StatusBadRequest)
+			return
+		}
+		body.Name = name
+		if err := body.validate(); err != nil {
+			util.WriteBackError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		obj, err := a.es.getCredential(req.Context(), body.Username)
+		if err != nil {
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, "an error occurred while saving the certificate binding", http.StatusInternalServerError)
+			return
+		}
+		if obj == nil {
+			msg := fmt.Sprintf(`no user or permission with "username"="%s"`, body.Username)
+			util.WriteBackError(w, msg, http.StatusBadRequest)
+			return
+		}
+
+		a.certBindingsMu.Lock()
+		defer a.certBindingsMu.Unlock()
+		bindings, err := a.es.getCertBindings(req.Context())
+		if err != nil {
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, "an error occurred while saving the certificate binding", http.StatusInternalServerError)
+			return
+		}
+		var updated []certBinding
+		for _, b := range bindings {
+			if b.Name == name {
+				continue
+			}
+			if b.CommonName == body.CommonName && b.SAN == body.SAN && b.Fingerprint == body.Fingerprint {
+				msg := fmt.Sprintf(`certificate binding "%s" already matches the same certificates`, b.Name)
+				util.WriteBackError(w, msg, http.StatusBadRequest)
+				return
+			}
+			updated = append(updated, b)
+		}
+		updated = append(updated, body)
+
+		if err := a.es.saveCertBindings(req.Context(), updated); err != nil {
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, "an error occurred while saving the certificate binding", http.StatusInternalServerError)
+			return
+		}
+		a.certBindings.set(updated)
+
+		util.WriteBackMessage(w, "Certificate binding saved successfully.", http.StatusOK)
+	}
+}
+
+func (a *Auth) deleteCertBinding() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		name := mux.Vars(req)["name"]
+
+		a.certBindingsMu.Lock()
+		defer a.certBindingsMu.Unlock()
+		bindings, err := a.es.getCertBindings(req.Context())
+		if err != nil {
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, "an error occurred while deleting the certificate binding", http.StatusInternalServerError)
+			return
+		}
+		var updated []certBinding
+		for _, b := range bindings {
+			if b.Name != name {
+				updated = append(updated, b)
+			}
+		}
+		if len(updated) == len(bindings) {
+			util.WriteBackError(w, fmt.Sprintf(`certificate binding with "name"="%s" not found`, name), http.StatusNotFound)
+			return
+		}
+
+		if err := a.es.saveCertBindings(req.Context(), updated); err != nil {
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, "an error occurred while deleting the certificate binding", http.StatusInternalServerError)
+			return
+		}
+		a.certBindings.set(updated)
+
+		util.WriteBackMessage(w, "Certificate binding deleted successfully.", http.StatusOK)
+	}
+}
+
+func (a *Auth) getAPIKeys() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		keys, err := a.es.getAPIKeys(req.Context())
+		if err != nil {
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, "an error occurred while fetching the api keys", http.StatusInternalServerError)
+			return
+		}
+		response := []apiKeyResponse{}
+		for _, k := range keys {
+			response = append(response, k.response())
+		}
+		raw, err := json.Marshal(response)
+		if err != nil {
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, "an error occurred while fetching the api keys", http.StatusInternalServerError)
+			return
+		}
+		util.WriteBackRaw(w, raw, http.StatusOK)
+	}
+}
+
+func (a *Auth) getAPIKey() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		id := mux.Vars(req)["id"]
+		k, err := a.es.getAPIKey(req.Context(), id)
+		if err != nil {
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, "an error occurred while fetching the api key", http.StatusInternalServerError)
+			return
+		}
+		if k == nil {
+			util.WriteBackError(w, fmt.Sprintf(`api key with "id"="%s" not found`, id), http.StatusNotFound)
+			return
+		}
+		raw, err := json.Marshal(k.response())
+		if err != nil {
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, "an error occurred while fetching the api key", http.StatusInternalServerError)
+			return
+		}
+		util.WriteBackRaw(w, raw, http.StatusOK)
+	}
+}
+
+func (a *Auth) postAPIKey() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		reqBody, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, "Can't read request body", http.StatusBadRequest)
+			return
+		}
+		defer req.Body.Close()
+
+		var body struct {
+			Name      string `json:"name"`
+			Username  string `json:"username"`
+			ExpiresAt string `json:"expires_at"`
+		}
+		err = json.Unmarshal(reqBody, &body)
+		if err != nil {
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, "Can't parse request body", http.StatusBadRequest)
+			return
+		}
+		k, key, err := newAPIKey(body.Name, body.Username, body.ExpiresAt)
+		if err != nil {
+			util.WriteBackError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		obj, err := a.es.getCredential(req.Context(), body.Username)
+		if err != nil {
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, "an error occurred while creating the api key", http.StatusInternalServerError)
+			return
+		}
+		p, ok := obj.(*permission.Permission)
+		if !ok {
+			msg := fmt.Sprintf(`no permission with "username"="%s"`, body.Username)
+			util.WriteBackError(w, msg, http.StatusBadRequest)
+			return
+		}
+		allowed, err := canMintAPIKey(req.Context(), p)
+		if err != nil {
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, "an error occurred while creating the api key", http.StatusInternalServerError)
+			return
+		}
+		if !allowed {
+			msg := fmt.Sprintf(`only the admins and the owner of the permission with "username"="%s" can create its api keys`, body.Username)
+			util.WriteBackError(w, msg, http.StatusUnauthorized)
+			return
+		}
+
+		if err := a.es.createAPIKey(req.Context(), *k); err != nil {
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, "an error occurred while creating the api key", http.StatusInternalServerError)
+			return
+		}
+
+		// the key can't be retrieved later on, only its hash is stored
+		response := k.response()
+		response.Key = key
+		raw, err := json.Marshal(response)
+		if err != nil {
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, "an error occurred while creating the api key", http.StatusInternalServerError)
+			return
+		}
+		util.WriteBackRaw(w, raw, http.StatusCreated)
+	}
+}
+
+func (a *Auth) deleteAPIKey() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		id := mux.Vars(req)["id"]
+		deleted, err := a.es.deleteAPIKey(req.Context(), id)
+		if err != nil {
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, "an error occurred while deleting the api key", http.StatusInternalServerError)
+			return
+		}
+		if !deleted {
+			util.WriteBackError(w, fmt.Sprintf(`api key with "id"="%s" not found`, id), http.StatusNotFound)
+			return
+		}
+		a.apiKeys.remove(id)
+
+		util.WriteBackMessage(w, "API key deleted successfully.", http.StatusOK)
+	}
+}
+
+func (a *Auth) oidcLoginHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if a.oidc == nil {
+			util.WriteBackError(w, errOIDCNotConfigured.Error(), http.StatusNotFound)
+			return
+		}
+		provider, err := a.oidc.discover(req.Context())
+		if err != nil {
+			log.Errorln(logTag, ": unable to discover the openid connect provider:", err)
+			util.WriteBackError(w, "unable to reach the openid connect provider", http.StatusBadGateway)
+			return
+		}
+		state, err := newOIDCState()
+		if err != nil {
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, "an error occurred while logging in", http.StatusInternalServerError)
+			return
+		}
+		signed, err := a.signOIDCState(state)
+		if err != nil {
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, "an error occurred while logging in", http.StatusInternalServerError)
+			return
+		}
+		authorizationURL, err := a.oidc.authorizationURL(provider, state)
+		if err != nil {
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, "an error occurred while logging in", http.StatusInternalServerError)
+			return
+		}
+
+		http.SetCookie(w, &http.Cookie{
+			Name:     oidcStateCookie,
+			Value:    signed,
+			Path:     "/_oidc",
+			MaxAge:   int(oidcStateTTL / time.Second),
+			HttpOnly: true,
+			Secure:   req.TLS != nil,
+			SameSite: http.SameSiteLaxMode,
+		})
+		http.Redirect(w, req, authorizationURL, http.StatusFound)
+	}
+}
+
+func (a *Auth) oidcCallbackHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if a.oidc == nil {
+			util.WriteBackError(w, errOIDCNotConfigured.Error(), http.StatusNotFound)
+			return
+		}
+		// the state is only valid for a single callback
+		http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Path: "/_oidc", MaxAge: -1, HttpOnly: true})
+
+		query := req.URL.Query()
+		if e := query.Get("error"); e != "" {
+			msg := fmt.Sprintf("login failed: %s %s", e, query.Get("error_description"))
+			util.WriteBackError(w, msg, http.StatusUnauthorized)
+			return
+		}
+		cookie, err := req.Cookie(oidcStateCookie)
+		if err != nil {
+			util.WriteBackError(w, "login state not found, the login must be started via /_oidc/login", http.StatusBadRequest)
+			return
+		}
+		state, err := a.parseOIDCState(cookie.Value)
+		if err != nil {
+			util.WriteBackError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(query.Get("state")), []byte(state.State)) != 1 {
+			util.WriteBackError(w, "invalid login state", http.StatusBadRequest)
+			return
+		}
+		code := query.Get("code")
+		if code == "" {
+			util.WriteBackError(w, "authorization code is required", http.StatusBadRequest)
+			return
+		}
+
+		provider, err := a.oidc.discover(req.Context())
+		if err != nil {
+			log.Errorln(logTag, ": unable to discover the openid connect provider:", err)
+			util.WriteBackError(w, "unable to reach the openid connect provider", http.StatusBadGateway)
+			return
+		}
+		idClaims, err := a.oidc.exchange(req.Context(), provider, code, state)
+		if err != nil {
+			log.Errorln(logTag, ": openid connect login failed:", err)
+			util.WriteBackError(w, "login failed: unable to verify the identity of the user", http.StatusUnauthorized)
+			return
+		}
+		u, err := a.oidc.userFor(idClaims)
+		if err != nil {
+			util.WriteBackError(w, fmt.Sprintf("login failed: %v", err), http.StatusForbidden)
+			return
+		}
+		response, err := a.oidcLogin(req.Context(), u)
+		if err != nil {
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, "an error occurred while logging in", http.StatusInternalServerError)
+			return
+		}
+
+		if a.oidc.postLoginRedirect == "" {
+			writeTokenResponse(w, response)
+			return
+		}
+		// the tokens are passed in the fragment, which isn't sent to any server
+		fragment := url.Values{}
+		fragment.Set("access_token", response.AccessToken)
+		fragment.Set("token_type", response.TokenType)
+		fragment.Set("expires_in", strconv.FormatInt(response.ExpiresIn, 10))
+		fragment.Set("refresh_token", response.RefreshToken)
+		fragment.Set("refresh_expires_in", strconv.FormatInt(response.RefreshExpiresIn, 10))
+		w.Header().Set("Cache-Control", "no-store")
+		http.Redirect(w, req, a.oidc.postLoginRedirect+"#"+fragment.Encode(), http.StatusFound)
+	}
+}
+
+// twoFactorUser returns the user of the request, whose two-factor authentication is managed.
+func twoFactorUser(w http.ResponseWriter, req *http.Request) (*user.User, bool) {
+	reqUser, err := user.FromContext(req.Context())
+	if err != nil {
+		util.WriteBackError(w, "two-factor authentication is only available to users", http.StatusBadRequest)
+		return nil, false
+	}
+	if isExternalUser(reqUser) {
+		util.WriteBackError(w, "the second factor of the users of an external identity provider is managed by the provider", http.StatusBadRequest)
+		return nil, false
+	}
+	return reqUser, true
+}
+
+func readOTPCode(req *http.Request) (string, error) {
+	var body struct {
+		Code string `json:"code"`
+	}
+	reqBody, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return "", err
+	}
+	defer req.Body.Close()
+	if len(reqBody) == 0 {
+		return "", nil
+	}
+	err = json.Unmarshal(reqBody, &body)
+	return body.Code, err
+}
+
+func (a *Auth) enrollTOTP() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		reqUser, ok := twoFactorUser(w, req)
+		if !ok {
+			return
+		}
+		existing, err := a.es.getTOTPEnrollment(req.Context(), reqUser.Username)
+		if err != nil {
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, "an error occurred while enrolling the second factor", http.StatusInternalServerError)
+			return
+		}
+		if existing != nil && existing.Enabled {
+			util.WriteBackError(w, "two-factor authentication is already enabled, disable it first", http.StatusConflict)
+			return
+		}
+
+		// a pending enrollment is replaced by the new one
+		e, err := newTOTPEnrollment(reqUser.Username)
+		if err != nil {
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, "an error occurred while enrolling the second factor", http.StatusInternalServerError)
+			return
+		}
+		if existing != nil {
+			e.seqNo, e.primaryTerm = existing.seqNo, existing.primaryTerm
+		}
+		if err := a.es.saveTOTPEnrollment(req.Context(), e); err != nil {
+			if err == errTOTPModified {
+				util.WriteBackError(w, err.Error(), http.StatusConflict)
+				return
+			}
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, "an error occurred while enrolling the second factor", http.StatusInternalServerError)
+			return
+		}
+
+		raw, err := json.Marshal(totpEnrollmentResponse{
+			Secret:     e.Secret,
+			OTPAuthURL: e.otpAuthURL(a.totp.issuer),
+		})
+		if err != nil {
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, "an error occurred while enrolling the second factor", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		util.WriteBackRaw(w, raw, http.StatusOK)
+	}
+}
+
+func (a *Auth) verifyTOTP() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		reqUser, ok := twoFactorUser(w, req)
+		if !ok {
+			return
+		}
+		code, err := readOTPCode(req)
+		if err != nil {
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, "Can't parse request body", http.StatusBadRequest)
+			return
+		}
+		if code == "" {
+			util.WriteBackError(w, `"code" is required in the request body`, http.StatusBadRequest)
+			return
+		}
+		e, err := a.es.getTOTPEnrollment(req.Context(), reqUser.Username)
+		if err != nil {
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, "an error occurred while verifying the second factor", http.StatusInternalServerError)
+			return
+		}
+		if e == nil || e.Enabled {
+			msg := fmt.Sprintf("no pending enrollment, start one via %senroll", totp2FAPath)
+			util.WriteBackError(w, msg, http.StatusBadRequest)
+			return
+		}
+
+		// the code proves that the secret was added to the authenticator app
+		step, ok := matchTOTP(e.Secret, strings.TrimSpace(code), time.Now())
+		if !ok || !a.totpSteps.use(reqUser.Username, step) {
+			a.lockouts.recordFailure(req, reqUser.Username, iplookup.FromRequest(req))
+			util.WriteBackError(w, errInvalidSecondFactor.Error(), http.StatusUnauthorized)
+			return
+		}
+		codes, hashes, err := newRecoveryCodes()
+		if err != nil {
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, "an error occurred while verifying the second factor", http.StatusInternalServerError)
+			return
+		}
+		e.Enabled = true
+		e.EnabledAt = time.Now().Format(time.RFC3339)
+		e.RecoveryCodeHashes = hashes
+		if err := a.es.saveTOTPEnrollment(req.Context(), e); err != nil {
+			if err == errTOTPModified {
+				util.WriteBackError(w, err.Error(), http.StatusConflict)
+				return
+			}
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, "an error occurred while verifying the second factor", http.StatusInternalServerError)
+			return
+		}
+
+		// the recovery codes can't be retrieved later on, only their hashes are stored
+		raw, err := json.Marshal(map[string]interface{}{
+			"enabled":        true,
+			"recovery_codes": codes,
+		})
+		if err != nil {
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, "an error occurred while verifying the second factor", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		util.WriteBackRaw(w, raw, http.StatusOK)
+	}
+}
+
+func (a *Auth) disableTOTP() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		reqUser, ok := twoFactorUser(w, req)
+		if !ok {
+			return
+		}
+		code, err := readOTPCode(req)
+		if err != nil {
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, "Can't parse request body", http.StatusBadRequest)
+			return
+		}
+		e, err := a.getEnabledTOTP(req.Context(), reqUser.Username)
+		if err != nil {
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, "an error occurred while disabling the second factor", http.StatusInternalServerError)
+			return
+		}
+		if e == nil {
+			util.WriteBackError(w, "two-factor authentication isn't enabled", http.StatusBadRequest)
+			return
+		}
+
+		// disabling it takes a one-time password, unless one was verified with the request
+		if !hasVerifiedOTP(req.Context()) {
+			if code == "" {
+				util.WriteBackError(w, `"code" is required in the request body`, http.StatusBadRequest)
+				return
+			}
+			if err := a.verifySecondFactor(req.Context(), e, code, true); err != nil {
+				if err == errInvalidSecondFactor {
+					a.lockouts.recordFailure(req, reqUser.Username, iplookup.FromRequest(req))
+					util.WriteBackError(w, err.Error(), http.StatusUnauthorized)
+					return
+				}
+				log.Errorln(logTag, ":", err)
+				util.WriteBackError(w, "an error occurred while disabling the second factor", http.StatusInternalServerError)
+				return
+			}
+		}
+		if err := a.es.deleteTOTPEnrollment(req.Context(), reqUser.Username); err != nil {
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, "an error occurred while disabling the second factor", http.StatusInternalServerError)
+			return
+		}
+		util.WriteBackMessage(w, "Two-factor authentication disabled successfully.", http.StatusOK)
+	}
+}
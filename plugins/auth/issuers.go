@@ -0,0 +1,553 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/appbaseio/arc/model/claims"
+	"github.com/appbaseio/arc/util"
+	"github.com/dgrijalva/jwt-go"
+)
+
+const (
+	// defaultRoleKey is the claim that holds the role when an issuer doesn't specify one.
+	defaultRoleKey = "role"
+
+	// minKeysRefreshInterval limits how often the keys of an issuer are fetched
+	// on encountering a token signed with an unknown key.
+	minKeysRefreshInterval = time.Minute
+
+	// legacyIssuerName is the name of the issuer configured through the public key record.
+	legacyIssuerName = "_public_key"
+
+	// minSecretLength is the minimum length in bytes of the hmac secrets, as per RFC 7518.
+	minSecretLength = 32
+)
+
+// issuer is the configuration of a trusted jwt issuer. Tokens are verified using the
+// keys fetched from the issuer's JWKS url and the inline keys, selected by "kid".
+type issuer struct {
+	Name       string   `json:"name"`
+	Issuer     string   `json:"issuer"`
+	JWKSURL    string   `json:"jwks_url,omitempty"`
+	Keys       []jwk    `json:"keys,omitempty"`
+	Audience   string   `json:"audience,omitempty"`
+	RoleKey    string   `json:"role_key"`
+	ClockSkew  string   `json:"clock_skew,omitempty"`
+	Algorithms []string `json:"algorithms,omitempty"`
+}
+
+// normalized returns the issuer with the defaults applied to its unset fields.
+func (i issuer) normalized() issuer {
+	if strings.TrimSpace(i.RoleKey) == "" {
+		i.RoleKey = defaultRoleKey
+	}
+	return i
+}
+
+// jwk is a JSON web key as defined in RFC 7517.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+	K   string `json:"k,omitempty"`
+}
+
+// redacted returns the issuer with the shared secrets of its keys redacted.
+func (i issuer) redacted() issuer {
+	if len(i.Keys) == 0 {
+		return i
+	}
+	keys := make([]jwk, len(i.Keys))
+	for j, k := range i.Keys {
+		if k.K != "" {
+			k.K = redactedSecret
+		}
+		keys[j] = k
+	}
+	i.Keys = keys
+	return i
+}
+
+// jwks is a JSON web key set as served by an issuer's JWKS url.
+type jwks struct {
+	Keys []jwk `json:"keys"`
+}
+
+// verificationKey is a public key along with the algorithm it is restricted to, if any.
+type verificationKey struct {
+	key interface{}
+	alg string
+}
+
+// jwtIssuer is a trusted issuer along with its verification keys.
+type jwtIssuer struct {
+	config    issuer
+	clockSkew time.Duration
+
+	mu          sync.RWMutex
+	inlineKeys  map[string]verificationKey
+	remoteKeys  map[string]verificationKey
+	refreshedAt time.Time
+}
+
+// jwksClient fetches the key sets, unlike util.HTTPClient it verifies the tls certificates.
+var jwksClient = &http.Client{Timeout: 30 * time.Second}
+
+func newJWTIssuer(config issuer) (*jwtIssuer, error) {
+	if config.Name == "" {
+		return nil, fmt.Errorf(`issuer "name" cannot be empty`)
+	}
+	if config.Name != legacyIssuerName && config.Issuer == "" {
+		return nil, fmt.Errorf(`issuer "%s": "issuer" cannot be empty`, config.Name)
+	}
+	if config.JWKSURL == "" && len(config.Keys) == 0 {
+		return nil, fmt.Errorf(`issuer "%s": either "jwks_url" or "keys" must be provided`, config.Name)
+	}
+	if config.JWKSURL != "" {
+		u, err := url.Parse(config.JWKSURL)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return nil, fmt.Errorf(`issuer "%s": invalid "jwks_url" "%s"`, config.Name, config.JWKSURL)
+		}
+	}
+	config = config.normalized()
+	if err := validateAlgorithms(config.Algorithms); err != nil {
+		return nil, fmt.Errorf(`issuer "%s": %v`, config.Name, err)
+	}
+
+	i := &jwtIssuer{
+		config:     config,
+		inlineKeys: make(map[string]verificationKey),
+		remoteKeys: make(map[string]verificationKey),
+	}
+	if config.ClockSkew != "" {
+		skew, err := time.ParseDuration(config.ClockSkew)
+		if err != nil || skew < 0 {
+			return nil, fmt.Errorf(`issuer "%s": invalid "clock_skew" "%s"`, config.Name, config.ClockSkew)
+		}
+		i.clockSkew = skew
+	}
+	for _, k := range config.Keys {
+		key, err := k.verificationKey()
+		if err != nil {
+			return nil, fmt.Errorf(`issuer "%s": invalid key "%s": %v`, config.Name, k.Kid, err)
+		}
+		i.inlineKeys[k.Kid] = key
+	}
+	return i, nil
+}
+
+// newLegacyIssuer returns the issuer configured through the public key record, which
+// accepts the tokens of any issuer that are signed with the record's key.
+func newLegacyIssuer(record publicKey) (*jwtIssuer, error) {
+	if (record.PublicKey == "") == (record.Secret == "") {
+		return nil, fmt.Errorf("either the public key or the secret must be provided")
+	}
+	if err := validateAlgorithms(record.Algorithms); err != nil {
+		return nil, err
+	}
+
+	var key interface{}
+	if record.PublicKey != "" {
+		publicKeyBuf, err := util.DecodeBase64Key(record.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		key, err = parsePublicKeyPEM(publicKeyBuf)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		secret, err := util.DecodeBase64Key(record.Secret)
+		if err != nil {
+			return nil, err
+		}
+		if len(secret) < minSecretLength {
+			return nil, fmt.Errorf("secret must be at least %d bytes long", minSecretLength)
+		}
+		key = secret
+	}
+
+	roleKey := record.RoleKey
+	if strings.TrimSpace(roleKey) == "" {
+		roleKey = defaultRoleKey
+	}
+	return &jwtIssuer{
+		config: issuer{
+			Name:       legacyIssuerName,
+			RoleKey:    roleKey,
+			Algorithms: record.Algorithms,
+		},
+		inlineKeys: map[string]verificationKey{"": {key: key}},
+		remoteKeys: make(map[string]verificationKey),
+	}, nil
+}
+
+// verificationKey decodes the public key of the jwk.
+func (k jwk) verificationKey() (verificationKey, error) {
+	if k.Use != "" && k.Use != "sig" {
+		return verificationKey{}, fmt.Errorf(`key with "use" "%s" can't be used to verify signatures`, k.Use)
+	}
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil || len(n) == 0 {
+			return verificationKey{}, fmt.Errorf(`invalid modulus "n"`)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return verificationKey{}, fmt.Errorf(`invalid exponent "e"`)
+		}
+		key := &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+		return verificationKey{key: key, alg: k.Alg}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return verificationKey{}, fmt.Errorf(`unsupported curve "%s"`, k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) == 0 {
+			return verificationKey{}, fmt.Errorf(`invalid coordinate "x"`)
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil || len(y) == 0 {
+			return verificationKey{}, fmt.Errorf(`invalid coordinate "y"`)
+		}
+		key := &ecdsa.PublicKey{
+			Curve: curve,
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+		if !curve.IsOnCurve(key.X, key.Y) {
+			return verificationKey{}, fmt.Errorf("point isn't on the curve %s", k.Crv)
+		}
+		return verificationKey{key: key, alg: k.Alg}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return verificationKey{}, fmt.Errorf(`unsupported curve "%s"`, k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return verificationKey{}, fmt.Errorf(`invalid public key "x"`)
+		}
+		return verificationKey{key: ed25519.PublicKey(x), alg: k.Alg}, nil
+	case "oct":
+		secret, err := base64.RawURLEncoding.DecodeString(k.K)
+		if err != nil {
+			return verificationKey{}, fmt.Errorf(`invalid secret "k"`)
+		}
+		if len(secret) < minSecretLength {
+			return verificationKey{}, fmt.Errorf("secret must be at least %d bytes long", minSecretLength)
+		}
+		return verificationKey{key: secret, alg: k.Alg}, nil
+	default:
+		return verificationKey{}, fmt.Errorf(`unsupported key type "%s"`, k.Kty)
+	}
+}
+
+// allows checks whether the issuer's tokens can be signed using the algorithm. The key
+// used to verify a token must additionally be of the type the algorithm requires.
+func (i *jwtIssuer) allows(alg string) bool {
+	if len(i.config.Algorithms) == 0 {
+		return true
+	}
+	for _, a := range i.config.Algorithms {
+		if a == alg {
+			return true
+		}
+	}
+	return false
+}
+
+// key returns the verification key with the given kid. An issuer with a single key of
+// its own, like the public key record, verifies every token with it whatever its kid,
+// while a token without a kid can only be verified if the issuer has a single key.
+func (i *jwtIssuer) key(kid string) (verificationKey, bool) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	if key, ok := i.inlineKeys[kid]; ok {
+		return key, true
+	}
+	if i.config.JWKSURL == "" && len(i.inlineKeys) == 1 {
+		for _, key := range i.inlineKeys {
+			return key, true
+		}
+	}
+	if key, ok := i.remoteKeys[kid]; ok {
+		return key, true
+	}
+	if kid == "" && len(i.inlineKeys)+len(i.remoteKeys) == 1 {
+		for _, key := range i.inlineKeys {
+			return key, true
+		}
+		for _, key := range i.remoteKeys {
+			return key, true
+		}
+	}
+	return verificationKey{}, false
+}
+
+// refreshKeys fetches the keys from the issuer's JWKS url.
+func (i *jwtIssuer) refreshKeys(ctx context.Context) error {
+	if i.config.JWKSURL == "" {
+		return nil
+	}
+	i.mu.Lock()
+	i.refreshedAt = time.Now()
+	i.mu.Unlock()
+
+	req, err := http.NewRequest(http.MethodGet, i.config.JWKSURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := jwksClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code %d while fetching %s", resp.StatusCode, i.config.JWKSURL)
+	}
+
+	var set jwks
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return fmt.Errorf("unable to parse the key set at %s: %v", i.config.JWKSURL, err)
+	}
+	keys := make(map[string]verificationKey)
+	for _, k := range set.Keys {
+		key, err := k.verificationKey()
+		if err != nil {
+			// key sets often contain encryption keys as well
+			log.Debugln(logTag, ": issuer", i.config.Name, ": skipping key", k.Kid, ":", err)
+			continue
+		}
+		keys[k.Kid] = key
+	}
+
+	i.mu.Lock()
+	i.remoteKeys = keys
+	i.mu.Unlock()
+	return nil
+}
+
+// refreshKeysIfStale refreshes the keys unless they were refreshed recently, which
+// prevents tokens with unknown kids from triggering a fetch on every request.
+func (i *jwtIssuer) refreshKeysIfStale(ctx context.Context) {
+	i.mu.RLock()
+	stale := time.Since(i.refreshedAt) > minKeysRefreshInterval
+	i.mu.RUnlock()
+	if !stale {
+		return
+	}
+	if err := i.refreshKeys(ctx); err != nil {
+		log.Errorln(logTag, ": unable to refresh the keys of issuer", i.config.Name, ":", err)
+	}
+}
+
+// keyFor returns the key of the issuer the token is signed with, selected by the token's "kid" header.
+func (i *jwtIssuer) keyFor(token *jwt.Token) (interface{}, error) {
+	if !i.allows(token.Method.Alg()) {
+		return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	key, ok := i.key(kid)
+	if !ok {
+		// the issuer might have rotated its keys
+		i.refreshKeysIfStale(context.Background())
+		key, ok = i.key(kid)
+	}
+	if !ok {
+		return nil, fmt.Errorf("No key found with kid %q", kid)
+	}
+	if !keyMatchesMethod(key.key, token.Method) || (key.alg != "" && key.alg != token.Method.Alg()) {
+		return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
+	}
+	return key.key, nil
+}
+
+// validateClaims validates the registered claims of a token issued by the issuer.
+func (i *jwtIssuer) validateClaims(c jwt.MapClaims) error {
+	now := time.Now()
+	if exp, ok := numericDate(c, "exp"); ok && now.After(exp.Add(i.clockSkew)) {
+		return fmt.Errorf("token is expired")
+	}
+	if nbf, ok := numericDate(c, "nbf"); ok && now.Before(nbf.Add(-i.clockSkew)) {
+		return fmt.Errorf("token is not valid yet")
+	}
+	if iat, ok := numericDate(c, "iat"); ok && now.Before(iat.Add(-i.clockSkew)) {
+		return fmt.Errorf("token used before issued")
+	}
+	if i.config.Issuer != "" {
+		if iss, _ := c["iss"].(string); iss != i.config.Issuer {
+			return fmt.Errorf("invalid issuer")
+		}
+	}
+	if i.config.Audience != "" && !hasAudience(c, i.config.Audience) {
+		return fmt.Errorf("invalid audience")
+	}
+	return nil
+}
+
+// roles returns the roles of the token stored against the issuer's role claim, which
+// holds either a single role or an array of roles. The role key is looked up as a claim
+// name first, since namespaced claims like "https://example.com/roles" contain dots,
+// and then as a dotted path to a nested claim.
+func (i *jwtIssuer) roles(c jwt.MapClaims) ([]string, bool) {
+	value, ok := c[i.config.RoleKey]
+	if !ok {
+		value, ok = claims.Claims(c).Lookup(i.config.RoleKey)
+	}
+	if !ok && i.config.Name == legacyIssuerName {
+		// the public key record has always fallen back to the "role" claim
+		value, ok = c[defaultRoleKey]
+	}
+	if !ok {
+		return nil, false
+	}
+	var roles []string
+	switch v := value.(type) {
+	case string:
+		if v != "" {
+			roles = append(roles, v)
+		}
+	case []interface{}:
+		for _, elem := range v {
+			role, ok := elem.(string)
+			if !ok {
+				return nil, false
+			}
+			if role != "" {
+				roles = append(roles, role)
+			}
+		}
+	}
+	return roles, len(roles) > 0
+}
+
+func numericDate(c jwt.MapClaims, claim string) (time.Time, bool) {
+	switch v := c[claim].(type) {
+	case float64:
+		return time.Unix(int64(v), 0), true
+	case json.Number:
+		n, err := v.Int64()
+		return time.Unix(n, 0), err == nil
+	}
+	return time.Time{}, false
+}
+
+// hasAudience checks whether the "aud" claim, either a string or an array of strings, contains the audience.
+func hasAudience(c jwt.MapClaims, audience string) bool {
+	switch aud := c["aud"].(type) {
+	case string:
+		return aud == audience
+	case []interface{}:
+		for _, a := range aud {
+			if s, ok := a.(string); ok && s == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// issuerSet holds the trusted issuers.
+type issuerSet struct {
+	mu      sync.RWMutex
+	legacy  *jwtIssuer
+	issuers map[string]*jwtIssuer
+}
+
+func newIssuerSet() *issuerSet {
+	return &issuerSet{issuers: make(map[string]*jwtIssuer)}
+}
+
+// forToken returns the issuer of a token with the given "iss" claim. Tokens issued by
+// an unknown issuer fall back to the legacy issuer, if configured.
+func (s *issuerSet) forToken(iss string) (*jwtIssuer, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if iss != "" {
+		for _, i := range s.issuers {
+			if i.config.Issuer == iss {
+				return i, true
+			}
+		}
+	}
+	return s.legacy, s.legacy != nil
+}
+
+func (s *issuerSet) setLegacy(i *jwtIssuer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.legacy = i
+}
+
+// set replaces the issuers with the given configurations. Issuers whose configuration
+// didn't change are retained along with their fetched keys.
+func (s *issuerSet) set(configs []issuer) []*jwtIssuer {
+	issuers := make(map[string]*jwtIssuer)
+	var added []*jwtIssuer
+
+	s.mu.RLock()
+	for _, config := range configs {
+		if i, ok := s.issuers[config.Name]; ok && reflect.DeepEqual(i.config, config.normalized()) {
+			issuers[config.Name] = i
+			continue
+		}
+		i, err := newJWTIssuer(config)
+		if err != nil {
+			log.Errorln(logTag, ": skipping invalid issuer:", err)
+			continue
+		}
+		issuers[config.Name] = i
+		added = append(added, i)
+	}
+	s.mu.RUnlock()
+
+	s.mu.Lock()
+	s.issuers = issuers
+	s.mu.Unlock()
+	return added
+}
+
+func (s *issuerSet) list() []*jwtIssuer {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var issuers []*jwtIssuer
+	for _, i := range s.issuers {
+		issuers = append(issuers, i)
+	}
+	return issuers
+}
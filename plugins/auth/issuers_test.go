@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestIssuerSet(t *testing.T) {
+	Convey("Reloading the issuers", t, func() {
+		s := newIssuerSet()
+		configs := []issuer{{Name: "idp", Issuer: "https://idp.example.com", JWKSURL: "https://idp.example.com/jwks"}}
+		added := s.set(configs)
+		So(added, ShouldHaveLength, 1)
+		So(added[0].config.RoleKey, ShouldEqual, defaultRoleKey)
+
+		Convey("retains the issuers whose configuration didn't change", func() {
+			So(s.set(configs), ShouldBeEmpty)
+			So(s.list(), ShouldResemble, added)
+
+			configs[0].RoleKey = defaultRoleKey
+			So(s.set(configs), ShouldBeEmpty)
+		})
+
+		Convey("rebuilds the issuers whose configuration changed", func() {
+			configs[0].RoleKey = "roles"
+			changed := s.set(configs)
+			So(changed, ShouldHaveLength, 1)
+			So(changed[0].config.RoleKey, ShouldEqual, "roles")
+		})
+	})
+}
+
+func TestIssuerKeys(t *testing.T) {
+	secret := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("s", minSecretLength)))
+
+	Convey("Verifying the tokens of an issuer", t, func() {
+		Convey("ignores the kid with the key of the public key record", func() {
+			i, err := newLegacyIssuer(publicKey{Secret: secret})
+			So(err, ShouldBeNil)
+			_, ok := i.key("")
+			So(ok, ShouldBeTrue)
+			_, ok = i.key("idp-2024")
+			So(ok, ShouldBeTrue)
+		})
+
+		Convey("reads the namespaced role claims", func() {
+			i, err := newLegacyIssuer(publicKey{Secret: secret, RoleKey: "https://example.com/roles"})
+			So(err, ShouldBeNil)
+			roles, ok := i.roles(jwt.MapClaims{"https://example.com/roles": []interface{}{"admin", "shop"}})
+			So(ok, ShouldBeTrue)
+			So(roles, ShouldResemble, []string{"admin", "shop"})
+
+			i, err = newLegacyIssuer(publicKey{Secret: secret, RoleKey: "app.roles"})
+			So(err, ShouldBeNil)
+			roles, ok = i.roles(jwt.MapClaims{"app": map[string]interface{}{"roles": "shop"}})
+			So(ok, ShouldBeTrue)
+			So(roles, ShouldResemble, []string{"shop"})
+		})
+	})
+}
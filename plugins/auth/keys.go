@@ -0,0 +1,127 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// redactedSecret replaces the shared secrets in the responses.
+const redactedSecret = "[REDACTED]"
+
+// supportedAlgorithms are the jwt signing algorithms that can be allowed for an issuer.
+var supportedAlgorithms = map[string]bool{
+	"HS256": true, "HS384": true, "HS512": true,
+	"RS256": true, "RS384": true, "RS512": true,
+	"PS256": true, "PS384": true, "PS512": true,
+	"ES256": true, "ES384": true, "ES512": true,
+	"EdDSA": true,
+}
+
+func validateAlgorithms(algorithms []string) error {
+	for _, alg := range algorithms {
+		if !supportedAlgorithms[alg] {
+			return fmt.Errorf(`unsupported algorithm "%s"`, alg)
+		}
+	}
+	return nil
+}
+
+// parsePublicKeyPEM parses a PEM encoded rsa, ecdsa or ed25519 public key or certificate.
+func parsePublicKeyPEM(buf []byte) (interface{}, error) {
+	block, _ := pem.Decode(buf)
+	if block == nil {
+		return nil, fmt.Errorf("key must be PEM encoded")
+	}
+
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "CERTIFICATE":
+		var cert *x509.Certificate
+		cert, err = x509.ParseCertificate(block.Bytes)
+		if err == nil {
+			key = cert.PublicKey
+		}
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch key.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey:
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", key)
+	}
+}
+
+// keyMatchesMethod checks whether the key can verify the signatures of the signing
+// method. Verifying a token only with a key of the type its algorithm requires keeps
+// algorithm confusion attacks, e.g. an rsa public key used as an hmac secret, at bay.
+func keyMatchesMethod(key interface{}, method jwt.SigningMethod) bool {
+	switch m := method.(type) {
+	case *jwt.SigningMethodHMAC:
+		secret, ok := key.([]byte)
+		return ok && len(secret) > 0
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+		_, ok := key.(*rsa.PublicKey)
+		return ok
+	case *jwt.SigningMethodECDSA:
+		k, ok := key.(*ecdsa.PublicKey)
+		return ok && k.Curve.Params().BitSize == m.CurveBits
+	case *signingMethodEdDSA:
+		_, ok := key.(ed25519.PublicKey)
+		return ok
+	default:
+		return false
+	}
+}
+
+// signingMethodEdDSA implements the EdDSA signing method using ed25519 keys,
+// which isn't provided by the jwt library.
+type signingMethodEdDSA struct{}
+
+var edDSA = &signingMethodEdDSA{}
+
+func init() {
+	jwt.RegisterSigningMethod(edDSA.Alg(), func() jwt.SigningMethod {
+		return edDSA
+	})
+}
+
+func (m *signingMethodEdDSA) Alg() string {
+	return "EdDSA"
+}
+
+func (m *signingMethodEdDSA) Verify(signingString, signature string, key interface{}) error {
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return jwt.ErrInvalidKeyType
+	}
+	sig, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(publicKey, []byte(signingString), sig) {
+		return jwt.ErrSignatureInvalid
+	}
+	return nil
+}
+
+func (m *signingMethodEdDSA) Sign(signingString string, key interface{}) (string, error) {
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return "", jwt.ErrInvalidKeyType
+	}
+	return jwt.EncodeSegment(ed25519.Sign(privateKey, []byte(signingString))), nil
+}
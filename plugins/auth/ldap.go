@@ -0,0 +1,273 @@
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/appbaseio/arc/model/credential"
+	"github.com/appbaseio/arc/model/user"
+)
+
+const (
+	envLDAPURL            = "LDAP_URL"
+	envLDAPBindDN         = "LDAP_BIND_DN"
+	envLDAPBaseDN         = "LDAP_BASE_DN"
+	envLDAPUserFilter     = "LDAP_USER_FILTER"
+	envLDAPGroupAttribute = "LDAP_GROUP_ATTRIBUTE"
+	envLDAPGroupMappings  = "LDAP_GROUP_MAPPINGS"
+	envLDAPProvision      = "LDAP_PROVISION_USERS"
+	envLDAPCacheTTL       = "LDAP_CACHE_TTL"
+	envLDAPStartTLS       = "LDAP_START_TLS"
+	defaultLDAPFilter     = "(uid=%s)"
+	defaultLDAPGroupAttr  = "memberOf"
+	defaultLDAPCacheTTL   = time.Minute
+	// ldapPasswordHashType marks the users whose password is verified by the ldap server.
+	ldapPasswordHashType = "ldap"
+)
+
+// ldapConfig configures the authentication of the users against an ldap server.
+type ldapConfig struct {
+	url string
+	// startTLS upgrades the ldap:// connections to TLS, the passwords aren't sent in
+	// clear text.
+	startTLS bool
+	// tlsConfig verifies the certificate of the server, against the system roots if nil.
+	tlsConfig *tls.Config
+	// bindDN is the dn the users bind with, "%s" is replaced by the username,
+	// e.g. "uid=%s,ou=people,dc=example,dc=com" or "%s@example.com" for active directory.
+	bindDN string
+	// baseDN is where the entry of the user is searched with the userFilter, the
+	// entry is read at the bind dn if not set.
+	baseDN     string
+	userFilter string
+	// groupAttribute is the attribute of the entry listing the groups of the user.
+	groupAttribute string
+	// groups maps the dn or the common name of the groups to what their members are granted.
+	groups map[string]groupGrant
+	// provision saves a shadow user record for the users authenticated via ldap.
+	provision bool
+	cacheTTL  time.Duration
+}
+
+// ldapConfigFromEnv returns the ldap config, nil if the ldap authentication isn't enabled.
+func ldapConfigFromEnv() (*ldapConfig, error) {
+	config := &ldapConfig{
+		url:            os.Getenv(envLDAPURL),
+		bindDN:         os.Getenv(envLDAPBindDN),
+		baseDN:         os.Getenv(envLDAPBaseDN),
+		userFilter:     os.Getenv(envLDAPUserFilter),
+		groupAttribute: os.Getenv(envLDAPGroupAttribute),
+	}
+	if config.url == "" {
+		return nil, nil
+	}
+	if value := os.Getenv(envLDAPStartTLS); value != "" {
+		startTLS, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", envLDAPStartTLS, err)
+		}
+		config.startTLS = startTLS
+	}
+	u, err := url.Parse(config.url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", envLDAPURL, err)
+	}
+	switch u.Scheme {
+	case "ldap":
+		if !config.startTLS {
+			return nil, fmt.Errorf("%s: the ldap:// urls send the passwords in clear text, use an ldaps:// url or set %s", envLDAPURL, envLDAPStartTLS)
+		}
+	case "ldaps":
+		if config.startTLS {
+			return nil, fmt.Errorf("%s can't be set with an ldaps:// url", envLDAPStartTLS)
+		}
+	default:
+		return nil, fmt.Errorf("invalid %s: unsupported scheme %q", envLDAPURL, u.Scheme)
+	}
+	if !strings.Contains(config.bindDN, "%s") {
+		return nil, fmt.Errorf("%s must contain %%s, which is replaced by the username", envLDAPBindDN)
+	}
+	if config.userFilter == "" {
+		config.userFilter = defaultLDAPFilter
+	}
+	if _, err := ldapFilter(config.userFilter, ""); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", envLDAPUserFilter, err)
+	}
+	if config.groupAttribute == "" {
+		config.groupAttribute = defaultLDAPGroupAttr
+	}
+
+	groups, err := groupMappingsFromEnv(envLDAPGroupMappings)
+	if err != nil {
+		return nil, err
+	}
+	config.groups = groups
+
+	if value := os.Getenv(envLDAPProvision); value != "" {
+		provision, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", envLDAPProvision, err)
+		}
+		config.provision = provision
+	}
+	config.cacheTTL, err = envDuration(envLDAPCacheTTL, defaultLDAPCacheTTL)
+	if err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// ldapGroupNames returns the names the group can be mapped by, i.e. its dn and the
+// value of its first rdn.
+func ldapGroupNames(dn string) []string {
+	names := []string{strings.ToLower(dn)}
+	rdn := dn
+	if i := strings.Index(dn, ","); i >= 0 {
+		rdn = dn[:i]
+	}
+	if i := strings.Index(rdn, "="); i >= 0 {
+		names = append(names, strings.ToLower(rdn[i+1:]))
+	}
+	return names
+}
+
+// userFor returns the arc user granted what the mapped groups of the user grant.
+func (c *ldapConfig) userFor(username string, groups []string) (*user.User, error) {
+	var grants []groupGrant
+	for _, group := range groups {
+		for _, name := range ldapGroupNames(group) {
+			if grant, ok := c.groups[name]; ok {
+				grants = append(grants, grant)
+				break
+			}
+		}
+	}
+	return userForGrants(username, grants, ldapPasswordHashType)
+}
+
+// ldapGroups binds with the credentials of the user and returns the dns of its groups.
+func (c *ldapConfig) ldapGroups(username, password string) ([]string, error) {
+	// an empty password results in an unauthenticated bind, which always succeeds
+	if username == "" || password == "" {
+		return nil, errInvalidLogin
+	}
+	conn, err := dialLDAP(c.url, c.startTLS, c.tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.close()
+
+	bindDN := strings.Replace(c.bindDN, "%s", escapeDN(username), -1)
+	if err := conn.bind(bindDN, password); err != nil {
+		if e, ok := err.(*ldapError); ok && e.code == ldapResultInvalidCredentials {
+			return nil, errInvalidLogin
+		}
+		return nil, err
+	}
+
+	baseDN, scope, filter := c.baseDN, int64(ldapScopeSubtree), c.userFilter
+	if baseDN == "" {
+		baseDN, scope, filter = bindDN, ldapScopeBase, "(objectClass=*)"
+	}
+	searchFilter, err := ldapFilter(filter, username)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := conn.search(baseDN, scope, searchFilter, []string{c.groupAttribute})
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) != 1 {
+		return nil, fmt.Errorf("expected a single ldap entry for %s, found %d", username, len(entries))
+	}
+	return entries[0].attributes[strings.ToLower(c.groupAttribute)], nil
+}
+
+// ldapCache remembers the users recently authenticated via ldap, against the hash
+// of their credentials, so that the ldap server isn't hit by every request.
+type ldapCache struct {
+	mu    sync.Mutex
+	users map[string]cachedLDAPUser
+}
+
+type cachedLDAPUser struct {
+	user      *user.User
+	expiresAt time.Time
+}
+
+func newLDAPCache() *ldapCache {
+	return &ldapCache{users: make(map[string]cachedLDAPUser)}
+}
+
+func (c *ldapCache) get(key string) (*user.User, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cached, ok := c.users[key]
+	if ok && time.Now().After(cached.expiresAt) {
+		delete(c.users, key)
+		return nil, false
+	}
+	return cached.user, ok
+}
+
+func (c *ldapCache) put(key string, u *user.User, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for k, cached := range c.users {
+		if now.After(cached.expiresAt) {
+			delete(c.users, k)
+		}
+	}
+	c.users[key] = cachedLDAPUser{user: u, expiresAt: now.Add(ttl)}
+}
+
+// usesLDAP checks whether the credential of the username is verified by the ldap server,
+// i.e. there is either no such credential stored or a shadow user record of it.
+func (a *Auth) usesLDAP(obj credential.AuthCredential) bool {
+	if a.ldap == nil {
+		return false
+	}
+	if obj == nil {
+		return true
+	}
+	u, ok := obj.(*user.User)
+	return ok && u.PasswordHashType == ldapPasswordHashType
+}
+
+// ldapAuthenticate authenticates the user against the ldap server and returns the
+// user granted what its groups are mapped to.
+func (a *Auth) ldapAuthenticate(ctx context.Context, username, password string) (*user.User, error) {
+	key := hashToken(username + "\x00" + password)
+	if u, ok := a.ldapUsers.get(key); ok {
+		return u, nil
+	}
+	groups, err := a.ldap.ldapGroups(username, password)
+	if err != nil {
+		return nil, err
+	}
+	u, err := a.ldap.userFor(username, groups)
+	if err != nil {
+		return nil, err
+	}
+	if a.ldap.provision {
+		if _, err := a.es.putUser(ctx, *u); err != nil {
+			log.Errorln(logTag, ": unable to save the shadow record of ldap user", username, ":", err)
+		} else {
+			a.cache.remove(usernameKey(username))
+		}
+	}
+	if a.ldap.cacheTTL > 0 {
+		a.ldapUsers.put(key, u, a.ldap.cacheTTL)
+	}
+	return u, nil
+}
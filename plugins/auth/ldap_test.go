@@ -0,0 +1,267 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/appbaseio/arc/model/category"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// ldapStandIn is an in-process ldap server that supports StartTLS, the simple bind and
+// the search of the entries by their uid. Like most servers, it refuses the binds over
+// connections that aren't encrypted.
+type ldapStandIn struct {
+	listener net.Listener
+	// certificate is presented on StartTLS, the clients trust it via roots
+	certificate tls.Certificate
+	roots       *x509.CertPool
+	// passwords and groups are keyed by the dn of the entries
+	passwords map[string]string
+	groups    map[string][]string
+}
+
+func newLDAPStandIn(t *testing.T) *ldapStandIn {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "ldap"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	raw, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &ldapStandIn{
+		listener:    listener,
+		certificate: tls.Certificate{Certificate: [][]byte{raw}, PrivateKey: key},
+		roots:       x509.NewCertPool(),
+		passwords:   make(map[string]string),
+		groups:      make(map[string][]string),
+	}
+	s.roots.AddCert(cert)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *ldapStandIn) url() string {
+	return "ldap://" + s.listener.Addr().String()
+}
+
+func (s *ldapStandIn) serve(conn net.Conn) {
+	defer func() { conn.Close() }()
+	encrypted := false
+	for {
+		message, err := readBERPacket(conn)
+		if err != nil || len(message.children) < 2 {
+			return
+		}
+		id, request := message.children[0].int(), message.children[1]
+		reply := func(op *berPacket) {
+			conn.Write(berSequence(berInt(berTagInteger, id), op).bytes())
+		}
+		result := func(tag byte, code int64) {
+			reply(berConstructed(berClassApplication, tag,
+				berInt(berTagEnumerated, code), berString(""), berString("")))
+		}
+
+		switch {
+		case request.is(berClassApplication, ldapExtendedRequest):
+			if encrypted || string(request.children[0].value) != ldapStartTLSOID {
+				result(ldapExtendedResponse, ldapResultProtocolError)
+				continue
+			}
+			result(ldapExtendedResponse, ldapResultSuccess)
+			conn = tls.Server(conn, &tls.Config{Certificates: []tls.Certificate{s.certificate}})
+			encrypted = true
+		case request.is(berClassApplication, ldapBindRequest):
+			if !encrypted {
+				result(ldapBindResponse, ldapResultConfidentialityRequired)
+				continue
+			}
+			dn, password := string(request.children[1].value), string(request.children[2].value)
+			if expected, ok := s.passwords[dn]; !ok || expected != password {
+				result(ldapBindResponse, ldapResultInvalidCredentials)
+				continue
+			}
+			result(ldapBindResponse, ldapResultSuccess)
+		case request.is(berClassApplication, ldapSearchRequest):
+			uid := standInFilterValue(request.children[6], "uid")
+			for dn, groups := range s.groups {
+				if !strings.HasPrefix(dn, "uid="+uid+",") {
+					continue
+				}
+				values := berConstructed(berClassUniversal, berTagSet)
+				for _, group := range groups {
+					values.children = append(values.children, berString(group))
+				}
+				reply(berConstructed(berClassApplication, ldapSearchResultItem,
+					berString(dn),
+					berSequence(berSequence(berString("memberOf"), values)),
+				))
+			}
+			result(ldapSearchResultDone, ldapResultSuccess)
+		case request.is(berClassApplication, ldapUnbindRequest):
+			return
+		}
+	}
+}
+
+// standInFilterValue returns the value the filter matches the attribute against.
+func standInFilterValue(filter *berPacket, attribute string) string {
+	if filter.is(berClassContext, ldapFilterEquality) && string(filter.children[0].value) == attribute {
+		return string(filter.children[1].value)
+	}
+	for _, child := range filter.children {
+		if value := standInFilterValue(child, attribute); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+func TestLDAP(t *testing.T) {
+	server := newLDAPStandIn(t)
+	defer server.listener.Close()
+	server.passwords["uid=alice,ou=people,dc=example,dc=com"] = "secret"
+	server.groups["uid=alice,ou=people,dc=example,dc=com"] = []string{
+		"cn=arc-admins,ou=groups,dc=example,dc=com",
+		"cn=developers,ou=groups,dc=example,dc=com",
+	}
+	server.passwords["uid=bob,ou=people,dc=example,dc=com"] = "secret"
+	server.groups["uid=bob,ou=people,dc=example,dc=com"] = []string{
+		"cn=developers,ou=groups,dc=example,dc=com",
+		"cn=marketing,ou=groups,dc=example,dc=com",
+	}
+
+	config := &ldapConfig{
+		url:            server.url(),
+		startTLS:       true,
+		tlsConfig:      &tls.Config{RootCAs: server.roots},
+		bindDN:         "uid=%s,ou=people,dc=example,dc=com",
+		baseDN:         "ou=people,dc=example,dc=com",
+		userFilter:     "(&(objectClass=person)(uid=%s))",
+		groupAttribute: "memberOf",
+		groups: map[string]groupGrant{
+			"cn=arc-admins,ou=groups,dc=example,dc=com": {IsAdmin: true},
+			"developers": {
+				Categories: []category.Category{category.Docs, category.Search},
+				Indices:    []string{"logs-*"},
+			},
+		},
+	}
+
+	Convey("Authenticating against ldap", t, func() {
+		Convey("Binding with valid credentials returns the groups", func() {
+			groups, err := config.ldapGroups("bob", "secret")
+			So(err, ShouldBeNil)
+			So(groups, ShouldHaveLength, 2)
+		})
+
+		Convey("Binding upgrades the connection to tls", func() {
+			plain := *config
+			plain.startTLS = false
+			_, err := plain.ldapGroups("bob", "secret")
+			So(err, ShouldNotBeNil)
+			So(err.(*ldapError).code, ShouldEqual, ldapResultConfidentialityRequired)
+
+			untrusted := *config
+			untrusted.tlsConfig = nil
+			_, err = untrusted.ldapGroups("bob", "secret")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Binding with invalid credentials fails", func() {
+			_, err := config.ldapGroups("bob", "invalid")
+			So(err, ShouldEqual, errInvalidLogin)
+			_, err = config.ldapGroups("bob", "")
+			So(err, ShouldEqual, errInvalidLogin)
+			_, err = config.ldapGroups("bob,ou=people", "secret")
+			So(err, ShouldEqual, errInvalidLogin)
+		})
+
+		Convey("Groups are mapped by their dn or common name", func() {
+			groups, err := config.ldapGroups("alice", "secret")
+			So(err, ShouldBeNil)
+			u, err := config.userFor("alice", groups)
+			So(err, ShouldBeNil)
+			So(*u.IsAdmin, ShouldBeTrue)
+			So(u.PasswordHashType, ShouldEqual, ldapPasswordHashType)
+
+			groups, err = config.ldapGroups("bob", "secret")
+			So(err, ShouldBeNil)
+			u, err = config.userFor("bob", groups)
+			So(err, ShouldBeNil)
+			So(*u.IsAdmin, ShouldBeFalse)
+			So(u.Categories, ShouldResemble, []category.Category{category.Docs, category.Search})
+			So(u.Indices, ShouldResemble, []string{"logs-*"})
+		})
+
+		Convey("Users without a mapped group are rejected", func() {
+			_, err := config.userFor("carol", []string{"cn=marketing,ou=groups,dc=example,dc=com"})
+			So(err, ShouldEqual, errNoMappedGroup)
+		})
+
+		Convey("The ldap:// urls require StartTLS", func() {
+			defer os.Unsetenv(envLDAPURL)
+			defer os.Unsetenv(envLDAPStartTLS)
+			os.Setenv(envLDAPBindDN, "uid=%s,ou=people,dc=example,dc=com")
+			defer os.Unsetenv(envLDAPBindDN)
+
+			os.Setenv(envLDAPURL, "ldap://ldap.example.com")
+			_, err := ldapConfigFromEnv()
+			So(err, ShouldNotBeNil)
+			os.Setenv(envLDAPStartTLS, "true")
+			c, err := ldapConfigFromEnv()
+			So(err, ShouldBeNil)
+			So(c.startTLS, ShouldBeTrue)
+
+			os.Setenv(envLDAPURL, "ldaps://ldap.example.com")
+			_, err = ldapConfigFromEnv()
+			So(err, ShouldNotBeNil)
+			os.Unsetenv(envLDAPStartTLS)
+			_, err = ldapConfigFromEnv()
+			So(err, ShouldBeNil)
+		})
+
+		Convey("Filters and dns are encoded safely", func() {
+			_, err := ldapFilter("(uid=%s", "bob")
+			So(err, ShouldNotBeNil)
+			filter, err := ldapFilter("(uid=%s)", "*)(uid=*")
+			So(err, ShouldBeNil)
+			So(string(filter.children[1].value), ShouldEqual, "*)(uid=*")
+			So(escapeDN("bob,ou=people"), ShouldEqual, `bob\,ou\=people`)
+		})
+	})
+}
@@ -0,0 +1,476 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// The auth plugin only needs the simple bind and the search operations of LDAPv3,
+// the subset of BER required to encode and decode them is implemented below.
+
+const (
+	berClassUniversal   = 0x00
+	berClassApplication = 0x40
+	berClassContext     = 0x80
+
+	berTagBoolean     = 0x01
+	berTagInteger     = 0x02
+	berTagOctetString = 0x04
+	berTagEnumerated  = 0x0a
+	berTagSequence    = 0x10
+	berTagSet         = 0x11
+
+	ldapBindRequest      = 0
+	ldapBindResponse     = 1
+	ldapUnbindRequest    = 2
+	ldapSearchRequest    = 3
+	ldapSearchResultItem = 4
+	ldapSearchResultDone = 5
+	ldapExtendedRequest  = 23
+	ldapExtendedResponse = 24
+
+	// ldapStartTLSOID is the name of the extended operation that upgrades the
+	// connection to TLS.
+	ldapStartTLSOID = "1.3.6.1.4.1.1466.20037"
+
+	ldapFilterAnd      = 0
+	ldapFilterEquality = 3
+	ldapFilterPresent  = 7
+
+	ldapScopeBase    = 0
+	ldapScopeSubtree = 2
+
+	ldapResultSuccess                 = 0
+	ldapResultProtocolError           = 2
+	ldapResultConfidentialityRequired = 13
+	ldapResultInvalidCredentials      = 49
+
+	// ldapMaxPacketSize caps the size of the packets read from the server.
+	ldapMaxPacketSize = 1 << 24
+	ldapTimeout       = 10 * time.Second
+)
+
+// berPacket is a BER encoded element, either primitive with a value or constructed
+// with children.
+type berPacket struct {
+	class       byte
+	constructed bool
+	tag         byte
+	value       []byte
+	children    []*berPacket
+}
+
+func berPrimitive(class, tag byte, value []byte) *berPacket {
+	return &berPacket{class: class, tag: tag, value: value}
+}
+
+func berConstructed(class, tag byte, children ...*berPacket) *berPacket {
+	return &berPacket{class: class, constructed: true, tag: tag, children: children}
+}
+
+func berString(value string) *berPacket {
+	return berPrimitive(berClassUniversal, berTagOctetString, []byte(value))
+}
+
+func berInt(tag byte, value int64) *berPacket {
+	var buf []byte
+	for {
+		buf = append([]byte{byte(value)}, buf...)
+		value >>= 8
+		if (value == 0 && buf[0]&0x80 == 0) || (value == -1 && buf[0]&0x80 != 0) {
+			break
+		}
+	}
+	return berPrimitive(berClassUniversal, tag, buf)
+}
+
+func berBool(value bool) *berPacket {
+	if value {
+		return berPrimitive(berClassUniversal, berTagBoolean, []byte{0xff})
+	}
+	return berPrimitive(berClassUniversal, berTagBoolean, []byte{0x00})
+}
+
+func berSequence(children ...*berPacket) *berPacket {
+	return berConstructed(berClassUniversal, berTagSequence, children...)
+}
+
+// int returns the value of an integer or enumerated packet.
+func (p *berPacket) int() int64 {
+	var value int64
+	for i, b := range p.value {
+		if i == 0 && b&0x80 != 0 {
+			value = -1
+		}
+		value = value<<8 | int64(b)
+	}
+	return value
+}
+
+func (p *berPacket) is(class, tag byte) bool {
+	return p.class == class && p.tag == tag
+}
+
+func (p *berPacket) bytes() []byte {
+	content := p.value
+	if p.constructed {
+		content = nil
+		for _, child := range p.children {
+			content = append(content, child.bytes()...)
+		}
+	}
+	identifier := p.class | p.tag
+	if p.constructed {
+		identifier |= 0x20
+	}
+	buf := []byte{identifier}
+	if n := len(content); n < 0x80 {
+		buf = append(buf, byte(n))
+	} else {
+		var length []byte
+		for ; n > 0; n >>= 8 {
+			length = append([]byte{byte(n)}, length...)
+		}
+		buf = append(buf, 0x80|byte(len(length)))
+		buf = append(buf, length...)
+	}
+	return append(buf, content...)
+}
+
+// readBERPacket reads the next packet from the reader.
+func readBERPacket(r io.Reader) (*berPacket, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if header[0]&0x1f == 0x1f {
+		return nil, fmt.Errorf("ber: multi-byte tags are not supported")
+	}
+	length := int(header[1])
+	if length&0x80 != 0 {
+		n := length & 0x7f
+		if n == 0 || n > 4 {
+			return nil, fmt.Errorf("ber: unsupported length encoding")
+		}
+		buf := make([]byte, n)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		length = 0
+		for _, b := range buf {
+			length = length<<8 | int(b)
+		}
+	}
+	if length > ldapMaxPacketSize {
+		return nil, fmt.Errorf("ber: packet of %d bytes is too large", length)
+	}
+	content := make([]byte, length)
+	if _, err := io.ReadFull(r, content); err != nil {
+		return nil, err
+	}
+
+	p := &berPacket{
+		class:       header[0] & 0xc0,
+		constructed: header[0]&0x20 != 0,
+		tag:         header[0] & 0x1f,
+	}
+	if !p.constructed {
+		p.value = content
+		return p, nil
+	}
+	reader := strings.NewReader(string(content))
+	for reader.Len() > 0 {
+		child, err := readBERPacket(reader)
+		if err != nil {
+			return nil, err
+		}
+		p.children = append(p.children, child)
+	}
+	return p, nil
+}
+
+// ldapFilter parses a search filter composed of equality "(attr=value)", presence
+// "(attr=*)" and "(&...)" filters. The "%s" in the values is replaced by the given
+// argument, which is encoded as is, so it needn't be escaped.
+func ldapFilter(filter, arg string) (*berPacket, error) {
+	p, rest, err := parseLDAPFilter(strings.TrimSpace(filter), arg)
+	if err != nil {
+		return nil, err
+	}
+	if rest != "" {
+		return nil, fmt.Errorf("invalid ldap filter %q: unexpected %q", filter, rest)
+	}
+	return p, nil
+}
+
+func parseLDAPFilter(filter, arg string) (*berPacket, string, error) {
+	if !strings.HasPrefix(filter, "(") {
+		return nil, "", fmt.Errorf("invalid ldap filter %q: expected \"(\"", filter)
+	}
+	if strings.HasPrefix(filter, "(&") {
+		rest := filter[2:]
+		and := berConstructed(berClassContext, ldapFilterAnd)
+		for strings.HasPrefix(rest, "(") {
+			child, r, err := parseLDAPFilter(rest, arg)
+			if err != nil {
+				return nil, "", err
+			}
+			and.children = append(and.children, child)
+			rest = r
+		}
+		if !strings.HasPrefix(rest, ")") || len(and.children) == 0 {
+			return nil, "", fmt.Errorf("invalid ldap filter %q", filter)
+		}
+		return and, rest[1:], nil
+	}
+	end := strings.Index(filter, ")")
+	if end < 0 {
+		return nil, "", fmt.Errorf("invalid ldap filter %q: expected \")\"", filter)
+	}
+	parts := strings.SplitN(filter[1:end], "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return nil, "", fmt.Errorf("invalid ldap filter %q: expected \"(attribute=value)\"", filter)
+	}
+	if parts[1] == "*" {
+		return berPrimitive(berClassContext, ldapFilterPresent, []byte(parts[0])), filter[end+1:], nil
+	}
+	value := strings.Replace(parts[1], "%s", arg, -1)
+	return berConstructed(berClassContext, ldapFilterEquality, berString(parts[0]), berString(value)), filter[end+1:], nil
+}
+
+// ldapEntry is an entry returned by a search.
+type ldapEntry struct {
+	dn         string
+	attributes map[string][]string
+}
+
+// ldapConn is a connection to an LDAP server.
+type ldapConn struct {
+	conn      net.Conn
+	reader    *bufio.Reader
+	messageID int64
+}
+
+// dialLDAP connects to the server at the ldap:// or ldaps:// url. The ldap:// connections
+// are upgraded to TLS via StartTLS when startTLS is set.
+func dialLDAP(rawURL string, startTLS bool, tlsConfig *tls.Config) (*ldapConn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{}
+	if tlsConfig != nil {
+		config = tlsConfig.Clone()
+	}
+	if config.ServerName == "" {
+		config.ServerName = u.Hostname()
+	}
+	host := u.Host
+	dialer := &net.Dialer{Timeout: ldapTimeout}
+	var conn net.Conn
+	switch u.Scheme {
+	case "ldap":
+		if u.Port() == "" {
+			host = net.JoinHostPort(host, "389")
+		}
+		conn, err = dialer.Dial("tcp", host)
+	case "ldaps":
+		if u.Port() == "" {
+			host = net.JoinHostPort(host, "636")
+		}
+		conn, err = tls.DialWithDialer(dialer, "tcp", host, config)
+	default:
+		return nil, fmt.Errorf("unsupported ldap url scheme %q", u.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(ldapTimeout))
+	c := &ldapConn{conn: conn, reader: bufio.NewReader(conn)}
+	if startTLS && u.Scheme == "ldap" {
+		if err := c.startTLS(config); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// startTLS upgrades the connection to TLS, before any credential is sent over it.
+func (c *ldapConn) startTLS(config *tls.Config) error {
+	id, err := c.send(berConstructed(berClassApplication, ldapExtendedRequest,
+		berPrimitive(berClassContext, 0, []byte(ldapStartTLSOID)),
+	))
+	if err != nil {
+		return err
+	}
+	response, err := c.receive(id)
+	if err != nil {
+		return err
+	}
+	if !response.is(berClassApplication, ldapExtendedResponse) {
+		return fmt.Errorf("ldap: unexpected response to the starttls request")
+	}
+	if err := ldapResultError(response); err != nil {
+		return err
+	}
+	// the server must not send anything else before the handshake
+	if c.reader.Buffered() > 0 {
+		return fmt.Errorf("ldap: unexpected data before the tls handshake")
+	}
+	conn := tls.Client(c.conn, config)
+	if err := conn.Handshake(); err != nil {
+		return err
+	}
+	c.conn, c.reader = conn, bufio.NewReader(conn)
+	return nil
+}
+
+func (c *ldapConn) send(op *berPacket) (int64, error) {
+	c.messageID++
+	message := berSequence(berInt(berTagInteger, c.messageID), op)
+	_, err := c.conn.Write(message.bytes())
+	return c.messageID, err
+}
+
+// receive reads the next message with the given id and returns its protocol op.
+func (c *ldapConn) receive(messageID int64) (*berPacket, error) {
+	for {
+		message, err := readBERPacket(c.reader)
+		if err != nil {
+			return nil, err
+		}
+		if len(message.children) < 2 {
+			return nil, fmt.Errorf("ldap: malformed message")
+		}
+		if message.children[0].int() == messageID {
+			return message.children[1], nil
+		}
+	}
+}
+
+// ldapResultError returns the error of an LDAPResult, nil if it succeeded.
+func ldapResultError(result *berPacket) error {
+	if len(result.children) < 3 {
+		return fmt.Errorf("ldap: malformed result")
+	}
+	code := result.children[0].int()
+	if code == ldapResultSuccess {
+		return nil
+	}
+	return &ldapError{code: code, message: string(result.children[2].value)}
+}
+
+type ldapError struct {
+	code    int64
+	message string
+}
+
+func (e *ldapError) Error() string {
+	if e.message == "" {
+		return fmt.Sprintf("ldap: result code %d", e.code)
+	}
+	return fmt.Sprintf("ldap: result code %d: %s", e.code, e.message)
+}
+
+// bind authenticates the connection with the dn and password.
+func (c *ldapConn) bind(dn, password string) error {
+	id, err := c.send(berConstructed(berClassApplication, ldapBindRequest,
+		berInt(berTagInteger, 3),
+		berString(dn),
+		berPrimitive(berClassContext, 0, []byte(password)),
+	))
+	if err != nil {
+		return err
+	}
+	response, err := c.receive(id)
+	if err != nil {
+		return err
+	}
+	if !response.is(berClassApplication, ldapBindResponse) {
+		return fmt.Errorf("ldap: unexpected response to the bind request")
+	}
+	return ldapResultError(response)
+}
+
+// search returns the entries matching the filter within the scope of the base dn.
+func (c *ldapConn) search(baseDN string, scope int64, filter *berPacket, attributes []string) ([]ldapEntry, error) {
+	attrs := berSequence()
+	for _, attr := range attributes {
+		attrs.children = append(attrs.children, berString(attr))
+	}
+	id, err := c.send(berConstructed(berClassApplication, ldapSearchRequest,
+		berString(baseDN),
+		berInt(berTagEnumerated, scope),
+		berInt(berTagEnumerated, 0),
+		berInt(berTagInteger, 0),
+		berInt(berTagInteger, int64(ldapTimeout/time.Second)),
+		berBool(false),
+		filter,
+		attrs,
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []ldapEntry
+	for {
+		response, err := c.receive(id)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case response.is(berClassApplication, ldapSearchResultItem):
+			if len(response.children) < 2 {
+				return nil, fmt.Errorf("ldap: malformed search result entry")
+			}
+			entry := ldapEntry{
+				dn:         string(response.children[0].value),
+				attributes: make(map[string][]string),
+			}
+			for _, attr := range response.children[1].children {
+				if len(attr.children) < 2 {
+					continue
+				}
+				name := strings.ToLower(string(attr.children[0].value))
+				for _, value := range attr.children[1].children {
+					entry.attributes[name] = append(entry.attributes[name], string(value.value))
+				}
+			}
+			entries = append(entries, entry)
+		case response.is(berClassApplication, ldapSearchResultDone):
+			return entries, ldapResultError(response)
+		}
+	}
+}
+
+// close unbinds and closes the connection.
+func (c *ldapConn) close() error {
+	c.send(berPrimitive(berClassApplication, ldapUnbindRequest, nil))
+	return c.conn.Close()
+}
+
+// escapeDN escapes the special characters of a dn attribute value.
+func escapeDN(value string) string {
+	var b strings.Builder
+	for i, r := range value {
+		switch {
+		case strings.ContainsRune(`,+"\<>;=`, r),
+			i == 0 && (r == ' ' || r == '#'),
+			i == len(value)-1 && r == ' ':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r == 0:
+			b.WriteString(`\00`)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
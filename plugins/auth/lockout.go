@@ -0,0 +1,211 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/appbaseio/arc/model/lockout"
+	"github.com/appbaseio/arc/util"
+)
+
+const (
+	// LockoutByUsername identifies the lockouts of a username.
+	LockoutByUsername = "username"
+	// LockoutByIP identifies the lockouts of a source ip.
+	LockoutByIP = "ip"
+)
+
+// lockoutPolicy defines after how many failures and for how long the usernames
+// and source ips are locked out.
+type lockoutPolicy struct {
+	usernameThreshold int
+	ipThreshold       int
+	duration          time.Duration
+	maxDuration       time.Duration
+}
+
+// lockoutDuration returns the duration of the lockout after the given number of
+// failures. It doubles with every failure beyond the threshold, up to the maximum.
+func (p lockoutPolicy) lockoutDuration(failures, threshold int) time.Duration {
+	if threshold <= 0 || failures < threshold {
+		return 0
+	}
+	d := p.duration
+	for i := threshold; i < failures && d < p.maxDuration; i++ {
+		d *= 2
+	}
+	if d > p.maxDuration {
+		d = p.maxDuration
+	}
+	return d
+}
+
+type failureEntry struct {
+	Type        string    `json:"type"`
+	Value       string    `json:"value"`
+	Failures    int       `json:"failures"`
+	LastFailure time.Time `json:"last_failure"`
+	LockedUntil time.Time `json:"locked_until,omitempty"`
+}
+
+// lockoutTracker tracks the failed authentication attempts per username and per
+// source ip. The attempts are tracked in memory, i.e. per arc instance.
+type lockoutTracker struct {
+	mu      sync.Mutex
+	policy  lockoutPolicy
+	entries map[string]*failureEntry
+}
+
+func newLockoutTracker(policy lockoutPolicy) *lockoutTracker {
+	return &lockoutTracker{
+		policy:  policy,
+		entries: make(map[string]*failureEntry),
+	}
+}
+
+func lockoutKey(lockoutType, value string) string {
+	return lockoutType + ":" + value
+}
+
+func (t *lockoutTracker) setPolicy(policy lockoutPolicy) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.policy = policy
+}
+
+// lockedUntil returns the time until which either the username or the source ip is locked out.
+func (t *lockoutTracker) lockedUntil(username, ip string) (time.Time, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	var until time.Time
+	for _, key := range []string{lockoutKey(LockoutByUsername, username), lockoutKey(LockoutByIP, ip)} {
+		if e, ok := t.entries[key]; ok && e.LockedUntil.After(now) && e.LockedUntil.After(until) {
+			until = e.LockedUntil
+		}
+	}
+	return until, !until.IsZero()
+}
+
+// recordFailure records a failed authentication attempt of the username from the source ip.
+// The lockouts it causes are added to the lockout events of the request context, if any.
+func (t *lockoutTracker) recordFailure(req *http.Request, username, ip string) {
+	var events []lockout.Event
+	t.mu.Lock()
+	if username != "" {
+		if e := t.fail(LockoutByUsername, username, t.policy.usernameThreshold); e != nil {
+			events = append(events, *e)
+		}
+	}
+	if ip != "" {
+		if e := t.fail(LockoutByIP, ip, t.policy.ipThreshold); e != nil {
+			events = append(events, *e)
+		}
+	}
+	t.mu.Unlock()
+
+	reqEvents, _ := lockout.FromContext(req.Context())
+	for _, e := range events {
+		log.Warnln(logTag, ": locked out", e.Type, e.Value, "after", e.Failures, "failed attempts until", e.LockedUntil.Format(time.RFC3339))
+		if reqEvents != nil {
+			reqEvents.Add(e)
+		}
+	}
+}
+
+// fail increments the failures of the entry, returning a lockout event if it gets locked out.
+func (t *lockoutTracker) fail(lockoutType, value string, threshold int) *lockout.Event {
+	key := lockoutKey(lockoutType, value)
+	now := time.Now()
+	e, ok := t.entries[key]
+	if !ok || t.isStale(e, now) {
+		e = &failureEntry{Type: lockoutType, Value: value}
+		t.entries[key] = e
+	}
+	e.Failures++
+	e.LastFailure = now
+	d := t.policy.lockoutDuration(e.Failures, threshold)
+	if d == 0 {
+		return nil
+	}
+	e.LockedUntil = now.Add(d)
+	return &lockout.Event{Type: lockoutType, Value: value, Failures: e.Failures, LockedUntil: e.LockedUntil}
+}
+
+// isStale checks whether the failures of the entry can be forgotten, which happens once
+// no failures are recorded for the maximum lockout duration after the lockout ends.
+func (t *lockoutTracker) isStale(e *failureEntry, now time.Time) bool {
+	last := e.LastFailure
+	if e.LockedUntil.After(last) {
+		last = e.LockedUntil
+	}
+	return now.Sub(last) > t.policy.maxDuration
+}
+
+// recordSuccess forgets the failures of the username once it authenticates successfully.
+func (t *lockoutTracker) recordSuccess(username string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.entries, lockoutKey(LockoutByUsername, username))
+}
+
+// list returns the usernames and source ips with recent failures, most recent first.
+func (t *lockoutTracker) list() []failureEntry {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	entries := []failureEntry{}
+	for key, e := range t.entries {
+		if t.isStale(e, now) {
+			delete(t.entries, key)
+			continue
+		}
+		entries = append(entries, *e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].LastFailure.After(entries[j].LastFailure)
+	})
+	return entries
+}
+
+// clear removes the failures and the lockout of the given username or ip. If neither
+// is provided, all the failures and lockouts are removed. It returns the number of
+// entries removed.
+func (t *lockoutTracker) clear(username, ip string) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if username == "" && ip == "" {
+		n := len(t.entries)
+		t.entries = make(map[string]*failureEntry)
+		return n
+	}
+	var n int
+	for _, key := range []string{lockoutKey(LockoutByUsername, username), lockoutKey(LockoutByIP, ip)} {
+		if _, ok := t.entries[key]; ok {
+			delete(t.entries, key)
+			n++
+		}
+	}
+	return n
+}
+
+// purgeStale periodically removes the entries whose failures can be forgotten.
+func (t *lockoutTracker) purgeStale(interval time.Duration) {
+	for range time.Tick(interval) {
+		t.list()
+	}
+}
+
+// writeLockedOut responds to a request whose username or source ip is locked out.
+func writeLockedOut(w http.ResponseWriter, until time.Time) {
+	retryAfter := int(time.Until(until)/time.Second) + 1
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	msg := fmt.Sprintf("too many failed authentication attempts, retry after %d seconds", retryAfter)
+	util.WriteBackError(w, msg, http.StatusTooManyRequests)
+}
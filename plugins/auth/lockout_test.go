@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/appbaseio/arc/model/lockout"
+)
+
+func TestLockout(t *testing.T) {
+	policy := lockoutPolicy{
+		usernameThreshold: 3,
+		ipThreshold:       5,
+		duration:          time.Minute,
+		maxDuration:       10 * time.Minute,
+	}
+
+	Convey("Locking out", t, func() {
+		Convey("doubles the lockout with every failure, up to the maximum", func() {
+			So(policy.lockoutDuration(2, 3), ShouldEqual, 0)
+			So(policy.lockoutDuration(3, 3), ShouldEqual, time.Minute)
+			So(policy.lockoutDuration(4, 3), ShouldEqual, 2*time.Minute)
+			So(policy.lockoutDuration(6, 3), ShouldEqual, 8*time.Minute)
+			So(policy.lockoutDuration(7, 3), ShouldEqual, 10*time.Minute)
+			So(policy.lockoutDuration(100, 0), ShouldEqual, 0)
+		})
+
+		tracker := newLockoutTracker(policy)
+		reqLockouts := &lockout.Events{}
+		req := httptest.NewRequest("GET", "/", nil)
+		req = req.WithContext(lockout.NewContext(req.Context(), reqLockouts))
+
+		Convey("locks out the usernames after the threshold", func() {
+			for i := 0; i < 2; i++ {
+				tracker.recordFailure(req, "alice", "10.0.0.1")
+			}
+			_, locked := tracker.lockedUntil("alice", "10.0.0.2")
+			So(locked, ShouldBeFalse)
+
+			tracker.recordFailure(req, "alice", "10.0.0.1")
+			until, locked := tracker.lockedUntil("alice", "10.0.0.2")
+			So(locked, ShouldBeTrue)
+			So(until, ShouldHappenWithin, time.Second, time.Now().Add(time.Minute))
+			events := reqLockouts.List()
+			So(events, ShouldHaveLength, 1)
+			So(events[0].Type, ShouldEqual, LockoutByUsername)
+			So(events[0].Value, ShouldEqual, "alice")
+
+			// another username from the same ip isn't locked out yet
+			_, locked = tracker.lockedUntil("bob", "10.0.0.1")
+			So(locked, ShouldBeFalse)
+		})
+
+		Convey("locks out the source ips after the threshold", func() {
+			for _, username := range []string{"a", "b", "c", "d", "e"} {
+				tracker.recordFailure(req, username, "10.0.0.1")
+			}
+			_, locked := tracker.lockedUntil("f", "10.0.0.1")
+			So(locked, ShouldBeTrue)
+			events := reqLockouts.List()
+			So(events[len(events)-1].Type, ShouldEqual, LockoutByIP)
+		})
+
+		Convey("forgets the failures of a username authenticating successfully", func() {
+			tracker.recordFailure(req, "alice", "10.0.0.1")
+			tracker.recordFailure(req, "alice", "10.0.0.1")
+			tracker.recordSuccess("alice")
+			tracker.recordFailure(req, "alice", "10.0.0.1")
+			_, locked := tracker.lockedUntil("alice", "")
+			So(locked, ShouldBeFalse)
+		})
+
+		Convey("forgets the stale failures", func() {
+			tracker.recordFailure(req, "alice", "10.0.0.1")
+			tracker.entries[lockoutKey(LockoutByUsername, "alice")].LastFailure = time.Now().Add(-time.Hour)
+			entries := tracker.list()
+			So(entries, ShouldHaveLength, 1)
+			So(entries[0].Type, ShouldEqual, LockoutByIP)
+		})
+
+		Convey("clears the lockouts", func() {
+			tracker.recordFailure(req, "alice", "10.0.0.1")
+			tracker.recordFailure(req, "bob", "10.0.0.2")
+			So(tracker.clear("alice", ""), ShouldEqual, 1)
+			So(tracker.clear("", ""), ShouldEqual, 3)
+			So(tracker.list(), ShouldBeEmpty)
+		})
+	})
+}
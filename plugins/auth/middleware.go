@@ -5,23 +5,26 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"strings"
 
 	log "github.com/sirupsen/logrus"
 
 	"github.com/appbaseio/arc/middleware"
 	"github.com/appbaseio/arc/middleware/classify"
+	"github.com/appbaseio/arc/middleware/explain"
 	"github.com/appbaseio/arc/middleware/validate"
 	"github.com/appbaseio/arc/model/category"
+	"github.com/appbaseio/arc/model/claims"
 	"github.com/appbaseio/arc/model/credential"
 	"github.com/appbaseio/arc/model/index"
 	"github.com/appbaseio/arc/model/op"
 	"github.com/appbaseio/arc/model/permission"
 	"github.com/appbaseio/arc/model/user"
 	"github.com/appbaseio/arc/util"
+	"github.com/appbaseio/arc/util/iplookup"
 	"github.com/dgrijalva/jwt-go"
 	"github.com/dgrijalva/jwt-go/request"
 	"github.com/gorilla/mux"
-	"golang.org/x/crypto/bcrypt"
 )
 
 type chain struct {
@@ -43,6 +46,27 @@ func list() []middleware.Middleware {
 	}
 }
 
+// userChain authenticates the routes through which the users manage their own credentials,
+// which fall under the user category.
+type userChain struct {
+	middleware.Fifo
+}
+
+func (c *userChain) Wrap(h http.HandlerFunc) http.HandlerFunc {
+	return c.Adapt(h, userList()...)
+}
+
+func userList() []middleware.Middleware {
+	return []middleware.Middleware{
+		classifyUserCategory,
+		classifyIndices,
+		classify.Op(),
+		BasicAuth(),
+		validate.Operation(),
+		validate.Category(),
+	}
+}
+
 func classifyIndices(h http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, req *http.Request) {
 		publicKeyIndex := os.Getenv(envPublicKeyEsIndex)
@@ -66,6 +90,52 @@ func classifyCategory(h http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
+func classifyUserCategory(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		userCategory := category.User
+
+		ctx := category.NewContext(req.Context(), &userCategory)
+		req = req.WithContext(ctx)
+
+		h(w, req)
+	}
+}
+
+// isAdmin lets through only the requests authenticated as admin users, the permissions
+// can't be admins.
+func isAdmin(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		ctx := req.Context()
+
+		reqCredential, err := credential.FromContext(ctx)
+		if err != nil {
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, "an error occurred while validating user admin", http.StatusInternalServerError)
+			return
+		}
+		if reqCredential != credential.User {
+			w.Header().Set("www-authenticate", "Basic realm=\"Authentication Required\"")
+			util.WriteBackError(w, "only admin users are allowed to access the route", http.StatusUnauthorized)
+			return
+		}
+
+		reqUser, err := user.FromContext(ctx)
+		if err != nil {
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, "an error occurred while validating user admin", http.StatusInternalServerError)
+			return
+		}
+		if reqUser.IsAdmin == nil || !*reqUser.IsAdmin {
+			msg := fmt.Sprintf(`user with "username"="%s" is not an admin`, reqUser.Username)
+			w.Header().Set("www-authenticate", "Basic realm=\"Authentication Required\"")
+			util.WriteBackError(w, msg, http.StatusUnauthorized)
+			return
+		}
+
+		h(w, req)
+	}
+}
+
 // BasicAuth middleware authenticates each requests against the basic auth credentials.
 func BasicAuth() middleware.Middleware {
 	return Instance().basicAuth
@@ -89,17 +159,48 @@ func (a *Auth) basicAuth(h http.HandlerFunc) http.HandlerFunc {
 			return
 		}
 
+		if t := explain.FromContext(ctx); t != nil {
+			authenticateAs(w, req, h, t.Credential, *reqCategory)
+			return
+		}
+
 		username, password, hasBasicAuth := req.BasicAuth()
-		jwtToken, err := request.ParseFromRequest(req, request.AuthorizationHeaderExtractor, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
-				return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
+		reqIP := iplookup.FromRequest(req)
+		// the one-time password isn't forwarded upstream
+		otp := req.Header.Get(otpHeader)
+		req.Header.Del(otpHeader)
+		var apiKey string
+		var hasAPIKey bool
+		if !hasBasicAuth {
+			// api keys can only be passed as a query param by the requests that read data
+			apiKey, hasAPIKey = apiKeyFromRequest(req, *reqOp == op.Read)
+		}
+		if hasBasicAuth || hasAPIKey {
+			// repeated failures lock out the username and the source ip for a while
+			if until, locked := a.lockouts.lockedUntil(username, reqIP); locked {
+				writeLockedOut(w, until)
+				return
 			}
-			if a.jwtRsaPublicKey == nil {
-				return nil, fmt.Errorf("No Public Key Registered")
+		}
+		if hasAPIKey {
+			username, err = a.validateAPIKey(ctx, apiKey)
+			if err != nil {
+				if err == errInvalidAPIKey {
+					a.lockouts.recordFailure(req, "", reqIP)
+				} else {
+					log.Errorln(logTag, ":", err)
+				}
+				util.WriteBackError(w, errInvalidAPIKey.Error(), http.StatusUnauthorized)
+				return
 			}
-			return a.jwtRsaPublicKey, nil
-		})
-		if !hasBasicAuth && err != nil {
+		}
+		jwtToken, jwtIssuer, err := a.parseJWT(req)
+		// requests without an authorization header can be authenticated by a client certificate
+		var hasCert bool
+		if !hasBasicAuth && !hasAPIKey && err == request.ErrNoTokenInRequest {
+			username, hasCert = a.certBindings.usernameFor(req.TLS)
+		}
+		if !hasBasicAuth && !hasAPIKey && !hasCert && err != nil {
 			var msg string
 			if err == request.ErrNoTokenInRequest {
 				msg = "Basic Auth or JWT is required"
@@ -111,38 +212,71 @@ func (a *Auth) basicAuth(h http.HandlerFunc) http.HandlerFunc {
 			return
 		}
 
-		role := ""
-		if !hasBasicAuth {
-			if claims, ok := jwtToken.Claims.(jwt.MapClaims); ok && jwtToken.Valid {
-				if a.jwtRoleKey != "" && claims[a.jwtRoleKey] != nil {
-					role = claims[a.jwtRoleKey].(string)
-				} else if u, ok := claims["role"]; ok {
-					role = u.(string)
-				} else {
+		var roles []string
+		var sessionClaims jwt.MapClaims
+		if !hasBasicAuth && !hasAPIKey && !hasCert {
+			jwtClaims := jwtToken.Claims.(jwt.MapClaims)
+			if a.revocations.isRevoked(jwtClaims) {
+				w.Header().Set("www-authenticate", "Basic realm=\"Authentication Required\"")
+				util.WriteBackError(w, "JWT has been revoked", http.StatusUnauthorized)
+				return
+			}
+			if jwtIssuer == a.sessionIssuer {
+				// session tokens issued by arc identify the user or permission that logged in
+				username, err = a.validateSessionToken(ctx, jwtClaims)
+				if err != nil {
 					w.Header().Set("www-authenticate", "Basic realm=\"Authentication Required\"")
-					util.WriteBackError(w, fmt.Sprintf("Invalid JWT"), http.StatusUnauthorized)
+					util.WriteBackError(w, fmt.Sprintf("Invalid JWT: %v", err), http.StatusUnauthorized)
 					return
 				}
+				sessionClaims = jwtClaims
 			} else {
-				w.Header().Set("www-authenticate", "Basic realm=\"Authentication Required\"")
-				util.WriteBackError(w, fmt.Sprintf("Invalid JWT"), http.StatusUnauthorized)
-				return
+				var ok bool
+				roles, ok = jwtIssuer.roles(jwtClaims)
+				if !ok {
+					w.Header().Set("www-authenticate", "Basic realm=\"Authentication Required\"")
+					util.WriteBackError(w, fmt.Sprintf("Invalid JWT"), http.StatusUnauthorized)
+					return
+				}
 			}
+			// store the claims in the context, permission filters are rendered using them
+			ctx = claims.NewContext(ctx, claims.Claims(jwtClaims))
 		}
 		// we don't know if the credentials provided here are of a 'user' or a 'permission'
 		var obj credential.AuthCredential
-		if role != "" {
-			obj, err = a.es.getRolePermission(ctx, role)
-			if err != nil || obj == nil {
-				msg := fmt.Sprintf("No API credentials match with provided role: %s", role)
+		var ldapAuthenticated bool
+		if len(roles) > 0 {
+			var rolePermission *permission.Permission
+			rolePermission, err = a.getEffectivePermission(ctx, roles)
+			if err != nil || rolePermission == nil {
+				msg := fmt.Sprintf("No API credentials match with provided roles: %s", strings.Join(roles, ", "))
 				log.Errorln(logTag, ":", err)
 				w.Header().Set("www-authenticate", "Basic realm=\"Authentication Required\"")
 				util.WriteBackError(w, msg, http.StatusUnauthorized)
 				return
 			}
+			obj = rolePermission
 		} else {
 			obj, err = a.getCredential(ctx, username)
+			if err == nil && hasBasicAuth && a.usesLDAP(obj) {
+				// the users that aren't stored in arc are authenticated by the ldap server
+				obj, err = a.ldapAuthenticate(ctx, username, password)
+				if err != nil {
+					if err == errInvalidLogin || err == errNoMappedGroup {
+						a.lockouts.recordFailure(req, username, reqIP)
+					} else {
+						log.Errorln(logTag, ": ldap authentication failed:", err)
+					}
+					w.Header().Set("www-authenticate", "Basic realm=\"Authentication Required\"")
+					util.WriteBackError(w, "invalid username or password", http.StatusUnauthorized)
+					return
+				}
+				ldapAuthenticated = true
+			}
 			if err != nil || obj == nil {
+				if err == nil && hasBasicAuth {
+					a.lockouts.recordFailure(req, username, reqIP)
+				}
 				msg := fmt.Sprintf("No API credentials match with provided username: %s", username)
 				log.Errorln(logTag, ":", err)
 				w.Header().Set("www-authenticate", "Basic realm=\"Authentication Required\"")
@@ -161,11 +295,36 @@ func (a *Auth) basicAuth(h http.HandlerFunc) http.HandlerFunc {
 			{
 				// if the request is made to elasticsearch using user credentials, then the user has to be an admin
 				reqUser := obj.(*user.User)
-				if hasBasicAuth && bcrypt.CompareHashAndPassword([]byte(reqUser.Password), []byte(password)) != nil {
+				if hasAPIKey {
+					util.WriteBackError(w, "api keys can only grant permissions", http.StatusUnauthorized)
+					return
+				}
+				if hasBasicAuth && !ldapAuthenticated && !isUserPassword(reqUser, password) {
+					a.lockouts.recordFailure(req, username, reqIP)
 					w.Header().Set("www-authenticate", "Basic realm=\"Authentication Required\"")
 					util.WriteBackError(w, "invalid password", http.StatusUnauthorized)
 					return
 				}
+				// the client certificates don't replace the second factor
+				if hasBasicAuth || hasCert || sessionClaims != nil {
+					verifiedOTP, err := a.checkSecondFactor(req, reqUser, *reqCategory, otp, sessionClaims)
+					if err != nil {
+						switch err {
+						case errInvalidSecondFactor:
+							a.lockouts.recordFailure(req, username, reqIP)
+							util.WriteBackError(w, err.Error(), http.StatusUnauthorized)
+						case errSecondFactorRequired, errSecondFactorNotEnrolled, errSessionWithoutSecondFactor:
+							util.WriteBackError(w, err.Error(), http.StatusUnauthorized)
+						default:
+							log.Errorln(logTag, ": unable to verify the second factor:", err)
+							util.WriteBackError(w, "error occurred while authenticating the request", http.StatusInternalServerError)
+						}
+						return
+					}
+					if verifiedOTP {
+						ctx = withVerifiedOTP(ctx)
+					}
+				}
 
 				if reqCategory.IsFromES() || reqCategory.IsFromRS() {
 					authenticated = *reqUser.IsAdmin
@@ -182,11 +341,6 @@ func (a *Auth) basicAuth(h http.HandlerFunc) http.HandlerFunc {
 
 				}
 
-				// cache the user
-				if _, ok := a.cachedCredential(username); !ok {
-					a.cacheCredential(username, reqUser)
-				}
-
 				// store request user and credential identifier in the context
 				ctx = credential.NewContext(ctx, credential.User)
 				ctx = user.NewContext(ctx, reqUser)
@@ -195,7 +349,8 @@ func (a *Auth) basicAuth(h http.HandlerFunc) http.HandlerFunc {
 		case *permission.Permission:
 			{
 				reqPermission := obj.(*permission.Permission)
-				if hasBasicAuth && reqPermission.Password != password {
+				if hasBasicAuth && !isPermissionPassword(reqPermission, password) {
+					a.lockouts.recordFailure(req, username, reqIP)
 					w.Header().Set("www-authenticate", "Basic realm=\"Authentication Required\"")
 					util.WriteBackError(w, "invalid password", http.StatusUnauthorized)
 					return
@@ -208,11 +363,6 @@ func (a *Auth) basicAuth(h http.HandlerFunc) http.HandlerFunc {
 					errorMsg = "credential is not allowed to access" + " " + str
 				}
 
-				// cache the permission
-				if _, ok := a.cachedCredential(username); !ok {
-					a.cacheCredential(username, reqPermission)
-				}
-
 				// store the request permission and credential identifier in the context
 				ctx = credential.NewContext(ctx, credential.Permission)
 				ctx = permission.NewContext(ctx, reqPermission)
@@ -221,6 +371,12 @@ func (a *Auth) basicAuth(h http.HandlerFunc) http.HandlerFunc {
 		default:
 			log.Println(logTag, ": unreachable state ...")
 		}
+		if hasBasicAuth {
+			a.lockouts.recordSuccess(username)
+			if !ldapAuthenticated && authenticated {
+				a.rehashPassword(obj, password)
+			}
+		}
 
 		if !authenticated {
 			w.Header().Set("www-authenticate", "Basic realm=\"Authentication Required\"")
@@ -228,45 +384,151 @@ func (a *Auth) basicAuth(h http.HandlerFunc) http.HandlerFunc {
 			return
 		}
 
-		// remove user/permission from cache on write operation
+		h(w, req)
+
+		// remove the modified user/permission from the cache once the write is done
 		if *reqOp == op.Write || *reqOp == op.Delete {
-			username := mux.Vars(req)["username"]
-			a.removeCredentialFromCache(username)
+			a.invalidateCredential(req, *reqCategory)
 		}
+	}
+}
 
-		h(w, req)
+// authenticateAs authenticates the simulated requests of the explain endpoints as the
+// credential being explained, checking its access to the category like basicAuth does.
+// The passwords, second factors and lockouts aren't checked.
+func authenticateAs(w http.ResponseWriter, req *http.Request, h http.HandlerFunc,
+	c credential.AuthCredential, reqCategory category.Category) {
+	ctx := req.Context()
+	switch obj := c.(type) {
+	case *user.User:
+		if (reqCategory.IsFromES() || reqCategory.IsFromRS()) && (obj.IsAdmin == nil || !*obj.IsAdmin) {
+			msg := "only admin users are allowed to access elasticsearch"
+			if reqCategory.IsFromRS() {
+				msg = "only admin users are allowed to access reactivesearch"
+			}
+			util.WriteBackError(w, msg, http.StatusUnauthorized)
+			return
+		}
+		ctx = credential.NewContext(ctx, credential.User)
+		ctx = user.NewContext(ctx, obj)
+	case *permission.Permission:
+		if !obj.HasCategory(reqCategory) {
+			util.WriteBackError(w, "credential is not allowed to access "+reqCategory.String(), http.StatusUnauthorized)
+			return
+		}
+		ctx = credential.NewContext(ctx, credential.Permission)
+		ctx = permission.NewContext(ctx, obj)
+	default:
+		util.WriteBackError(w, "no credential to explain the request for", http.StatusUnauthorized)
+		return
+	}
+	h(w, req.WithContext(ctx))
+}
+
+// parseJWT parses and verifies the jwt in the request using the keys of its issuer.
+func (a *Auth) parseJWT(req *http.Request) (*jwt.Token, *jwtIssuer, error) {
+	tokenString, err := request.AuthorizationHeaderExtractor.ExtractToken(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	return a.parseToken(tokenString)
+}
+
+// parseToken parses and verifies the jwt using the keys of its issuer.
+func (a *Auth) parseToken(tokenString string) (*jwt.Token, *jwtIssuer, error) {
+	// the registered claims are validated against the issuer's clock skew below
+	parser := &jwt.Parser{SkipClaimsValidation: true}
+	token, err := parser.Parse(tokenString, a.jwtKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	tokenClaims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, nil, fmt.Errorf("Invalid JWT")
+	}
+	iss, _ := tokenClaims["iss"].(string)
+	tokenIssuer, ok := a.issuerFor(iss)
+	if !ok {
+		return nil, nil, fmt.Errorf("No Public Key Registered")
+	}
+	if err := tokenIssuer.validateClaims(tokenClaims); err != nil {
+		return nil, nil, err
 	}
+	return token, tokenIssuer, nil
+}
+
+// jwtKey returns the key of the token's issuer, selected by the token's "kid" header.
+func (a *Auth) jwtKey(token *jwt.Token) (interface{}, error) {
+	tokenClaims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, fmt.Errorf("Invalid JWT")
+	}
+	iss, _ := tokenClaims["iss"].(string)
+	tokenIssuer, ok := a.issuerFor(iss)
+	if !ok {
+		return nil, fmt.Errorf("No Public Key Registered")
+	}
+	return tokenIssuer.keyFor(token)
 }
 
 func (a *Auth) getCredential(ctx context.Context, username string) (credential.AuthCredential, error) {
-	c, ok := a.cachedCredential(username)
-	if ok {
+	key := usernameKey(username)
+	if c, ok := a.cache.get(key); ok {
 		return c, nil
 	}
-	return a.es.getCredential(ctx, username)
+	c, err := a.es.getCredential(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+	if c != nil {
+		a.cache.put(key, c)
+	}
+	return c, nil
 }
 
-func (a *Auth) cachedCredential(username string) (credential.AuthCredential, bool) {
-	a.mu.Lock()
-	defer a.mu.Unlock()
-	if c, ok := a.credentialCache[username]; ok {
-		return c, ok
+func (a *Auth) getRolePermission(ctx context.Context, role string) (*permission.Permission, error) {
+	key := roleKey(role)
+	if c, ok := a.cache.get(key); ok {
+		return c.(*permission.Permission), nil
+	}
+	p, err := a.es.getRolePermission(ctx, role)
+	if err != nil {
+		return nil, err
+	}
+	if p != nil {
+		a.cache.put(key, p)
 	}
-	return nil, false
+	return p, nil
 }
 
-func (a *Auth) removeCredentialFromCache(username string) {
-	a.mu.Lock()
-	defer a.mu.Unlock()
-	delete(a.credentialCache, username)
+// getEffectivePermission returns the permission merged from the permissions of the roles.
+// The roles without a permission are ignored, it returns nil if none of them has one.
+func (a *Auth) getEffectivePermission(ctx context.Context, roles []string) (*permission.Permission, error) {
+	var rolePermissions []*permission.Permission
+	for _, role := range roles {
+		p, err := a.getRolePermission(ctx, role)
+		if err != nil {
+			return nil, err
+		}
+		if p != nil {
+			rolePermissions = append(rolePermissions, p)
+		}
+	}
+	return permission.Merge(rolePermissions...), nil
 }
 
-func (a *Auth) cacheCredential(username string, c credential.AuthCredential) {
-	if c == nil {
-		log.Println(logTag, ": cannot cache 'nil' credential, skipping...")
-		return
+// invalidateCredential removes the user or permission modified by the request from the cache.
+func (a *Auth) invalidateCredential(req *http.Request, reqCategory category.Category) {
+	vars := mux.Vars(req)
+	if username, ok := vars["username"]; ok {
+		a.cache.removeCredential(username)
+	}
+	// role routes, i.e. /_role/{name}, modify the permission with the given role
+	if role, ok := vars["name"]; ok {
+		a.cache.remove(roleKey(role))
+	}
+	// users can modify themselves through routes without a username
+	if username, _, ok := req.BasicAuth(); ok && reqCategory == category.User {
+		a.cache.removeCredential(username)
 	}
-	a.mu.Lock()
-	defer a.mu.Unlock()
-	a.credentialCache[username] = c
 }
@@ -0,0 +1,381 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/appbaseio/arc/model/claims"
+	"github.com/appbaseio/arc/model/user"
+	"github.com/dgrijalva/jwt-go"
+)
+
+const (
+	envOIDCIssuerURL         = "OIDC_ISSUER_URL"
+	envOIDCClientID          = "OIDC_CLIENT_ID"
+	envOIDCClientSecret      = "OIDC_CLIENT_SECRET"
+	envOIDCRedirectURL       = "OIDC_REDIRECT_URL"
+	envOIDCScopes            = "OIDC_SCOPES"
+	envOIDCUsernameClaim     = "OIDC_USERNAME_CLAIM"
+	envOIDCGroupsClaim       = "OIDC_GROUPS_CLAIM"
+	envOIDCGroupMappings     = "OIDC_GROUP_MAPPINGS"
+	envOIDCPostLoginRedirect = "OIDC_POST_LOGIN_REDIRECT_URL"
+	defaultOIDCScopes        = "openid profile email"
+	defaultOIDCUsernameClaim = "preferred_username"
+	defaultOIDCGroupsClaim   = "groups"
+	// oidcPasswordHashType marks the users that log in via the openid connect provider.
+	oidcPasswordHashType = "oidc"
+	oidcIssuerName       = "_oidc"
+	// the state of a login in progress is kept in a cookie, as a token signed by arc
+	oidcStateCookie   = "arc_oidc_state"
+	oidcStateAudience = "arc_oidc_state"
+	oidcStateTTL      = 10 * time.Minute
+	// oidcDiscoveryTTL is the duration after which the provider metadata is fetched again.
+	oidcDiscoveryTTL = time.Hour
+)
+
+var errOIDCNotConfigured = fmt.Errorf("openid connect login is not configured")
+
+// oidcConfig configures arc as an openid connect relying party, which logs in the
+// users via the authorization code flow with PKCE.
+type oidcConfig struct {
+	issuerURL     string
+	clientID      string
+	clientSecret  string
+	redirectURL   string
+	scopes        string
+	usernameClaim string
+	groupsClaim   string
+	// groups maps the names of the groups in the groups claim to what their members are granted.
+	groups map[string]groupGrant
+	// postLoginRedirect is where the browser is redirected to with the arc session tokens,
+	// the tokens are returned in the response if not set.
+	postLoginRedirect string
+
+	mu           sync.Mutex
+	provider     *oidcProvider
+	discoveredAt time.Time
+}
+
+// oidcProvider is the metadata of the provider, as served by its discovery endpoint.
+type oidcProvider struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+	// verifier verifies the id tokens issued by the provider.
+	verifier *jwtIssuer
+}
+
+// oidcState is the state of a login in progress.
+type oidcState struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	jwt.StandardClaims
+}
+
+// oidcConfigFromEnv returns the openid connect config, nil if it isn't enabled.
+func oidcConfigFromEnv() (*oidcConfig, error) {
+	config := &oidcConfig{
+		issuerURL:         strings.TrimSuffix(os.Getenv(envOIDCIssuerURL), "/"),
+		clientID:          os.Getenv(envOIDCClientID),
+		clientSecret:      os.Getenv(envOIDCClientSecret),
+		redirectURL:       os.Getenv(envOIDCRedirectURL),
+		scopes:            os.Getenv(envOIDCScopes),
+		usernameClaim:     os.Getenv(envOIDCUsernameClaim),
+		groupsClaim:       os.Getenv(envOIDCGroupsClaim),
+		postLoginRedirect: os.Getenv(envOIDCPostLoginRedirect),
+	}
+	if config.issuerURL == "" {
+		return nil, nil
+	}
+	if config.clientID == "" || config.redirectURL == "" {
+		return nil, fmt.Errorf("%s and %s are required to enable the openid connect login", envOIDCClientID, envOIDCRedirectURL)
+	}
+	if config.scopes == "" {
+		config.scopes = defaultOIDCScopes
+	}
+	if config.usernameClaim == "" {
+		config.usernameClaim = defaultOIDCUsernameClaim
+	}
+	if config.groupsClaim == "" {
+		config.groupsClaim = defaultOIDCGroupsClaim
+	}
+	groups, err := groupMappingsFromEnv(envOIDCGroupMappings)
+	if err != nil {
+		return nil, err
+	}
+	config.groups = groups
+	return config, nil
+}
+
+// discover returns the provider metadata, fetching it from the discovery endpoint
+// unless it was fetched recently.
+func (c *oidcConfig) discover(ctx context.Context) (*oidcProvider, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.provider != nil && time.Since(c.discoveredAt) < oidcDiscoveryTTL {
+		return c.provider, nil
+	}
+
+	discoveryURL := c.issuerURL + "/.well-known/openid-configuration"
+	req, err := http.NewRequest(http.MethodGet, discoveryURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := jwksClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d while fetching %s", resp.StatusCode, discoveryURL)
+	}
+	var provider oidcProvider
+	if err := json.NewDecoder(resp.Body).Decode(&provider); err != nil {
+		return nil, fmt.Errorf("unable to parse the provider metadata at %s: %v", discoveryURL, err)
+	}
+	if strings.TrimSuffix(provider.Issuer, "/") != c.issuerURL {
+		return nil, fmt.Errorf("provider metadata issuer %q doesn't match %q", provider.Issuer, c.issuerURL)
+	}
+	if provider.AuthorizationEndpoint == "" || provider.TokenEndpoint == "" || provider.JWKSURI == "" {
+		return nil, fmt.Errorf("provider metadata at %s is incomplete", discoveryURL)
+	}
+	provider.verifier, err = newJWTIssuer(issuer{
+		Name:     oidcIssuerName,
+		Issuer:   provider.Issuer,
+		Audience: c.clientID,
+		JWKSURL:  provider.JWKSURI,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := provider.verifier.refreshKeys(ctx); err != nil {
+		return nil, err
+	}
+	c.provider, c.discoveredAt = &provider, time.Now()
+	return c.provider, nil
+}
+
+// pkceChallenge returns the S256 code challenge of the code verifier.
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// authorizationURL returns the url the browser is redirected to in order to log in.
+func (c *oidcConfig) authorizationURL(provider *oidcProvider, state oidcState) (string, error) {
+	u, err := url.Parse(provider.AuthorizationEndpoint)
+	if err != nil {
+		return "", err
+	}
+	query := u.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", c.clientID)
+	query.Set("redirect_uri", c.redirectURL)
+	query.Set("scope", c.scopes)
+	query.Set("state", state.State)
+	query.Set("nonce", state.Nonce)
+	query.Set("code_challenge", pkceChallenge(state.Verifier))
+	query.Set("code_challenge_method", "S256")
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// exchange exchanges the authorization code for the id token of the user, and returns
+// the claims of the validated id token.
+func (c *oidcConfig) exchange(ctx context.Context, provider *oidcProvider, code string, state oidcState) (jwt.MapClaims, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", c.redirectURL)
+	form.Set("code_verifier", state.Verifier)
+	if c.clientSecret == "" {
+		form.Set("client_id", c.clientID)
+	}
+	req, err := http.NewRequest(http.MethodPost, provider.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if c.clientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(c.clientID), url.QueryEscape(c.clientSecret))
+	}
+	resp, err := jwksClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var tokens struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+		return nil, fmt.Errorf("unable to parse the token response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token request failed: %s %s", tokens.Error, tokens.ErrorDescription)
+	}
+	if tokens.IDToken == "" {
+		return nil, fmt.Errorf("token response doesn't contain an id token")
+	}
+	return c.validateIDToken(provider, tokens.IDToken, state.Nonce)
+}
+
+// validateIDToken verifies the id token and validates its claims as per the openid
+// connect core spec.
+func (c *oidcConfig) validateIDToken(provider *oidcProvider, idToken, nonce string) (jwt.MapClaims, error) {
+	parser := &jwt.Parser{SkipClaimsValidation: true}
+	token, err := parser.Parse(idToken, provider.verifier.keyFor)
+	if err != nil {
+		return nil, fmt.Errorf("invalid id token: %v", err)
+	}
+	idClaims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, fmt.Errorf("invalid id token")
+	}
+	if err := provider.verifier.validateClaims(idClaims); err != nil {
+		return nil, fmt.Errorf("invalid id token: %v", err)
+	}
+	if _, ok := numericDate(idClaims, "exp"); !ok {
+		return nil, fmt.Errorf("invalid id token: missing exp claim")
+	}
+	if aud, ok := idClaims["aud"].([]interface{}); ok && len(aud) > 1 {
+		if azp, _ := idClaims["azp"].(string); azp != c.clientID {
+			return nil, fmt.Errorf("invalid id token: invalid azp claim")
+		}
+	}
+	if tokenNonce, _ := idClaims["nonce"].(string); subtle.ConstantTimeCompare([]byte(tokenNonce), []byte(nonce)) != 1 {
+		return nil, fmt.Errorf("invalid id token: invalid nonce")
+	}
+	return idClaims, nil
+}
+
+// userFor returns the arc user for the claims of the id token, granted what the
+// mapped groups of the user grant.
+func (c *oidcConfig) userFor(idClaims jwt.MapClaims) (*user.User, error) {
+	value, _ := claims.Claims(idClaims).Lookup(c.usernameClaim)
+	username, _ := value.(string)
+	if username == "" {
+		return nil, fmt.Errorf("id token doesn't contain the %q claim", c.usernameClaim)
+	}
+
+	var groups []string
+	value, _ = claims.Claims(idClaims).Lookup(c.groupsClaim)
+	switch v := value.(type) {
+	case string:
+		groups = append(groups, v)
+	case []interface{}:
+		for _, elem := range v {
+			if group, ok := elem.(string); ok {
+				groups = append(groups, group)
+			}
+		}
+	}
+	var grants []groupGrant
+	for _, group := range groups {
+		if grant, ok := c.groups[strings.ToLower(group)]; ok {
+			grants = append(grants, grant)
+		}
+	}
+	u, err := userForGrants(username, grants, oidcPasswordHashType)
+	if err != nil {
+		return nil, err
+	}
+	if email, ok := idClaims["email"].(string); ok {
+		u.Email = email
+	}
+	return u, nil
+}
+
+// newOIDCState starts a login, generating its state, nonce and PKCE code verifier.
+func newOIDCState() (oidcState, error) {
+	var s oidcState
+	var err error
+	if s.State, err = randomToken(16); err != nil {
+		return s, err
+	}
+	if s.Nonce, err = randomToken(16); err != nil {
+		return s, err
+	}
+	if s.Verifier, err = randomToken(32); err != nil {
+		return s, err
+	}
+	s.Audience = oidcStateAudience
+	s.ExpiresAt = time.Now().Add(oidcStateTTL).Unix()
+	return s, nil
+}
+
+// signOIDCState signs the state of the login with the session signing key, so that
+// the callback can be handled by any arc instance.
+func (a *Auth) signOIDCState(s oidcState) (string, error) {
+	if a.signingKey == nil {
+		return "", fmt.Errorf("sessions are not initialized")
+	}
+	token := jwt.NewWithClaims(edDSA, s)
+	token.Header["kid"] = a.signingKid
+	return token.SignedString(a.signingKey)
+}
+
+// parseOIDCState verifies the signed state of the login.
+func (a *Auth) parseOIDCState(signed string) (oidcState, error) {
+	var s oidcState
+	if a.signingKey == nil {
+		return s, fmt.Errorf("sessions are not initialized")
+	}
+	token, err := jwt.ParseWithClaims(signed, &s, func(token *jwt.Token) (interface{}, error) {
+		if token.Method != edDSA {
+			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
+		}
+		return a.signingKey.Public(), nil
+	})
+	if err != nil || !token.Valid {
+		return s, fmt.Errorf("invalid or expired login state")
+	}
+	if !s.VerifyAudience(oidcStateAudience, true) {
+		return s, fmt.Errorf("invalid login state")
+	}
+	return s, nil
+}
+
+// oidcLogin provisions the shadow user record of the user logged in via the provider
+// and starts an arc session for it.
+func (a *Auth) oidcLogin(ctx context.Context, u *user.User) (*tokenResponse, error) {
+	existing, err := a.es.getCredential(ctx, u.Username)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		// the credentials managed in arc can't be taken over via the provider
+		if shadow, ok := existing.(*user.User); !ok || shadow.PasswordHashType != oidcPasswordHashType {
+			return nil, fmt.Errorf("username %q is already taken by an arc credential", u.Username)
+		}
+	}
+	if _, err := a.es.putUser(ctx, *u); err != nil {
+		return nil, err
+	}
+	a.cache.remove(usernameKey(u.Username))
+
+	sid, err := randomToken(16)
+	if err != nil {
+		return nil, err
+	}
+	s := &session{
+		Type:      sessionDocType,
+		Username:  u.Username,
+		CreatedAt: time.Now().UnixNano() / int64(time.Millisecond),
+	}
+	return a.issueTokens(ctx, sid, s)
+}
@@ -0,0 +1,197 @@
+package auth
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// mockOIDCProvider is a local openid connect provider that issues an id token for
+// a single user in exchange for the authorization codes it handed out.
+type mockOIDCProvider struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu sync.Mutex
+	// authorizations holds the code challenge and nonce of the handed out codes
+	authorizations map[string]url.Values
+	claims         jwt.MapClaims
+}
+
+func newMockOIDCProvider(t *testing.T) *mockOIDCProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &mockOIDCProvider{key: key, authorizations: make(map[string]url.Values)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, req *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 p.server.URL,
+			"authorization_endpoint": p.server.URL + "/authorize",
+			"token_endpoint":         p.server.URL + "/token",
+			"jwks_uri":               p.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, req *http.Request) {
+		json.NewEncoder(w).Encode(jwks{Keys: []jwk{{
+			Kty: "RSA",
+			Kid: "mock",
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, req *http.Request) {
+		clientID, clientSecret, _ := req.BasicAuth()
+		req.ParseForm()
+		p.mu.Lock()
+		authorization, ok := p.authorizations[req.PostForm.Get("code")]
+		delete(p.authorizations, req.PostForm.Get("code"))
+		p.mu.Unlock()
+		if !ok || clientID != "arc" || clientSecret != "secret" ||
+			req.PostForm.Get("grant_type") != "authorization_code" ||
+			pkceChallenge(req.PostForm.Get("code_verifier")) != authorization.Get("code_challenge") {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		idClaims := jwt.MapClaims{
+			"iss":   p.server.URL,
+			"aud":   "arc",
+			"exp":   time.Now().Add(time.Minute).Unix(),
+			"iat":   time.Now().Unix(),
+			"nonce": authorization.Get("nonce"),
+		}
+		for k, v := range p.claims {
+			idClaims[k] = v
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, idClaims)
+		token.Header["kid"] = "mock"
+		idToken, _ := token.SignedString(key)
+		json.NewEncoder(w).Encode(map[string]string{"id_token": idToken, "token_type": "Bearer"})
+	})
+	p.server = httptest.NewServer(mux)
+	return p
+}
+
+// authorize hands out an authorization code for the authorization url, as the
+// provider would after the user logged in.
+func (p *mockOIDCProvider) authorize(authorizationURL string) string {
+	u, _ := url.Parse(authorizationURL)
+	code, _ := randomToken(8)
+	p.mu.Lock()
+	p.authorizations[code] = u.Query()
+	p.mu.Unlock()
+	return code
+}
+
+func TestOIDC(t *testing.T) {
+	provider := newMockOIDCProvider(t)
+	defer provider.server.Close()
+	provider.claims = jwt.MapClaims{
+		"sub":                "1234",
+		"preferred_username": "alice",
+		"email":              "alice@example.com",
+		"groups":             []interface{}{"Arc-Admins", "developers"},
+	}
+
+	config := &oidcConfig{
+		issuerURL:     provider.server.URL,
+		clientID:      "arc",
+		clientSecret:  "secret",
+		redirectURL:   "http://localhost:8000/_oidc/callback",
+		scopes:        defaultOIDCScopes,
+		usernameClaim: defaultOIDCUsernameClaim,
+		groupsClaim:   defaultOIDCGroupsClaim,
+		groups:        map[string]groupGrant{"arc-admins": {IsAdmin: true}},
+	}
+
+	Convey("Logging in via openid connect", t, func() {
+		ctx := context.Background()
+		metadata, err := config.discover(ctx)
+		So(err, ShouldBeNil)
+		state, err := newOIDCState()
+		So(err, ShouldBeNil)
+		authorizationURL, err := config.authorizationURL(metadata, state)
+		So(err, ShouldBeNil)
+
+		Convey("The authorization request uses PKCE", func() {
+			u, err := url.Parse(authorizationURL)
+			So(err, ShouldBeNil)
+			So(u.Query().Get("code_challenge_method"), ShouldEqual, "S256")
+			So(u.Query().Get("code_challenge"), ShouldEqual, pkceChallenge(state.Verifier))
+			So(u.Query().Get("state"), ShouldEqual, state.State)
+		})
+
+		Convey("The code is exchanged for a valid id token", func() {
+			idClaims, err := config.exchange(ctx, metadata, provider.authorize(authorizationURL), state)
+			So(err, ShouldBeNil)
+			u, err := config.userFor(idClaims)
+			So(err, ShouldBeNil)
+			So(u.Username, ShouldEqual, "alice")
+			So(u.Email, ShouldEqual, "alice@example.com")
+			So(*u.IsAdmin, ShouldBeTrue)
+			So(u.PasswordHashType, ShouldEqual, oidcPasswordHashType)
+		})
+
+		Convey("The code can't be exchanged without the code verifier", func() {
+			code := provider.authorize(authorizationURL)
+			other := state
+			other.Verifier = "invalid"
+			_, err := config.exchange(ctx, metadata, code, other)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("The id token must carry the nonce of the login", func() {
+			code := provider.authorize(authorizationURL)
+			other := state
+			other.Nonce = "invalid"
+			_, err := config.exchange(ctx, metadata, code, other)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Users without a mapped group are rejected", func() {
+			_, err := config.userFor(jwt.MapClaims{"preferred_username": "bob", "groups": []interface{}{"marketing"}})
+			So(err, ShouldEqual, errNoMappedGroup)
+		})
+	})
+
+	Convey("Signing the login state", t, func() {
+		_, signingKey, err := ed25519.GenerateKey(rand.Reader)
+		So(err, ShouldBeNil)
+		a := &Auth{signingKid: "kid", signingKey: signingKey}
+		state, err := newOIDCState()
+		So(err, ShouldBeNil)
+		signed, err := a.signOIDCState(state)
+		So(err, ShouldBeNil)
+
+		parsed, err := a.parseOIDCState(signed)
+		So(err, ShouldBeNil)
+		So(parsed.Verifier, ShouldEqual, state.Verifier)
+
+		_, err = a.parseOIDCState(signed + "x")
+		So(err, ShouldNotBeNil)
+
+		// the session access tokens signed with the same key aren't login states
+		accessToken, err := jwt.NewWithClaims(edDSA, jwt.MapClaims{
+			"aud": sessionTokenIssuer,
+			"exp": time.Now().Add(time.Minute).Unix(),
+		}).SignedString(signingKey)
+		So(err, ShouldBeNil)
+		_, err = a.parseOIDCState(accessToken)
+		So(err, ShouldNotBeNil)
+	})
+}
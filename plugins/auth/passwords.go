@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/appbaseio/arc/model/credential"
+	"github.com/appbaseio/arc/model/permission"
+	"github.com/appbaseio/arc/model/user"
+	"github.com/appbaseio/arc/util/hasher"
+)
+
+// rehashing holds the usernames whose password hash is being upgraded, so that the
+// concurrent requests of a credential don't all rehash its password.
+var rehashing sync.Map
+
+// verifyPassword checks the password against the one of the user or permission.
+func verifyPassword(obj credential.AuthCredential, password string) bool {
+	switch c := obj.(type) {
+	case *user.User:
+		return isUserPassword(c, password)
+	case *permission.Permission:
+		return isPermissionPassword(c, password)
+	default:
+		return false
+	}
+}
+
+// isUserPassword verifies the password against the hash stored in the user, with the
+// hasher of its hash type. The users created before the hash types were recorded hold
+// a bcrypt hash.
+func isUserPassword(u *user.User, password string) bool {
+	hashType := u.PasswordHashType
+	if hashType == "" {
+		hashType = hasher.Bcrypt
+	}
+	return hasher.Verify(hashType, u.Password, password)
+}
+
+// isPermissionPassword verifies the password against the one stored in the permission.
+// Permissions that are yet to be migrated still hold their password in plaintext.
+func isPermissionPassword(p *permission.Permission, password string) bool {
+	if p.PasswordHashType == "" {
+		return subtle.ConstantTimeCompare([]byte(p.Password), []byte(password)) == 1
+	}
+	return hasher.Verify(p.PasswordHashType, p.Password, password)
+}
+
+// rehashPassword replaces, in the background, the stored hash of the verified password
+// if it wasn't computed by the configured hasher with its configured cost. This way the
+// credentials are upgraded as they are used.
+func (a *Auth) rehashPassword(obj credential.AuthCredential, password string) {
+	var username, hash, hashType string
+	switch c := obj.(type) {
+	case *user.User:
+		if isExternalUser(c) {
+			return
+		}
+		username, hash, hashType = c.Username, c.Password, c.PasswordHashType
+		if hashType == "" {
+			hashType = hasher.Bcrypt
+		}
+	case *permission.Permission:
+		username, hash, hashType = c.Username, c.Password, c.PasswordHashType
+	default:
+		return
+	}
+	if hashType != "" && !hasher.NeedsRehash(hashType, hash) {
+		return
+	}
+	if _, inProgress := rehashing.LoadOrStore(username, true); inProgress {
+		return
+	}
+
+	go func() {
+		defer rehashing.Delete(username)
+		newHash, newHashType, err := hasher.Hash(password)
+		if err != nil {
+			log.Errorln(logTag, ": unable to rehash the password of", username, ":", err)
+			return
+		}
+		if err := a.es.updatePasswordHash(context.Background(), obj, newHash, newHashType); err != nil {
+			log.Errorln(logTag, ": unable to save the rehashed password of", username, ":", err)
+			return
+		}
+		a.cache.removeCredential(username)
+		log.Println(logTag, ": rehashed the password of", username, "using", newHashType)
+	}()
+}
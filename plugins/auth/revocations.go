@@ -0,0 +1,213 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
+)
+
+// revocation revokes the jwts with the given "jti" claim, the jwts of the given subject
+// issued before a point in time, or all the jwts issued before a point in time.
+type revocation struct {
+	ID           string `json:"id"`
+	JTI          string `json:"jti,omitempty"`
+	Subject      string `json:"subject,omitempty"`
+	Issuer       string `json:"issuer,omitempty"`
+	IssuedBefore string `json:"issued_before,omitempty"`
+	Reason       string `json:"reason,omitempty"`
+	CreatedAt    string `json:"created_at"`
+	// ExpiresAt is the time after which the revocation can be forgotten, i.e. the
+	// time the revoked tokens expire at.
+	ExpiresAt string `json:"expires_at,omitempty"`
+}
+
+// newRevocation validates the revocation and fills in its id and timestamps.
+func newRevocation(r revocation) (revocation, error) {
+	now := time.Now()
+	if r.JTI == "" && r.Subject == "" && r.IssuedBefore == "" {
+		return r, fmt.Errorf(`either "jti", "subject" or "issued_before" must be provided`)
+	}
+	if r.JTI != "" && r.Subject != "" {
+		return r, fmt.Errorf(`"jti" and "subject" cannot be provided together`)
+	}
+	if r.JTI != "" && r.IssuedBefore != "" {
+		return r, fmt.Errorf(`"issued_before" cannot be provided with "jti"`)
+	}
+	if r.Issuer != "" && r.Subject == "" && r.JTI == "" {
+		return r, fmt.Errorf(`"issuer" can only be provided with "jti" or "subject"`)
+	}
+	if r.IssuedBefore == "" && r.Subject != "" {
+		// revoke all the tokens of the subject issued so far, the "iat" claim has a second
+		// precision so the tokens issued during the current second are revoked as well
+		r.IssuedBefore = now.Truncate(time.Second).Add(time.Second).Format(time.RFC3339)
+	}
+	if r.IssuedBefore != "" {
+		if _, err := time.Parse(time.RFC3339, r.IssuedBefore); err != nil {
+			return r, fmt.Errorf(`invalid "issued_before", must be in RFC3339 format: %v`, err)
+		}
+	}
+	if r.ExpiresAt != "" {
+		if _, err := time.Parse(time.RFC3339, r.ExpiresAt); err != nil {
+			return r, fmt.Errorf(`invalid "expires_at", must be in RFC3339 format: %v`, err)
+		}
+	}
+	r.ID = uuid.New().String()
+	r.CreatedAt = now.Format(time.RFC3339)
+	return r, nil
+}
+
+func (r revocation) isExpired(now time.Time) bool {
+	if r.ExpiresAt == "" {
+		return false
+	}
+	expiresAt, err := time.Parse(time.RFC3339, r.ExpiresAt)
+	return err == nil && now.After(expiresAt)
+}
+
+// revocationSet holds the revocations in memory, so that the tokens can be checked
+// against them without querying elasticsearch.
+type revocationSet struct {
+	mu           sync.RWMutex
+	revocations  map[string]revocation
+	jtis         map[string][]revocation
+	subjects     map[string][]revocation
+	issuedBefore time.Time
+}
+
+func newRevocationSet() *revocationSet {
+	s := &revocationSet{}
+	s.set(nil)
+	return s
+}
+
+// set replaces the revocations held by the set.
+func (s *revocationSet) set(revocations []revocation) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.setLocked(revocations)
+}
+
+func (s *revocationSet) setLocked(revocations []revocation) {
+	s.revocations = make(map[string]revocation)
+	s.jtis = make(map[string][]revocation)
+	s.subjects = make(map[string][]revocation)
+	s.issuedBefore = time.Time{}
+	for _, r := range revocations {
+		s.addLocked(r)
+	}
+}
+
+func (s *revocationSet) add(r revocation) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.addLocked(r)
+}
+
+func (s *revocationSet) addLocked(r revocation) {
+	s.revocations[r.ID] = r
+	switch {
+	case r.JTI != "":
+		s.jtis[r.JTI] = append(s.jtis[r.JTI], r)
+	case r.Subject != "":
+		s.subjects[r.Subject] = append(s.subjects[r.Subject], r)
+	default:
+		if t, err := time.Parse(time.RFC3339, r.IssuedBefore); err == nil && t.After(s.issuedBefore) {
+			s.issuedBefore = t
+		}
+	}
+}
+
+// remove removes the revocation with the given id, the set is rebuilt as the global
+// "issued before" time depends on the remaining revocations.
+func (s *revocationSet) remove(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var remaining []revocation
+	for _, r := range s.revocations {
+		if r.ID != id {
+			remaining = append(remaining, r)
+		}
+	}
+	s.setLocked(remaining)
+}
+
+// isRevoked checks whether the token with the given claims has been revoked. Tokens
+// without an "iat" claim are treated as issued before any point in time.
+func (s *revocationSet) isRevoked(c jwt.MapClaims) bool {
+	jti, _ := c["jti"].(string)
+	sub, _ := c["sub"].(string)
+	iss, _ := c["iss"].(string)
+	iat, hasIat := numericDate(c, "iat")
+	issuedBefore := func(value string) bool {
+		t, err := time.Parse(time.RFC3339, value)
+		return err == nil && (!hasIat || iat.Before(t))
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if !s.issuedBefore.IsZero() && (!hasIat || iat.Before(s.issuedBefore)) {
+		return true
+	}
+	if jti != "" {
+		for _, r := range s.jtis[jti] {
+			if r.Issuer == "" || r.Issuer == iss {
+				return true
+			}
+		}
+	}
+	if sub != "" {
+		for _, r := range s.subjects[sub] {
+			if (r.Issuer == "" || r.Issuer == iss) && issuedBefore(r.IssuedBefore) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (s *revocationSet) list() []revocation {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	revocations := []revocation{}
+	for _, r := range s.revocations {
+		revocations = append(revocations, r)
+	}
+	return revocations
+}
+
+// reloadRevocations loads the revocations, which might have been modified by another
+// arc instance, and deletes the ones that have expired.
+func (a *Auth) reloadRevocations(ctx context.Context) error {
+	revocations, err := a.es.getRevocations(ctx)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	var active []revocation
+	for _, r := range revocations {
+		if !r.isExpired(now) {
+			active = append(active, r)
+			continue
+		}
+		if err := a.es.deleteRevocation(ctx, r.ID); err != nil {
+			log.Errorln(logTag, ": unable to delete the expired revocation", r.ID, ":", err)
+		}
+	}
+	a.revocations.set(active)
+	return nil
+}
+
+// refreshRevocations periodically reloads the revocations.
+func (a *Auth) refreshRevocations(interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := a.reloadRevocations(context.Background()); err != nil {
+			log.Errorln(logTag, ": unable to fetch the revocations:", err)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRevocations(t *testing.T) {
+	Convey("Revoking tokens", t, func() {
+		s := newRevocationSet()
+
+		Convey("revokes the tokens of a subject issued up to the revocation", func() {
+			r, err := newRevocation(revocation{Subject: "alice"})
+			So(err, ShouldBeNil)
+			s.set([]revocation{r})
+
+			now := time.Now()
+			issuedAt := func(t time.Time) jwt.MapClaims {
+				return jwt.MapClaims{"sub": "alice", "iat": float64(t.Unix())}
+			}
+			So(s.isRevoked(issuedAt(now)), ShouldBeTrue)
+			So(s.isRevoked(issuedAt(now.Add(-time.Hour))), ShouldBeTrue)
+			So(s.isRevoked(issuedAt(now.Add(2*time.Second))), ShouldBeFalse)
+			So(s.isRevoked(jwt.MapClaims{"sub": "bob", "iat": float64(now.Unix())}), ShouldBeFalse)
+		})
+
+		Convey("revokes the tokens by id", func() {
+			r, err := newRevocation(revocation{JTI: "token-1", Issuer: "idp"})
+			So(err, ShouldBeNil)
+			s.set([]revocation{r})
+			So(s.isRevoked(jwt.MapClaims{"jti": "token-1", "iss": "idp"}), ShouldBeTrue)
+			So(s.isRevoked(jwt.MapClaims{"jti": "token-1", "iss": "other"}), ShouldBeFalse)
+		})
+
+		Convey("revokes the tokens issued before a point in time", func() {
+			r, err := newRevocation(revocation{IssuedBefore: "2024-03-01T00:00:00Z"})
+			So(err, ShouldBeNil)
+			s.set([]revocation{r})
+			cutoff, _ := time.Parse(time.RFC3339, r.IssuedBefore)
+			So(s.isRevoked(jwt.MapClaims{"iat": float64(cutoff.Unix() - 1)}), ShouldBeTrue)
+			So(s.isRevoked(jwt.MapClaims{"iat": float64(cutoff.Unix())}), ShouldBeFalse)
+			So(s.isRevoked(jwt.MapClaims{}), ShouldBeTrue)
+		})
+
+		Convey("keeps the revocations added while removing another", func() {
+			s.set([]revocation{{ID: "old", JTI: "token-0"}})
+			var wg sync.WaitGroup
+			for i := 0; i < 50; i++ {
+				wg.Add(2)
+				id := fmt.Sprintf("token-%d", i+1)
+				go func() {
+					defer wg.Done()
+					s.add(revocation{ID: id, JTI: id})
+				}()
+				go func() {
+					defer wg.Done()
+					s.remove("old")
+				}()
+			}
+			wg.Wait()
+			So(s.list(), ShouldHaveLength, 50)
+			So(s.isRevoked(jwt.MapClaims{"jti": "token-0"}), ShouldBeFalse)
+			So(s.isRevoked(jwt.MapClaims{"jti": "token-50"}), ShouldBeTrue)
+		})
+
+		Convey("validates the revocations", func() {
+			_, err := newRevocation(revocation{})
+			So(err, ShouldNotBeNil)
+			_, err = newRevocation(revocation{JTI: "token-1", Subject: "alice"})
+			So(err, ShouldNotBeNil)
+			_, err = newRevocation(revocation{IssuedBefore: "yesterday"})
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -8,6 +8,7 @@ import (
 
 func (a *Auth) routes() []plugins.Route {
 	middleware := (&chain{}).Wrap
+	userMiddleware := (&userChain{}).Wrap
 	routes := []plugins.Route{
 		{
 			Name:        "Get public key",
@@ -23,6 +24,182 @@ func (a *Auth) routes() []plugins.Route {
 			HandlerFunc: middleware(a.setPublicKey()),
 			Description: "Create or Update the public key",
 		},
+		{
+			Name:        "Get jwt issuers",
+			Methods:     []string{http.MethodGet},
+			Path:        "/_jwt_issuers",
+			HandlerFunc: middleware(a.getIssuers()),
+			Description: "Returns the trusted jwt issuers",
+		},
+		{
+			Name:        "Get jwt issuer",
+			Methods:     []string{http.MethodGet},
+			Path:        "/_jwt_issuer/{name}",
+			HandlerFunc: middleware(a.getIssuer()),
+			Description: "Returns the trusted jwt issuer with the given name",
+		},
+		{
+			Name:        "Put jwt issuer",
+			Methods:     []string{http.MethodPut},
+			Path:        "/_jwt_issuer/{name}",
+			HandlerFunc: middleware(a.putIssuer()),
+			Description: "Create or Update a trusted jwt issuer",
+		},
+		{
+			Name:        "Delete jwt issuer",
+			Methods:     []string{http.MethodDelete},
+			Path:        "/_jwt_issuer/{name}",
+			HandlerFunc: middleware(a.deleteIssuer()),
+			Description: "Deletes a trusted jwt issuer",
+		},
+		{
+			Name:        "Get effective permission",
+			Methods:     []string{http.MethodPost},
+			Path:        "/_effective_permission",
+			HandlerFunc: middleware(a.getEffectivePermissionForToken()),
+			Description: "Returns the permission merged from the roles of the given jwt",
+		},
+		{
+			Name:        "Get jwt revocations",
+			Methods:     []string{http.MethodGet},
+			Path:        "/_revocations",
+			HandlerFunc: middleware(a.getRevocations()),
+			Description: "Returns the jwt revocations",
+		},
+		{
+			Name:        "Post jwt revocation",
+			Methods:     []string{http.MethodPost},
+			Path:        "/_revocations",
+			HandlerFunc: middleware(a.postRevocation()),
+			Description: "Revokes the jwts with a jti, of a subject, or issued before a point in time",
+		},
+		{
+			Name:        "Delete jwt revocation",
+			Methods:     []string{http.MethodDelete},
+			Path:        "/_revocations/{id}",
+			HandlerFunc: middleware(a.deleteRevocation()),
+			Description: "Deletes a jwt revocation",
+		},
+		{
+			Name:        "Get lockouts",
+			Methods:     []string{http.MethodGet},
+			Path:        "/_lockouts",
+			HandlerFunc: middleware(a.getLockouts()),
+			Description: "Returns the usernames and source ips with failed authentication attempts or lockouts",
+		},
+		{
+			Name:        "Delete lockouts",
+			Methods:     []string{http.MethodDelete},
+			Path:        "/_lockouts",
+			HandlerFunc: middleware(a.deleteLockouts()),
+			Description: "Clears the lockout of the given username or ip, or all the lockouts",
+		},
+		{
+			Name:        "Get certificate bindings",
+			Methods:     []string{http.MethodGet},
+			Path:        "/_cert_bindings",
+			HandlerFunc: middleware(isAdmin(a.getCertBindings())),
+			Description: "Returns the bindings of client certificates to users and permissions",
+		},
+		{
+			Name:        "Put certificate binding",
+			Methods:     []string{http.MethodPut},
+			Path:        "/_cert_binding/{name}",
+			HandlerFunc: middleware(isAdmin(a.putCertBinding())),
+			Description: "Binds the client certificates with a common name, san or fingerprint to a user or permission",
+		},
+		{
+			Name:        "Delete certificate binding",
+			Methods:     []string{http.MethodDelete},
+			Path:        "/_cert_binding/{name}",
+			HandlerFunc: middleware(isAdmin(a.deleteCertBinding())),
+			Description: "Deletes a client certificate binding",
+		},
+		{
+			Name:        "Get api keys",
+			Methods:     []string{http.MethodGet},
+			Path:        "/_apikey",
+			HandlerFunc: middleware(a.getAPIKeys()),
+			Description: "Returns the api keys, without the keys themselves",
+		},
+		{
+			Name:        "Post api key",
+			Methods:     []string{http.MethodPost},
+			Path:        "/_apikey",
+			HandlerFunc: middleware(a.postAPIKey()),
+			Description: "Creates an api key that grants a permission, the key is only returned once",
+		},
+		{
+			Name:        "Get api key",
+			Methods:     []string{http.MethodGet},
+			Path:        "/_apikey/{id}",
+			HandlerFunc: middleware(a.getAPIKey()),
+			Description: "Returns the api key with the given id, without the key itself",
+		},
+		{
+			Name:        "Delete api key",
+			Methods:     []string{http.MethodDelete},
+			Path:        "/_apikey/{id}",
+			HandlerFunc: middleware(a.deleteAPIKey()),
+			Description: "Deletes an api key",
+		},
+		{
+			Name:        "Enroll totp",
+			Methods:     []string{http.MethodPost},
+			Path:        totp2FAPath + "enroll",
+			HandlerFunc: userMiddleware(a.enrollTOTP()),
+			Description: "Generates a totp secret for the user, two-factor authentication is enabled once a code is verified",
+		},
+		{
+			Name:        "Verify totp",
+			Methods:     []string{http.MethodPost},
+			Path:        totp2FAPath + "verify",
+			HandlerFunc: userMiddleware(a.verifyTOTP()),
+			Description: "Enables two-factor authentication with a code of the enrolled secret and returns the recovery codes",
+		},
+		{
+			Name:        "Disable totp",
+			Methods:     []string{http.MethodPost},
+			Path:        totp2FAPath + "disable",
+			HandlerFunc: userMiddleware(a.disableTOTP()),
+			Description: "Disables two-factor authentication of the user",
+		},
+		// the session routes authenticate the requests with the credentials they carry
+		{
+			Name:        "Login",
+			Methods:     []string{http.MethodPost},
+			Path:        "/_login",
+			HandlerFunc: a.loginHandler(),
+			Description: "Verifies the credentials and returns an access token and a refresh token",
+		},
+		{
+			Name:        "Refresh token",
+			Methods:     []string{http.MethodPost},
+			Path:        "/_token/refresh",
+			HandlerFunc: a.refreshTokenHandler(),
+			Description: "Exchanges a refresh token for a new access token and refresh token",
+		},
+		{
+			Name:        "OpenID Connect login",
+			Methods:     []string{http.MethodGet},
+			Path:        "/_oidc/login",
+			HandlerFunc: a.oidcLoginHandler(),
+			Description: "Redirects to the openid connect provider to log in",
+		},
+		{
+			Name:        "OpenID Connect callback",
+			Methods:     []string{http.MethodGet},
+			Path:        "/_oidc/callback",
+			HandlerFunc: a.oidcCallbackHandler(),
+			Description: "Completes the openid connect login and starts an arc session",
+		},
+		{
+			Name:        "Logout",
+			Methods:     []string{http.MethodPost},
+			Path:        "/_logout",
+			HandlerFunc: a.logoutHandler(),
+			Description: "Revokes the session of the given refresh token or access token",
+		},
 	}
 	return routes
 }
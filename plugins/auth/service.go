@@ -11,6 +11,7 @@ import (
 type authService interface {
 	getCredential(ctx context.Context, username string) (credential.AuthCredential, error)
 	putUser(ctx context.Context, u user.User) (bool, error)
+	updatePasswordHash(ctx context.Context, obj credential.AuthCredential, hash, hashType string) error
 	getUser(ctx context.Context, username string) (*user.User, error)
 	getRawUser(ctx context.Context, username string) ([]byte, error)
 	putPermission(ctx context.Context, p permission.Permission) (bool, error)
@@ -20,4 +21,25 @@ type authService interface {
 	createIndex(indexName, mapping string) (bool, error)
 	savePublicKey(ctx context.Context, indexName string, record publicKey) (interface{}, error)
 	getPublicKey(ctx context.Context) (publicKey, error)
+	getIssuers(ctx context.Context) ([]issuer, error)
+	saveIssuers(ctx context.Context, issuers []issuer) error
+	getCredentialsSeqNo(ctx context.Context) (int64, error)
+	getCertBindings(ctx context.Context) ([]certBinding, error)
+	saveCertBindings(ctx context.Context, bindings []certBinding) error
+	getSigningKey(ctx context.Context) (*signingKeyRecord, error)
+	createSigningKey(ctx context.Context, record signingKeyRecord) (bool, error)
+	getSession(ctx context.Context, sid string) (*session, error)
+	saveSession(ctx context.Context, sid string, s *session) error
+	deleteSession(ctx context.Context, sid string) error
+	deleteExpiredSessions(ctx context.Context) error
+	getAPIKey(ctx context.Context, id string) (*apiKey, error)
+	getAPIKeys(ctx context.Context) ([]apiKey, error)
+	createAPIKey(ctx context.Context, k apiKey) error
+	deleteAPIKey(ctx context.Context, id string) (bool, error)
+	getTOTPEnrollment(ctx context.Context, username string) (*totpEnrollment, error)
+	saveTOTPEnrollment(ctx context.Context, e *totpEnrollment) error
+	deleteTOTPEnrollment(ctx context.Context, username string) error
+	getRevocations(ctx context.Context) ([]revocation, error)
+	saveRevocation(ctx context.Context, r revocation) error
+	deleteRevocation(ctx context.Context, id string) error
 }
@@ -0,0 +1,405 @@
+package auth
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/appbaseio/arc/model/permission"
+	"github.com/appbaseio/arc/model/user"
+	"github.com/dgrijalva/jwt-go"
+)
+
+const (
+	// sessionIssuerName is the name of the issuer of the arc session tokens.
+	sessionIssuerName = "_arc"
+	// sessionTokenIssuer is the "iss" and "aud" claim of the arc session tokens.
+	sessionTokenIssuer = "arc"
+	sessionDocType     = "session"
+	sessionDocPrefix   = "_session_"
+	// sessionCheckInterval is the duration for which a session is assumed to be active
+	// once verified, sessions logged out via other arc instances are noticed after it.
+	sessionCheckInterval = 30 * time.Second
+)
+
+var (
+	errInvalidLogin        = fmt.Errorf("invalid username or password")
+	errInvalidRefreshToken = fmt.Errorf("invalid or expired refresh token")
+	errInvalidAccessToken  = fmt.Errorf("invalid access token")
+)
+
+// signingKeyRecord is the ed25519 key arc signs the session access tokens with.
+type signingKeyRecord struct {
+	Kid        string `json:"kid"`
+	PrivateKey string `json:"private_key"`
+	CreatedAt  string `json:"created_at"`
+}
+
+// session is a login session whose refresh token can be exchanged for access tokens.
+type session struct {
+	Type             string `json:"type"`
+	Username         string `json:"username"`
+	RefreshTokenHash string `json:"refresh_token_hash"`
+	CreatedAt        int64  `json:"created_at"`
+	ExpiresAt        int64  `json:"expires_at"`
+	// AuthMethods are the methods the session was authenticated with, e.g. "pwd" and "otp".
+	AuthMethods []string `json:"auth_methods,omitempty"`
+	seqNo       int64
+	primaryTerm int64
+}
+
+// tokenResponse is returned on login and on refreshing a session.
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	TokenType        string `json:"token_type"`
+	ExpiresIn        int64  `json:"expires_in"`
+	RefreshToken     string `json:"refresh_token"`
+	RefreshExpiresIn int64  `json:"refresh_expires_in"`
+}
+
+// sessionCache remembers the sessions that were recently verified to be active. An entry
+// is only relevant for sessionCheckInterval, the stale entries are swept once per interval
+// so that the sessions which are never checked again, e.g. once expired, don't pile up.
+type sessionCache struct {
+	mu         sync.Mutex
+	verifiedAt map[string]time.Time
+	sweptAt    time.Time
+}
+
+func newSessionCache() *sessionCache {
+	return &sessionCache{verifiedAt: make(map[string]time.Time), sweptAt: time.Now()}
+}
+
+func (c *sessionCache) isActive(sid string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	verifiedAt, ok := c.verifiedAt[sid]
+	if ok && time.Since(verifiedAt) > sessionCheckInterval {
+		delete(c.verifiedAt, sid)
+		return false
+	}
+	return ok
+}
+
+func (c *sessionCache) put(sid string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if now.Sub(c.sweptAt) > sessionCheckInterval {
+		for id, verifiedAt := range c.verifiedAt {
+			if now.Sub(verifiedAt) > sessionCheckInterval {
+				delete(c.verifiedAt, id)
+			}
+		}
+		c.sweptAt = now
+	}
+	c.verifiedAt[sid] = now
+}
+
+func (c *sessionCache) remove(sid string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.verifiedAt, sid)
+}
+
+func randomToken(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// initSessions loads the key the session tokens are signed with, generating one
+// if no arc instance has done so yet.
+func (a *Auth) initSessions(ctx context.Context) error {
+	record, err := a.es.getSigningKey(ctx)
+	if err != nil {
+		return err
+	}
+	if record == nil {
+		_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return err
+		}
+		kid, err := randomToken(8)
+		if err != nil {
+			return err
+		}
+		record = &signingKeyRecord{
+			Kid:        kid,
+			PrivateKey: base64.StdEncoding.EncodeToString(privateKey.Seed()),
+			CreatedAt:  time.Now().Format(time.RFC3339),
+		}
+		created, err := a.es.createSigningKey(ctx, *record)
+		if err != nil {
+			return err
+		}
+		if !created {
+			// another arc instance generated the key in the meantime
+			if record, err = a.es.getSigningKey(ctx); err != nil || record == nil {
+				return fmt.Errorf("unable to fetch the session signing key: %v", err)
+			}
+		}
+	}
+
+	seed, err := base64.StdEncoding.DecodeString(record.PrivateKey)
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return fmt.Errorf("invalid session signing key")
+	}
+	privateKey := ed25519.NewKeyFromSeed(seed)
+	sessionIssuer, err := newJWTIssuer(issuer{
+		Name:       sessionIssuerName,
+		Issuer:     sessionTokenIssuer,
+		Audience:   sessionTokenIssuer,
+		Algorithms: []string{edDSA.Alg()},
+		Keys: []jwk{{
+			Kty: "OKP",
+			Kid: record.Kid,
+			Crv: "Ed25519",
+			X:   base64.RawURLEncoding.EncodeToString(privateKey.Public().(ed25519.PublicKey)),
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	a.signingKid, a.signingKey, a.sessionIssuer = record.Kid, privateKey, sessionIssuer
+	return nil
+}
+
+// issuerFor returns the issuer of a token with the given "iss" claim.
+func (a *Auth) issuerFor(iss string) (*jwtIssuer, bool) {
+	if a.sessionIssuer != nil && iss == sessionTokenIssuer {
+		return a.sessionIssuer, true
+	}
+	return a.issuers.forToken(iss)
+}
+
+// login verifies the credentials, along with the one-time password of the users with
+// two-factor authentication enabled, and starts a new session for them.
+func (a *Auth) login(ctx context.Context, username, password, otp string) (*tokenResponse, error) {
+	obj, err := a.getCredential(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+	if obj == nil || !verifyPassword(obj, password) {
+		return nil, errInvalidLogin
+	}
+	if p, ok := obj.(*permission.Permission); ok {
+		if expired, err := p.IsExpired(); err != nil || expired {
+			return nil, errInvalidLogin
+		}
+	}
+	a.rehashPassword(obj, password)
+	methods := []string{authMethodPassword}
+	if u, ok := obj.(*user.User); ok {
+		secondFactor, err := a.loginSecondFactor(ctx, u, otp)
+		if err != nil {
+			return nil, err
+		}
+		if secondFactor {
+			methods = append(methods, authMethodOTP)
+		}
+	}
+
+	sid, err := randomToken(16)
+	if err != nil {
+		return nil, err
+	}
+	s := &session{
+		Type:        sessionDocType,
+		Username:    username,
+		CreatedAt:   time.Now().UnixNano() / int64(time.Millisecond),
+		AuthMethods: methods,
+	}
+	return a.issueTokens(ctx, sid, s)
+}
+
+// loginSecondFactor verifies the one-time password of a user logging in, it returns
+// whether the session is authenticated with a second factor.
+func (a *Auth) loginSecondFactor(ctx context.Context, u *user.User, otp string) (bool, error) {
+	if isExternalUser(u) {
+		return false, nil
+	}
+	e, err := a.getEnabledTOTP(ctx, u.Username)
+	if err != nil {
+		return false, err
+	}
+	if e == nil {
+		if a.totp != nil && a.totp.requiredForAdmins && *u.IsAdmin {
+			return false, errSecondFactorNotEnrolled
+		}
+		return false, nil
+	}
+	if otp == "" {
+		return false, errSecondFactorRequired
+	}
+	// the codes can't be replayed to start another session
+	if err := a.verifySecondFactor(ctx, e, otp, true); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// refresh exchanges the refresh token of a session for a new access and refresh token.
+func (a *Auth) refresh(ctx context.Context, refreshToken string) (*tokenResponse, error) {
+	sid, s, err := a.getSessionForRefreshToken(ctx, refreshToken)
+	if err != nil {
+		return nil, err
+	}
+	// the user or permission might have been deleted since the login
+	obj, err := a.getCredential(ctx, s.Username)
+	if err != nil || obj == nil {
+		return nil, errInvalidRefreshToken
+	}
+	return a.issueTokens(ctx, sid, s)
+}
+
+// logout ends the session of the refresh or access token.
+func (a *Auth) logout(ctx context.Context, token string) error {
+	var sid string
+	if strings.Count(token, ".") == 2 {
+		jwtToken, tokenIssuer, err := a.parseToken(token)
+		if err != nil || tokenIssuer != a.sessionIssuer {
+			return errInvalidAccessToken
+		}
+		sid, _ = jwtToken.Claims.(jwt.MapClaims)["sid"].(string)
+	} else {
+		var err error
+		sid, _, err = a.getSessionForRefreshToken(ctx, token)
+		if err != nil {
+			return err
+		}
+	}
+	if sid == "" {
+		return errInvalidAccessToken
+	}
+	a.activeSessions.remove(sid)
+	return a.es.deleteSession(ctx, sid)
+}
+
+// getSessionForRefreshToken returns the active session the refresh token belongs to.
+func (a *Auth) getSessionForRefreshToken(ctx context.Context, refreshToken string) (string, *session, error) {
+	parts := strings.SplitN(refreshToken, ".", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return "", nil, errInvalidRefreshToken
+	}
+	sid, secret := parts[0], parts[1]
+	s, err := a.es.getSession(ctx, sid)
+	if err != nil {
+		return "", nil, err
+	}
+	if s == nil || subtle.ConstantTimeCompare([]byte(s.RefreshTokenHash), []byte(hashToken(secret))) != 1 {
+		return "", nil, errInvalidRefreshToken
+	}
+	if time.Now().After(time.Unix(0, s.ExpiresAt*int64(time.Millisecond))) {
+		return "", nil, errInvalidRefreshToken
+	}
+	return sid, s, nil
+}
+
+// issueTokens rotates the refresh token of the session and signs a new access token.
+func (a *Auth) issueTokens(ctx context.Context, sid string, s *session) (*tokenResponse, error) {
+	if a.sessionIssuer == nil {
+		return nil, fmt.Errorf("sessions are not initialized")
+	}
+	secret, err := randomToken(32)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	s.RefreshTokenHash = hashToken(secret)
+	s.ExpiresAt = now.Add(a.refreshTokenTTL).UnixNano() / int64(time.Millisecond)
+	// a refresh token can only be used once, a concurrent refresh with the same token fails
+	if err := a.es.saveSession(ctx, sid, s); err != nil {
+		return nil, err
+	}
+
+	jti, err := randomToken(16)
+	if err != nil {
+		return nil, err
+	}
+	tokenClaims := jwt.MapClaims{
+		"iss": sessionTokenIssuer,
+		"aud": sessionTokenIssuer,
+		"sub": s.Username,
+		"sid": sid,
+		"jti": jti,
+		"iat": now.Unix(),
+		"exp": now.Add(a.accessTokenTTL).Unix(),
+	}
+	if len(s.AuthMethods) > 0 {
+		tokenClaims["amr"] = s.AuthMethods
+	}
+	token := jwt.NewWithClaims(edDSA, tokenClaims)
+	token.Header["kid"] = a.signingKid
+	accessToken, err := token.SignedString(a.signingKey)
+	if err != nil {
+		return nil, err
+	}
+	a.activeSessions.put(sid)
+
+	return &tokenResponse{
+		AccessToken:      accessToken,
+		TokenType:        "Bearer",
+		ExpiresIn:        int64(a.accessTokenTTL / time.Second),
+		RefreshToken:     sid + "." + secret,
+		RefreshExpiresIn: int64(a.refreshTokenTTL / time.Second),
+	}, nil
+}
+
+// validateSessionToken validates a session access token and returns its username.
+func (a *Auth) validateSessionToken(ctx context.Context, c jwt.MapClaims) (string, error) {
+	username, _ := c["sub"].(string)
+	sid, _ := c["sid"].(string)
+	if _, hasExpiry := numericDate(c, "exp"); !hasExpiry || username == "" || sid == "" {
+		return "", errInvalidAccessToken
+	}
+	if a.activeSessions.isActive(sid) {
+		return username, nil
+	}
+	s, err := a.es.getSession(ctx, sid)
+	if err != nil {
+		return "", err
+	}
+	if s == nil || s.Username != username {
+		return "", fmt.Errorf("session has ended")
+	}
+	a.activeSessions.put(sid)
+	return username, nil
+}
+
+// hasAuthMethod checks whether the "amr" claim of a session token contains the method.
+func hasAuthMethod(c jwt.MapClaims, method string) bool {
+	methods, _ := c["amr"].([]interface{})
+	for _, m := range methods {
+		if s, ok := m.(string); ok && s == method {
+			return true
+		}
+	}
+	return false
+}
+
+// purgeExpiredSessions periodically deletes the sessions whose refresh tokens have expired.
+func (a *Auth) purgeExpiredSessions(interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := a.es.deleteExpiredSessions(context.Background()); err != nil {
+			log.Errorln(logTag, ": unable to delete the expired sessions:", err)
+		}
+	}
+}
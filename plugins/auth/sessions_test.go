@@ -0,0 +1,44 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSessionCache(t *testing.T) {
+	Convey("Caching the verified sessions", t, func() {
+		c := newSessionCache()
+
+		Convey("remembers the sessions for the check interval", func() {
+			c.put("a")
+			So(c.isActive("a"), ShouldBeTrue)
+			So(c.isActive("b"), ShouldBeFalse)
+
+			c.verifiedAt["a"] = time.Now().Add(-sessionCheckInterval - time.Second)
+			So(c.isActive("a"), ShouldBeFalse)
+			So(c.verifiedAt, ShouldNotContainKey, "a")
+		})
+
+		Convey("forgets the sessions logged out", func() {
+			c.put("a")
+			c.remove("a")
+			So(c.isActive("a"), ShouldBeFalse)
+		})
+
+		Convey("sweeps the stale sessions", func() {
+			stale := time.Now().Add(-sessionCheckInterval - time.Second)
+			c.verifiedAt["a"], c.verifiedAt["b"] = stale, stale
+			c.put("c")
+			// the sweep runs at most once per interval
+			So(c.verifiedAt, ShouldHaveLength, 3)
+
+			c.sweptAt = stale
+			c.put("d")
+			So(c.verifiedAt, ShouldHaveLength, 2)
+			So(c.verifiedAt, ShouldContainKey, "c")
+			So(c.verifiedAt, ShouldContainKey, "d")
+		})
+	})
+}
@@ -0,0 +1,334 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/appbaseio/arc/model/category"
+	"github.com/appbaseio/arc/model/user"
+	"github.com/dgrijalva/jwt-go"
+)
+
+const (
+	envTOTPIssuer             = "TOTP_ISSUER"
+	envTOTPEnforcedCategories = "TOTP_ENFORCED_CATEGORIES"
+	envTOTPRequiredForAdmins  = "TOTP_REQUIRED_FOR_ADMINS"
+	defaultTOTPIssuer         = "arc"
+	totpDocType               = "totp"
+	totpDocPrefix             = "_totp_"
+	// otpHeader carries the one-time password of the basic auth requests.
+	otpHeader = "X-Arc-OTP"
+	// totp2FAPath is the prefix of the routes that manage the second factor of the user.
+	totp2FAPath = "/_user/_2fa/"
+	// the codes are generated as per rfc 6238 with its default parameters
+	totpPeriod = 30
+	totpDigits = 6
+	// totpSkew is the number of periods before and after the current one whose codes
+	// are accepted, it makes up for clock drift and the time it takes to enter a code.
+	totpSkew          = 1
+	totpSecretSize    = 20
+	recoveryCodeCount = 10
+	// authMethodPassword and authMethodOTP are the "amr" claims of the session tokens.
+	authMethodPassword = "pwd"
+	authMethodOTP      = "otp"
+)
+
+var (
+	errSecondFactorRequired    = fmt.Errorf("a one-time password is required in the %s header", otpHeader)
+	errInvalidSecondFactor     = fmt.Errorf("invalid one-time password")
+	errSecondFactorNotEnrolled = fmt.Errorf("two-factor authentication must be enabled, enroll via %senroll", totp2FAPath)
+	errTOTPModified            = fmt.Errorf("two-factor authentication was modified concurrently")
+	// errSessionWithoutSecondFactor is returned for the sessions started before the user
+	// enabled two-factor authentication.
+	errSessionWithoutSecondFactor = fmt.Errorf("the session isn't authenticated with a one-time password, log in again")
+)
+
+var defaultTOTPEnforcedCategories = []category.Category{category.User, category.Permission}
+
+// totpPolicy configures which requests must be authenticated with a second factor.
+type totpPolicy struct {
+	// issuer is the name the authenticator apps display the codes with.
+	issuer string
+	// enforced are the categories that the users with two-factor authentication enabled
+	// can only access with a one-time password.
+	enforced []category.Category
+	// requiredForAdmins rejects the sessions and the requests to the enforced categories
+	// of the admin users that haven't enabled two-factor authentication.
+	requiredForAdmins bool
+}
+
+func totpPolicyFromEnv() (*totpPolicy, error) {
+	policy := &totpPolicy{
+		issuer:   os.Getenv(envTOTPIssuer),
+		enforced: defaultTOTPEnforcedCategories,
+	}
+	if policy.issuer == "" {
+		policy.issuer = defaultTOTPIssuer
+	}
+	if value, ok := os.LookupEnv(envTOTPEnforcedCategories); ok {
+		policy.enforced = nil
+		for _, name := range strings.Split(value, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+			var c category.Category
+			if err := json.Unmarshal([]byte(strconv.Quote(name)), &c); err != nil {
+				return nil, fmt.Errorf("invalid %s: %v", envTOTPEnforcedCategories, err)
+			}
+			policy.enforced = append(policy.enforced, c)
+		}
+	}
+	if value := os.Getenv(envTOTPRequiredForAdmins); value != "" {
+		required, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", envTOTPRequiredForAdmins, err)
+		}
+		policy.requiredForAdmins = required
+	}
+	return policy, nil
+}
+
+func (p *totpPolicy) enforces(c category.Category) bool {
+	return hasCategory(p.enforced, c)
+}
+
+// totpEnrollment is the totp secret of a user. Only the hashes of the recovery codes
+// are stored, each of them can be used once in place of a code.
+type totpEnrollment struct {
+	Type               string   `json:"type"`
+	Username           string   `json:"username"`
+	Secret             string   `json:"secret"`
+	Enabled            bool     `json:"enabled"`
+	RecoveryCodeHashes []string `json:"recovery_code_hashes"`
+	CreatedAt          string   `json:"created_at"`
+	EnabledAt          string   `json:"enabled_at,omitempty"`
+	seqNo              int64
+	primaryTerm        int64
+}
+
+// totpEnrollmentResponse is returned when a user starts the enrollment, the secret
+// is to be added to an authenticator app, either as is or by scanning the url.
+type totpEnrollmentResponse struct {
+	Secret     string `json:"secret"`
+	OTPAuthURL string `json:"otpauth_url"`
+}
+
+func newTOTPEnrollment(username string) (*totpEnrollment, error) {
+	buf := make([]byte, totpSecretSize)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, err
+	}
+	return &totpEnrollment{
+		Type:      totpDocType,
+		Username:  username,
+		Secret:    base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(buf),
+		CreatedAt: time.Now().Format(time.RFC3339),
+	}, nil
+}
+
+// otpAuthURL returns the key uri understood by the authenticator apps.
+func (e *totpEnrollment) otpAuthURL(issuer string) string {
+	params := url.Values{}
+	params.Set("secret", e.Secret)
+	params.Set("issuer", issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", strconv.Itoa(totpDigits))
+	params.Set("period", strconv.Itoa(totpPeriod))
+	label := url.PathEscape(issuer + ":" + e.Username)
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+// totpCode returns the code of the secret for the given period.
+func totpCode(secret string, step int64) (string, error) {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	code := strconv.FormatUint(uint64(value%1000000), 10)
+	return strings.Repeat("0", totpDigits-len(code)) + code, nil
+}
+
+// matchTOTP returns the period of the code if it is valid at the given time.
+func matchTOTP(secret, code string, now time.Time) (int64, bool) {
+	if len(code) != totpDigits {
+		return 0, false
+	}
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		expected, err := totpCode(secret, step)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// newRecoveryCodes generates the recovery codes and returns them along with their hashes.
+func newRecoveryCodes() ([]string, []string, error) {
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		// 80 random bits make the codes infeasible to recover from their hashes
+		buf := make([]byte, 10)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(encoding.EncodeToString(buf))
+		codes[i] = code[:4] + "-" + code[4:8] + "-" + code[8:12] + "-" + code[12:]
+		hashes[i] = hashToken(code)
+	}
+	return codes, hashes, nil
+}
+
+// normalizeRecoveryCode ignores the case and the separators of the recovery codes.
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.Replace(code, "-", "", -1)
+	return strings.Replace(code, " ", "", -1)
+}
+
+// usedTOTPSteps remembers the last period whose code each user logged in with, so
+// that a code can't be replayed to start another session.
+type usedTOTPSteps struct {
+	mu    sync.Mutex
+	steps map[string]int64
+}
+
+func newUsedTOTPSteps() *usedTOTPSteps {
+	return &usedTOTPSteps{steps: make(map[string]int64)}
+}
+
+// use marks the period as used by the user, it returns false if it already was.
+func (u *usedTOTPSteps) use(username string, step int64) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	current := time.Now().Unix() / totpPeriod
+	for name, s := range u.steps {
+		if s < current-totpSkew {
+			delete(u.steps, name)
+		}
+	}
+	if last, ok := u.steps[username]; ok && step <= last {
+		return false
+	}
+	u.steps[username] = step
+	return true
+}
+
+// isExternalUser checks whether the user authenticates via an external identity
+// provider, which is in charge of its second factor.
+func isExternalUser(u *user.User) bool {
+	return u.PasswordHashType == ldapPasswordHashType || u.PasswordHashType == oidcPasswordHashType
+}
+
+// verifySecondFactor verifies the code, either a totp code or one of the recovery codes,
+// against the enabled enrollment of the user. The recovery codes are consumed on use, the
+// totp codes only if singleUse is set.
+func (a *Auth) verifySecondFactor(ctx context.Context, e *totpEnrollment, code string, singleUse bool) error {
+	code = strings.TrimSpace(code)
+	if step, ok := matchTOTP(e.Secret, code, time.Now()); ok {
+		if singleUse && !a.totpSteps.use(e.Username, step) {
+			return errInvalidSecondFactor
+		}
+		return nil
+	}
+	hash := hashToken(normalizeRecoveryCode(code))
+	for i, h := range e.RecoveryCodeHashes {
+		if subtle.ConstantTimeCompare([]byte(h), []byte(hash)) != 1 {
+			continue
+		}
+		remaining := make([]string, 0, len(e.RecoveryCodeHashes)-1)
+		remaining = append(remaining, e.RecoveryCodeHashes[:i]...)
+		remaining = append(remaining, e.RecoveryCodeHashes[i+1:]...)
+		e.RecoveryCodeHashes = remaining
+		// a concurrent use of the same recovery code fails to save the enrollment
+		if err := a.es.saveTOTPEnrollment(ctx, e); err != nil {
+			if err == errTOTPModified {
+				return errInvalidSecondFactor
+			}
+			return err
+		}
+		return nil
+	}
+	return errInvalidSecondFactor
+}
+
+// getEnabledTOTP returns the enrollment of the user if it has two-factor authentication enabled.
+func (a *Auth) getEnabledTOTP(ctx context.Context, username string) (*totpEnrollment, error) {
+	e, err := a.es.getTOTPEnrollment(ctx, username)
+	if err != nil || e == nil || !e.Enabled {
+		return nil, err
+	}
+	return e, nil
+}
+
+// checkSecondFactor verifies the second factor of a basic auth or session request of the
+// user if the policy enforces one for the category, sessionClaims are nil for the basic
+// auth requests. It returns whether the one-time password of the request was verified.
+func (a *Auth) checkSecondFactor(req *http.Request, u *user.User, c category.Category, otp string, sessionClaims jwt.MapClaims) (bool, error) {
+	if a.totp == nil || !a.totp.enforces(c) || isExternalUser(u) {
+		return false, nil
+	}
+	e, err := a.getEnabledTOTP(req.Context(), u.Username)
+	if err != nil {
+		return false, err
+	}
+	if e == nil {
+		// the admins that are required to enable it can still enroll
+		if a.totp.requiredForAdmins && *u.IsAdmin && !strings.HasPrefix(req.URL.Path, totp2FAPath) {
+			return false, errSecondFactorNotEnrolled
+		}
+		return false, nil
+	}
+	if sessionClaims != nil {
+		if !hasAuthMethod(sessionClaims, authMethodOTP) {
+			return false, errSessionWithoutSecondFactor
+		}
+		return false, nil
+	}
+	if otp == "" {
+		return false, errSecondFactorRequired
+	}
+	if err := a.verifySecondFactor(req.Context(), e, otp, false); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+type secondFactorKey struct{}
+
+// withVerifiedOTP marks the request context as carrying a verified one-time password.
+func withVerifiedOTP(ctx context.Context) context.Context {
+	return context.WithValue(ctx, secondFactorKey{}, true)
+}
+
+func hasVerifiedOTP(ctx context.Context) bool {
+	verified, _ := ctx.Value(secondFactorKey{}).(bool)
+	return verified
+}
@@ -0,0 +1,102 @@
+package auth
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/appbaseio/arc/model/category"
+	"github.com/dgrijalva/jwt-go"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTOTP(t *testing.T) {
+	// the secret of the test vectors of rfc 6238, "12345678901234567890" in base32
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+	Convey("Generating the codes", t, func() {
+		for unix, expected := range map[int64]string{
+			59:         "287082",
+			1111111109: "081804",
+			1234567890: "005924",
+			2000000000: "279037",
+		} {
+			code, err := totpCode(secret, unix/totpPeriod)
+			So(err, ShouldBeNil)
+			So(code, ShouldEqual, expected)
+		}
+	})
+
+	Convey("Matching the codes", t, func() {
+		now := time.Unix(1111111109, 0)
+		step, ok := matchTOTP(secret, "081804", now)
+		So(ok, ShouldBeTrue)
+		So(step, ShouldEqual, 1111111109/totpPeriod)
+
+		// the codes of the adjacent periods make up for clock drift
+		_, ok = matchTOTP(secret, "081804", now.Add(totpPeriod*time.Second))
+		So(ok, ShouldBeTrue)
+		_, ok = matchTOTP(secret, "081804", now.Add(3*totpPeriod*time.Second))
+		So(ok, ShouldBeFalse)
+		_, ok = matchTOTP(secret, "81804", now)
+		So(ok, ShouldBeFalse)
+	})
+
+	Convey("Codes can't be replayed", t, func() {
+		steps := newUsedTOTPSteps()
+		step := time.Now().Unix() / totpPeriod
+		So(steps.use("alice", step), ShouldBeTrue)
+		So(steps.use("alice", step), ShouldBeFalse)
+		So(steps.use("alice", step-1), ShouldBeFalse)
+		So(steps.use("bob", step), ShouldBeTrue)
+		So(steps.use("alice", step+1), ShouldBeTrue)
+	})
+
+	Convey("Generating the recovery codes", t, func() {
+		codes, hashes, err := newRecoveryCodes()
+		So(err, ShouldBeNil)
+		So(codes, ShouldHaveLength, recoveryCodeCount)
+		So(hashes, ShouldHaveLength, recoveryCodeCount)
+		So(hashToken(normalizeRecoveryCode(codes[0])), ShouldEqual, hashes[0])
+		So(hashToken(normalizeRecoveryCode(strings.ToUpper(codes[1]))), ShouldEqual, hashes[1])
+		So(codes[0], ShouldNotEqual, codes[1])
+	})
+
+	Convey("Enrolling a secret", t, func() {
+		e, err := newTOTPEnrollment("alice")
+		So(err, ShouldBeNil)
+		So(e.Enabled, ShouldBeFalse)
+		code, err := totpCode(e.Secret, time.Now().Unix()/totpPeriod)
+		So(err, ShouldBeNil)
+		_, ok := matchTOTP(e.Secret, code, time.Now())
+		So(ok, ShouldBeTrue)
+		So(e.otpAuthURL("arc"), ShouldStartWith, "otpauth://totp/arc:alice?")
+		So(e.otpAuthURL("arc"), ShouldContainSubstring, "secret="+e.Secret)
+	})
+
+	Convey("Configuring the enforcement policy", t, func() {
+		defer os.Unsetenv(envTOTPEnforcedCategories)
+		policy, err := totpPolicyFromEnv()
+		So(err, ShouldBeNil)
+		So(policy.enforces(category.User), ShouldBeTrue)
+		So(policy.enforces(category.Permission), ShouldBeTrue)
+		So(policy.enforces(category.Search), ShouldBeFalse)
+
+		os.Setenv(envTOTPEnforcedCategories, "user, auth")
+		policy, err = totpPolicyFromEnv()
+		So(err, ShouldBeNil)
+		So(policy.enforces(category.Auth), ShouldBeTrue)
+		So(policy.enforces(category.Permission), ShouldBeFalse)
+
+		os.Setenv(envTOTPEnforcedCategories, "users")
+		_, err = totpPolicyFromEnv()
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Reading the auth methods of a session", t, func() {
+		So(hasAuthMethod(jwt.MapClaims{"amr": []interface{}{"pwd", "otp"}}, authMethodOTP), ShouldBeTrue)
+		So(hasAuthMethod(jwt.MapClaims{"amr": []interface{}{"pwd"}}, authMethodOTP), ShouldBeFalse)
+		So(hasAuthMethod(jwt.MapClaims{}, authMethodOTP), ShouldBeFalse)
+	})
+}
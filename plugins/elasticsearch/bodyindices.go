@@ -0,0 +1,239 @@
+package elasticsearch
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/appbaseio/arc/middleware/validate"
+	"github.com/appbaseio/arc/model/acl"
+	"github.com/appbaseio/arc/model/index"
+	"github.com/appbaseio/arc/util"
+)
+
+// bodyIndex is an index referenced in the request body along with its location in the body.
+type bodyIndex struct {
+	name     string
+	location string
+}
+
+// bulkActions are the actions accepted in a _bulk request, mapped to whether
+// the action line is followed by a source line.
+var bulkActions = map[string]bool{
+	"index":  true,
+	"create": true,
+	"update": true,
+	"delete": false,
+}
+
+// validateBodyIndices validates the indices that _bulk, _msearch, _mget and _mtermvectors
+// requests reference in their bodies against the request credential. The indices in the
+// url are already validated by validate.Indices.
+func validateBodyIndices(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		ctx := req.Context()
+
+		reqACL, err := acl.FromContext(ctx)
+		if err != nil {
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, "an error occurred while validating indices", http.StatusInternalServerError)
+			return
+		}
+
+		parse := bodyIndicesParser(*reqACL)
+		if parse == nil {
+			h(w, req)
+			return
+		}
+
+		body, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, "can't read request body", http.StatusBadRequest)
+			return
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		indices, err := parse(body)
+		if err != nil {
+			util.WriteBackError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// the body indices aren't rewritten, the patterns that can expand to system
+		// indices must exclude them along with the other indices of their location
+		excludesSystem := make(map[string]bool)
+		for _, i := range indices {
+			if i.name == index.SystemExclusion {
+				excludesSystem[i.location] = true
+			}
+		}
+		for _, i := range indices {
+			if index.ExpandsToSystem(i.name) && !excludesSystem[i.location] {
+				msg := fmt.Sprintf(`"%s" index referenced in %s of the request body can expand to system indices, which must be excluded with "%s"`,
+					i.name, i.location, index.SystemExclusion)
+				util.WriteBackError(w, msg, http.StatusBadRequest)
+				return
+			}
+			ok, err := validate.IndexAccess(ctx, i.name)
+			if err != nil {
+				log.Errorln(logTag, ":", err)
+				util.WriteBackError(w, "an error occurred while validating indices", http.StatusInternalServerError)
+				return
+			}
+			if !ok {
+				msg := fmt.Sprintf(`credentials cannot access "%s" index referenced in %s of the request body`, i.name, i.location)
+				w.Header().Set("www-authenticate", "Basic realm=\"Authentication Required\"")
+				util.WriteBackError(w, msg, http.StatusUnauthorized)
+				return
+			}
+		}
+
+		h(w, req)
+	}
+}
+
+// bodyIndicesParser returns the parser of the indices referenced in the request body
+// of the acl, or nil if its requests don't reference indices in their bodies.
+func bodyIndicesParser(a acl.ACL) func([]byte) ([]bodyIndex, error) {
+	switch a {
+	case acl.Bulk:
+		return bulkIndices
+	case acl.Msearch:
+		return msearchIndices
+	case acl.Mget, acl.Mtermvectors:
+		return docsIndices
+	default:
+		return nil
+	}
+}
+
+// ndjsonLines splits a newline delimited body into lines, ignoring the trailing newline.
+func ndjsonLines(body []byte) []string {
+	lines := strings.Split(string(body), "\n")
+	if len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// bulkIndices returns the indices referenced by the action lines of a _bulk request body.
+func bulkIndices(body []byte) ([]bodyIndex, error) {
+	var indices []bodyIndex
+	expectSource := false
+	for i, line := range ndjsonLines(body) {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if expectSource {
+			expectSource = false
+			continue
+		}
+
+		location := fmt.Sprintf("line %d", i+1)
+		var action map[string]struct {
+			Index string `json:"_index"`
+		}
+		if err := json.Unmarshal([]byte(line), &action); err != nil || len(action) != 1 {
+			return nil, fmt.Errorf("malformed action on %s of the request body", location)
+		}
+		for name, meta := range action {
+			hasSource, ok := bulkActions[name]
+			if !ok {
+				return nil, fmt.Errorf(`unknown action "%s" on %s of the request body`, name, location)
+			}
+			expectSource = hasSource
+			if meta.Index != "" {
+				indices = append(indices, bodyIndex{meta.Index, location})
+			}
+		}
+	}
+	return indices, nil
+}
+
+// msearchIndexKeys are the keys of a _msearch header that reference indices.
+var msearchIndexKeys = []string{"index", "indices"}
+
+// msearchIndicesOptions are the keys of a _msearch header that mention indices without
+// referencing any.
+var msearchIndicesOptions = map[string]bool{
+	"allow_no_indices": true,
+}
+
+// msearchIndices returns the indices referenced by the header lines of a _msearch request body.
+func msearchIndices(body []byte) ([]bodyIndex, error) {
+	var indices []bodyIndex
+	for i, line := range ndjsonLines(body) {
+		// header and search body lines alternate, the header may be empty
+		if i%2 == 1 || strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		location := fmt.Sprintf("line %d", i+1)
+		var header map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(line), &header); err != nil {
+			return nil, fmt.Errorf("malformed header on %s of the request body", location)
+		}
+		// reject the keys that might reference indices without being validated
+		for key := range header {
+			if util.IsExists(key, msearchIndexKeys) || msearchIndicesOptions[key] {
+				continue
+			}
+			lower := strings.ToLower(key)
+			if strings.Contains(lower, "index") || strings.Contains(lower, "indices") {
+				return nil, fmt.Errorf(`unsupported "%s" in header on %s of the request body`, key, location)
+			}
+		}
+
+		for _, key := range msearchIndexKeys {
+			value, ok := header[key]
+			if !ok || string(value) == "null" {
+				continue
+			}
+			// the indices are either a comma separated string or an array of strings
+			var names []string
+			var name string
+			if err := json.Unmarshal(value, &name); err == nil {
+				names = strings.Split(name, ",")
+			} else if err := json.Unmarshal(value, &names); err != nil {
+				return nil, fmt.Errorf(`malformed "%s" in header on %s of the request body`, key, location)
+			}
+			for _, name := range names {
+				name = strings.TrimSpace(name)
+				if name != "" {
+					indices = append(indices, bodyIndex{name, location})
+				}
+			}
+		}
+	}
+	return indices, nil
+}
+
+// docsIndices returns the indices referenced by the docs of a _mget or _mtermvectors request body.
+func docsIndices(body []byte) ([]bodyIndex, error) {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return nil, nil
+	}
+
+	var reqBody struct {
+		Docs []struct {
+			Index string `json:"_index"`
+		} `json:"docs"`
+	}
+	if err := json.Unmarshal(body, &reqBody); err != nil {
+		return nil, fmt.Errorf("malformed request body: %v", err)
+	}
+
+	var indices []bodyIndex
+	for i, doc := range reqBody.Docs {
+		if doc.Index != "" {
+			indices = append(indices, bodyIndex{doc.Index, fmt.Sprintf("docs[%d]", i)})
+		}
+	}
+	return indices, nil
+}
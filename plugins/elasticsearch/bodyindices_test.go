@@ -0,0 +1,54 @@
+package elasticsearch
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestBodyIndices(t *testing.T) {
+	Convey("Parsing the indices of the request bodies", t, func() {
+		Convey("reads the indices of the msearch headers", func() {
+			indices, err := msearchIndices([]byte(`{"index": "products,cart"}
+{"query": {"match_all": {}}}
+{}
+{"query": {"match_all": {}}}
+{"indices": "secret"}
+{"query": {"match_all": {}}}
+{"index": ["orders"], "allow_no_indices": true}
+{"query": {"match_all": {}}}
+`))
+			So(err, ShouldBeNil)
+			So(indices, ShouldResemble, []bodyIndex{
+				{"products", "line 1"},
+				{"cart", "line 1"},
+				{"secret", "line 5"},
+				{"orders", "line 7"},
+			})
+		})
+
+		Convey("rejects the msearch headers with unknown index keys", func() {
+			_, err := msearchIndices([]byte(`{"_index": "secret"}
+{"query": {"match_all": {}}}
+`))
+			So(err, ShouldNotBeNil)
+			_, err = msearchIndices([]byte(`{"indices": {"name": "secret"}}
+{"query": {"match_all": {}}}
+`))
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("reads the indices of the bulk actions and docs", func() {
+			indices, err := bulkIndices([]byte(`{"index": {"_index": "products"}}
+{"name": "apple"}
+{"delete": {"_index": "cart", "_id": "1"}}
+`))
+			So(err, ShouldBeNil)
+			So(indices, ShouldResemble, []bodyIndex{{"products", "line 1"}, {"cart", "line 3"}})
+
+			indices, err = docsIndices([]byte(`{"docs": [{"_index": "products", "_id": "1"}, {"_id": "2"}]}`))
+			So(err, ShouldBeNil)
+			So(indices, ShouldResemble, []bodyIndex{{"products", "docs[0]"}})
+		})
+	})
+}
@@ -0,0 +1,220 @@
+package elasticsearch
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/appbaseio/arc/model/acl"
+	"github.com/appbaseio/arc/model/claims"
+	"github.com/appbaseio/arc/model/credential"
+	"github.com/appbaseio/arc/model/permission"
+	"github.com/appbaseio/arc/util"
+	"github.com/gorilla/mux"
+)
+
+// filteredACLs are the acls whose requests are restricted to the documents
+// matched by the permission filter.
+var filteredACLs = map[acl.ACL]bool{
+	acl.Search:        true,
+	acl.Msearch:       true,
+	acl.Count:         true,
+	acl.DeleteByQuery: true,
+	acl.UpdateByQuery: true,
+}
+
+// queryStringParams maps the url params of a lucene query, i.e. "q", to the
+// corresponding query_string query options.
+var queryStringParams = map[string]string{
+	"q":                "query",
+	"df":               "default_field",
+	"default_operator": "default_operator",
+	"analyzer":         "analyzer",
+	"analyze_wildcard": "analyze_wildcard",
+	"lenient":          "lenient",
+}
+
+// filterDocuments wraps the query of the request in a bool query that filters the
+// documents using the permission filter, rendered with the claims of the request jwt.
+func filterDocuments(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		ctx := req.Context()
+
+		reqACL, err := acl.FromContext(ctx)
+		if err != nil {
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, "an error occurred while filtering documents", http.StatusInternalServerError)
+			return
+		}
+		if !filteredACLs[*reqACL] {
+			h(w, req)
+			return
+		}
+
+		reqCredential, err := credential.FromContext(ctx)
+		if err != nil || reqCredential != credential.Permission {
+			h(w, req)
+			return
+		}
+		reqPermission, err := permission.FromContext(ctx)
+		if err != nil {
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, "an error occurred while filtering documents", http.StatusInternalServerError)
+			return
+		}
+		if !reqPermission.HasFilter() {
+			h(w, req)
+			return
+		}
+
+		// requests authenticated with basic auth don't carry any claims
+		reqClaims, _ := claims.FromContext(ctx)
+		filter, err := reqPermission.RenderFilter(reqClaims)
+		if err != nil {
+			w.Header().Set("www-authenticate", "Basic realm=\"Authentication Required\"")
+			util.WriteBackError(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		// the query of a search template is only known once it's rendered by elasticsearch
+		if template, err := mux.CurrentRoute(req).GetPathTemplate(); err == nil && strings.HasSuffix(template, "/template") {
+			w.Header().Set("www-authenticate", "Basic realm=\"Authentication Required\"")
+			util.WriteBackError(w, "search templates can't be used with a permission that filters documents", http.StatusUnauthorized)
+			return
+		}
+
+		body, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, "can't read request body", http.StatusBadRequest)
+			return
+		}
+
+		var modifiedBody []byte
+		if *reqACL == acl.Msearch {
+			modifiedBody, err = filterMsearchBody(body, filter)
+		} else {
+			modifiedBody, err = filterBody(body, filter, req)
+		}
+		if err != nil {
+			util.WriteBackError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(modifiedBody))
+		req.ContentLength = int64(len(modifiedBody))
+
+		h(w, req)
+	}
+}
+
+// filterBody applies the filter to the query of a request body. A lucene query
+// in the url overrides the query in the body, it is therefore moved to the body.
+func filterBody(body []byte, filter map[string]interface{}, req *http.Request) ([]byte, error) {
+	reqBody := make(map[string]interface{})
+	if len(bytes.TrimSpace(body)) > 0 {
+		if err := json.Unmarshal(body, &reqBody); err != nil {
+			return nil, fmt.Errorf("malformed request body: %v", err)
+		}
+	}
+
+	params := req.URL.Query()
+	if q := params.Get("q"); q != "" {
+		queryString := make(map[string]interface{})
+		for param, option := range queryStringParams {
+			if value := params.Get(param); value != "" {
+				queryString[option] = value
+			}
+			params.Del(param)
+		}
+		if _, ok := reqBody["query"]; ok {
+			return nil, fmt.Errorf(`request cannot contain both the "q" param and a query in the body`)
+		}
+		reqBody["query"] = map[string]interface{}{"query_string": queryString}
+		req.URL.RawQuery = params.Encode()
+	}
+
+	if err := applyFilter(reqBody, filter); err != nil {
+		return nil, err
+	}
+	return json.Marshal(reqBody)
+}
+
+// filterMsearchBody applies the filter to the query of every search in a _msearch request body.
+func filterMsearchBody(body []byte, filter map[string]interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	for i, line := range ndjsonLines(body) {
+		// header and search body lines alternate
+		if i%2 == 0 {
+			buf.WriteString(line)
+			buf.WriteString("\n")
+			continue
+		}
+
+		reqBody := make(map[string]interface{})
+		if strings.TrimSpace(line) != "" {
+			if err := json.Unmarshal([]byte(line), &reqBody); err != nil {
+				return nil, fmt.Errorf("malformed search on line %d of the request body", i+1)
+			}
+		}
+		if err := applyFilter(reqBody, filter); err != nil {
+			return nil, fmt.Errorf("%v, on line %d of the request body", err, i+1)
+		}
+		raw, err := json.Marshal(reqBody)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(raw)
+		buf.WriteString("\n")
+	}
+	return buf.Bytes(), nil
+}
+
+// applyFilter wraps the query in the request body in a bool query with the given filter.
+func applyFilter(reqBody map[string]interface{}, filter map[string]interface{}) error {
+	// suggesters don't respect the query and would expose the unfiltered documents
+	if _, ok := reqBody["suggest"]; ok {
+		return fmt.Errorf(`"suggest" can't be used with a permission that filters documents`)
+	}
+	// global aggregations ignore the query and would aggregate over all the documents
+	for _, key := range []string{"aggs", "aggregations"} {
+		if hasGlobalAggregation(reqBody[key]) {
+			return fmt.Errorf(`"global" aggregations can't be used with a permission that filters documents`)
+		}
+	}
+
+	boolQuery := map[string]interface{}{
+		"filter": []interface{}{filter},
+	}
+	if query, ok := reqBody["query"]; ok && query != nil {
+		boolQuery["must"] = []interface{}{query}
+	}
+	reqBody["query"] = map[string]interface{}{"bool": boolQuery}
+	return nil
+}
+
+// hasGlobalAggregation checks whether the aggregations, or any of their sub-aggregations,
+// contain a global aggregation.
+func hasGlobalAggregation(aggs interface{}) bool {
+	named, ok := aggs.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	for _, agg := range named {
+		definition, ok := agg.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if _, ok := definition["global"]; ok {
+			return true
+		}
+		if hasGlobalAggregation(definition["aggs"]) || hasGlobalAggregation(definition["aggregations"]) {
+			return true
+		}
+	}
+	return false
+}
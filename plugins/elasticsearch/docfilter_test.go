@@ -0,0 +1,43 @@
+package elasticsearch
+
+import (
+	"encoding/json"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestApplyFilter(t *testing.T) {
+	filter := map[string]interface{}{"term": map[string]interface{}{"tenant": "acme"}}
+	body := func(raw string) map[string]interface{} {
+		reqBody := make(map[string]interface{})
+		if err := json.Unmarshal([]byte(raw), &reqBody); err != nil {
+			t.Fatal(err)
+		}
+		return reqBody
+	}
+
+	Convey("Applying a document filter", t, func() {
+		Convey("wraps the query in a bool filter", func() {
+			reqBody := body(`{"query": {"match_all": {}}, "aggs": {"brands": {"terms": {"field": "brand"}}}}`)
+			So(applyFilter(reqBody, filter), ShouldBeNil)
+			So(reqBody["query"], ShouldResemble, map[string]interface{}{
+				"bool": map[string]interface{}{
+					"filter": []interface{}{filter},
+					"must":   []interface{}{map[string]interface{}{"match_all": map[string]interface{}{}}},
+				},
+			})
+		})
+
+		Convey("rejects the suggesters", func() {
+			So(applyFilter(body(`{"suggest": {"s": {"text": "ap"}}}`), filter), ShouldNotBeNil)
+		})
+
+		Convey("rejects the global aggregations at any depth", func() {
+			So(applyFilter(body(`{"aggs": {"all": {"global": {}}}}`), filter), ShouldNotBeNil)
+			So(applyFilter(body(`{"aggregations": {"brands": {"terms": {"field": "brand"},
+				"aggs": {"all": {"global": {}, "aggregations": {"count": {"value_count": {"field": "id"}}}}}}}}`), filter), ShouldNotBeNil)
+			So(applyFilter(body(`{"aggs": {"global": {"terms": {"field": "brand"}}}}`), filter), ShouldBeNil)
+		})
+	})
+}
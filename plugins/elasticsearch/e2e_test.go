@@ -1,7 +1,9 @@
 package elasticsearch
 
 import (
+	"bytes"
 	"encoding/json"
+	"io/ioutil"
 	"net/http"
 	"testing"
 
@@ -10,6 +12,30 @@ import (
 	. "github.com/smartystreets/goconvey/convey"
 )
 
+// makeRequestAs makes a request to arc with the given basic auth credentials.
+func makeRequestAs(username, password, method, url string, requestBody interface{}) (map[string]interface{}, error) {
+	marshalledRequest, err := json.Marshal(requestBody)
+	if err != nil {
+		return nil, err
+	}
+	req, _ := http.NewRequest(method, "http://localhost:8000"+url, bytes.NewBuffer(marshalledRequest))
+	req.SetBasicAuth(username, password)
+	req.Header.Add("Content-Type", "application/json")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	var response map[string]interface{}
+	err = json.Unmarshal(body, &response)
+	return response, err
+}
+
 type Nodes struct {
 	Total int `json:"total"`
 }
@@ -39,4 +65,71 @@ func TestElasticsearch(t *testing.T) {
 			}
 		})
 	})
+
+	Convey("Field level security", t, func() {
+		doc := map[string]interface{}{
+			"name":    "foo",
+			"ssn":     "123-45-6789",
+			"address": map[string]interface{}{"city": "bar", "zip": "12345"},
+		}
+		_, err, _ := util.MakeHttpRequest(http.MethodPut, "/fls-test/_doc/1?refresh=true", doc)
+		So(err, ShouldBeNil)
+
+		response, err, _ := util.MakeHttpRequest(http.MethodPost, "/_permission", map[string]interface{}{
+			"indices":        []string{"fls-test"},
+			"exclude_fields": []string{"ssn", "address.zip"},
+		})
+		So(err, ShouldBeNil)
+		permission := response.(map[string]interface{})
+		username := permission["username"].(string)
+		password := permission["password"].(string)
+
+		expectedSource := map[string]interface{}{
+			"name":    "foo",
+			"address": map[string]interface{}{"city": "bar"},
+		}
+
+		Convey("GET document", func() {
+			response, err := makeRequestAs(username, password, http.MethodGet, "/fls-test/_doc/1", nil)
+			So(err, ShouldBeNil)
+			So(response["_source"], ShouldResemble, expectedSource)
+		})
+
+		Convey("GET document with stored fields", func() {
+			response, err := makeRequestAs(username, password, http.MethodGet, "/fls-test/_doc/1?stored_fields=ssn,name&_source=false", nil)
+			So(err, ShouldBeNil)
+			fields, _ := response["fields"].(map[string]interface{})
+			So(fields, ShouldNotContainKey, "ssn")
+		})
+
+		Convey("GET document source", func() {
+			response, err := makeRequestAs(username, password, http.MethodGet, "/fls-test/_doc/1/_source", nil)
+			So(err, ShouldBeNil)
+			So(response, ShouldResemble, expectedSource)
+		})
+
+		Convey("Multi GET documents", func() {
+			response, err := makeRequestAs(username, password, http.MethodPost, "/fls-test/_mget", map[string]interface{}{
+				"ids": []string{"1"},
+			})
+			So(err, ShouldBeNil)
+			docs := response["docs"].([]interface{})
+			So(docs, ShouldHaveLength, 1)
+			So(docs[0].(map[string]interface{})["_source"], ShouldResemble, expectedSource)
+		})
+
+		Convey("Explain document", func() {
+			response, err := makeRequestAs(username, password, http.MethodPost, "/fls-test/_doc/1/_explain?_source=true", map[string]interface{}{
+				"query": map[string]interface{}{"match_all": map[string]interface{}{}},
+			})
+			So(err, ShouldBeNil)
+			get := response["get"].(map[string]interface{})
+			So(get["_source"], ShouldResemble, expectedSource)
+		})
+
+		Reset(func() {
+			util.MakeHttpRequest(http.MethodDelete, "/_permission/"+username, nil)
+			util.MakeHttpRequest(http.MethodDelete, "/fls-test", nil)
+		})
+	})
 }
@@ -0,0 +1,170 @@
+package elasticsearch
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/appbaseio/arc/model/acl"
+	"github.com/appbaseio/arc/model/index"
+	"github.com/appbaseio/arc/model/permission"
+)
+
+// fieldFilteredACLs are the acls whose responses are restricted to the fields the
+// permission can access. Search and msearch requests are instead restricted by
+// injecting the source filters in the request body.
+var fieldFilteredACLs = map[acl.ACL]bool{
+	acl.Get:          true,
+	acl.Doc:          true,
+	acl.Source:       true,
+	acl.Mget:         true,
+	acl.Explain:      true,
+	acl.Termvectors:  true,
+	acl.Mtermvectors: true,
+}
+
+// fieldFilter restricts the fields of the documents in a response to the include
+// and exclude fields of a permission. Like elasticsearch source filtering, the
+// patterns are matched against the full path of a field and support wildcards.
+type fieldFilter struct {
+	includes []string
+	excludes []string
+}
+
+// newFieldFilter returns the field filter of the permission, or nil if the
+// permission can access every field.
+func newFieldFilter(p *permission.Permission) *fieldFilter {
+	isEmpty := len(p.Includes) == 0 && len(p.Excludes) == 0
+	isDefaultInclude := len(p.Includes) > 0 && p.Includes[0] == "*"
+	if isEmpty || (isDefaultInclude && len(p.Excludes) == 0) {
+		return nil
+	}
+	return &fieldFilter{includes: p.Includes, excludes: p.Excludes}
+}
+
+// matches checks whether the field, or any of its parent objects, is matched by the patterns.
+func matches(patterns []string, field string) bool {
+	for {
+		for _, pattern := range patterns {
+			if index.Glob(pattern, field) {
+				return true
+			}
+		}
+		i := strings.LastIndex(field, ".")
+		if i == -1 {
+			return false
+		}
+		field = field[:i]
+	}
+}
+
+// allowed checks whether the field with the given full path can be accessed.
+func (f *fieldFilter) allowed(field string) bool {
+	if matches(f.excludes, field) {
+		return false
+	}
+	return len(f.includes) == 0 || matches(f.includes, field)
+}
+
+// filterSource returns the source with only the fields that can be accessed. An object
+// that isn't included itself is kept if any of its nested fields are included.
+func (f *fieldFilter) filterSource(source map[string]interface{}, prefix string) map[string]interface{} {
+	filtered := make(map[string]interface{})
+	for key, value := range source {
+		field := prefix + key
+		if f.allowed(field) {
+			filtered[key] = f.filterValue(value, field, true)
+			continue
+		}
+		if matches(f.excludes, field) {
+			continue
+		}
+		if value = f.filterValue(value, field, false); value != nil {
+			filtered[key] = value
+		}
+	}
+	return filtered
+}
+
+// filterValue filters the objects in the value of the given field. If the field isn't
+// included, it returns nil in case none of the nested fields are included either.
+func (f *fieldFilter) filterValue(value interface{}, field string, included bool) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		filtered := f.filterSource(v, field+".")
+		if !included && len(filtered) == 0 {
+			return nil
+		}
+		return filtered
+	case []interface{}:
+		var filtered []interface{}
+		for _, elem := range v {
+			if elem = f.filterValue(elem, field, included); elem != nil {
+				filtered = append(filtered, elem)
+			}
+		}
+		if !included && len(filtered) == 0 {
+			return nil
+		}
+		if filtered == nil {
+			filtered = []interface{}{}
+		}
+		return filtered
+	default:
+		if !included {
+			return nil
+		}
+		return v
+	}
+}
+
+// filterFields removes the fields that can't be accessed from a map keyed by
+// full field paths, such as the "fields" and "term_vectors" of a document.
+func (f *fieldFilter) filterFields(fields map[string]interface{}) {
+	for field := range fields {
+		if !f.allowed(field) {
+			delete(fields, field)
+		}
+	}
+}
+
+// filterDoc filters the fields of a document returned by the get, explain and termvectors apis.
+func (f *fieldFilter) filterDoc(doc map[string]interface{}) {
+	if source, ok := doc["_source"].(map[string]interface{}); ok {
+		doc["_source"] = f.filterSource(source, "")
+	}
+	if fields, ok := doc["fields"].(map[string]interface{}); ok {
+		f.filterFields(fields)
+	}
+	if termVectors, ok := doc["term_vectors"].(map[string]interface{}); ok {
+		f.filterFields(termVectors)
+	}
+	// explain returns the document under "get"
+	if get, ok := doc["get"].(map[string]interface{}); ok {
+		f.filterDoc(get)
+	}
+}
+
+// filterResponse filters the fields of the documents in the response body of the given acl.
+// If isSource is set, the response body is the document source itself.
+func (f *fieldFilter) filterResponse(reqACL acl.ACL, isSource bool, body []byte) ([]byte, error) {
+	var response map[string]interface{}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case isSource:
+		response = f.filterSource(response, "")
+	case reqACL == acl.Mget || reqACL == acl.Mtermvectors:
+		docs, _ := response["docs"].([]interface{})
+		for _, doc := range docs {
+			if doc, ok := doc.(map[string]interface{}); ok {
+				f.filterDoc(doc)
+			}
+		}
+	default:
+		f.filterDoc(response)
+	}
+
+	return json.Marshal(response)
+}
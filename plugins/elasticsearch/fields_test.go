@@ -0,0 +1,36 @@
+package elasticsearch
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFieldFilter(t *testing.T) {
+	Convey("Filtering the fields of documents", t, func() {
+		Convey("matches the full paths with wildcards", func() {
+			f := &fieldFilter{includes: []string{"name", "user.*"}, excludes: []string{"user.ssn", "secret["}}
+			So(f.filterSource(map[string]interface{}{
+				"name":    "apple",
+				"cost":    10,
+				"secret[": "key",
+				"user": map[string]interface{}{
+					"ssn":     "1",
+					"address": map[string]interface{}{"city": "berlin"},
+				},
+			}, ""), ShouldResemble, map[string]interface{}{
+				"name": "apple",
+				"user": map[string]interface{}{
+					"address": map[string]interface{}{"city": "berlin"},
+				},
+			})
+		})
+
+		Convey("excludes the fields matching a pattern with special characters", func() {
+			f := &fieldFilter{excludes: []string{"secret[", "a/*"}}
+			So(f.allowed("secret["), ShouldBeFalse)
+			So(f.allowed("a/b/c"), ShouldBeFalse)
+			So(f.allowed("secret"), ShouldBeTrue)
+		})
+	})
+}
@@ -0,0 +1,92 @@
+package elasticsearch
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/appbaseio/arc/model/acl"
+	"github.com/appbaseio/arc/model/credential"
+	"github.com/appbaseio/arc/model/index"
+	"github.com/appbaseio/arc/model/op"
+	"github.com/appbaseio/arc/model/permission"
+	"github.com/appbaseio/arc/util"
+)
+
+// scopePermission replaces the permission of the request with the one scoped to the
+// grants that allow the request, so that the field and document rules of those grants
+// are the ones applied to the request and its response. The indices referenced in the
+// request body count along with the ones in the url.
+func scopePermission(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		ctx := req.Context()
+
+		reqCredential, err := credential.FromContext(ctx)
+		if err != nil || reqCredential != credential.Permission {
+			h(w, req)
+			return
+		}
+		errMsg := "an error occurred while applying the permission grants"
+		reqPermission, err := permission.FromContext(ctx)
+		if err != nil {
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, errMsg, http.StatusInternalServerError)
+			return
+		}
+		if !reqPermission.HasGrants() {
+			h(w, req)
+			return
+		}
+
+		reqACL, err := acl.FromContext(ctx)
+		if err != nil {
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, errMsg, http.StatusInternalServerError)
+			return
+		}
+		reqOp, err := op.FromContext(ctx)
+		if err != nil {
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, errMsg, http.StatusInternalServerError)
+			return
+		}
+		reqIndices, err := index.FromContext(ctx)
+		if err != nil {
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, errMsg, http.StatusInternalServerError)
+			return
+		}
+
+		indices := append([]string{}, reqIndices...)
+		if parse := bodyIndicesParser(*reqACL); parse != nil {
+			body, err := ioutil.ReadAll(req.Body)
+			if err != nil {
+				log.Errorln(logTag, ":", err)
+				util.WriteBackError(w, "can't read request body", http.StatusBadRequest)
+				return
+			}
+			req.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+			bodyIndices, err := parse(body)
+			if err != nil {
+				util.WriteBackError(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			for _, i := range bodyIndices {
+				indices = append(indices, i.name)
+			}
+		}
+
+		scoped, err := reqPermission.ForRequest(indices, reqACL, reqOp)
+		if err != nil {
+			w.Header().Set("www-authenticate", "Basic realm=\"Authentication Required\"")
+			util.WriteBackError(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		ctx = permission.NewContext(ctx, scoped)
+		h(w, req.WithContext(ctx))
+	}
+}
@@ -23,6 +23,7 @@ import (
 	"github.com/appbaseio/arc/model/permission"
 	"github.com/appbaseio/arc/plugins/auth"
 	"github.com/appbaseio/arc/plugins/logs"
+	"github.com/appbaseio/arc/plugins/permissions"
 	"github.com/appbaseio/arc/util"
 	"github.com/gorilla/mux"
 )
@@ -51,6 +52,12 @@ func list() []middleware.Middleware {
 		validate.ACL(),
 		validate.Operation(),
 		validate.PermissionExpiry(),
+		validate.PermissionSchedule(),
+		permissions.Quota(),
+		validateBodyIndices,
+		scopePermission,
+		filterDocuments,
+		excludeSystemIndices,
 		intercept,
 	}
 }
@@ -226,6 +233,22 @@ func intercept(h http.HandlerFunc) http.HandlerFunc {
 			util.WriteBackError(w, "error reading response body", http.StatusInternalServerError)
 			return
 		}
+
+		// restrict the fields of the documents in the response
+		if fieldFilteredACLs[*reqACL] && result.StatusCode == http.StatusOK {
+			if reqPermission, err := permission.FromContext(ctx); err == nil {
+				if f := newFieldFilter(reqPermission); f != nil {
+					// the source of a document can also be fetched with /{index}/_source/{id}
+					isSource := *reqACL == acl.Source || mux.Vars(req)["type"] == "_source"
+					body, err = f.filterResponse(*reqACL, isSource, body)
+					if err != nil {
+						log.Errorln(logTag, ":", err)
+						util.WriteBackError(w, "error filtering response fields", http.StatusInternalServerError)
+						return
+					}
+				}
+			}
+		}
 		for _, index := range indices {
 			alias := classify.GetIndexAlias(index)
 			if alias != "" {
@@ -0,0 +1,44 @@
+package elasticsearch
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/appbaseio/arc/model/index"
+	"github.com/appbaseio/arc/util"
+	"github.com/gorilla/mux"
+)
+
+// excludeSystemIndices rewrites the indices in the url of the request before it gets
+// forwarded, so that the patterns that can expand to system indices, e.g. "*" and "_all",
+// exclude them. The index patterns of the credentials only match the system indices that
+// are named explicitly, while elasticsearch expands the wildcards to them too.
+func excludeSystemIndices(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		vars := mux.Vars(req)
+		indexVar, ok := vars["index"]
+		if !ok {
+			h(w, req)
+			return
+		}
+		indices := util.IndicesFromRequest(req)
+		excluded := index.ExcludeSystem(indices)
+		if len(excluded) == len(indices) {
+			h(w, req)
+			return
+		}
+
+		rewritten := strings.Join(excluded, ",")
+		segments := strings.Split(req.URL.Path, "/")
+		for i, segment := range segments {
+			if segment == indexVar {
+				segments[i] = rewritten
+				break
+			}
+		}
+		req.URL.Path = strings.Join(segments, "/")
+		req.URL.RawPath = ""
+		vars["index"] = rewritten
+		h(w, mux.SetURLVars(req, vars))
+	}
+}
@@ -0,0 +1,42 @@
+package elasticsearch
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestExcludeSystemIndices(t *testing.T) {
+	var forwarded *http.Request
+	router := mux.NewRouter()
+	router.Path("/{index}/_search").HandlerFunc(excludeSystemIndices(func(w http.ResponseWriter, req *http.Request) {
+		forwarded = req
+	}))
+	router.Path("/_cat/indices/{index}").HandlerFunc(excludeSystemIndices(func(w http.ResponseWriter, req *http.Request) {
+		forwarded = req
+	}))
+	serve := func(path string) *http.Request {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+		return forwarded
+	}
+
+	Convey("Excluding the system indices", t, func() {
+		Convey("rewrites the patterns that can expand to them", func() {
+			req := serve("/*/_search?q=apple")
+			So(req.URL.Path, ShouldEqual, "/*,-.*/_search")
+			So(req.URL.RawQuery, ShouldEqual, "q=apple")
+			So(mux.Vars(req)["index"], ShouldEqual, "*,-.*")
+
+			So(serve("/_all/_search").URL.Path, ShouldEqual, "/*,-.*/_search")
+			So(serve("/_cat/indices/*-logs").URL.Path, ShouldEqual, "/_cat/indices/*-logs,-.*")
+		})
+
+		Convey("leaves the other indices untouched", func() {
+			So(serve("/products,logs-*/_search").URL.Path, ShouldEqual, "/products,logs-*/_search")
+			So(serve("/.logs*/_search").URL.Path, ShouldEqual, "/.logs*/_search")
+		})
+	})
+}
@@ -117,7 +117,7 @@ func (es *elasticsearch) rolloverIndexJob(alias string) {
 		Settings(settings).
 		Do(ctx)
 	if err != nil {
-		log.Printf(logTag, "error while creating a rollover service %s %v", alias, err)
+		log.Errorln(logTag, ": error while creating a rollover service", alias, ":", err)
 	}
 	log.Println(logTag, ": rollover res oldIndex", rolloverService.OldIndex)
 	log.Println(logTag, ": rollover res newIndex", rolloverService.NewIndex)
@@ -41,7 +41,8 @@ var (
 
 // Logs plugin records an elasticsearch request and its response.
 type Logs struct {
-	es logsService
+	es       logsService
+	redactor *redactor
 }
 
 // Instance returns the singleton instance of Logs plugin.
@@ -66,6 +67,9 @@ func (l *Logs) InitFunc() error {
 		indexName = defaultLogsEsIndex
 	}
 
+	// sensitive headers and body fields are redacted from the records
+	l.redactor = newRedactor()
+
 	// initialize the elasticsearch client
 	var err error
 	l.es, err = initPlugin(indexName, config)
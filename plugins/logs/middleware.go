@@ -13,9 +13,11 @@ import (
 
 	"github.com/appbaseio/arc/middleware"
 	"github.com/appbaseio/arc/middleware/classify"
+	"github.com/appbaseio/arc/middleware/explain"
 	"github.com/appbaseio/arc/middleware/validate"
 	"github.com/appbaseio/arc/model/category"
 	"github.com/appbaseio/arc/model/index"
+	"github.com/appbaseio/arc/model/lockout"
 	"github.com/appbaseio/arc/plugins/auth"
 	"github.com/appbaseio/arc/util"
 )
@@ -68,6 +70,7 @@ func classifyCategory(h http.HandlerFunc) http.HandlerFunc {
 
 type Request struct {
 	URI     string              `json:"uri"`
+	Query   string              `json:"query,omitempty"`
 	Method  string              `json:"method"`
 	Headers map[string][]string `json:"header"`
 	Body    string              `json:"body"`
@@ -96,8 +99,8 @@ func Recorder() middleware.Middleware {
 
 func (l *Logs) recorder(h http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// skip logs from streams
-		if r.Header.Get("X-Request-Category") == "streams" {
+		// skip logs from streams and simulated requests
+		if r.Header.Get("X-Request-Category") == "streams" || explain.FromContext(r.Context()) != nil {
 			h(w, r)
 			return
 		}
@@ -118,8 +121,13 @@ func (l *Logs) recorder(h http.HandlerFunc) http.HandlerFunc {
 			headers[key] = values
 		}
 
+		// collect the lockouts caused by the authentication failure of the request
+		reqLockouts := &lockout.Events{}
+		r = r.WithContext(lockout.NewContext(r.Context(), reqLockouts))
+
 		request := Request{
 			URI:     r.URL.Path,
+			Query:   r.URL.RawQuery,
 			Headers: headers,
 			Body:    string(reqBody),
 			Method:  r.Method,
@@ -137,6 +145,9 @@ func (l *Logs) recorder(h http.HandlerFunc) http.HandlerFunc {
 
 		// Record the document
 		go l.recordResponse(&request, respRecorder, r)
+		for _, e := range reqLockouts.List() {
+			l.recordLockout(e, r)
+		}
 	}
 }
 
@@ -197,5 +208,42 @@ func (l *Logs) recordResponse(request *Request, w *httptest.ResponseRecorder, re
 			rec.Response.Took = &resBody.Settings.Took
 		}
 	}
+	if l.redactor != nil {
+		l.redactor.redact(&rec)
+	}
 	l.es.indexRecord(context.Background(), rec)
 }
+
+// recordLockout records a username or source ip getting locked out after repeated
+// authentication failures, so that the lockouts show up along with the other logs.
+func (l *Logs) recordLockout(event lockout.Event, req *http.Request) {
+	var rec record
+	rec.Category = category.Auth
+	rec.Timestamp = time.Now()
+	headers := make(map[string][]string)
+	for key, values := range req.Header {
+		headers[key] = values
+	}
+	rec.Request = Request{
+		URI:     req.URL.Path,
+		Query:   req.URL.RawQuery,
+		Method:  req.Method,
+		Headers: headers,
+	}
+	if reqIndices, err := index.FromContext(req.Context()); err == nil {
+		rec.Indices = reqIndices
+	}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Errorln(logTag, ":", err)
+		return
+	}
+	rec.Response.Code = http.StatusTooManyRequests
+	rec.Response.Status = http.StatusText(http.StatusTooManyRequests)
+	rec.Response.Body = string(body)
+	if l.redactor != nil {
+		l.redactor.redact(&rec)
+	}
+	go l.es.indexRecord(context.Background(), rec)
+}
@@ -0,0 +1,244 @@
+package logs
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	envDropHeaders      = "LOGS_DROP_HEADERS"
+	envMaskHeaders      = "LOGS_MASK_HEADERS"
+	envMaskBodyFields   = "LOGS_MASK_BODY_FIELDS"
+	envMaskQueryParams  = "LOGS_MASK_QUERY_PARAMS"
+	envMaxBodySize      = "LOGS_MAX_BODY_SIZE"
+	defaultMaskHeaders  = "Authorization,Proxy-Authorization,Cookie,Set-Cookie,X-Api-Key"
+	defaultMaskFields   = "password"
+	defaultMaskParams   = "api_key,access_token,token,password"
+	defaultMaxBodySize  = 1 << 20
+	redactedPlaceholder = "[REDACTED]"
+)
+
+// redactor strips sensitive information from a log record before it gets indexed.
+type redactor struct {
+	// dropHeaders are removed from the recorded headers altogether.
+	dropHeaders map[string]bool
+	// maskHeaders are recorded with their values replaced by a placeholder.
+	maskHeaders map[string]bool
+	// maskFields are the JSON body paths whose values are replaced by a placeholder.
+	// A path with a single field name matches the field at any depth, while a
+	// dotted path is matched from the root of the body. A "*" matches any field.
+	maskFields [][]string
+	// maskParams are the query params of the request whose values are replaced by
+	// a placeholder.
+	maskParams map[string]bool
+	// maxBodySize caps the number of bytes stored for request and response bodies,
+	// a non-positive value leaves the bodies uncapped.
+	maxBodySize int
+}
+
+// newRedactor returns a redactor configured through the environment.
+func newRedactor() *redactor {
+	maxBodySize := defaultMaxBodySize
+	if value := os.Getenv(envMaxBodySize); value != "" {
+		size, err := strconv.Atoi(value)
+		if err != nil {
+			log.Errorln(logTag, ": invalid", envMaxBodySize, "value, defaulting to", defaultMaxBodySize, ":", err)
+		} else {
+			maxBodySize = size
+		}
+	}
+
+	r := &redactor{
+		dropHeaders: make(map[string]bool),
+		maskHeaders: make(map[string]bool),
+		maskParams:  make(map[string]bool),
+		maxBodySize: maxBodySize,
+	}
+	for _, header := range splitEnv(envDropHeaders, "") {
+		r.dropHeaders[http.CanonicalHeaderKey(header)] = true
+	}
+	for _, header := range splitEnv(envMaskHeaders, defaultMaskHeaders) {
+		r.maskHeaders[http.CanonicalHeaderKey(header)] = true
+	}
+	for _, field := range splitEnv(envMaskBodyFields, defaultMaskFields) {
+		r.maskFields = append(r.maskFields, strings.Split(field, "."))
+	}
+	for _, param := range splitEnv(envMaskQueryParams, defaultMaskParams) {
+		r.maskParams[param] = true
+	}
+	return r
+}
+
+// splitEnv returns the comma separated values of the env var, or of the default
+// value in case the env var isn't set.
+func splitEnv(envVar, defaultValue string) []string {
+	value, ok := os.LookupEnv(envVar)
+	if !ok {
+		value = defaultValue
+	}
+	var values []string
+	for _, token := range strings.Split(value, ",") {
+		token = strings.TrimSpace(token)
+		if token != "" {
+			values = append(values, token)
+		}
+	}
+	return values
+}
+
+// redact removes the sensitive query params, headers and body fields from the record.
+func (r *redactor) redact(rec *record) {
+	rec.Request.Query = r.redactQuery(rec.Request.Query)
+	rec.Request.Headers = r.redactHeaders(rec.Request.Headers)
+	rec.Request.Body = r.redactBody(rec.Request.Body)
+	rec.Response.Headers = r.redactHeaders(rec.Response.Headers)
+	rec.Response.Body = r.redactBody(rec.Response.Body)
+}
+
+func (r *redactor) redactHeaders(headers map[string][]string) map[string][]string {
+	redacted := make(map[string][]string, len(headers))
+	for key, values := range headers {
+		name := http.CanonicalHeaderKey(key)
+		if r.dropHeaders[name] {
+			continue
+		}
+		if r.maskHeaders[name] {
+			values = []string{redactedPlaceholder}
+		}
+		redacted[key] = values
+	}
+	return redacted
+}
+
+// redactQuery masks the values of the sensitive params of the raw query, leaving the
+// other params as they were sent.
+func (r *redactor) redactQuery(query string) string {
+	if query == "" || len(r.maskParams) == 0 {
+		return query
+	}
+	params := strings.Split(query, "&")
+	for i, param := range params {
+		rawKey := param
+		if j := strings.IndexByte(param, '='); j >= 0 {
+			rawKey = param[:j]
+		}
+		key, err := url.QueryUnescape(rawKey)
+		if err != nil {
+			key = rawKey
+		}
+		if r.maskParams[key] {
+			params[i] = rawKey + "=" + redactedPlaceholder
+		}
+	}
+	return strings.Join(params, "&")
+}
+
+func (r *redactor) redactBody(body string) string {
+	if body != "" && len(r.maskFields) > 0 {
+		body = r.maskBody(body)
+	}
+	if r.maxBodySize > 0 && len(body) > r.maxBodySize {
+		// don't cut a multi-byte character in half
+		cut := r.maxBodySize
+		for cut > 0 && !utf8.RuneStart(body[cut]) {
+			cut--
+		}
+		body = body[:cut]
+	}
+	return body
+}
+
+// maskBody masks the fields in a JSON body. Bodies that aren't a single JSON document,
+// such as the newline delimited ones accepted by _bulk and _msearch, are masked line by
+// line. Lines that aren't valid JSON are recorded as is.
+func (r *redactor) maskBody(body string) string {
+	d := json.NewDecoder(strings.NewReader(body))
+	d.UseNumber()
+	var doc interface{}
+	if err := d.Decode(&doc); err == nil {
+		if _, err := d.Token(); err == io.EOF {
+			if masked, ok := r.maskDoc(doc); ok {
+				return masked
+			}
+			return body
+		}
+	}
+
+	lines := strings.Split(body, "\n")
+	for i, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		var doc interface{}
+		d := json.NewDecoder(strings.NewReader(line))
+		d.UseNumber()
+		if err := d.Decode(&doc); err != nil {
+			continue
+		}
+		if masked, ok := r.maskDoc(doc); ok {
+			lines[i] = masked
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// maskDoc masks the fields of the decoded doc and encodes it back, it reports whether
+// any field was masked.
+func (r *redactor) maskDoc(doc interface{}) (string, bool) {
+	masked := false
+	for _, path := range r.maskFields {
+		if maskPath(doc, path, len(path) == 1) {
+			masked = true
+		}
+	}
+	if !masked {
+		return "", false
+	}
+	var buf bytes.Buffer
+	e := json.NewEncoder(&buf)
+	e.SetEscapeHTML(false)
+	if err := e.Encode(doc); err != nil {
+		return "", false
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), true
+}
+
+// maskPath replaces the value at the given path in the doc. If anyDepth is set, the
+// path is also looked up in every nested object. It reports whether a value was masked.
+func maskPath(doc interface{}, path []string, anyDepth bool) bool {
+	masked := false
+	switch v := doc.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if path[0] == "*" || path[0] == key {
+				if len(path) == 1 {
+					v[key] = redactedPlaceholder
+					masked = true
+					continue
+				}
+				if maskPath(value, path[1:], false) {
+					masked = true
+				}
+			}
+			if anyDepth && maskPath(value, path, true) {
+				masked = true
+			}
+		}
+	case []interface{}:
+		for _, value := range v {
+			if maskPath(value, path, anyDepth) {
+				masked = true
+			}
+		}
+	}
+	return masked
+}
@@ -0,0 +1,125 @@
+package logs
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func testRedactor(maxBodySize int, fields ...string) *redactor {
+	r := &redactor{
+		dropHeaders: map[string]bool{"X-Internal": true},
+		maskHeaders: map[string]bool{"Authorization": true},
+		maskParams:  map[string]bool{"api_key": true},
+		maxBodySize: maxBodySize,
+	}
+	for _, field := range fields {
+		r.maskFields = append(r.maskFields, strings.Split(field, "."))
+	}
+	return r
+}
+
+func TestRedactor(t *testing.T) {
+	Convey("Redacting log records", t, func() {
+		Convey("drops and masks the headers", func() {
+			r := testRedactor(0)
+			headers := r.redactHeaders(map[string][]string{
+				"x-internal":    {"1"},
+				"Authorization": {"Basic Zm9vOmJhcg=="},
+				"Content-Type":  {"application/json"},
+			})
+			So(headers, ShouldResemble, map[string][]string{
+				"Authorization": {redactedPlaceholder},
+				"Content-Type":  {"application/json"},
+			})
+		})
+
+		Convey("masks the body fields", func() {
+			tests := []struct {
+				name   string
+				fields []string
+				body   string
+				want   string
+			}{
+				{
+					name:   "at any depth",
+					fields: []string{"password"},
+					body:   `{"user":{"password":"secret","name":"jo"}}`,
+					want:   `{"user":{"name":"jo","password":"[REDACTED]"}}`,
+				},
+				{
+					name:   "from the root",
+					fields: []string{"user.ssn"},
+					body:   `{"ssn":"1","user":{"ssn":"2"}}`,
+					want:   `{"ssn":"1","user":{"ssn":"[REDACTED]"}}`,
+				},
+				{
+					name:   "in arrays",
+					fields: []string{"users.token"},
+					body:   `{"users":[{"token":"a"},{"token":"b","id":1}]}`,
+					want:   `{"users":[{"token":"[REDACTED]"},{"id":1,"token":"[REDACTED]"}]}`,
+				},
+				{
+					name:   "under any field",
+					fields: []string{"*.token"},
+					body:   `{"token":"a","github":{"token":"b"}}`,
+					want:   `{"github":{"token":"[REDACTED]"},"token":"a"}`,
+				},
+				{
+					name:   "in pretty printed bodies",
+					fields: []string{"password"},
+					body:   "{\n  \"password\": \"secret\",\n  \"size\": 10\n}",
+					want:   `{"password":"[REDACTED]","size":10}`,
+				},
+				{
+					name:   "line by line in ndjson bodies",
+					fields: []string{"password"},
+					body:   "{\"index\":{}}\n{\"password\":\"secret\"}\nnot json\n",
+					want:   "{\"index\":{}}\n{\"password\":\"[REDACTED]\"}\nnot json\n",
+				},
+				{
+					name:   "leaving the bodies without them untouched",
+					fields: []string{"password"},
+					body:   "{\n  \"query\": {}\n}",
+					want:   "{\n  \"query\": {}\n}",
+				},
+			}
+			for _, test := range tests {
+				Convey(test.name, func() {
+					So(testRedactor(0, test.fields...).redactBody(test.body), ShouldEqual, test.want)
+				})
+			}
+		})
+
+		Convey("truncates the bodies", func() {
+			tests := []struct {
+				name string
+				max  int
+				body string
+				want string
+			}{
+				{name: "to the max size", max: 4, body: "abcdef", want: "abcd"},
+				{name: "unless uncapped", max: 0, body: "abcdef", want: "abcdef"},
+				{name: "on a character boundary", max: 4, body: "abcé", want: "abc"},
+			}
+			for _, test := range tests {
+				Convey(test.name, func() {
+					So(testRedactor(test.max).redactBody(test.body), ShouldEqual, test.want)
+				})
+			}
+		})
+
+		Convey("masks the query params", func() {
+			r := testRedactor(0)
+			So(r.redactQuery("q=apple&api_key=arc_1_secret"), ShouldEqual, "q=apple&api_key=[REDACTED]")
+			So(r.redactQuery("api%5Fkey=arc_1_secret"), ShouldEqual, "api%5Fkey=[REDACTED]")
+			So(r.redactQuery(""), ShouldEqual, "")
+
+			rec := record{Request: Request{URI: "/products/_search", Query: "api_key=arc_1_secret"}}
+			r.redact(&rec)
+			So(rec.Request.URI, ShouldEqual, "/products/_search")
+			So(rec.Request.Query, ShouldEqual, "api_key=[REDACTED]")
+		})
+	})
+}
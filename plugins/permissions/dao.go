@@ -4,22 +4,38 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"time"
 
+	es7 "github.com/olivere/elastic/v7"
 	log "github.com/sirupsen/logrus"
 
 	"github.com/appbaseio/arc/model/permission"
 	"github.com/appbaseio/arc/util"
+	"github.com/appbaseio/arc/util/hasher"
 )
 
 type elasticsearch struct {
-	indexName string
-	mapping   string
+	indexName    string
+	quotasIndex  string
+	historyIndex string
+	mapping      string
 }
 
-func initPlugin(indexName, mapping string) (*elasticsearch, error) {
+func initPlugin(indexName, quotasIndex, historyIndex, mapping string) (*elasticsearch, error) {
 	ctx := context.Background()
 
-	es := &elasticsearch{indexName, mapping}
+	es := &elasticsearch{indexName, quotasIndex, historyIndex, mapping}
+
+	// the usage of the quotas and the history of the permissions are kept in their own indices
+	if err := createIndex(quotasIndex, mapping); err != nil {
+		return nil, err
+	}
+	if err := createIndex(historyIndex, mapping); err != nil {
+		return nil, err
+	}
+	if err := es.putHistoryMapping(ctx); err != nil {
+		return nil, fmt.Errorf("%s: error while updating mapping of index named %s: %v", logTag, historyIndex, err)
+	}
 
 	// Check if the meta index already exists
 	exists, err := util.GetClient7().IndexExists(indexName).
@@ -29,6 +45,13 @@ func initPlugin(indexName, mapping string) (*elasticsearch, error) {
 	}
 	if exists {
 		log.Println(logTag, ": index named", indexName, "already exists, skipping...")
+		// hash the passwords if not hashed already
+		if err := es.hashPasswords(); err != nil {
+			return nil, err
+		}
+		if err := es.putFilterMapping(ctx); err != nil {
+			return nil, fmt.Errorf("%s: error while updating mapping of index named %s: %v", logTag, indexName, err)
+		}
 		return es, nil
 	}
 
@@ -43,10 +66,145 @@ func initPlugin(indexName, mapping string) (*elasticsearch, error) {
 		return nil, fmt.Errorf("%s: error while creating index named %s: %v", logTag, indexName, err)
 	}
 
+	if err := es.putFilterMapping(ctx); err != nil {
+		return nil, fmt.Errorf("%s: error while updating mapping of index named %s: %v", logTag, indexName, err)
+	}
+
 	log.Println(logTag, ": successfully created index named", indexName)
 	return es, nil
 }
 
+func createIndex(indexName, mapping string) error {
+	ctx := context.Background()
+
+	exists, err := util.GetClient7().IndexExists(indexName).
+		Do(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: error while checking if index already exists: %v", logTag, err)
+	}
+	if exists {
+		log.Println(logTag, ": index named", indexName, "already exists, skipping...")
+		return nil
+	}
+
+	replicas := util.GetReplicas()
+	settings := fmt.Sprintf(mapping, util.HiddenIndexSettings(), replicas)
+	_, err = util.GetClient7().CreateIndex(indexName).
+		Body(settings).
+		Do(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: error while creating index named %s: %v", logTag, indexName, err)
+	}
+
+	log.Println(logTag, ": successfully created index named", indexName)
+	return nil
+}
+
+// filterMapping stores the permission and grant filters without indexing them, since
+// arbitrary query DSL would otherwise result in conflicting dynamic mappings.
+const filterMapping = `{
+	"properties": {
+		"filter": { "type": "object", "enabled": false },
+		"grants": { "properties": { "filter": { "type": "object", "enabled": false } } }
+	}
+}`
+
+// patchScript applies a patch that replaces, rather than merges, the permission
+// filter, schedule and quotas. A merged filter would end up with the clauses of both
+// the filters, and the unset fields of a schedule or quotas wouldn't be removed.
+const patchScript = `
+for (entry in params.patch.entrySet()) {
+	if (entry.getKey() == "limits" && ctx._source.limits != null) {
+		ctx._source.limits.putAll(entry.getValue());
+	} else {
+		ctx._source[entry.getKey()] = entry.getValue();
+	}
+}`
+
+// addUsageScript adds the requests counted by an arc instance to the stored usage.
+const addUsageScript = `
+ctx._source.total += params.total;
+if (ctx._source.categories == null) {
+	ctx._source.categories = [:];
+}
+for (entry in params.categories.entrySet()) {
+	def count = ctx._source.categories.get(entry.getKey());
+	ctx._source.categories.put(entry.getKey(), count == null ? entry.getValue() : count + entry.getValue());
+}`
+
+// replacesFields checks whether the patch sets fields that must be replaced by patchScript.
+func replacesFields(patch map[string]interface{}) bool {
+	for _, field := range []string{"filter", "schedule", "quotas"} {
+		if _, ok := patch[field]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (es *elasticsearch) putFilterMapping(ctx context.Context) error {
+	switch util.GetVersion() {
+	case 6:
+		return es.putFilterMappingEs6(ctx)
+	default:
+		return es.putFilterMappingEs7(ctx)
+	}
+}
+
+func (es *elasticsearch) putHistoryMapping(ctx context.Context) error {
+	switch util.GetVersion() {
+	case 6:
+		return es.putHistoryMappingEs6(ctx)
+	default:
+		return es.putHistoryMappingEs7(ctx)
+	}
+}
+
+func (es *elasticsearch) hashPasswords() error {
+	// get all the permissions along with their stored passwords
+	rawPermissions, err := es.getRawPermissions(context.Background())
+	if err != nil {
+		return err
+	}
+
+	// unmarshal into list of permissions
+	permissions := []permission.Permission{}
+	err = json.Unmarshal(rawPermissions, &permissions)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range permissions {
+		// don't do anything if already hashed
+		if p.PasswordHashType != "" {
+			continue
+		}
+
+		// hash the password
+		hashedPassword, hashType, err := hasher.Hash(p.Password)
+		if err != nil {
+			log.Errorln(logTag, ": an error occurred while hashing password for permission", p.Username, ":", err)
+			continue
+		}
+
+		// patch the permission
+		_, err = es.patchPermission(context.Background(), p.Username, map[string]interface{}{
+			"password":           hashedPassword,
+			"password_hash_type": hashType,
+		})
+		if err != nil {
+			return err
+		}
+
+		log.Println(logTag, ": hashed password for permission", p.Username, "using", hashType)
+	}
+
+	return nil
+}
+
+// applyExpiredField computes the "expired" field of the permission, along with the
+// "schedule_status" of the permissions with a schedule, and strips the stored password
+// hash, which must never be exposed in a response.
 func applyExpiredField(data []byte) ([]byte, error) {
 	var rawPermission *permission.Permission
 	err := json.Unmarshal(data, &rawPermission)
@@ -57,6 +215,14 @@ func applyExpiredField(data []byte) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
+	rawPermission.ScheduleStatus = ""
+	if rawPermission.Schedule != nil {
+		rawPermission.ScheduleStatus, err = rawPermission.Schedule.Status(time.Now())
+		if err != nil {
+			return nil, err
+		}
+	}
+	rawPermission.Password = ""
 	marshalled, err := json.Marshal(rawPermission)
 	if err != nil {
 		return nil, fmt.Errorf("unable to marshal slice of raw permissions: %v", err)
@@ -87,6 +253,15 @@ func (es *elasticsearch) getRawPermission(ctx context.Context, username string)
 	}
 }
 
+func (es *elasticsearch) getRawPermissions(ctx context.Context) ([]byte, error) {
+	switch util.GetVersion() {
+	case 6:
+		return es.getRawPermissionsEs6(ctx)
+	default:
+		return es.getRawPermissionsEs7(ctx)
+	}
+}
+
 func (es *elasticsearch) postPermission(ctx context.Context, p permission.Permission) (bool, error) {
 	_, err := util.GetClient7().Index().
 		Refresh("wait_for").
@@ -158,3 +333,71 @@ func (es *elasticsearch) getRawRolePermission(ctx context.Context, role string)
 		return es.getRawRolePermissionEs7(ctx, role)
 	}
 }
+
+func (es *elasticsearch) getQuotaUsage(ctx context.Context, id string) (*usage, error) {
+	switch util.GetVersion() {
+	case 6:
+		return es.getQuotaUsageEs6(ctx, id)
+	default:
+		return es.getQuotaUsageEs7(ctx, id)
+	}
+}
+
+func (es *elasticsearch) addQuotaUsage(ctx context.Context, id string, delta *usage) (*usage, error) {
+	switch util.GetVersion() {
+	case 6:
+		return es.addQuotaUsageEs6(ctx, id, delta)
+	default:
+		return es.addQuotaUsageEs7(ctx, id, delta)
+	}
+}
+
+// getPermissionSource returns the permission as it is stored, password hash included,
+// or nil if it doesn't exist.
+func (es *elasticsearch) getPermissionSource(ctx context.Context, username string) ([]byte, error) {
+	switch util.GetVersion() {
+	case 6:
+		return es.getPermissionSourceEs6(ctx, username)
+	default:
+		return es.getPermissionSourceEs7(ctx, username)
+	}
+}
+
+// getHistory returns the last size versions of the permission, most recent first.
+func (es *elasticsearch) getHistory(ctx context.Context, username string, size int) ([]historyEntry, error) {
+	switch util.GetVersion() {
+	case 6:
+		return es.getHistoryEs6(ctx, username, size)
+	default:
+		return es.getHistoryEs7(ctx, username, size)
+	}
+}
+
+func (es *elasticsearch) getHistoryEntry(ctx context.Context, username string, version int64) (*historyEntry, error) {
+	switch util.GetVersion() {
+	case 6:
+		return es.getHistoryEntryEs6(ctx, username, version)
+	default:
+		return es.getHistoryEntryEs7(ctx, username, version)
+	}
+}
+
+// addHistoryEntry stores the entry unless its version already exists, in which case it
+// returns false.
+func (es *elasticsearch) addHistoryEntry(ctx context.Context, entry historyEntry) (bool, error) {
+	_, err := util.GetClient7().Index().
+		Refresh("wait_for").
+		Index(es.historyIndex).
+		Id(historyID(entry.Username, entry.Version)).
+		OpType("create").
+		BodyJson(entry).
+		Do(ctx)
+	if es7.IsConflict(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
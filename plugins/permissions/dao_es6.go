@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 
 	"github.com/appbaseio/arc/util"
 	es6 "gopkg.in/olivere/elastic.v6"
@@ -32,7 +33,7 @@ func (es *elasticsearch) getRawRolePermissionEs6(ctx context.Context, role strin
 		return nil, err
 	}
 	for _, hit := range resp.Hits.Hits {
-		src, err := json.Marshal(*hit.Source)
+		src, err := applyExpiredField(*hit.Source)
 		if err == nil {
 			return src, nil
 		}
@@ -96,6 +97,28 @@ func (es *elasticsearch) getPermissionsEs6(ctx context.Context, indices []string
 	return raw, nil
 }
 
+func (es *elasticsearch) getRawPermissionsEs6(ctx context.Context) ([]byte, error) {
+	// scroll through the permissions, a single search only returns a page of them
+	scroll := util.GetClient6().Scroll(es.indexName).Size(1000)
+	defer scroll.Clear(context.Background())
+
+	var rawPermissions []json.RawMessage
+	for {
+		resp, err := scroll.Do(ctx)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, hit := range resp.Hits.Hits {
+			rawPermissions = append(rawPermissions, *hit.Source)
+		}
+	}
+
+	return json.Marshal(rawPermissions)
+}
+
 func (es *elasticsearch) getRawPermissionEs6(ctx context.Context, username string) ([]byte, error) {
 	response, err := util.GetClient6().Get().
 		Index(es.indexName).
@@ -114,13 +137,17 @@ func (es *elasticsearch) getRawPermissionEs6(ctx context.Context, username strin
 }
 
 func (es *elasticsearch) patchPermissionEs6(ctx context.Context, username string, patch map[string]interface{}) ([]byte, error) {
-	response, err := util.GetClient6().Update().
+	update := util.GetClient6().Update().
 		Refresh("wait_for").
 		Index(es.indexName).
 		Type(typeName).
-		Id(username).
-		Doc(patch).
-		Do(ctx)
+		Id(username)
+	if replacesFields(patch) {
+		update = update.Script(es6.NewScript(patchScript).Params(map[string]interface{}{"patch": patch}))
+	} else {
+		update = update.Doc(patch)
+	}
+	response, err := update.Do(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -132,3 +159,124 @@ func (es *elasticsearch) patchPermissionEs6(ctx context.Context, username string
 
 	return src, nil
 }
+
+func (es *elasticsearch) putFilterMappingEs6(ctx context.Context) error {
+	_, err := util.GetClient6().PutMapping().
+		Index(es.indexName).
+		Type(typeName).
+		BodyString(filterMapping).
+		Do(ctx)
+	return err
+}
+
+func (es *elasticsearch) getQuotaUsageEs6(ctx context.Context, id string) (*usage, error) {
+	response, err := util.GetClient6().Get().
+		Index(es.quotasIndex).
+		Type(typeName).
+		Id(id).
+		Do(ctx)
+	if es6.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var u usage
+	if err := json.Unmarshal(*response.Source, &u); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+func (es *elasticsearch) addQuotaUsageEs6(ctx context.Context, id string, delta *usage) (*usage, error) {
+	params := map[string]interface{}{
+		"total":      delta.Total,
+		"categories": delta.Categories,
+	}
+	response, err := util.GetClient6().Update().
+		Index(es.quotasIndex).
+		Type(typeName).
+		Id(id).
+		Script(es6.NewScript(addUsageScript).Params(params)).
+		Upsert(delta).
+		RetryOnConflict(3).
+		FetchSource(true).
+		Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if response.GetResult == nil || response.GetResult.Source == nil {
+		return nil, fmt.Errorf("usage %s wasn't returned by the update", id)
+	}
+	var u usage
+	if err := json.Unmarshal(*response.GetResult.Source, &u); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+func (es *elasticsearch) putHistoryMappingEs6(ctx context.Context) error {
+	_, err := util.GetClient6().PutMapping().
+		Index(es.historyIndex).
+		Type(typeName).
+		BodyString(historyMapping).
+		Do(ctx)
+	return err
+}
+
+func (es *elasticsearch) getPermissionSourceEs6(ctx context.Context, username string) ([]byte, error) {
+	response, err := util.GetClient6().Get().
+		Index(es.indexName).
+		Type(typeName).
+		Id(username).
+		FetchSource(true).
+		Do(ctx)
+	if es6.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return *response.Source, nil
+}
+
+func (es *elasticsearch) getHistoryEs6(ctx context.Context, username string, size int) ([]historyEntry, error) {
+	resp, err := util.GetClient6().Search().
+		Index(es.historyIndex).
+		Query(es6.NewTermQuery("username", username)).
+		Sort("version", false).
+		Size(size).
+		Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []historyEntry{}
+	for _, hit := range resp.Hits.Hits {
+		var entry historyEntry
+		if err := json.Unmarshal(*hit.Source, &entry); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func (es *elasticsearch) getHistoryEntryEs6(ctx context.Context, username string, version int64) (*historyEntry, error) {
+	response, err := util.GetClient6().Get().
+		Index(es.historyIndex).
+		Type(typeName).
+		Id(historyID(username, version)).
+		Do(ctx)
+	if es6.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entry historyEntry
+	if err := json.Unmarshal(*response.Source, &entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
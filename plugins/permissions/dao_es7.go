@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 
 	"github.com/appbaseio/arc/util"
 	es7 "github.com/olivere/elastic/v7"
@@ -32,7 +33,7 @@ func (es *elasticsearch) getRawRolePermissionEs7(ctx context.Context, role strin
 		return nil, err
 	}
 	for _, hit := range resp.Hits.Hits {
-		src, err := json.Marshal(hit.Source)
+		src, err := applyExpiredField(hit.Source)
 		if err == nil {
 			return src, nil
 		}
@@ -96,6 +97,28 @@ func (es *elasticsearch) getPermissionsEs7(ctx context.Context, indices []string
 	return raw, nil
 }
 
+func (es *elasticsearch) getRawPermissionsEs7(ctx context.Context) ([]byte, error) {
+	// scroll through the permissions, a single search only returns a page of them
+	scroll := util.GetClient7().Scroll(es.indexName).Size(1000)
+	defer scroll.Clear(context.Background())
+
+	var rawPermissions []json.RawMessage
+	for {
+		resp, err := scroll.Do(ctx)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, hit := range resp.Hits.Hits {
+			rawPermissions = append(rawPermissions, hit.Source)
+		}
+	}
+
+	return json.Marshal(rawPermissions)
+}
+
 func (es *elasticsearch) getRawPermissionEs7(ctx context.Context, username string) ([]byte, error) {
 	response, err := util.GetClient7().Get().
 		Index(es.indexName).
@@ -114,12 +137,16 @@ func (es *elasticsearch) getRawPermissionEs7(ctx context.Context, username strin
 }
 
 func (es *elasticsearch) patchPermissionEs7(ctx context.Context, username string, patch map[string]interface{}) ([]byte, error) {
-	response, err := util.GetClient7().Update().
+	update := util.GetClient7().Update().
 		Refresh("wait_for").
 		Index(es.indexName).
-		Id(username).
-		Doc(patch).
-		Do(ctx)
+		Id(username)
+	if replacesFields(patch) {
+		update = update.Script(es7.NewScript(patchScript).Params(map[string]interface{}{"patch": patch}))
+	} else {
+		update = update.Doc(patch)
+	}
+	response, err := update.Do(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -131,3 +158,118 @@ func (es *elasticsearch) patchPermissionEs7(ctx context.Context, username string
 
 	return src, nil
 }
+
+func (es *elasticsearch) putFilterMappingEs7(ctx context.Context) error {
+	_, err := util.GetClient7().PutMapping().
+		Index(es.indexName).
+		BodyString(filterMapping).
+		Do(ctx)
+	return err
+}
+
+func (es *elasticsearch) getQuotaUsageEs7(ctx context.Context, id string) (*usage, error) {
+	response, err := util.GetClient7().Get().
+		Index(es.quotasIndex).
+		Id(id).
+		Do(ctx)
+	if es7.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var u usage
+	if err := json.Unmarshal(response.Source, &u); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+func (es *elasticsearch) addQuotaUsageEs7(ctx context.Context, id string, delta *usage) (*usage, error) {
+	params := map[string]interface{}{
+		"total":      delta.Total,
+		"categories": delta.Categories,
+	}
+	response, err := util.GetClient7().Update().
+		Index(es.quotasIndex).
+		Id(id).
+		Script(es7.NewScript(addUsageScript).Params(params)).
+		Upsert(delta).
+		RetryOnConflict(3).
+		FetchSource(true).
+		Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if response.GetResult == nil || response.GetResult.Source == nil {
+		return nil, fmt.Errorf("usage %s wasn't returned by the update", id)
+	}
+	var u usage
+	if err := json.Unmarshal(response.GetResult.Source, &u); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+func (es *elasticsearch) putHistoryMappingEs7(ctx context.Context) error {
+	_, err := util.GetClient7().PutMapping().
+		Index(es.historyIndex).
+		BodyString(historyMapping).
+		Do(ctx)
+	return err
+}
+
+func (es *elasticsearch) getPermissionSourceEs7(ctx context.Context, username string) ([]byte, error) {
+	response, err := util.GetClient7().Get().
+		Index(es.indexName).
+		Id(username).
+		FetchSource(true).
+		Do(ctx)
+	if es7.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return response.Source, nil
+}
+
+func (es *elasticsearch) getHistoryEs7(ctx context.Context, username string, size int) ([]historyEntry, error) {
+	resp, err := util.GetClient7().Search().
+		Index(es.historyIndex).
+		Query(es7.NewTermQuery("username", username)).
+		Sort("version", false).
+		Size(size).
+		Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []historyEntry{}
+	for _, hit := range resp.Hits.Hits {
+		var entry historyEntry
+		if err := json.Unmarshal(hit.Source, &entry); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func (es *elasticsearch) getHistoryEntryEs7(ctx context.Context, username string, version int64) (*historyEntry, error) {
+	response, err := util.GetClient7().Get().
+		Index(es.historyIndex).
+		Id(historyID(username, version)).
+		Do(ctx)
+	if es7.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entry historyEntry
+	if err := json.Unmarshal(response.Source, &entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
@@ -63,21 +63,23 @@ var defaultAdminLimits = permission.Limits{
 }
 
 var createPermissionResponse = map[string]interface{}{
-	"owner":          "foo",
-	"creator":        "foo",
-	"role":           "",
-	"categories":     adminCategories,
-	"acls":           category.ACLsFor(adminCategories...),
-	"ops":            adminOps,
-	"indices":        []string{"*"},
-	"sources":        []string{"0.0.0.0/0"},
-	"referers":       []string{"*"},
-	"ttl":            -1,
-	"limits":         &defaultAdminLimits,
-	"description":    "TEST PERMISSION",
-	"include_fields": nil,
-	"exclude_fields": nil,
-	"expired":        false,
+	"password_hash_type": "bcrypt",
+	"owner":              "foo",
+	"creator":            "foo",
+	"role":               "",
+	"categories":         adminCategories,
+	"acls":               category.ACLsFor(adminCategories...),
+	"ops":                adminOps,
+	"indices":            []string{"*"},
+	"sources":            []string{"0.0.0.0/0"},
+	"referers":           []string{"*"},
+	"ttl":                -1,
+	"limits":             &defaultAdminLimits,
+	"description":        "TEST PERMISSION",
+	"include_fields":     nil,
+	"exclude_fields":     nil,
+	"filter":             nil,
+	"expired":            false,
 }
 
 var updatePermissionsRequest = map[string]interface{}{
@@ -200,6 +202,9 @@ func TestPermission(t *testing.T) {
 			password, _ = parsedResponse["password"].(string)
 			createdAt, _ = parsedResponse["created_at"].(string)
 
+			// the password is only returned once, on creation
+			So(password, ShouldNotBeEmpty)
+
 			delete(parsedResponse, "username")
 			delete(parsedResponse, "password")
 			delete(parsedResponse, "created_at")
@@ -217,7 +222,6 @@ func TestPermission(t *testing.T) {
 			}
 			var getPermissionResponse = createPermissionResponse
 			getPermissionResponse["username"] = username
-			getPermissionResponse["password"] = password
 			getPermissionResponse["created_at"] = createdAt
 			mockMap := util.StructToMap(getPermissionResponse)
 
@@ -232,7 +236,6 @@ func TestPermission(t *testing.T) {
 			}
 			var getPermissionsResponse = allPermissionsResponse
 			getPermissionsResponse[0]["username"] = username
-			getPermissionsResponse[0]["password"] = password
 			getPermissionsResponse[0]["created_at"] = createdAt
 			var mockMap []interface{}
 			parsedResponse, _ := response.([]interface{})
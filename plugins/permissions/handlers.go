@@ -5,14 +5,19 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"strconv"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 
+	"github.com/appbaseio/arc/middleware/explain"
 	"github.com/appbaseio/arc/model/acl"
 	"github.com/appbaseio/arc/model/index"
 	"github.com/appbaseio/arc/model/permission"
 	"github.com/appbaseio/arc/model/user"
+	"github.com/appbaseio/arc/plugins"
 	"github.com/appbaseio/arc/util"
+	"github.com/appbaseio/arc/util/hasher"
 	"github.com/gorilla/mux"
 )
 
@@ -32,6 +37,204 @@ func (p *permissions) getPermission() http.HandlerFunc {
 	}
 }
 
+func (p *permissions) explainPermission() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		vars := mux.Vars(req)
+		username := vars["username"]
+
+		obj, err := p.es.getPermission(req.Context(), username)
+		if err != nil {
+			msg := fmt.Sprintf(`permission with "username"="%s" not found`, username)
+			log.Errorln(logTag, ":", msg, ":", err)
+			util.WriteBackError(w, msg, http.StatusNotFound)
+			return
+		}
+		explain.Serve(w, req, plugins.Router(), obj)
+	}
+}
+
+func (p *permissions) getPermissionUsage() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		vars := mux.Vars(req)
+		username := vars["username"]
+
+		obj, err := p.es.getPermission(req.Context(), username)
+		if err != nil {
+			msg := fmt.Sprintf(`permission with "username"="%s" not found`, username)
+			log.Errorln(logTag, ":", msg, ":", err)
+			util.WriteBackError(w, msg, http.StatusNotFound)
+			return
+		}
+
+		if p.quotas == nil {
+			msg := "the quotas aren't initialized"
+			log.Errorln(logTag, ":", msg)
+			util.WriteBackError(w, msg, http.StatusInternalServerError)
+			return
+		}
+		usages, err := p.quotas.usage(req.Context(), username, time.Now())
+		if err != nil {
+			msg := fmt.Sprintf(`an error occurred while fetching the usage of permission "username"="%s"`, username)
+			log.Errorln(logTag, ":", msg, ":", err)
+			util.WriteBackError(w, msg, http.StatusInternalServerError)
+			return
+		}
+		response := map[string]interface{}{
+			"username": username,
+			"quotas":   obj.Quotas,
+			"daily":    usages[periodDay],
+			"monthly":  usages[periodMonth],
+		}
+		raw, err := json.Marshal(response)
+		if err != nil {
+			msg := "error marshalling the permission usage"
+			log.Errorln(logTag, ":", msg, ":", err)
+			util.WriteBackError(w, msg, http.StatusInternalServerError)
+			return
+		}
+		util.WriteBackRaw(w, raw, http.StatusOK)
+	}
+}
+
+func (p *permissions) getPermissionHistory() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		vars := mux.Vars(req)
+		username := vars["username"]
+
+		size := defaultHistorySize
+		if value := req.URL.Query().Get("size"); value != "" {
+			var err error
+			size, err = strconv.Atoi(value)
+			if err != nil || size <= 0 || size > maxHistorySize {
+				msg := fmt.Sprintf(`"size" must be a number between 1 and %d`, maxHistorySize)
+				util.WriteBackError(w, msg, http.StatusBadRequest)
+				return
+			}
+		}
+
+		entries, err := p.es.getHistory(req.Context(), username, size)
+		if err != nil {
+			msg := fmt.Sprintf(`an error occurred while fetching the history of permission "username"="%s"`, username)
+			log.Errorln(logTag, ":", msg, ":", err)
+			util.WriteBackError(w, msg, http.StatusInternalServerError)
+			return
+		}
+		if len(entries) == 0 {
+			msg := fmt.Sprintf(`history of permission with "username"="%s" not found`, username)
+			util.WriteBackError(w, msg, http.StatusNotFound)
+			return
+		}
+		for i := range entries {
+			if entries[i], err = entries[i].withoutPassword(); err != nil {
+				msg := fmt.Sprintf(`an error occurred while fetching the history of permission "username"="%s"`, username)
+				log.Errorln(logTag, ":", msg, ":", err)
+				util.WriteBackError(w, msg, http.StatusInternalServerError)
+				return
+			}
+		}
+
+		raw, err := json.Marshal(entries)
+		if err != nil {
+			msg := "error marshalling the permission history"
+			log.Errorln(logTag, ":", msg, ":", err)
+			util.WriteBackError(w, msg, http.StatusInternalServerError)
+			return
+		}
+		util.WriteBackRaw(w, raw, http.StatusOK)
+	}
+}
+
+func (p *permissions) rollbackPermission() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		vars := mux.Vars(req)
+		username := vars["username"]
+		ctx := req.Context()
+
+		version, err := strconv.ParseInt(vars["version"], 10, 64)
+		if err != nil || version <= 0 {
+			util.WriteBackError(w, "version must be a positive number", http.StatusBadRequest)
+			return
+		}
+
+		entry, err := p.es.getHistoryEntry(ctx, username, version)
+		if err != nil {
+			msg := fmt.Sprintf(`an error occurred while fetching version %d of permission "username"="%s"`, version, username)
+			log.Errorln(logTag, ":", msg, ":", err)
+			util.WriteBackError(w, msg, http.StatusInternalServerError)
+			return
+		}
+		if entry == nil {
+			msg := fmt.Sprintf(`version %d of permission with "username"="%s" not found`, version, username)
+			util.WriteBackError(w, msg, http.StatusNotFound)
+			return
+		}
+		if entry.Permission == nil {
+			msg := fmt.Sprintf(`version %d deleted permission with "username"="%s", it can't be restored`, version, username)
+			util.WriteBackError(w, msg, http.StatusBadRequest)
+			return
+		}
+
+		var restored permission.Permission
+		if err := json.Unmarshal(entry.Permission, &restored); err != nil {
+			msg := fmt.Sprintf(`an error occurred while restoring version %d of permission "username"="%s"`, version, username)
+			log.Errorln(logTag, ":", msg, ":", err)
+			util.WriteBackError(w, msg, http.StatusInternalServerError)
+			return
+		}
+		// the role might have been given to another permission since
+		if restored.Role != "" {
+			rawRolePermission, err := p.es.getRawRolePermission(ctx, restored.Role)
+			if err != nil {
+				msg := fmt.Sprintf(`an error occurred while restoring permission for role=%s`, restored.Role)
+				log.Errorln(logTag, ": unable to check if role=", restored.Role, "exists:", err)
+				util.WriteBackError(w, msg, http.StatusInternalServerError)
+				return
+			}
+			var rolePermission permission.Permission
+			if rawRolePermission != nil && json.Unmarshal(rawRolePermission, &rolePermission) == nil &&
+				rolePermission.Username != username {
+				msg := fmt.Sprintf(`permission with role=%s already exists`, restored.Role)
+				util.WriteBackError(w, msg, http.StatusBadRequest)
+				return
+			}
+		}
+
+		before, err := p.es.getPermissionSource(ctx, username)
+		if err != nil {
+			msg := fmt.Sprintf(`an error occurred while restoring version %d of permission "username"="%s"`, version, username)
+			log.Errorln(logTag, ":", msg, ":", err)
+			util.WriteBackError(w, msg, http.StatusInternalServerError)
+			return
+		}
+		if ok, err := p.es.postPermission(ctx, restored); !ok || err != nil {
+			msg := fmt.Sprintf(`an error occurred while restoring version %d of permission "username"="%s"`, version, username)
+			log.Errorln(logTag, ":", msg, ":", err)
+			util.WriteBackError(w, msg, http.StatusInternalServerError)
+			return
+		}
+		p.recordHistory(ctx, historyRollback, requestActor(req), username, before, entry.Permission, version)
+
+		rawPermission, err := applyExpiredField(entry.Permission)
+		if err != nil {
+			msg := fmt.Sprintf(`permission with "username"="%s" restored`, username)
+			log.Errorln(logTag, ": unable to return the restored permission", username, ":", err)
+			util.WriteBackMessage(w, msg, http.StatusOK)
+			return
+		}
+		util.WriteBackRaw(w, rawPermission, http.StatusOK)
+	}
+}
+
+// requestActor returns the username of the user making the request, to be recorded in the
+// history of the permissions it changes.
+func requestActor(req *http.Request) string {
+	if reqUser, err := user.FromContext(req.Context()); err == nil && reqUser != nil {
+		return reqUser.Username
+	}
+	username, _, _ := req.BasicAuth()
+	return username
+}
+
 func (p *permissions) postPermission(opts ...permission.Options) http.HandlerFunc {
 	return func(w http.ResponseWriter, req *http.Request) {
 		creator, _, _ := req.BasicAuth()
@@ -91,9 +294,15 @@ func (p *permissions) postPermission(opts ...permission.Options) http.HandlerFun
 		if permissionBody.Excludes != nil {
 			permissionOptions = append(permissionOptions, permission.SetExcludes(permissionBody.Excludes))
 		}
+		if permissionBody.Filter != nil {
+			permissionOptions = append(permissionOptions, permission.SetFilter(permissionBody.Filter))
+		}
 		if permissionBody.Indices != nil {
 			permissionOptions = append(permissionOptions, permission.SetIndices(permissionBody.Indices))
 		}
+		if permissionBody.Grants != nil {
+			permissionOptions = append(permissionOptions, permission.SetGrants(permissionBody.Grants))
+		}
 		if permissionBody.Limits != nil {
 			permissionOptions = append(permissionOptions, permission.SetLimits(permissionBody.Limits, *reqUser.IsAdmin))
 		}
@@ -103,6 +312,12 @@ func (p *permissions) postPermission(opts ...permission.Options) http.HandlerFun
 		if permissionBody.TTL != 0 {
 			permissionOptions = append(permissionOptions, permission.SetTTL(permissionBody.TTL))
 		}
+		if permissionBody.Schedule != nil {
+			permissionOptions = append(permissionOptions, permission.SetSchedule(permissionBody.Schedule))
+		}
+		if permissionBody.Quotas != nil {
+			permissionOptions = append(permissionOptions, permission.SetQuotas(permissionBody.Quotas))
+		}
 
 		var newPermission *permission.Permission
 		if *reqUser.IsAdmin {
@@ -116,6 +331,17 @@ func (p *permissions) postPermission(opts ...permission.Options) http.HandlerFun
 			return
 		}
 
+		// the plaintext password is returned only once, in this response,
+		// the permission itself is stored with the hashed password
+		hashedPassword, hashType, err := hasher.Hash(newPermission.Password)
+		if err != nil {
+			msg := fmt.Sprintf(`an error occurred while creating permission for "creator"="%s"`, creator)
+			log.Errorln(logTag, ": an error occurred while hashing password:", err)
+			util.WriteBackError(w, msg, http.StatusInternalServerError)
+			return
+		}
+		newPermission.PasswordHashType = hashType
+
 		rawPermission, err := json.Marshal(*newPermission)
 		if err != nil {
 			msg := fmt.Sprintf(`an error occurred while creating permission for "creator"="%s"`, creator)
@@ -123,6 +349,7 @@ func (p *permissions) postPermission(opts ...permission.Options) http.HandlerFun
 			util.WriteBackError(w, msg, http.StatusInternalServerError)
 			return
 		}
+		newPermission.Password = hashedPassword
 
 		if newPermission.Role != "" {
 			var roleExists bool
@@ -143,6 +370,11 @@ func (p *permissions) postPermission(opts ...permission.Options) http.HandlerFun
 
 		ok, err := p.es.postPermission(req.Context(), *newPermission)
 		if ok && err == nil {
+			if storedPermission, err := json.Marshal(*newPermission); err == nil {
+				p.recordHistory(req.Context(), historyCreate, requestActor(req), newPermission.Username, nil, storedPermission, 0)
+			} else {
+				log.Errorln(logTag, ": unable to marshal the permission for its history:", err)
+			}
 			util.WriteBackRaw(w, rawPermission, http.StatusOK)
 			return
 		}
@@ -193,8 +425,8 @@ func (p *permissions) patchPermission() http.HandlerFunc {
 			return
 		}
 
-		// If user is trying to patch acls without providing categories.
-		if patch["categories"] == nil && patch["acls"] != nil {
+		// If user is trying to patch acls or grants without providing categories.
+		if patch["categories"] == nil && (patch["acls"] != nil || patch["grants"] != nil) {
 			// we need to fetch the permission from elasticsearch before we make
 			// a patch request in order to validate the acls that the user intends
 			// to patch against the categories it already has.
@@ -205,12 +437,19 @@ func (p *permissions) patchPermission() http.HandlerFunc {
 				return
 			}
 
-			acls, ok := patch["acls"].([]acl.ACL)
-			if !ok {
-				msg := fmt.Sprintf(`an error occurred while validating categories patch for user "%s"`, username)
-				log.Println(logTag, ": unable to cast categories patch to []acl.ACL")
-				util.WriteBackError(w, msg, http.StatusInternalServerError)
-				return
+			var acls []acl.ACL
+			if patch["acls"] != nil {
+				var ok bool
+				acls, ok = patch["acls"].([]acl.ACL)
+				if !ok {
+					msg := fmt.Sprintf(`an error occurred while validating categories patch for user "%s"`, username)
+					log.Println(logTag, ": unable to cast categories patch to []acl.ACL")
+					util.WriteBackError(w, msg, http.StatusInternalServerError)
+					return
+				}
+			}
+			for _, g := range obj.Grants {
+				acls = append(acls, g.ACLs...)
 			}
 
 			if err := reqPermission.ValidateACLs(acls...); err != nil {
@@ -236,8 +475,18 @@ func (p *permissions) patchPermission() http.HandlerFunc {
 			}
 		}
 
+		before, err := p.es.getPermissionSource(req.Context(), username)
+		if err != nil {
+			log.Errorln(logTag, ": unable to fetch permission", username, "for its history:", err)
+		}
 		_, err2 := p.es.patchPermission(req.Context(), username, patch)
 		if err2 == nil {
+			after, err := p.es.getPermissionSource(req.Context(), username)
+			if err != nil {
+				log.Errorln(logTag, ": unable to fetch permission", username, "for its history:", err)
+			} else {
+				p.recordHistory(req.Context(), historyPatch, requestActor(req), username, before, after, 0)
+			}
 			util.WriteBackMessage(w, "permission is updated successfully", http.StatusOK)
 			return
 		}
@@ -253,8 +502,13 @@ func (p *permissions) deletePermission() http.HandlerFunc {
 		vars := mux.Vars(req)
 		username := vars["username"]
 
+		before, err := p.es.getPermissionSource(req.Context(), username)
+		if err != nil {
+			log.Errorln(logTag, ": unable to fetch permission", username, "for its history:", err)
+		}
 		ok, err := p.es.deletePermission(req.Context(), username)
 		if ok && err == nil {
+			p.recordHistory(req.Context(), historyDelete, requestActor(req), username, before, nil, 0)
 			msg := fmt.Sprintf(`permission with "username"="%s" deleted`, username)
 			util.WriteBackMessage(w, msg, http.StatusOK)
 			return
@@ -0,0 +1,154 @@
+package permissions
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	envPermissionsHistoryEsIndex     = "PERMISSIONS_HISTORY_ES_INDEX"
+	defaultPermissionsHistoryEsIndex = ".permissions_history"
+	historyCreate                    = "create"
+	historyPatch                     = "patch"
+	historyDelete                    = "delete"
+	historyRollback                  = "rollback"
+	defaultHistorySize               = 100
+	maxHistorySize                   = 1000
+	historyVersionAttempts           = 10
+)
+
+// historyMapping indexes the fields the history is searched and sorted by, the snapshots
+// and diffs are stored as they are since they would result in conflicting dynamic mappings.
+const historyMapping = `{
+	"properties": {
+		"username": { "type": "keyword" },
+		"version": { "type": "long" },
+		"action": { "type": "keyword" },
+		"actor": { "type": "keyword" },
+		"timestamp": { "type": "date" },
+		"restored_version": { "type": "long" },
+		"permission": { "type": "object", "enabled": false },
+		"diff": { "type": "object", "enabled": false }
+	}
+}`
+
+// historyEntry is a version of a permission: the permission as it was after a create, patch,
+// delete or rollback, along with who made the change, when, and the fields it changed. The
+// permission of a delete entry is nil.
+type historyEntry struct {
+	Username        string                 `json:"username"`
+	Version         int64                  `json:"version"`
+	Action          string                 `json:"action"`
+	Actor           string                 `json:"actor"`
+	Timestamp       string                 `json:"timestamp"`
+	RestoredVersion int64                  `json:"restored_version,omitempty"`
+	Permission      json.RawMessage        `json:"permission,omitempty"`
+	Diff            map[string]fieldChange `json:"diff"`
+}
+
+// fieldChange is the value of a permission field before and after a change, nil meaning unset.
+type fieldChange struct {
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
+}
+
+// historyID returns the id of the history doc of the given version of the permission.
+func historyID(username string, version int64) string {
+	return fmt.Sprintf("%s:%d", username, version)
+}
+
+// diffPermissions returns the fields that differ between the two raw permissions, either of
+// which can be nil. The password hash is left out.
+func diffPermissions(before, after []byte) (map[string]fieldChange, error) {
+	var from, to map[string]interface{}
+	if before != nil {
+		if err := json.Unmarshal(before, &from); err != nil {
+			return nil, err
+		}
+	}
+	if after != nil {
+		if err := json.Unmarshal(after, &to); err != nil {
+			return nil, err
+		}
+	}
+	diff := make(map[string]fieldChange)
+	for field, value := range from {
+		if !reflect.DeepEqual(value, to[field]) {
+			diff[field] = fieldChange{From: value, To: to[field]}
+		}
+	}
+	for field, value := range to {
+		if _, ok := from[field]; !ok {
+			diff[field] = fieldChange{To: value}
+		}
+	}
+	delete(diff, "password")
+	return diff, nil
+}
+
+// recordHistory stores a new version of the permission, after being nil if it was deleted.
+// The history is recorded once the change is made, so a failure is only logged.
+func (p *permissions) recordHistory(ctx context.Context, action, actor, username string,
+	before, after []byte, restoredVersion int64) {
+	diff, err := diffPermissions(before, after)
+	if err != nil {
+		log.Errorln(logTag, ": unable to diff the versions of permission", username, ":", err)
+		return
+	}
+	entry := historyEntry{
+		Username:        username,
+		Action:          action,
+		Actor:           actor,
+		Timestamp:       time.Now().Format(time.RFC3339),
+		RestoredVersion: restoredVersion,
+		Permission:      after,
+		Diff:            diff,
+	}
+
+	latest, err := p.es.getHistory(ctx, username, 1)
+	if err != nil {
+		log.Errorln(logTag, ": unable to fetch the history of permission", username, ":", err)
+		return
+	}
+	entry.Version = 1
+	if len(latest) > 0 {
+		entry.Version = latest[0].Version + 1
+	}
+	// the version might be taken by a concurrent change that isn't searchable yet
+	for i := 0; i < historyVersionAttempts; i++ {
+		created, err := p.es.addHistoryEntry(ctx, entry)
+		if err != nil {
+			log.Errorln(logTag, ": unable to record the history of permission", username, ":", err)
+			return
+		}
+		if created {
+			return
+		}
+		entry.Version++
+	}
+	log.Errorln(logTag, ": unable to record the history of permission", username, ": no version available")
+}
+
+// withoutPassword strips the password hash from the snapshot of the entry, which must never
+// be exposed in a response.
+func (h historyEntry) withoutPassword() (historyEntry, error) {
+	if h.Permission == nil {
+		return h, nil
+	}
+	var snapshot map[string]interface{}
+	if err := json.Unmarshal(h.Permission, &snapshot); err != nil {
+		return h, err
+	}
+	delete(snapshot, "password")
+	raw, err := json.Marshal(snapshot)
+	if err != nil {
+		return h, err
+	}
+	h.Permission = raw
+	return h, nil
+}
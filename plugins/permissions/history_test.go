@@ -0,0 +1,143 @@
+package permissions
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/appbaseio/arc/model/permission"
+)
+
+// historyStore keeps the permissions and their history in memory in place of elasticsearch.
+type historyStore struct {
+	permissionService
+	permissions map[string][]byte
+	history     map[string]historyEntry
+}
+
+func (s *historyStore) getPermissionSource(ctx context.Context, username string) ([]byte, error) {
+	return s.permissions[username], nil
+}
+
+func (s *historyStore) postPermission(ctx context.Context, p permission.Permission) (bool, error) {
+	raw, err := json.Marshal(p)
+	if err != nil {
+		return false, err
+	}
+	s.permissions[p.Username] = raw
+	return true, nil
+}
+
+func (s *historyStore) getRawRolePermission(ctx context.Context, role string) ([]byte, error) {
+	for _, raw := range s.permissions {
+		var p permission.Permission
+		if err := json.Unmarshal(raw, &p); err == nil && p.Role == role {
+			return raw, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *historyStore) getHistory(ctx context.Context, username string, size int) ([]historyEntry, error) {
+	var entries []historyEntry
+	for version := int64(len(s.history)); version > 0 && len(entries) < size; version-- {
+		if entry, ok := s.history[historyID(username, version)]; ok {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+func (s *historyStore) getHistoryEntry(ctx context.Context, username string, version int64) (*historyEntry, error) {
+	entry, ok := s.history[historyID(username, version)]
+	if !ok {
+		return nil, nil
+	}
+	return &entry, nil
+}
+
+func (s *historyStore) addHistoryEntry(ctx context.Context, entry historyEntry) (bool, error) {
+	id := historyID(entry.Username, entry.Version)
+	if _, ok := s.history[id]; ok {
+		return false, nil
+	}
+	s.history[id] = entry
+	return true, nil
+}
+
+func TestHistory(t *testing.T) {
+	ctx := context.Background()
+
+	Convey("Permission history", t, func() {
+		store := &historyStore{permissions: make(map[string][]byte), history: make(map[string]historyEntry)}
+		p := &permissions{es: store}
+		v1 := []byte(`{"username": "shop", "password": "hash", "indices": ["products"], "role": "shop"}`)
+		v2 := []byte(`{"username": "shop", "password": "hash", "indices": ["products", "orders"], "role": "shop"}`)
+
+		Convey("records the fields changed by each version", func() {
+			p.recordHistory(ctx, historyCreate, "admin", "shop", nil, v1, 0)
+			p.recordHistory(ctx, historyPatch, "admin", "shop", v1, v2, 0)
+			p.recordHistory(ctx, historyDelete, "ops", "shop", v2, nil, 0)
+
+			entries, _ := store.getHistory(ctx, "shop", maxHistorySize)
+			So(entries, ShouldHaveLength, 3)
+			So(entries[0].Version, ShouldEqual, 3)
+			So(entries[0].Actor, ShouldEqual, "ops")
+			So(entries[0].Permission, ShouldBeNil)
+			So(entries[1].Diff, ShouldHaveLength, 1)
+			So(entries[1].Diff["indices"].To, ShouldResemble, []interface{}{"products", "orders"})
+			So(entries[2].Diff, ShouldNotContainKey, "password")
+
+			entry, err := entries[2].withoutPassword()
+			So(err, ShouldBeNil)
+			So(string(entry.Permission), ShouldNotContainSubstring, "hash")
+		})
+
+		Convey("skips the versions taken concurrently", func() {
+			store.history[historyID("shop", 1)] = historyEntry{Username: "shop", Version: 1}
+			store.history[historyID("shop", 3)] = historyEntry{Username: "shop", Version: 3}
+			p.recordHistory(ctx, historyPatch, "admin", "shop", v1, v2, 0)
+			So(store.history, ShouldContainKey, historyID("shop", 2))
+			p.recordHistory(ctx, historyPatch, "admin", "shop", v2, v1, 0)
+			So(store.history, ShouldContainKey, historyID("shop", 4))
+		})
+
+		rollback := func(version string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodPost, "/_permission/shop/_rollback/"+version, nil)
+			req = mux.SetURLVars(req, map[string]string{"username": "shop", "version": version})
+			w := httptest.NewRecorder()
+			p.rollbackPermission()(w, req)
+			return w
+		}
+
+		Convey("restores deleted permissions", func() {
+			p.recordHistory(ctx, historyCreate, "admin", "shop", nil, v1, 0)
+			p.recordHistory(ctx, historyDelete, "admin", "shop", v1, nil, 0)
+
+			So(rollback("2").Code, ShouldEqual, http.StatusBadRequest)
+			So(rollback("3").Code, ShouldEqual, http.StatusNotFound)
+			w := rollback("1")
+			So(w.Code, ShouldEqual, http.StatusOK)
+			So(w.Body.String(), ShouldNotContainSubstring, "hash")
+
+			var restored permission.Permission
+			So(json.Unmarshal(store.permissions["shop"], &restored), ShouldBeNil)
+			So(restored.Password, ShouldEqual, "hash")
+			So(restored.Indices, ShouldResemble, []string{"products"})
+			entry := store.history[historyID("shop", 3)]
+			So(entry.Action, ShouldEqual, historyRollback)
+			So(entry.RestoredVersion, ShouldEqual, 1)
+		})
+
+		Convey("doesn't restore a role given to another permission", func() {
+			p.recordHistory(ctx, historyCreate, "admin", "shop", nil, v1, 0)
+			store.permissions["other"] = []byte(`{"username": "other", "role": "shop"}`)
+			So(rollback("1").Code, ShouldEqual, http.StatusBadRequest)
+		})
+	})
+}
@@ -1,8 +1,10 @@
 package permissions
 
 import (
+	"fmt"
 	"os"
 	"sync"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 
@@ -25,7 +27,8 @@ var (
 )
 
 type permissions struct {
-	es permissionService
+	es     permissionService
+	quotas *quotas
 }
 
 // Use only this function to fetch the instance of permission from within
@@ -47,12 +50,30 @@ func (p *permissions) InitFunc() error {
 		indexName = defaultPermissionsEsIndex
 	}
 
+	quotasIndex := os.Getenv(envQuotasEsIndex)
+	if quotasIndex == "" {
+		quotasIndex = defaultQuotasEsIndex
+	}
+	historyIndex := os.Getenv(envPermissionsHistoryEsIndex)
+	if historyIndex == "" {
+		historyIndex = defaultPermissionsHistoryEsIndex
+	}
+	flushInterval := defaultQuotasFlushInterval
+	if value := os.Getenv(envQuotasFlushInterval); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("%s: invalid value for %s: %s", logTag, envQuotasFlushInterval, value)
+		}
+		flushInterval = d
+	}
+
 	// initialize the dao
 	var err error
-	p.es, err = initPlugin(indexName, settings)
+	p.es, err = initPlugin(indexName, quotasIndex, historyIndex, settings)
 	if err != nil {
 		return err
 	}
+	p.quotas = newQuotas(p.es, flushInterval)
 
 	return nil
 }
@@ -0,0 +1,464 @@
+package permissions
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/appbaseio/arc/middleware"
+	"github.com/appbaseio/arc/middleware/explain"
+	"github.com/appbaseio/arc/model/category"
+	"github.com/appbaseio/arc/model/credential"
+	"github.com/appbaseio/arc/model/permission"
+	"github.com/appbaseio/arc/util"
+)
+
+const (
+	envQuotasEsIndex              = "QUOTAS_ES_INDEX"
+	envQuotasFlushInterval        = "QUOTAS_FLUSH_INTERVAL"
+	defaultQuotasEsIndex          = ".quotas"
+	defaultQuotasFlushInterval    = 10 * time.Second
+	periodDay                     = "day"
+	periodMonth                   = "month"
+	dayLayout                     = "2006-01-02"
+	monthLayout                   = "2006-01"
+	quotaDailyLimitHeader         = "X-Quota-Daily-Limit"
+	quotaDailyRemainingHeader     = "X-Quota-Daily-Remaining"
+	quotaDailyResetHeader         = "X-Quota-Daily-Reset"
+	quotaMonthlyLimitHeader       = "X-Quota-Monthly-Limit"
+	quotaMonthlyRemainingHeader   = "X-Quota-Monthly-Remaining"
+	quotaMonthlyResetHeader       = "X-Quota-Monthly-Reset"
+	quotaUsageLoadTimeout         = 5 * time.Second
+	quotaUsageFlushRequestTimeout = 30 * time.Second
+)
+
+// usage is the number of requests made with a permission during a day or a month,
+// in total and per category.
+type usage struct {
+	Username   string           `json:"username"`
+	Period     string           `json:"period"`
+	Start      string           `json:"start"`
+	Total      int64            `json:"total"`
+	Categories map[string]int64 `json:"categories"`
+}
+
+func newUsage(username, period, start string) usage {
+	return usage{
+		Username:   username,
+		Period:     period,
+		Start:      start,
+		Categories: make(map[string]int64),
+	}
+}
+
+func (u *usage) add(other usage) {
+	u.Total += other.Total
+	for c, count := range other.Categories {
+		u.Categories[c] += count
+	}
+}
+
+// usageID returns the id of the usage doc of the permission for the period starting at start.
+func usageID(username, period, start string) string {
+	return fmt.Sprintf("%s:%s:%s", period, start, username)
+}
+
+// periodStart returns the day or the month, in UTC, that t belongs to, along with the
+// time at which the next one starts.
+func periodStart(period string, t time.Time) (string, time.Time) {
+	t = t.UTC()
+	if period == periodDay {
+		day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+		return day.Format(dayLayout), day.AddDate(0, 0, 1)
+	}
+	month := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	return month.Format(monthLayout), month.AddDate(0, 1, 0)
+}
+
+// counter is the usage of a permission as seen by this arc instance: the usage stored
+// in elasticsearch, where the requests counted by all the instances add up, along with
+// the requests counted since, which are yet to be flushed.
+type counter struct {
+	id       string
+	stored   usage
+	inflight usage
+	pending  usage
+}
+
+func (c *counter) used(cat string) (int64, int64) {
+	total := c.stored.Total + c.inflight.Total + c.pending.Total
+	byCategory := c.stored.Categories[cat] + c.inflight.Categories[cat] + c.pending.Categories[cat]
+	return total, byCategory
+}
+
+// quotas counts the requests made with the permissions that have quotas. The counts are
+// flushed to elasticsearch periodically rather than on every request, so the quotas can
+// be exceeded by the requests made to other arc instances within a flush interval.
+type quotas struct {
+	sync.Mutex
+	es       permissionService
+	counters map[string]*counter
+}
+
+func newQuotas(es permissionService, flushInterval time.Duration) *quotas {
+	q := &quotas{es: es, counters: make(map[string]*counter)}
+	go q.flushEvery(flushInterval)
+	return q
+}
+
+// counter returns the counter of the usage with the given id, loading the stored usage
+// the first time it is needed.
+func (q *quotas) counter(ctx context.Context, username, period, start string) (*counter, error) {
+	id := usageID(username, period, start)
+	q.Lock()
+	c, ok := q.counters[id]
+	q.Unlock()
+	if ok {
+		return c, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, quotaUsageLoadTimeout)
+	defer cancel()
+	stored, err := q.es.getQuotaUsage(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if stored == nil {
+		u := newUsage(username, period, start)
+		stored = &u
+	}
+	if stored.Categories == nil {
+		stored.Categories = make(map[string]int64)
+	}
+
+	q.Lock()
+	defer q.Unlock()
+	// the counter might have been loaded concurrently
+	if c, ok := q.counters[id]; ok {
+		return c, nil
+	}
+	c = &counter{
+		id:       id,
+		stored:   *stored,
+		inflight: newUsage(username, period, start),
+		pending:  newUsage(username, period, start),
+	}
+	q.counters[id] = c
+	return c, nil
+}
+
+// current returns the counter of the map for the id of c, registering c again if it has
+// been dropped by a flush since it was fetched. Must be called with the lock held.
+func (q *quotas) current(c *counter) *counter {
+	if existing, ok := q.counters[c.id]; ok {
+		return existing
+	}
+	q.counters[c.id] = c
+	return c
+}
+
+// budget is the state of the tightest quota of a permission for a period.
+type budget struct {
+	limit     int64
+	remaining int64
+	reset     time.Time
+}
+
+// quotaStatus is the state of the daily and monthly quotas of a permission for a request,
+// exceeded being whether the request was rejected.
+type quotaStatus struct {
+	daily, monthly *budget
+	exceeded       bool
+}
+
+func (s *quotaStatus) setHeaders(w http.ResponseWriter) {
+	if s.daily != nil {
+		w.Header().Set(quotaDailyLimitHeader, strconv.FormatInt(s.daily.limit, 10))
+		w.Header().Set(quotaDailyRemainingHeader, strconv.FormatInt(s.daily.remaining, 10))
+		w.Header().Set(quotaDailyResetHeader, strconv.FormatInt(s.daily.reset.Unix(), 10))
+	}
+	if s.monthly != nil {
+		w.Header().Set(quotaMonthlyLimitHeader, strconv.FormatInt(s.monthly.limit, 10))
+		w.Header().Set(quotaMonthlyRemainingHeader, strconv.FormatInt(s.monthly.remaining, 10))
+		w.Header().Set(quotaMonthlyResetHeader, strconv.FormatInt(s.monthly.reset.Unix(), 10))
+	}
+}
+
+// tightest returns the budget with the fewest remaining requests among the quotas that
+// are set, i.e. not zero.
+func tightest(reset time.Time, limits []int64, used []int64) *budget {
+	var b *budget
+	for i, limit := range limits {
+		if limit <= 0 {
+			continue
+		}
+		remaining := limit - used[i]
+		if remaining < 0 {
+			remaining = 0
+		}
+		if b == nil || remaining < b.remaining {
+			b = &budget{limit: limit, remaining: remaining, reset: reset}
+		}
+	}
+	return b
+}
+
+// take checks the quotas of the permission for a request of the given category and
+// counts the request unless a quota is exceeded or consume is false.
+func (q *quotas) take(ctx context.Context, p *permission.Permission, c category.Category,
+	now time.Time, consume bool) (*quotaStatus, error) {
+	day, dayReset := periodStart(periodDay, now)
+	month, monthReset := periodStart(periodMonth, now)
+	daily, err := q.counter(ctx, p.Username, periodDay, day)
+	if err != nil {
+		return nil, err
+	}
+	monthly, err := q.counter(ctx, p.Username, periodMonth, month)
+	if err != nil {
+		return nil, err
+	}
+
+	total, perCategory := p.Quotas.Quota, p.QuotaFor(c)
+	cat := c.String()
+
+	q.Lock()
+	defer q.Unlock()
+	daily, monthly = q.current(daily), q.current(monthly)
+	dailyTotal, dailyCategory := daily.used(cat)
+	monthlyTotal, monthlyCategory := monthly.used(cat)
+	status := &quotaStatus{
+		daily: tightest(dayReset, []int64{total.Daily, perCategory.Daily},
+			[]int64{dailyTotal, dailyCategory}),
+		monthly: tightest(monthReset, []int64{total.Monthly, perCategory.Monthly},
+			[]int64{monthlyTotal, monthlyCategory}),
+	}
+	status.exceeded = (status.daily != nil && status.daily.remaining <= 0) ||
+		(status.monthly != nil && status.monthly.remaining <= 0)
+	if status.exceeded || !consume {
+		return status, nil
+	}
+	for _, counter := range []*counter{daily, monthly} {
+		counter.pending.Total++
+		counter.pending.Categories[cat]++
+	}
+	for _, b := range []*budget{status.daily, status.monthly} {
+		if b != nil {
+			b.remaining--
+		}
+	}
+	return status, nil
+}
+
+// takeAll checks the quotas of each of the permissions a request is made with, e.g. the roles
+// of a jwt, and counts the request against all of them unless consume is false. Like the
+// quotas of a merged permission are the largest of the permissions, the request is only
+// rejected once every permission has a quota exceeded. The returned status is the one of
+// the permission with the most requests left, or an empty one if a permission is unlimited.
+func (q *quotas) takeAll(ctx context.Context, permissions []*permission.Permission, c category.Category,
+	now time.Time, consume bool) (*quotaStatus, error) {
+	var limited []*permission.Permission
+	var statuses []*quotaStatus
+	unlimited := false
+	for _, p := range permissions {
+		if !p.HasQuotas() {
+			unlimited = true
+			continue
+		}
+		status, err := q.take(ctx, p, c, now, false)
+		if err != nil {
+			return nil, err
+		}
+		limited = append(limited, p)
+		statuses = append(statuses, status)
+	}
+
+	allowed := unlimited
+	for _, status := range statuses {
+		if !status.exceeded {
+			allowed = true
+		}
+	}
+	if !allowed {
+		return statuses[0], nil
+	}
+	if consume {
+		for i, p := range limited {
+			status, err := q.take(ctx, p, c, now, true)
+			if err != nil {
+				return nil, err
+			}
+			statuses[i] = status
+		}
+	}
+	if unlimited {
+		return &quotaStatus{}, nil
+	}
+	return loosest(statuses), nil
+}
+
+// loosest returns the status with the most requests left among the ones not exceeded.
+func loosest(statuses []*quotaStatus) *quotaStatus {
+	var best *quotaStatus
+	var bestRemaining int64
+	for _, status := range statuses {
+		if status.exceeded {
+			continue
+		}
+		remaining := int64(math.MaxInt64)
+		for _, b := range []*budget{status.daily, status.monthly} {
+			if b != nil && b.remaining < remaining {
+				remaining = b.remaining
+			}
+		}
+		if best == nil || remaining > bestRemaining {
+			best, bestRemaining = status, remaining
+		}
+	}
+	return best
+}
+
+// usage returns the daily and monthly usage of the permission at the given time.
+func (q *quotas) usage(ctx context.Context, username string, now time.Time) (map[string]usage, error) {
+	result := make(map[string]usage)
+	for _, period := range []string{periodDay, periodMonth} {
+		start, _ := periodStart(period, now)
+		c, err := q.counter(ctx, username, period, start)
+		if err != nil {
+			return nil, err
+		}
+		q.Lock()
+		c = q.current(c)
+		u := newUsage(username, period, start)
+		u.add(c.stored)
+		u.add(c.inflight)
+		u.add(c.pending)
+		q.Unlock()
+		result[period] = u
+	}
+	return result, nil
+}
+
+func (q *quotas) flushEvery(interval time.Duration) {
+	for range time.Tick(interval) {
+		q.flush()
+	}
+}
+
+// flush adds the requests counted since the last flush to the stored usages, and picks
+// up the requests counted by the other arc instances in return. The counters without
+// any request since the last flush are dropped, and reloaded once needed again.
+func (q *quotas) flush() {
+	q.Lock()
+	var ids []string
+	for id, c := range q.counters {
+		if c.pending.Total == 0 {
+			delete(q.counters, id)
+			continue
+		}
+		c.inflight, c.pending = c.pending, newUsage(c.pending.Username, c.pending.Period, c.pending.Start)
+		ids = append(ids, id)
+	}
+	q.Unlock()
+
+	for _, id := range ids {
+		q.Lock()
+		c := q.counters[id]
+		delta := c.inflight
+		q.Unlock()
+
+		ctx, cancel := context.WithTimeout(context.Background(), quotaUsageFlushRequestTimeout)
+		stored, err := q.es.addQuotaUsage(ctx, id, &delta)
+		cancel()
+
+		q.Lock()
+		if err != nil {
+			log.Errorln(logTag, ": unable to flush the quota usage", id, ":", err)
+			c.pending.add(c.inflight)
+		} else {
+			if stored.Categories == nil {
+				stored.Categories = make(map[string]int64)
+			}
+			c.stored = *stored
+		}
+		c.inflight = newUsage(delta.Username, delta.Period, delta.Start)
+		q.Unlock()
+	}
+}
+
+// Quota returns a middleware that enforces the daily and monthly request quotas of the
+// permissions, responding with a 429 once a quota is exceeded. The state of the tightest
+// quotas is returned in the X-Quota-* headers.
+func Quota() middleware.Middleware {
+	return Instance().quota
+}
+
+func (p *permissions) quota(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		ctx := req.Context()
+
+		reqCredential, err := credential.FromContext(ctx)
+		if err != nil {
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if reqCredential != credential.Permission {
+			h(w, req)
+			return
+		}
+
+		errMsg := "An error occurred while validating the quotas"
+		reqPermission, err := permission.FromContext(ctx)
+		if err != nil {
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, errMsg, http.StatusInternalServerError)
+			return
+		}
+		// the usage of a merged permission is charged to each of its permissions
+		sources := reqPermission.QuotaSources()
+		hasQuotas := false
+		for _, source := range sources {
+			if source.HasQuotas() {
+				hasQuotas = true
+			}
+		}
+		if !hasQuotas {
+			h(w, req)
+			return
+		}
+		// the quotas can't be enforced until the permissions plugin is initialized
+		if p.quotas == nil {
+			log.Errorln(logTag, ": the quotas aren't initialized, refusing the request of", reqPermission.Username)
+			util.WriteBackError(w, errMsg, http.StatusInternalServerError)
+			return
+		}
+		reqCategory, err := category.FromContext(ctx)
+		if err != nil {
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, errMsg, http.StatusInternalServerError)
+			return
+		}
+
+		// simulated requests don't consume the quotas
+		consume := explain.FromContext(ctx) == nil
+		status, err := p.quotas.takeAll(ctx, sources, *reqCategory, time.Now(), consume)
+		if err != nil {
+			log.Errorln(logTag, ": unable to fetch the quota usage of", reqPermission.Username, ":", err)
+			util.WriteBackError(w, errMsg, http.StatusInternalServerError)
+			return
+		}
+		status.setHeaders(w)
+		if status.exceeded {
+			util.WriteBackMessage(w, "Quota exceeded", http.StatusTooManyRequests)
+			return
+		}
+
+		h(w, req)
+	}
+}
@@ -0,0 +1,178 @@
+package permissions
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/appbaseio/arc/model/category"
+	"github.com/appbaseio/arc/model/credential"
+	"github.com/appbaseio/arc/model/permission"
+)
+
+// usageStore keeps the quota usages in memory in place of elasticsearch.
+type usageStore struct {
+	permissionService
+	usages map[string]usage
+}
+
+func (s *usageStore) getQuotaUsage(ctx context.Context, id string) (*usage, error) {
+	u, ok := s.usages[id]
+	if !ok {
+		return nil, nil
+	}
+	return &u, nil
+}
+
+func (s *usageStore) addQuotaUsage(ctx context.Context, id string, delta *usage) (*usage, error) {
+	u, ok := s.usages[id]
+	if !ok {
+		u = newUsage(delta.Username, delta.Period, delta.Start)
+	}
+	u.add(*delta)
+	s.usages[id] = u
+	return &u, nil
+}
+
+func TestQuotas(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, time.March, 4, 15, 0, 0, 0, time.UTC)
+	p := &permission.Permission{
+		Username: "shop",
+		Quotas: &permission.Quotas{
+			Quota:      permission.Quota{Daily: 3, Monthly: 100},
+			Categories: map[string]permission.Quota{"docs": {Daily: 1}},
+		},
+	}
+
+	Convey("Permission quotas", t, func() {
+		store := &usageStore{usages: make(map[string]usage)}
+		q := &quotas{es: store, counters: make(map[string]*counter)}
+
+		Convey("count the requests until a quota is exceeded", func() {
+			for i := 0; i < 3; i++ {
+				status, err := q.take(ctx, p, category.Search, now, true)
+				So(err, ShouldBeNil)
+				So(status.exceeded, ShouldBeFalse)
+				So(status.daily.remaining, ShouldEqual, 2-i)
+				So(status.monthly.remaining, ShouldEqual, 99-i)
+			}
+			status, err := q.take(ctx, p, category.Search, now, true)
+			So(err, ShouldBeNil)
+			So(status.exceeded, ShouldBeTrue)
+			So(status.daily.reset, ShouldEqual, time.Date(2024, time.March, 5, 0, 0, 0, 0, time.UTC))
+
+			// the next day starts afresh, unlike the month
+			status, _ = q.take(ctx, p, category.Search, now.AddDate(0, 0, 1), true)
+			So(status.exceeded, ShouldBeFalse)
+			So(status.monthly.remaining, ShouldEqual, 96)
+		})
+
+		Convey("enforce the tightest of the total and category quotas", func() {
+			status, _ := q.take(ctx, p, category.Docs, now, true)
+			So(status.exceeded, ShouldBeFalse)
+			So(status.daily.limit, ShouldEqual, 1)
+			So(status.daily.remaining, ShouldEqual, 0)
+			status, _ = q.take(ctx, p, category.Docs, now, true)
+			So(status.exceeded, ShouldBeTrue)
+			status, _ = q.take(ctx, p, category.Search, now, true)
+			So(status.exceeded, ShouldBeFalse)
+			So(status.daily.remaining, ShouldEqual, 1)
+		})
+
+		Convey("don't count the requests that aren't consumed", func() {
+			for i := 0; i < 5; i++ {
+				status, _ := q.take(ctx, p, category.Search, now, false)
+				So(status.exceeded, ShouldBeFalse)
+			}
+			usages, err := q.usage(ctx, p.Username, now)
+			So(err, ShouldBeNil)
+			So(usages[periodDay].Total, ShouldEqual, 0)
+		})
+
+		Convey("persist the usage shared with the other instances", func() {
+			q.take(ctx, p, category.Docs, now, true)
+			q.take(ctx, p, category.Search, now, true)
+			q.flush()
+			day, _ := periodStart(periodDay, now)
+			id := usageID(p.Username, periodDay, day)
+			So(store.usages[id].Total, ShouldEqual, 2)
+			So(store.usages[id].Categories["docs"], ShouldEqual, 1)
+
+			// another instance counts a request in the meantime
+			other := newUsage(p.Username, periodDay, day)
+			other.Total = 1
+			store.addQuotaUsage(ctx, id, &other)
+			q.take(ctx, p, category.Search, now, true)
+			q.flush()
+			usages, _ := q.usage(ctx, p.Username, now)
+			So(usages[periodDay].Total, ShouldEqual, 4)
+			So(usages[periodMonth].Total, ShouldEqual, 3)
+
+			// idle counters are dropped and reloaded from the store
+			q.flush()
+			So(q.counters, ShouldBeEmpty)
+			status, _ := q.take(ctx, p, category.Search, now, true)
+			So(status.exceeded, ShouldBeTrue)
+		})
+
+		Convey("charge the usage of merged permissions to each of them", func() {
+			small := &permission.Permission{Username: "small", Quotas: &permission.Quotas{Quota: permission.Quota{Daily: 1}}}
+			large := &permission.Permission{Username: "large", Quotas: &permission.Quotas{Quota: permission.Quota{Daily: 2}}}
+			roles := []*permission.Permission{small, large}
+
+			status, err := q.takeAll(ctx, roles, category.Search, now, true)
+			So(err, ShouldBeNil)
+			So(status.exceeded, ShouldBeFalse)
+			So(status.daily.remaining, ShouldEqual, 1)
+			// the request allowed by the large quota isn't counted against the exceeded small one
+			status, _ = q.takeAll(ctx, roles, category.Search, now, true)
+			So(status.exceeded, ShouldBeFalse)
+			status, _ = q.takeAll(ctx, roles, category.Search, now, true)
+			So(status.exceeded, ShouldBeTrue)
+
+			usages, _ := q.usage(ctx, "small", now)
+			So(usages[periodDay].Total, ShouldEqual, 1)
+			usages, _ = q.usage(ctx, "large", now)
+			So(usages[periodDay].Total, ShouldEqual, 2)
+
+			// a permission without quotas lifts them, but the usage is still charged
+			unlimited := &permission.Permission{Username: "unlimited"}
+			status, _ = q.takeAll(ctx, append(roles, unlimited), category.Search, now, true)
+			So(status.exceeded, ShouldBeFalse)
+			So(status.daily, ShouldBeNil)
+		})
+
+		Convey("refuse the requests until they can be enforced", func() {
+			var served int
+			handler := (&permissions{}).quota(func(w http.ResponseWriter, req *http.Request) { served++ })
+			serve := func(p *permission.Permission) int {
+				search := category.Search
+				ctx := credential.NewContext(ctx, credential.Permission)
+				ctx = permission.NewContext(ctx, p)
+				ctx = category.NewContext(ctx, &search)
+				w := httptest.NewRecorder()
+				handler(w, httptest.NewRequest("GET", "/products/_search", nil).WithContext(ctx))
+				return w.Code
+			}
+			So(serve(p), ShouldEqual, http.StatusInternalServerError)
+			So(served, ShouldEqual, 0)
+			So(serve(&permission.Permission{Username: "unlimited"}), ShouldEqual, http.StatusOK)
+			So(served, ShouldEqual, 1)
+		})
+
+		Convey("are returned in the headers", func() {
+			status, _ := q.take(ctx, p, category.Search, now, true)
+			w := httptest.NewRecorder()
+			status.setHeaders(w)
+			So(w.Header().Get(quotaDailyLimitHeader), ShouldEqual, "3")
+			So(w.Header().Get(quotaDailyRemainingHeader), ShouldEqual, "2")
+			So(w.Header().Get(quotaMonthlyRemainingHeader), ShouldEqual, "99")
+			So(w.Header().Get(quotaMonthlyResetHeader), ShouldEqual, "1711929600")
+		})
+	})
+}
@@ -16,6 +16,34 @@ func (p *permissions) routes() []plugins.Route {
 			HandlerFunc: middleware(p.getPermission()),
 			Description: "Returns the permission with {username}",
 		},
+		{
+			Name:        "Explain permission",
+			Methods:     []string{http.MethodPost},
+			Path:        "/_permission/{username}/_explain",
+			HandlerFunc: middleware(p.explainPermission()),
+			Description: "Simulates a request made with the permission with {username} and returns the decision of each middleware",
+		},
+		{
+			Name:        "Get permission usage",
+			Methods:     []string{http.MethodGet},
+			Path:        "/_permission/{username}/_usage",
+			HandlerFunc: middleware(p.getPermissionUsage()),
+			Description: "Returns the daily and monthly usage of the quotas of the permission with {username}",
+		},
+		{
+			Name:        "Get permission history",
+			Methods:     []string{http.MethodGet},
+			Path:        "/_permission/{username}/_history",
+			HandlerFunc: middleware(p.getPermissionHistory()),
+			Description: "Returns the versions of the permission with {username}, most recent first",
+		},
+		{
+			Name:        "Rollback permission",
+			Methods:     []string{http.MethodPost},
+			Path:        "/_permission/{username}/_rollback/{version}",
+			HandlerFunc: middleware(p.rollbackPermission()),
+			Description: "Restores the permission with {username} to {version}, including when it has been deleted",
+		},
 		{
 			Name:        "Create permission",
 			Methods:     []string{http.MethodPost},
@@ -16,4 +16,10 @@ type permissionService interface {
 	getRawOwnerPermissions(ctx context.Context, owner string) ([]byte, error)
 	getRawRolePermission(ctx context.Context, role string) ([]byte, error)
 	checkRoleExists(ctx context.Context, role string) (bool, error)
+	getQuotaUsage(ctx context.Context, id string) (*usage, error)
+	addQuotaUsage(ctx context.Context, id string, delta *usage) (*usage, error)
+	getPermissionSource(ctx context.Context, username string) ([]byte, error)
+	getHistory(ctx context.Context, username string, size int) ([]historyEntry, error)
+	getHistoryEntry(ctx context.Context, username string, version int64) (*historyEntry, error)
+	addHistoryEntry(ctx context.Context, entry historyEntry) (bool, error)
 }
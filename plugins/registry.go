@@ -7,6 +7,7 @@ import (
 	log "github.com/sirupsen/logrus"
 
 	"github.com/appbaseio/arc/middleware"
+	"github.com/appbaseio/arc/middleware/explain"
 
 	"github.com/gorilla/mux"
 )
@@ -18,6 +19,9 @@ const logTag = "[registry]"
 // preferably following the same practice while naming a package.
 var plugins = make(map[string]Plugin)
 
+// router is the router the plugin routes are loaded in.
+var router *mux.Router
+
 type nameRoutes interface {
 	// Name returns the name of the plugin. Name of the plugin must be
 	// unique as it is the name of the plugin that is used as a key
@@ -107,13 +111,14 @@ func LoadRSPlugin(router *mux.Router, p RSPlugin, mw []middleware.Middleware) er
 }
 
 // loadRoutes registers the routes to the router that are associated with
-// that plugin.
-func loadRoutes(router *mux.Router, p nameRoutes) error {
+// that plugin. Only the routes served through a middleware chain can be explained.
+func loadRoutes(r *mux.Router, p nameRoutes) error {
+	router = r
 	for _, r := range p.Routes() {
 		err := router.Methods(r.Methods...).
 			Name(r.Name).
 			Path(r.Path).
-			HandlerFunc(r.HandlerFunc).
+			HandlerFunc(explain.Guard(r.HandlerFunc)).
 			GetError()
 		if err != nil {
 			return err
@@ -122,6 +127,12 @@ func loadRoutes(router *mux.Router, p nameRoutes) error {
 	return nil
 }
 
+// Router returns the router the plugin routes are loaded in, through which the
+// requests can be simulated, or nil if no plugin is loaded yet.
+func Router() *mux.Router {
+	return router
+}
+
 // ListPluginsStr returns a string listing the registered plugins.
 func ListPluginsStr() string {
 	str := "Registered plugins:\n"
@@ -6,21 +6,27 @@ import (
 	"fmt"
 	"os"
 
+	es7 "github.com/olivere/elastic/v7"
 	log "github.com/sirupsen/logrus"
 
 	"github.com/appbaseio/arc/model/user"
 	"github.com/appbaseio/arc/util"
-	"golang.org/x/crypto/bcrypt"
+	"github.com/appbaseio/arc/util/hasher"
 )
 
 type elasticsearch struct {
-	indexName string
+	indexName      string
+	passwordsIndex string
 }
 
-func initPlugin(indexName, mapping string) (*elasticsearch, error) {
+func initPlugin(indexName, passwordsIndex, mapping string) (*elasticsearch, error) {
 	ctx := context.Background()
 
-	es := &elasticsearch{indexName}
+	if err := createIndex(passwordsIndex, mapping); err != nil {
+		return nil, err
+	}
+
+	es := &elasticsearch{indexName, passwordsIndex}
 	defer func() {
 		if es != nil {
 			if err := es.postMasterUser(); err != nil {
@@ -62,6 +68,34 @@ func initPlugin(indexName, mapping string) (*elasticsearch, error) {
 	return es, nil
 }
 
+func createIndex(indexName, mapping string) error {
+	ctx := context.Background()
+
+	exists, err := util.GetClient7().IndexExists(indexName).
+		Do(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: error while checking if index already exists: %v",
+			logTag, err)
+	}
+	if exists {
+		log.Println(logTag, ": index named", indexName, "already exists, skipping...")
+		return nil
+	}
+
+	replicas := util.GetReplicas()
+	settings := fmt.Sprintf(mapping, util.HiddenIndexSettings(), replicas)
+	_, err = util.GetClient7().CreateIndex(indexName).
+		Body(settings).
+		Do(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: error while creating index named %s: %v",
+			logTag, indexName, err)
+	}
+
+	log.Println(logTag, ": successfully created index named", indexName)
+	return nil
+}
+
 func (es *elasticsearch) hashPasswords() error {
 	// get all users
 	rawUsers, err := es.getRawUsers(context.Background())
@@ -83,23 +117,23 @@ func (es *elasticsearch) hashPasswords() error {
 		}
 
 		// hash the password
-		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
+		hashedPassword, hashType, err := hasher.Hash(user.Password)
 		if err != nil {
-			msg := fmt.Sprintf("an error occurred while hashing password: %v", user.Password)
-			log.Errorln(logTag, ":", msg, ":", err)
+			log.Errorln(logTag, ": an error occurred while hashing password for user", user.Username, ":", err)
+			continue
 		}
 
 		// patch the user
 		_, err = es.patchUser(context.Background(), user.Username, map[string]interface{}{
-			"password":           string(hashedPassword),
-			"password_hash_type": "bcrypt",
+			"password":           hashedPassword,
+			"password_hash_type": hashType,
 		})
 
 		if err != nil {
 			return err
 		}
 
-		log.Println(logTag, "hashed password for user", user.Username, "using bcrypt")
+		log.Println(logTag, "hashed password for user", user.Username, "using", hashType)
 	}
 
 	return nil
@@ -114,18 +148,17 @@ func (es *elasticsearch) postMasterUser() error {
 	}
 
 	// hash the password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	hashedPassword, hashType, err := hasher.Hash(password)
 	if err != nil {
-		msg := fmt.Sprintf("an error occurred while hashing password: %v", password)
-		log.Errorln(logTag, ":", msg, ":", err)
+		return fmt.Errorf("%s: error while hashing the password of the master user: %v", logTag, err)
 	}
 
-	admin, err := user.NewAdmin(username, string(hashedPassword))
+	admin, err := user.NewAdmin(username, hashedPassword)
 	if err != nil {
 		return fmt.Errorf("%s: error while creating a master user: %v", logTag, err)
 	}
 
-	admin.PasswordHashType = "bcrypt"
+	admin.PasswordHashType = hashType
 
 	if created, err := es.postUser(context.Background(), *admin); !created || err != nil {
 		return fmt.Errorf("%s: error while creating a master user: %v", logTag, err)
@@ -199,5 +232,71 @@ func (es *elasticsearch) deleteUser(ctx context.Context, username string) (bool,
 		return false, err
 	}
 
+	// the password history and the pending reset go with the user
+	for _, id := range []string{passwordHistoryDocPrefix + username, resetTokenDocPrefix + username} {
+		_, err := util.GetClient7().Delete().
+			Index(es.passwordsIndex).
+			Id(id).
+			Do(ctx)
+		if err != nil && !es7.IsNotFound(err) {
+			log.Errorln(logTag, ": unable to delete the password records of", username, ":", err)
+		}
+	}
+
 	return true, nil
 }
+
+func (es *elasticsearch) getPasswordHistory(ctx context.Context, username string) (*passwordHistory, error) {
+	switch util.GetVersion() {
+	case 6:
+		return es.getPasswordHistoryEs6(ctx, username)
+	default:
+		return es.getPasswordHistoryEs7(ctx, username)
+	}
+}
+
+func (es *elasticsearch) savePasswordHistory(ctx context.Context, h *passwordHistory) error {
+	_, err := util.GetClient7().Index().
+		Refresh("wait_for").
+		Index(es.passwordsIndex).
+		Id(passwordHistoryDocPrefix + h.Username).
+		BodyJson(h).
+		Do(ctx)
+	return err
+}
+
+func (es *elasticsearch) getResetToken(ctx context.Context, username string) (*resetToken, error) {
+	switch util.GetVersion() {
+	case 6:
+		return es.getResetTokenEs6(ctx, username)
+	default:
+		return es.getResetTokenEs7(ctx, username)
+	}
+}
+
+// saveResetToken replaces the pending reset of the user, if any.
+func (es *elasticsearch) saveResetToken(ctx context.Context, t *resetToken) error {
+	_, err := util.GetClient7().Index().
+		Refresh("wait_for").
+		Index(es.passwordsIndex).
+		Id(resetTokenDocPrefix + t.Username).
+		BodyJson(t).
+		Do(ctx)
+	return err
+}
+
+// consumeResetToken deletes the reset fetched earlier, only if it hasn't been consumed
+// or replaced since, so that a token is used at most once.
+func (es *elasticsearch) consumeResetToken(ctx context.Context, t *resetToken) error {
+	_, err := util.GetClient7().Delete().
+		Refresh("wait_for").
+		Index(es.passwordsIndex).
+		Id(resetTokenDocPrefix + t.Username).
+		IfSeqNo(t.seqNo).
+		IfPrimaryTerm(t.primaryTerm).
+		Do(ctx)
+	if es7.IsConflict(err) || es7.IsNotFound(err) {
+		return errResetTokenUsed
+	}
+	return err
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"encoding/json"
 
+	es6 "gopkg.in/olivere/elastic.v6"
+
 	"github.com/appbaseio/arc/util"
 )
 
@@ -76,3 +78,44 @@ func (es *elasticsearch) deleteUserEs6(ctx context.Context, username string) (bo
 
 	return true, nil
 }
+
+func (es *elasticsearch) getPasswordHistoryEs6(ctx context.Context, username string) (*passwordHistory, error) {
+	response, err := util.GetClient6().Get().
+		Index(es.passwordsIndex).
+		Type(typeName).
+		Id(passwordHistoryDocPrefix + username).
+		Do(ctx)
+	if es6.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var h passwordHistory
+	if err := json.Unmarshal(*response.Source, &h); err != nil {
+		return nil, err
+	}
+	return &h, nil
+}
+
+func (es *elasticsearch) getResetTokenEs6(ctx context.Context, username string) (*resetToken, error) {
+	response, err := util.GetClient6().Get().
+		Index(es.passwordsIndex).
+		Type(typeName).
+		Id(resetTokenDocPrefix + username).
+		Do(ctx)
+	if es6.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var t resetToken
+	if err := json.Unmarshal(*response.Source, &t); err != nil {
+		return nil, err
+	}
+	if response.SeqNo != nil && response.PrimaryTerm != nil {
+		t.seqNo, t.primaryTerm = *response.SeqNo, *response.PrimaryTerm
+	}
+	return &t, nil
+}
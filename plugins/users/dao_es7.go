@@ -4,6 +4,8 @@ import (
 	"context"
 	"encoding/json"
 
+	es7 "github.com/olivere/elastic/v7"
+
 	"github.com/appbaseio/arc/util"
 )
 
@@ -73,3 +75,42 @@ func (es *elasticsearch) deleteUserEs7(ctx context.Context, username string) (bo
 
 	return true, nil
 }
+
+func (es *elasticsearch) getPasswordHistoryEs7(ctx context.Context, username string) (*passwordHistory, error) {
+	response, err := util.GetClient7().Get().
+		Index(es.passwordsIndex).
+		Id(passwordHistoryDocPrefix + username).
+		Do(ctx)
+	if es7.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var h passwordHistory
+	if err := json.Unmarshal(response.Source, &h); err != nil {
+		return nil, err
+	}
+	return &h, nil
+}
+
+func (es *elasticsearch) getResetTokenEs7(ctx context.Context, username string) (*resetToken, error) {
+	response, err := util.GetClient7().Get().
+		Index(es.passwordsIndex).
+		Id(resetTokenDocPrefix + username).
+		Do(ctx)
+	if es7.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var t resetToken
+	if err := json.Unmarshal(response.Source, &t); err != nil {
+		return nil, err
+	}
+	if response.SeqNo != nil && response.PrimaryTerm != nil {
+		t.seqNo, t.primaryTerm = *response.SeqNo, *response.PrimaryTerm
+	}
+	return &t, nil
+}
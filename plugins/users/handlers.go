@@ -8,11 +8,13 @@ import (
 
 	log "github.com/sirupsen/logrus"
 
+	"github.com/appbaseio/arc/middleware/explain"
 	"github.com/appbaseio/arc/model/acl"
 	"github.com/appbaseio/arc/model/user"
+	"github.com/appbaseio/arc/plugins"
 	"github.com/appbaseio/arc/util"
+	"github.com/appbaseio/arc/util/hasher"
 	"github.com/gorilla/mux"
-	"golang.org/x/crypto/bcrypt"
 )
 
 func (u *Users) getUser() http.HandlerFunc {
@@ -66,6 +68,22 @@ func (u *Users) getUserWithUsername() http.HandlerFunc {
 	}
 }
 
+func (u *Users) explainUser() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		vars := mux.Vars(req)
+		username := vars["username"]
+
+		reqUser, err := u.es.getUser(req.Context(), username)
+		if err != nil {
+			msg := fmt.Sprintf(`user with "username"="%s" not found`, username)
+			log.Errorln(logTag, ":", msg, ":", err)
+			util.WriteBackError(w, msg, http.StatusNotFound)
+			return
+		}
+		explain.Serve(w, req, plugins.Router(), reqUser)
+	}
+}
+
 func (u *Users) postUser() http.HandlerFunc {
 	return func(w http.ResponseWriter, req *http.Request) {
 		body, err := ioutil.ReadAll(req.Body)
@@ -111,19 +129,23 @@ func (u *Users) postUser() http.HandlerFunc {
 			util.WriteBackError(w, `user "password" shouldn't be empty`, http.StatusBadRequest)
 			return
 		}
+		if err := u.validatePassword(req.Context(), userBody.Username, userBody.Password); err != nil {
+			writePasswordError(w, err)
+			return
+		}
 
-		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(userBody.Password), bcrypt.DefaultCost)
+		hashedPassword, hashType, err := hasher.Hash(userBody.Password)
 		if err != nil {
-			msg := fmt.Sprintf("an error occurred while hashing password: %v", userBody.Password)
-			log.Errorln(logTag, ":", msg, ":", err)
-			util.WriteBackError(w, msg, http.StatusInternalServerError)
+			log.Errorln(logTag, ": an error occurred while hashing password:", err)
+			util.WriteBackError(w, "an error occurred while hashing password", http.StatusInternalServerError)
+			return
 		}
 
 		var newUser *user.User
 		if userBody.IsAdmin != nil && *userBody.IsAdmin {
-			newUser, err = user.NewAdmin(userBody.Username, string(hashedPassword), opts...)
+			newUser, err = user.NewAdmin(userBody.Username, hashedPassword, opts...)
 		} else {
-			newUser, err = user.New(userBody.Username, string(hashedPassword), opts...)
+			newUser, err = user.New(userBody.Username, hashedPassword, opts...)
 		}
 
 		if err != nil {
@@ -133,7 +155,7 @@ func (u *Users) postUser() http.HandlerFunc {
 			return
 		}
 
-		newUser.PasswordHashType = "bcrypt"
+		newUser.PasswordHashType = hashType
 
 		rawUser, err := json.Marshal(*newUser)
 		if err != nil {
@@ -145,6 +167,7 @@ func (u *Users) postUser() http.HandlerFunc {
 
 		ok, err := u.es.postUser(req.Context(), *newUser)
 		if ok && err == nil {
+			u.recordPassword(req.Context(), newUser.Username, hashedPassword, hashType)
 			util.WriteBackRaw(w, rawUser, http.StatusCreated)
 			return
 		}
@@ -182,6 +205,21 @@ func (u *Users) patchUser() http.HandlerFunc {
 			util.WriteBackError(w, err.Error(), http.StatusBadRequest)
 			return
 		}
+		// the new password is hashed, like the password the user was created with
+		if password, ok := patch["password"].(string); ok {
+			if err := u.validatePassword(req.Context(), username, password); err != nil {
+				writePasswordError(w, err)
+				return
+			}
+			hashedPassword, hashType, err := hasher.Hash(password)
+			if err != nil {
+				log.Errorln(logTag, ": an error occurred while hashing password:", err)
+				util.WriteBackError(w, "an error occurred while hashing password", http.StatusInternalServerError)
+				return
+			}
+			patch["password"] = hashedPassword
+			patch["password_hash_type"] = hashType
+		}
 
 		// If user is trying to patch acls without providing categories.
 		if patch["categories"] == nil && patch["acls"] != nil {
@@ -212,6 +250,10 @@ func (u *Users) patchUser() http.HandlerFunc {
 
 		_, err2 := u.es.patchUser(req.Context(), username, patch)
 		if err2 == nil {
+			if hash, ok := patch["password"].(string); ok {
+				hashType, _ := patch["password_hash_type"].(string)
+				u.recordPassword(req.Context(), username, hash, hashType)
+			}
 			util.WriteBackMessage(w, "User is updated successfully", http.StatusOK)
 			return
 		}
@@ -254,6 +296,21 @@ func (u *Users) patchUserWithUsername() http.HandlerFunc {
 			util.WriteBackError(w, err.Error(), http.StatusBadRequest)
 			return
 		}
+		// the new password is hashed, like the password the user was created with
+		if password, ok := patch["password"].(string); ok {
+			if err := u.validatePassword(req.Context(), username, password); err != nil {
+				writePasswordError(w, err)
+				return
+			}
+			hashedPassword, hashType, err := hasher.Hash(password)
+			if err != nil {
+				log.Errorln(logTag, ": an error occurred while hashing password:", err)
+				util.WriteBackError(w, "an error occurred while hashing password", http.StatusInternalServerError)
+				return
+			}
+			patch["password"] = hashedPassword
+			patch["password_hash_type"] = hashType
+		}
 		// If user is trying to patch acls without providing categories.
 		if patch["categories"] == nil && patch["acls"] != nil {
 			// we need to fetch the user object from elasticsearch before we make
@@ -283,6 +340,10 @@ func (u *Users) patchUserWithUsername() http.HandlerFunc {
 
 		_, err2 := u.es.patchUser(req.Context(), username, patch)
 		if err2 == nil {
+			if hash, ok := patch["password"].(string); ok {
+				hashType, _ := patch["password_hash_type"].(string)
+				u.recordPassword(req.Context(), username, hash, hashType)
+			}
 			util.WriteBackMessage(w, "User is updated successfully", http.StatusOK)
 			return
 		}
@@ -345,3 +406,116 @@ func (u *Users) getAllUsers() http.HandlerFunc {
 		util.WriteBackRaw(w, raw, http.StatusOK)
 	}
 }
+
+type resetRequest struct {
+	Username string `json:"username"`
+}
+
+type passwordReset struct {
+	Username string `json:"username"`
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+func (u *Users) requestPasswordReset() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if u.sender == nil {
+			util.WriteBackError(w, "password reset isn't configured", http.StatusNotImplemented)
+			return
+		}
+
+		var body resetRequest
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			msg := "can't parse request body"
+			log.Errorln(logTag, ":", msg, ":", err)
+			util.WriteBackError(w, msg, http.StatusBadRequest)
+			return
+		}
+		if body.Username == "" {
+			util.WriteBackError(w, `can't reset the password without a "username"`, http.StatusBadRequest)
+			return
+		}
+
+		// the token is issued in the background so that the response doesn't tell
+		// whether the user exists
+		go u.sendResetToken(body.Username)
+
+		msg := "if the user exists and has an email, a password reset token has been sent to it"
+		util.WriteBackMessage(w, msg, http.StatusAccepted)
+	}
+}
+
+func (u *Users) resetPassword() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		ctx := req.Context()
+
+		var body passwordReset
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			msg := "can't parse request body"
+			log.Errorln(logTag, ":", msg, ":", err)
+			util.WriteBackError(w, msg, http.StatusBadRequest)
+			return
+		}
+		if body.Username == "" || body.Token == "" {
+			util.WriteBackError(w, `"username" and "token" are required to reset the password`, http.StatusBadRequest)
+			return
+		}
+		if body.Password == "" {
+			util.WriteBackError(w, `user "password" shouldn't be empty`, http.StatusBadRequest)
+			return
+		}
+
+		t, err := u.es.getResetToken(ctx, body.Username)
+		if err != nil {
+			log.Errorln(logTag, ": unable to fetch the password reset of", body.Username, ":", err)
+			util.WriteBackError(w, "an error occurred while resetting password", http.StatusInternalServerError)
+			return
+		}
+		if t == nil || !t.matches(body.Token) {
+			util.WriteBackError(w, errInvalidResetToken.Error(), http.StatusUnauthorized)
+			return
+		}
+		reqUser, err := u.es.getUser(ctx, body.Username)
+		if err != nil || isExternalUser(reqUser) {
+			util.WriteBackError(w, errInvalidResetToken.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		// the token is only consumed by a password the policy accepts, so that the
+		// user can retry with another one
+		if err := u.validatePassword(ctx, body.Username, body.Password); err != nil {
+			writePasswordError(w, err)
+			return
+		}
+		if err := u.es.consumeResetToken(ctx, t); err != nil {
+			if err == errResetTokenUsed {
+				util.WriteBackError(w, errInvalidResetToken.Error(), http.StatusUnauthorized)
+				return
+			}
+			log.Errorln(logTag, ": unable to consume the password reset of", body.Username, ":", err)
+			util.WriteBackError(w, "an error occurred while resetting password", http.StatusInternalServerError)
+			return
+		}
+
+		hashedPassword, hashType, err := hasher.Hash(body.Password)
+		if err != nil {
+			log.Errorln(logTag, ": an error occurred while hashing password:", err)
+			util.WriteBackError(w, "an error occurred while hashing password", http.StatusInternalServerError)
+			return
+		}
+		_, err = u.es.patchUser(ctx, body.Username, map[string]interface{}{
+			"password":           hashedPassword,
+			"password_hash_type": hashType,
+		})
+		if err != nil {
+			msg := fmt.Sprintf(`an error occurred while resetting the password of user with "username"="%s"`, body.Username)
+			log.Errorln(logTag, ":", msg, ":", err)
+			util.WriteBackError(w, msg, http.StatusInternalServerError)
+			return
+		}
+		u.recordPassword(ctx, body.Username, hashedPassword, hashType)
+
+		log.Println(logTag, ": reset the password of user", body.Username)
+		util.WriteBackMessage(w, "password is reset successfully", http.StatusOK)
+	}
+}
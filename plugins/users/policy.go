@@ -0,0 +1,257 @@
+package users
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/appbaseio/arc/model/user"
+	"github.com/appbaseio/arc/util"
+	"github.com/appbaseio/arc/util/hasher"
+)
+
+const (
+	envPasswordMinLength        = "PASSWORD_MIN_LENGTH"
+	envPasswordCharacterClasses = "PASSWORD_REQUIRED_CHARACTER_CLASSES"
+	envPasswordBreachedList     = "PASSWORD_BREACHED_LIST_FILE"
+	envPasswordHistorySize      = "PASSWORD_HISTORY_SIZE"
+	passwordHistoryDocPrefix    = "_history_"
+	passwordHistoryDocType      = "history"
+)
+
+// The character classes a policy can require the passwords to contain.
+const (
+	classLower  = "lower"
+	classUpper  = "upper"
+	classDigit  = "digit"
+	classSymbol = "symbol"
+)
+
+var (
+	characterClasses = map[string]func(rune) bool{
+		classLower:  unicode.IsLower,
+		classUpper:  unicode.IsUpper,
+		classDigit:  unicode.IsDigit,
+		classSymbol: func(r rune) bool { return unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r) },
+	}
+	sha1Line = regexp.MustCompile(`^[0-9A-Fa-f]{40}(:\d+)?$`)
+)
+
+// passwordPolicy holds the rules the passwords set via the users endpoints must satisfy.
+// The zero value accepts any non-empty password.
+type passwordPolicy struct {
+	minLength   int
+	classes     []string
+	breached    map[string]struct{}
+	historySize int
+}
+
+// passwordPolicyError lists the rules of the policy a password violates.
+type passwordPolicyError struct {
+	violations []string
+}
+
+func (e *passwordPolicyError) Error() string {
+	return "password doesn't satisfy the password policy: " + strings.Join(e.violations, ", ")
+}
+
+// passwordHistory holds the hashes of the last passwords of a user, most recent first.
+type passwordHistory struct {
+	Type     string           `json:"type"`
+	Username string           `json:"username"`
+	Hashes   []hashedPassword `json:"hashes"`
+}
+
+type hashedPassword struct {
+	Hash      string `json:"hash"`
+	HashType  string `json:"hash_type"`
+	CreatedAt string `json:"created_at"`
+}
+
+func passwordPolicyFromEnv() (*passwordPolicy, error) {
+	var p passwordPolicy
+	var err error
+	if p.minLength, err = envInt(envPasswordMinLength, 0); err != nil {
+		return nil, err
+	}
+	if p.historySize, err = envInt(envPasswordHistorySize, 0); err != nil {
+		return nil, err
+	}
+	if p.minLength < 0 || p.historySize < 0 {
+		return nil, fmt.Errorf("%s: %s and %s can't be negative", logTag, envPasswordMinLength, envPasswordHistorySize)
+	}
+	for _, class := range strings.Split(os.Getenv(envPasswordCharacterClasses), ",") {
+		class = strings.ToLower(strings.TrimSpace(class))
+		if class == "" {
+			continue
+		}
+		if _, ok := characterClasses[class]; !ok {
+			return nil, fmt.Errorf("%s: invalid character class %q in %s, expected one of lower, upper, digit or symbol",
+				logTag, class, envPasswordCharacterClasses)
+		}
+		p.classes = append(p.classes, class)
+	}
+	if path := os.Getenv(envPasswordBreachedList); path != "" {
+		if p.breached, err = loadBreachedPasswords(path); err != nil {
+			return nil, fmt.Errorf("%s: unable to load the breached passwords from %s: %v", logTag, path, err)
+		}
+		log.Println(logTag, ": loaded", len(p.breached), "breached passwords from", path)
+	}
+	return &p, nil
+}
+
+// loadBreachedPasswords reads a file with one password per line, blank lines and lines
+// starting with # are skipped. The lines can also be the sha1 hashes of the passwords,
+// optionally followed by a :count, as in the pwned passwords downloads. Only the sha1
+// hashes are kept in memory.
+func loadBreachedPasswords(path string) (map[string]struct{}, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	breached := make(map[string]struct{})
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if sha1Line.MatchString(line) {
+			breached[strings.ToUpper(line[:40])] = struct{}{}
+			continue
+		}
+		breached[sha1Hex(line)] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return breached, nil
+}
+
+func sha1Hex(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+// check validates the password against the rules that don't depend on the user.
+func (p *passwordPolicy) check(password string) error {
+	var violations []string
+	if p.minLength > 0 && utf8.RuneCountInString(password) < p.minLength {
+		violations = append(violations, fmt.Sprintf("must be at least %d characters long", p.minLength))
+	}
+	for _, class := range p.classes {
+		if strings.IndexFunc(password, characterClasses[class]) < 0 {
+			violations = append(violations, fmt.Sprintf("must contain a %s character", class))
+		}
+	}
+	if _, ok := p.breached[sha1Hex(password)]; ok {
+		violations = append(violations, "must not be a known breached password")
+	}
+	if len(violations) > 0 {
+		return &passwordPolicyError{violations}
+	}
+	return nil
+}
+
+// isReused checks whether the password matches one of the hashes.
+func isReused(hashes []hashedPassword, password string) bool {
+	for _, h := range hashes {
+		hashType := h.HashType
+		if hashType == "" {
+			hashType = hasher.Bcrypt
+		}
+		if hasher.Verify(hashType, h.Hash, password) {
+			return true
+		}
+	}
+	return false
+}
+
+// validatePassword checks the password the user is about to be created or updated with
+// against the policy, including the last passwords of the user when a history is kept.
+// The policy violations are returned as a *passwordPolicyError.
+func (u *Users) validatePassword(ctx context.Context, username, password string) error {
+	if err := u.policy.check(password); err != nil {
+		return err
+	}
+	if u.policy.historySize == 0 {
+		return nil
+	}
+
+	history, err := u.es.getPasswordHistory(ctx, username)
+	if err != nil {
+		return err
+	}
+	var hashes []hashedPassword
+	if history != nil {
+		hashes = history.Hashes
+	}
+	// the users whose password was set before the history was kept are checked
+	// against their current password
+	if len(hashes) == 0 {
+		if current, err := u.es.getUser(ctx, username); err == nil && !isExternalUser(current) {
+			hashes = append(hashes, hashedPassword{Hash: current.Password, HashType: current.PasswordHashType})
+		}
+	}
+	if len(hashes) > u.policy.historySize {
+		hashes = hashes[:u.policy.historySize]
+	}
+	if isReused(hashes, password) {
+		return &passwordPolicyError{[]string{fmt.Sprintf("must not be one of the last %d passwords", u.policy.historySize)}}
+	}
+	return nil
+}
+
+// recordPassword adds the hash of the password the user was just set with to its
+// history. The history isn't essential to the request, so the errors are only logged.
+func (u *Users) recordPassword(ctx context.Context, username, hash, hashType string) {
+	if u.policy.historySize == 0 {
+		return
+	}
+	history, err := u.es.getPasswordHistory(ctx, username)
+	if err != nil {
+		log.Errorln(logTag, ": unable to fetch the password history of", username, ":", err)
+		return
+	}
+	if history == nil {
+		history = &passwordHistory{Type: passwordHistoryDocType, Username: username}
+	}
+	entry := hashedPassword{Hash: hash, HashType: hashType, CreatedAt: time.Now().Format(time.RFC3339)}
+	history.Hashes = append([]hashedPassword{entry}, history.Hashes...)
+	if len(history.Hashes) > u.policy.historySize {
+		history.Hashes = history.Hashes[:u.policy.historySize]
+	}
+	if err := u.es.savePasswordHistory(ctx, history); err != nil {
+		log.Errorln(logTag, ": unable to save the password history of", username, ":", err)
+	}
+}
+
+// isExternalUser checks whether the user authenticates against an ldap server or an
+// openid connect provider, whose password isn't managed by arc.
+func isExternalUser(u *user.User) bool {
+	return u.PasswordHashType == "ldap" || u.PasswordHashType == "oidc"
+}
+
+// writePasswordError responds with the policy violations of the password, or with
+// an internal error if the password couldn't be validated.
+func writePasswordError(w http.ResponseWriter, err error) {
+	if _, ok := err.(*passwordPolicyError); ok {
+		util.WriteBackError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	log.Errorln(logTag, ": an error occurred while validating password:", err)
+	util.WriteBackError(w, "an error occurred while validating password", http.StatusInternalServerError)
+}
@@ -0,0 +1,112 @@
+package users
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/appbaseio/arc/util/hasher"
+)
+
+func TestPasswordPolicy(t *testing.T) {
+	Convey("Checking passwords against the policy", t, func() {
+		Convey("accepts any password by default", func() {
+			So((&passwordPolicy{}).check("x"), ShouldBeNil)
+		})
+
+		Convey("lists all the rules a password violates", func() {
+			p := &passwordPolicy{minLength: 10, classes: []string{classLower, classUpper, classDigit, classSymbol}}
+			err := p.check("apple")
+			So(err, ShouldHaveSameTypeAs, &passwordPolicyError{})
+			So(err.(*passwordPolicyError).violations, ShouldHaveLength, 4)
+			So(err.Error(), ShouldContainSubstring, "must be at least 10 characters long")
+			So(err.Error(), ShouldContainSubstring, "must contain a digit character")
+			So(p.check("Apple-Seed-42"), ShouldBeNil)
+			// the length is counted in characters rather than bytes
+			So((&passwordPolicy{minLength: 4}).check("ééé"), ShouldNotBeNil)
+		})
+
+		Convey("rejects the breached passwords", func() {
+			dir, err := ioutil.TempDir("", "breached")
+			So(err, ShouldBeNil)
+			defer os.RemoveAll(dir)
+			path := filepath.Join(dir, "breached.txt")
+			list := strings.Join([]string{
+				"# common passwords",
+				"password123",
+				"",
+				// sha1 of "letmein", in the pwned passwords format
+				"b7a875fc1ea228b9061041b7cec4bd3c52ab3ce3:1234",
+			}, "\n")
+			So(ioutil.WriteFile(path, []byte(list), 0600), ShouldBeNil)
+
+			breached, err := loadBreachedPasswords(path)
+			So(err, ShouldBeNil)
+			So(breached, ShouldHaveLength, 2)
+			p := &passwordPolicy{breached: breached}
+			So(p.check("password123"), ShouldNotBeNil)
+			So(p.check("letmein"), ShouldNotBeNil)
+			So(p.check("# common passwords"), ShouldBeNil)
+			So(p.check("correct horse battery staple"), ShouldBeNil)
+		})
+
+		Convey("is configured from the env", func() {
+			defer os.Unsetenv(envPasswordCharacterClasses)
+			os.Setenv(envPasswordCharacterClasses, "Upper, digit")
+			p, err := passwordPolicyFromEnv()
+			So(err, ShouldBeNil)
+			So(p.classes, ShouldResemble, []string{classUpper, classDigit})
+
+			os.Setenv(envPasswordCharacterClasses, "emoji")
+			_, err = passwordPolicyFromEnv()
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("detects the reused passwords", func() {
+			h, _ := hasher.NewBcrypt(4)
+			hash, err := h.Hash("old-password")
+			So(err, ShouldBeNil)
+			hashes := []hashedPassword{{Hash: hash, HashType: ""}}
+			So(isReused(hashes, "old-password"), ShouldBeTrue)
+			So(isReused(hashes, "new-password"), ShouldBeFalse)
+		})
+	})
+}
+
+func TestPasswordReset(t *testing.T) {
+	Convey("Resetting passwords", t, func() {
+		Convey("matches the issued token until it expires", func() {
+			token, rt, err := newResetToken("john", time.Hour)
+			So(err, ShouldBeNil)
+			So(rt.TokenHash, ShouldNotContainSubstring, token)
+			So(rt.matches(token), ShouldBeTrue)
+			So(rt.matches(token+"x"), ShouldBeFalse)
+
+			rt.ExpiresAt = time.Now().Add(-time.Second).Unix()
+			So(rt.matches(token), ShouldBeFalse)
+		})
+
+		Convey("mails the token to a valid address only", func() {
+			msg, err := mailMessage("arc@example.com", "john@appleseed.com", "subject", "line 1\nline 2")
+			So(err, ShouldBeNil)
+			So(string(msg), ShouldContainSubstring, "To: john@appleseed.com\r\n")
+			So(string(msg), ShouldEndWith, "\r\n\r\nline 1\r\nline 2")
+
+			_, err = mailMessage("arc@example.com", "john@appleseed.com\r\nBcc: eve@example.com", "subject", "body")
+			So(err, ShouldNotBeNil)
+			_, err = mailMessage("arc@example.com", "john", "subject", "body")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("links to the reset page when configured", func() {
+			u := &Users{resetTokenTTL: time.Hour, resetURL: "https://arc.example.com/reset"}
+			So(u.resetMailBody("john", "abc"), ShouldContainSubstring,
+				"https://arc.example.com/reset?token=abc&username=john")
+		})
+	})
+}
@@ -0,0 +1,217 @@
+package users
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	envResetTokenTTL     = "PASSWORD_RESET_TOKEN_TTL"
+	envResetURL          = "PASSWORD_RESET_URL"
+	envSMTPHost          = "SMTP_HOST"
+	envSMTPPort          = "SMTP_PORT"
+	envSMTPUsername      = "SMTP_USERNAME"
+	envSMTPPassword      = "SMTP_PASSWORD"
+	envSMTPFrom          = "SMTP_FROM"
+	defaultResetTokenTTL = time.Hour
+	defaultSMTPPort      = "587"
+	resetTokenDocPrefix  = "_reset_"
+	resetTokenDocType    = "reset"
+	resetTokenBytes      = 32
+	resetRequestInterval = time.Minute
+	resetRequestTimeout  = 30 * time.Second
+	resetMailSubject     = "Reset your arc password"
+)
+
+var (
+	errInvalidResetToken = errors.New("invalid or expired password reset token")
+	errResetTokenUsed    = errors.New("password reset token has already been used")
+)
+
+// resetToken is a pending password reset of a user. Only the sha256 hash of the token
+// is stored, and a user has at most one pending reset: requesting another one replaces it.
+type resetToken struct {
+	Type        string `json:"type"`
+	Username    string `json:"username"`
+	TokenHash   string `json:"token_hash"`
+	CreatedAt   int64  `json:"created_at"`
+	ExpiresAt   int64  `json:"expires_at"`
+	seqNo       int64
+	primaryTerm int64
+}
+
+// sender delivers the password reset tokens to the users.
+type sender interface {
+	send(to, subject, body string) error
+}
+
+// smtpSender sends the mails through an smtp server, upgrading the connection with
+// STARTTLS whenever the server supports it.
+type smtpSender struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+// senderFromEnv returns the smtp sender configured by the SMTP_* env vars, or nil if
+// SMTP_HOST isn't set, in which case the password reset flow is disabled.
+func senderFromEnv() (sender, error) {
+	host := os.Getenv(envSMTPHost)
+	if host == "" {
+		return nil, nil
+	}
+	port := os.Getenv(envSMTPPort)
+	if port == "" {
+		port = defaultSMTPPort
+	}
+	from := os.Getenv(envSMTPFrom)
+	if _, err := mail.ParseAddress(from); err != nil {
+		return nil, fmt.Errorf("%s: invalid value for %s: %v", logTag, envSMTPFrom, err)
+	}
+	s := &smtpSender{addr: net.JoinHostPort(host, port), from: from}
+	if username := os.Getenv(envSMTPUsername); username != "" {
+		s.auth = smtp.PlainAuth("", username, os.Getenv(envSMTPPassword), host)
+	}
+	return s, nil
+}
+
+func (s *smtpSender) send(to, subject, body string) error {
+	msg, err := mailMessage(s.from, to, subject, body)
+	if err != nil {
+		return err
+	}
+	from, _ := mail.ParseAddress(s.from)
+	rcpt, _ := mail.ParseAddress(to)
+	return smtp.SendMail(s.addr, s.auth, from.Address, []string{rcpt.Address}, msg)
+}
+
+// mailMessage builds a plain text mail, the addresses are validated so that the
+// user provided email can't inject headers.
+func mailMessage(from, to, subject, body string) ([]byte, error) {
+	if _, err := mail.ParseAddress(from); err != nil {
+		return nil, fmt.Errorf("invalid sender address: %v", err)
+	}
+	if strings.ContainsAny(to, "\r\n") {
+		return nil, fmt.Errorf("invalid recipient address")
+	}
+	if _, err := mail.ParseAddress(to); err != nil {
+		return nil, fmt.Errorf("invalid recipient address: %v", err)
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", to)
+	fmt.Fprintf(&b, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.Replace(body, "\n", "\r\n", -1))
+	return []byte(b.String()), nil
+}
+
+func newResetToken(username string, ttl time.Duration) (string, *resetToken, error) {
+	b := make([]byte, resetTokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	now := time.Now()
+	return token, &resetToken{
+		Type:      resetTokenDocType,
+		Username:  username,
+		TokenHash: hashResetToken(token),
+		CreatedAt: now.Unix(),
+		ExpiresAt: now.Add(ttl).Unix(),
+	}, nil
+}
+
+func hashResetToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// matches checks whether the token is the one issued for this reset and is yet to expire.
+func (t *resetToken) matches(token string) bool {
+	if time.Now().Unix() >= t.ExpiresAt {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(t.TokenHash), []byte(hashResetToken(token))) == 1
+}
+
+func (u *Users) resetMailBody(username, token string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "A password reset was requested for the arc user %q.\n\n", username)
+	if u.resetURL != "" {
+		link := u.resetURL
+		if strings.Contains(link, "?") {
+			link += "&"
+		} else {
+			link += "?"
+		}
+		link += url.Values{"username": {username}, "token": {token}}.Encode()
+		fmt.Fprintf(&b, "Follow the link below to choose a new password:\n\n%s\n\n", link)
+	} else {
+		fmt.Fprintf(&b, "Use the following token to choose a new password:\n\n%s\n\n", token)
+	}
+	fmt.Fprintf(&b, "The token expires in %s and can only be used once. ", u.resetTokenTTL)
+	b.WriteString("If you didn't request a password reset, you can ignore this mail.\n")
+	return b.String()
+}
+
+// sendResetToken issues a reset token for the user and mails it to the email of the
+// user. It runs in the background of the reset requests, which respond the same way
+// whether or not the user exists, so the failures are only logged.
+func (u *Users) sendResetToken(username string) {
+	ctx, cancel := context.WithTimeout(context.Background(), resetRequestTimeout)
+	defer cancel()
+
+	reqUser, err := u.es.getUser(ctx, username)
+	if err != nil {
+		log.Println(logTag, ": password reset requested for unknown user", username)
+		return
+	}
+	if isExternalUser(reqUser) || reqUser.Email == "" {
+		log.Println(logTag, ": password reset requested for user", username, "that can't be reset")
+		return
+	}
+
+	pending, err := u.es.getResetToken(ctx, username)
+	if err != nil {
+		log.Errorln(logTag, ": unable to fetch the pending password reset of", username, ":", err)
+		return
+	}
+	if pending != nil && time.Since(time.Unix(pending.CreatedAt, 0)) < resetRequestInterval {
+		log.Println(logTag, ": password reset already requested for", username, "in the last", resetRequestInterval)
+		return
+	}
+
+	token, t, err := newResetToken(username, u.resetTokenTTL)
+	if err != nil {
+		log.Errorln(logTag, ": unable to generate a password reset token:", err)
+		return
+	}
+	if err := u.es.saveResetToken(ctx, t); err != nil {
+		log.Errorln(logTag, ": unable to save the password reset token of", username, ":", err)
+		return
+	}
+	if err := u.sender.send(reqUser.Email, resetMailSubject, u.resetMailBody(username, token)); err != nil {
+		log.Errorln(logTag, ": unable to send the password reset token of", username, ":", err)
+		return
+	}
+	log.Println(logTag, ": sent a password reset token to user", username)
+}
@@ -23,6 +23,13 @@ func (u *Users) routes() []plugins.Route {
 			HandlerFunc: middleware(isAdmin(u.getUserWithUsername())),
 			Description: "Returns the user with {username}",
 		},
+		{
+			Name:        "Explain user",
+			Methods:     []string{http.MethodPost},
+			Path:        "/_user/{username}/_explain",
+			HandlerFunc: middleware(isAdmin(u.explainUser())),
+			Description: "Simulates a request made by the user with {username} and returns the decision of each middleware",
+		},
 		{
 			Name:        "Get all users",
 			Methods:     []string{http.MethodGet},
@@ -65,6 +72,20 @@ func (u *Users) routes() []plugins.Route {
 			HandlerFunc: middleware(isAdmin(u.deleteUserWithUsername())),
 			Description: "Deletes the user with {username}",
 		},
+		{
+			Name:        "Request password reset",
+			Methods:     []string{http.MethodPost},
+			Path:        "/_user/_reset_request",
+			HandlerFunc: u.requestPasswordReset(),
+			Description: "Mails a single-use password reset token to the email of the user",
+		},
+		{
+			Name:        "Reset password",
+			Methods:     []string{http.MethodPost},
+			Path:        "/_user/_reset",
+			HandlerFunc: u.resetPassword(),
+			Description: "Resets the password of the user with a password reset token",
+		},
 	}
 	return routes
 }
@@ -12,4 +12,9 @@ type userService interface {
 	postUser(ctx context.Context, u user.User) (bool, error)
 	patchUser(ctx context.Context, username string, patch map[string]interface{}) ([]byte, error)
 	deleteUser(ctx context.Context, username string) (bool, error)
+	getPasswordHistory(ctx context.Context, username string) (*passwordHistory, error)
+	savePasswordHistory(ctx context.Context, h *passwordHistory) error
+	getResetToken(ctx context.Context, username string) (*resetToken, error)
+	saveResetToken(ctx context.Context, t *resetToken) error
+	consumeResetToken(ctx context.Context, t *resetToken) error
 }
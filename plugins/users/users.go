@@ -1,20 +1,25 @@
 package users
 
 import (
+	"fmt"
 	"os"
+	"strconv"
 	"sync"
+	"time"
 
 	"github.com/appbaseio/arc/middleware"
 	"github.com/appbaseio/arc/plugins"
 )
 
 const (
-	logTag              = "[users]"
-	envUsersEsIndex     = "USERS_ES_INDEX"
-	typeName            = "_doc"
-	envEsURL            = "ES_CLUSTER_URL"
-	defaultUsersEsIndex = ".users"
-	settings            = `{ "settings" : { %s "index.number_of_shards" : 1, "index.number_of_replicas" : %d } }`
+	logTag                      = "[users]"
+	envUsersEsIndex             = "USERS_ES_INDEX"
+	envUserPasswordsEsIndex     = "USER_PASSWORDS_ES_INDEX"
+	typeName                    = "_doc"
+	envEsURL                    = "ES_CLUSTER_URL"
+	defaultUsersEsIndex         = ".users"
+	defaultUserPasswordsEsIndex = ".user_passwords"
+	settings                    = `{ "settings" : { %s "index.number_of_shards" : 1, "index.number_of_replicas" : %d } }`
 )
 
 var (
@@ -24,14 +29,18 @@ var (
 
 // Users plugin deals with user management.
 type Users struct {
-	es userService
+	es            userService
+	policy        *passwordPolicy
+	sender        sender
+	resetTokenTTL time.Duration
+	resetURL      string
 }
 
 // Use only this function to fetch the instance of user from within
 // this package to avoid creating stateless duplicates of the plugin.
 // However, instance of Users is not meant to be used outside the package.
 func Instance() *Users {
-	once.Do(func() { singleton = &Users{} })
+	once.Do(func() { singleton = &Users{policy: &passwordPolicy{}} })
 	return singleton
 }
 
@@ -47,13 +56,33 @@ func (u *Users) InitFunc() error {
 	if indexName == "" {
 		indexName = defaultUsersEsIndex
 	}
+	// the password histories and reset tokens are kept apart from the user docs,
+	// which are returned as is by the users endpoints
+	passwordsIndex := os.Getenv(envUserPasswordsEsIndex)
+	if passwordsIndex == "" {
+		passwordsIndex = defaultUserPasswordsEsIndex
+	}
 
 	// initialize the dao
 	var err error
-	u.es, err = initPlugin(indexName, settings)
+	u.es, err = initPlugin(indexName, passwordsIndex, settings)
+	if err != nil {
+		return err
+	}
+
+	u.policy, err = passwordPolicyFromEnv()
+	if err != nil {
+		return err
+	}
+	u.sender, err = senderFromEnv()
+	if err != nil {
+		return err
+	}
+	u.resetTokenTTL, err = envDuration(envResetTokenTTL, defaultResetTokenTTL)
 	if err != nil {
 		return err
 	}
+	u.resetURL = os.Getenv(envResetURL)
 
 	return nil
 }
@@ -72,3 +101,27 @@ func (u *Users) ESMiddleware() []middleware.Middleware {
 func (u *Users) RSMiddleware() []middleware.Middleware {
 	return make([]middleware.Middleware, 0)
 }
+
+func envInt(envVar string, defaultValue int) (int, error) {
+	value := os.Getenv(envVar)
+	if value == "" {
+		return defaultValue, nil
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s: invalid value for %s: %v", logTag, envVar, err)
+	}
+	return i, nil
+}
+
+func envDuration(envVar string, defaultValue time.Duration) (time.Duration, error) {
+	value := os.Getenv(envVar)
+	if value == "" {
+		return defaultValue, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s: invalid value for %s: %v", logTag, envVar, err)
+	}
+	return d, nil
+}
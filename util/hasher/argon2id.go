@@ -0,0 +1,106 @@
+package hasher
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Argon2idParams are the costs of the argon2id hashes, the memory is in KiB.
+type Argon2idParams struct {
+	Time       uint32
+	Memory     uint32
+	Threads    uint8
+	SaltLength uint32
+	KeyLength  uint32
+}
+
+// DefaultArgon2idParams follow the owasp recommendation for argon2id, they keep the
+// memory low enough for the passwords to be verified on every basic auth request.
+var DefaultArgon2idParams = Argon2idParams{
+	Time:       2,
+	Memory:     19 * 1024,
+	Threads:    1,
+	SaltLength: 16,
+	KeyLength:  32,
+}
+
+type argon2idHasher struct {
+	params Argon2idParams
+}
+
+// NewArgon2id returns a hasher that hashes the passwords with argon2id at the given costs.
+func NewArgon2id(params Argon2idParams) (Hasher, error) {
+	if params.Time < 1 || params.Threads < 1 {
+		return nil, fmt.Errorf("argon2id time and threads must be at least 1")
+	}
+	if params.Memory < 8*uint32(params.Threads) {
+		return nil, fmt.Errorf("argon2id memory must be at least 8 KiB per thread")
+	}
+	if params.SaltLength < 8 || params.KeyLength < 16 {
+		return nil, fmt.Errorf("argon2id salt must be at least 8 bytes and the key at least 16 bytes")
+	}
+	return &argon2idHasher{params: params}, nil
+}
+
+// Hash returns the hash in the phc string format, along with the parameters needed
+// to verify it: $argon2id$v=19$m=<memory>,t=<time>,p=<threads>$<salt>$<key>
+func (h *argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.params.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	p := h.params
+	key := argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, p.KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, p.Memory, p.Time, p.Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (h *argon2idHasher) Verify(hash, password string) bool {
+	p, salt, key, err := parseArgon2id(hash)
+	if err != nil {
+		return false
+	}
+	computed := argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(computed, key) == 1
+}
+
+func (h *argon2idHasher) NeedsRehash(hash string) bool {
+	p, salt, key, err := parseArgon2id(hash)
+	if err != nil {
+		return true
+	}
+	return p.Time != h.params.Time || p.Memory != h.params.Memory || p.Threads != h.params.Threads ||
+		uint32(len(salt)) != h.params.SaltLength || uint32(len(key)) != h.params.KeyLength
+}
+
+func parseArgon2id(hash string) (Argon2idParams, []byte, []byte, error) {
+	var p Argon2idParams
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return p, nil, nil, fmt.Errorf("invalid argon2id hash")
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return p, nil, nil, fmt.Errorf("unsupported argon2id version")
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Time, &p.Threads); err != nil {
+		return p, nil, nil, fmt.Errorf("invalid argon2id parameters: %v", err)
+	}
+	if p.Time < 1 || p.Threads < 1 {
+		return p, nil, nil, fmt.Errorf("invalid argon2id parameters")
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return p, nil, nil, err
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return p, nil, nil, fmt.Errorf("invalid argon2id key")
+	}
+	return p, salt, key, nil
+}
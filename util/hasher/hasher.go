@@ -0,0 +1,160 @@
+package hasher
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// Password hash types, stored as the "password_hash_type" of the users and permissions.
+const (
+	Bcrypt   = "bcrypt"
+	Argon2id = "argon2id"
+)
+
+const (
+	envPasswordHasher  = "PASSWORD_HASHER"
+	envBcryptCost      = "BCRYPT_COST"
+	envArgon2idTime    = "ARGON2ID_TIME"
+	envArgon2idMemory  = "ARGON2ID_MEMORY"
+	envArgon2idThreads = "ARGON2ID_THREADS"
+)
+
+// Hasher hashes the passwords with a specific algorithm and cost.
+type Hasher interface {
+	// Hash returns the hash of the password, salted with a random salt.
+	Hash(password string) (string, error)
+	// Verify checks whether the hash is the hash of the password.
+	Verify(hash, password string) bool
+	// NeedsRehash checks whether the hash was computed with a cost other than the
+	// configured one.
+	NeedsRehash(hash string) bool
+}
+
+var (
+	mu          sync.RWMutex
+	defaultType = Bcrypt
+	hashers     = map[string]Hasher{
+		Bcrypt:   &bcryptHasher{cost: DefaultBcryptCost},
+		Argon2id: &argon2idHasher{params: DefaultArgon2idParams},
+	}
+)
+
+// Register registers the hasher against the hash type, replacing the registered one.
+func Register(hashType string, h Hasher) {
+	mu.Lock()
+	defer mu.Unlock()
+	hashers[hashType] = h
+}
+
+// Get returns the hasher registered against the hash type.
+func Get(hashType string) (Hasher, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	h, ok := hashers[hashType]
+	return h, ok
+}
+
+// SetDefault sets the hash type the passwords are hashed with.
+func SetDefault(hashType string) error {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := hashers[hashType]; !ok {
+		return fmt.Errorf("no password hasher registered for %q", hashType)
+	}
+	defaultType = hashType
+	return nil
+}
+
+// Default returns the hash type the passwords are hashed with.
+func Default() string {
+	mu.RLock()
+	defer mu.RUnlock()
+	return defaultType
+}
+
+// Hash hashes the password with the default hasher and returns the hash along with its type.
+func Hash(password string) (string, string, error) {
+	hashType := Default()
+	h, _ := Get(hashType)
+	hash, err := h.Hash(password)
+	if err != nil {
+		return "", "", err
+	}
+	return hash, hashType, nil
+}
+
+// Verify checks the password against the hash of the given type. It returns false for
+// the hash types without a registered hasher.
+func Verify(hashType, hash, password string) bool {
+	h, ok := Get(hashType)
+	if !ok {
+		return false
+	}
+	return h.Verify(hash, password)
+}
+
+// NeedsRehash checks whether the hash of the given type should be replaced with one
+// computed by the default hasher, either because it uses another algorithm or another cost.
+func NeedsRehash(hashType, hash string) bool {
+	if hashType != Default() {
+		return true
+	}
+	h, _ := Get(hashType)
+	return h.NeedsRehash(hash)
+}
+
+// ConfigureFromEnv registers the hashers with the costs set in the environment and sets
+// the default hasher to PASSWORD_HASHER, bcrypt if not set.
+func ConfigureFromEnv() error {
+	bcryptCost, err := envInt(envBcryptCost, DefaultBcryptCost)
+	if err != nil {
+		return err
+	}
+	bcryptHasher, err := NewBcrypt(bcryptCost)
+	if err != nil {
+		return err
+	}
+
+	params := DefaultArgon2idParams
+	time, err := envInt(envArgon2idTime, int(params.Time))
+	if err != nil {
+		return err
+	}
+	memory, err := envInt(envArgon2idMemory, int(params.Memory))
+	if err != nil {
+		return err
+	}
+	threads, err := envInt(envArgon2idThreads, int(params.Threads))
+	if err != nil {
+		return err
+	}
+	if threads > 255 {
+		return fmt.Errorf("invalid value for %s: must be at most 255", envArgon2idThreads)
+	}
+	params.Time, params.Memory, params.Threads = uint32(time), uint32(memory), uint8(threads)
+	argon2idHasher, err := NewArgon2id(params)
+	if err != nil {
+		return err
+	}
+
+	Register(Bcrypt, bcryptHasher)
+	Register(Argon2id, argon2idHasher)
+	if hashType := os.Getenv(envPasswordHasher); hashType != "" {
+		return SetDefault(hashType)
+	}
+	return SetDefault(Bcrypt)
+}
+
+func envInt(envVar string, defaultValue int) (int, error) {
+	value := os.Getenv(envVar)
+	if value == "" {
+		return defaultValue, nil
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil || i < 0 {
+		return 0, fmt.Errorf("invalid value for %s: %q", envVar, value)
+	}
+	return i, nil
+}
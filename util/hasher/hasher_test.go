@@ -0,0 +1,84 @@
+package hasher
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestHasher(t *testing.T) {
+	Convey("Hashing passwords", t, func() {
+		lowCostArgon2id := Argon2idParams{Time: 1, Memory: 64, Threads: 1, SaltLength: 16, KeyLength: 32}
+
+		Convey("with bcrypt", func() {
+			h, err := NewBcrypt(4)
+			So(err, ShouldBeNil)
+			hash, err := h.Hash("secret")
+			So(err, ShouldBeNil)
+			So(h.Verify(hash, "secret"), ShouldBeTrue)
+			So(h.Verify(hash, "Secret"), ShouldBeFalse)
+			So(h.NeedsRehash(hash), ShouldBeFalse)
+
+			other, _ := NewBcrypt(5)
+			So(other.Verify(hash, "secret"), ShouldBeTrue)
+			So(other.NeedsRehash(hash), ShouldBeTrue)
+
+			_, err = NewBcrypt(50)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("with argon2id", func() {
+			h, err := NewArgon2id(lowCostArgon2id)
+			So(err, ShouldBeNil)
+			hash, err := h.Hash("secret")
+			So(err, ShouldBeNil)
+			So(hash, ShouldStartWith, "$argon2id$v=19$m=64,t=1,p=1$")
+			So(h.Verify(hash, "secret"), ShouldBeTrue)
+			So(h.Verify(hash, "Secret"), ShouldBeFalse)
+			So(h.NeedsRehash(hash), ShouldBeFalse)
+
+			// the hashes are verified with the parameters they were computed with
+			params := lowCostArgon2id
+			params.Time = 2
+			other, err := NewArgon2id(params)
+			So(err, ShouldBeNil)
+			So(other.Verify(hash, "secret"), ShouldBeTrue)
+			So(other.NeedsRehash(hash), ShouldBeTrue)
+
+			So(h.Verify(strings.Replace(hash, "argon2id", "argon2i", 1), "secret"), ShouldBeFalse)
+			So(h.Verify("secret", "secret"), ShouldBeFalse)
+		})
+
+		Convey("with the registered hashers", func() {
+			defer func() {
+				os.Unsetenv(envPasswordHasher)
+				os.Unsetenv(envBcryptCost)
+				So(ConfigureFromEnv(), ShouldBeNil)
+			}()
+			os.Setenv(envBcryptCost, "4")
+			So(ConfigureFromEnv(), ShouldBeNil)
+			bcryptHash, hashType, err := Hash("secret")
+			So(err, ShouldBeNil)
+			So(hashType, ShouldEqual, Bcrypt)
+			So(NeedsRehash(hashType, bcryptHash), ShouldBeFalse)
+
+			// switching the hasher upgrades the existing hashes once verified
+			os.Setenv(envPasswordHasher, Argon2id)
+			So(ConfigureFromEnv(), ShouldBeNil)
+			Register(Argon2id, &argon2idHasher{params: lowCostArgon2id})
+			So(Verify(Bcrypt, bcryptHash, "secret"), ShouldBeTrue)
+			So(NeedsRehash(Bcrypt, bcryptHash), ShouldBeTrue)
+			argon2idHash, hashType, err := Hash("secret")
+			So(err, ShouldBeNil)
+			So(hashType, ShouldEqual, Argon2id)
+			So(Verify(hashType, argon2idHash, "secret"), ShouldBeTrue)
+			So(NeedsRehash(hashType, argon2idHash), ShouldBeFalse)
+
+			So(Verify("ldap", bcryptHash, "secret"), ShouldBeFalse)
+			os.Setenv(envPasswordHasher, "md5")
+			So(ConfigureFromEnv(), ShouldNotBeNil)
+		})
+	})
+}